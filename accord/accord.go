@@ -1,10 +1,15 @@
 package accord
 
 import (
+	"context"
+	"fmt"
+	"hash"
 	"os"
 	"os/signal"
 	"path"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -19,6 +24,33 @@ const (
 
 	// StateFilename is where we will persist the internal state of our process
 	StateFilename = "state.db"
+
+	// ConflictLogFilename is where we will persist our audit trail of conflict resolution decisions
+	ConflictLogFilename = "conflict.log"
+
+	// ReceiptLogFilename is where we will persist our delivery receipts, if they're enabled
+	ReceiptLogFilename = "receipts.log"
+
+	// ConfirmationsFilename is where we will persist our peers' processing confirmations, if they're enabled
+	ConfirmationsFilename = "confirmations.db"
+
+	// DeadLetterFilename is where we will persist the Messages we've given up on, if MaxHeadRetries or
+	// DependencyTimeout is set
+	DeadLetterFilename = "deadletter.queue"
+
+	// CursorsFilename is where we will persist our sync targets' positions in the sync queue, if PersistSyncCursors is
+	// set
+	CursorsFilename = "cursors.db"
+
+	// PendingFilename is where we will persist the Messages waiting to be processed while processing is paused
+	PendingFilename = "pending.queue"
+
+	// DeferredFilename is where we will persist the remote Messages we're holding back until their dependencies have
+	// been processed
+	DeferredFilename = "deferred.queue"
+
+	// LockFilename is the file we lock to keep two Accords from using the same data directory at once
+	LockFilename = "accord.lock"
 )
 
 // Status gives some insights into the current internal state of the Accord process
@@ -26,6 +58,118 @@ type Status struct {
 	ToBeSyncedSize uint64
 	HistorySize    uint64
 	State          uint64
+	Dedup          DedupStats
+
+	// Conflicts counts the paths remote Messages have taken through conflict resolution since we started (see
+	// Accord.ConflictStats)
+	Conflicts ConflictStats
+
+	// ExpiredSwept is the number of expired Messages swept out of our sync queue since we started
+	ExpiredSwept uint64
+
+	// DivergenceEvents is the number of remote Messages, since we started, that arrived while our state differed from
+	// the remote's state when it processed them
+	DivergenceEvents uint64
+
+	// CurrentDivergence is how many remote Messages in a row have arrived diverged, since we were last known to be
+	// aligned with a remote. It's zero while we're in sync and keeps climbing for as long as we're drifting apart
+	CurrentDivergence uint64
+
+	// HeadOfLineDrops is the number of Messages moved to our dead letter queue, since we started, because they kept
+	// failing to sync and were blocking everything behind them
+	HeadOfLineDrops uint64
+
+	// ToBeSyncedHighWater and ToBeSyncedDiskBytes are our sync queue's HighWaterMark and DiskBytes (see QueueUsage).
+	// They're zero if our queue backend can't report them
+	ToBeSyncedHighWater uint64
+	ToBeSyncedDiskBytes uint64
+
+	// HistoryLock describes how long conflict resolution has held our history locked, blocking all other processing.
+	// It's empty when DisableHistory is set
+	HistoryLock HistoryLockStats
+
+	// ProcessingPaused is whether processing is paused (see Accord.PauseProcessing), and PendingProcess is how many
+	// Messages are waiting for it to be resumed
+	ProcessingPaused bool
+	PendingProcess   uint64
+
+	// Deferred is how many remote Messages we're holding back until their dependencies have been processed (see
+	// Message.DependsOn)
+	Deferred uint64
+
+	// Maintenance is how our last automatic maintenance cycle went. It's empty until one has run (see
+	// AutoMaintenanceInterval)
+	Maintenance *MaintenanceReport `json:",omitempty"`
+
+	// Scheduling is how much of our TickScheduler each scheduled component has been given, by name. It's empty unless
+	// SchedulerSlots is set
+	Scheduling map[string]SchedulerShare
+
+	// Goroutines is how many goroutines we and our Components are running, by owner (see Accord.Goroutines)
+	Goroutines map[string]int
+
+	// DiskBytes is how much disk our sync queue, history and state took up between them when we last checked, against
+	// our MaxDiskBytes, and DiskFull is whether that was over it. They're only measured when MaxDiskBytes is set
+	DiskBytes    uint64
+	MaxDiskBytes uint64
+	DiskFull     bool
+}
+
+// DivergenceEvent describes a remote Message that arrived while our state had diverged from the remote's
+type DivergenceEvent struct {
+	// MessageID is the ID of the remote Message
+	MessageID uint64
+
+	// LocalState and RemoteState are our state and the Message's StateAt when it arrived
+	LocalState  uint64
+	RemoteState uint64
+
+	// Streak is how many remote Messages in a row (including this one) have arrived diverged
+	Streak uint64
+
+	// HistorySize is how many of our own Messages we're holding on to for conflict resolution, which is roughly how
+	// many local changes the remote has yet to see
+	HistorySize uint64
+
+	// Timestamp is when the Message arrived
+	Timestamp time.Time
+}
+
+// DedupStats keeps track of how our bloom filter dedup layer is performing, so that its false positive rate can be
+// tuned. These are only kept in memory and start over when the process does
+type DedupStats struct {
+	// Checks is the number of remote Messages we've checked against our bloom filter
+	Checks uint64
+
+	// MaybeSeen is the number of checks the bloom filter couldn't rule out, each of which cost us a history scan
+	MaybeSeen uint64
+
+	// FalsePositives is the number of MaybeSeen Messages that our history scan showed we hadn't actually handled
+	FalsePositives uint64
+
+	// Duplicates is the number of remote Messages we've dropped because we had already handled them
+	Duplicates uint64
+}
+
+// ConflictStats counts how our decisions about remote Messages have gone, by the path each took. Together they show how
+// divergent our cluster is and whether our Manager's conflict resolution is firing as expected; a sudden rise in
+// Skipped, say, is worth looking into. Messages we dropped as duplicates, or held back waiting on dependencies, aren't
+// counted until they're decided on
+type ConflictStats struct {
+	// Aligned is the number of remote Messages we processed straight away because our state matched theirs
+	Aligned uint64
+
+	// Accepted is the number our Manager chose to process when our states differed, and Skipped the number it chose
+	// not to
+	Accepted uint64
+	Skipped  uint64
+
+	// NoHistory is the number we processed without asking our Manager, as our states differed but our history is
+	// disabled
+	NoHistory uint64
+
+	// Expired is the number we didn't process because they had expired by the time they arrived
+	Expired uint64
 }
 
 // Manager is where the majority of application specific logic should be stored and is generally
@@ -43,6 +187,54 @@ type Manager interface {
 	ShouldProcess(msg Message, history *HistoryIterator) bool
 }
 
+// StateContributor can optionally be implemented by a Manager that has a natural state or version of its own (a
+// monotonically increasing database version, for instance) which would make a more meaningful divergence signal than
+// our default of summing Message IDs. If it is, the value StateDelta returns for a Message is what gets folded into our
+// state when the Message is handled, in place of its ID. Divergence is detected by comparing states across nodes, so
+// every node must use the same contribution function (and it must be deterministic) or they'll never appear aligned
+type StateContributor interface {
+	StateDelta(msg Message) uint64
+}
+
+// StateRelation is how a remote Message's StateAt relates to our own state, as decided by a StateComparator
+type StateRelation int
+
+const (
+	// StateAligned means our states match, so the Message can be processed without asking our Manager
+	StateAligned StateRelation = iota
+
+	// StateDiverged means our states differ, so our Manager is asked whether the Message should be processed
+	StateDiverged
+)
+
+// StateComparator can optionally be implemented by a Manager that wants a say in how a remote Message's state is
+// compared to ours, in place of our default of exact equality. Returning StateDiverged for everything means the
+// Manager's ShouldProcess is always consulted, while a comparison that tolerates some difference lets more Messages
+// take the fast path. It only decides how remote Messages are handled (and what counts as a divergence event), our
+// history is still only cleared when states match exactly. Like StateContributor, every node must use the same
+// comparison (and it must be deterministic) or nodes will disagree about which Messages need resolving
+type StateComparator interface {
+	Compare(local, remote uint64) StateRelation
+}
+
+// StateAwareResolver can optionally be implemented by a Manager that wants to factor our live state into its conflict
+// resolution decisions ("only apply this if our state is within range of the Message's StateAt", say). If it is,
+// Accord will call ShouldProcessWithState in place of ShouldProcess (and ConflictExplainer's ShouldProcessWithReason),
+// passing a read-only view of our State. The returned reason is recorded in our ConflictLog and may be left empty
+type StateAwareResolver interface {
+	ShouldProcessWithState(msg Message, history *HistoryIterator, state StateView) (bool, string)
+}
+
+// SyncFilter can optionally be implemented by a Manager with Messages that should take effect locally without ever
+// being sent to our peers (local-only administrative commands, say). If it is, ShouldSync is asked about every new
+// Message passed to HandleNewMessage, and one it returns false for is still processed and recorded in our state and
+// history but never added to our sync queue. Relayed Messages are always synced, as that's the point of relaying them.
+// Our peers never fold a Message they don't receive into their own state, so unless the Manager is also a
+// StateContributor that contributes nothing for it, keeping a Message to ourselves leaves us looking diverged
+type SyncFilter interface {
+	ShouldSync(msg Message) bool
+}
+
 // Accord is the main struct responsible for maintaining state and coordinating
 // all goroutines that serve for synchronizing operations
 type Accord struct {
@@ -52,10 +244,280 @@ type Accord struct {
 	// etc...)
 	Logger *logrus.Entry
 
+	// Persistence controls how aggressively our sync queue and state writes are flushed to stable storage, trading
+	// throughput for how much can be lost if the machine goes down. See PersistenceMode for the details of each mode.
+	// This should be set before calling Start and defaults to PersistAsync
+	Persistence PersistenceMode
+
+	// Dedup optionally enables a bloom filter of every Message we've handled, which is used to cheaply detect (and drop)
+	// remote Messages we've already handled. This should be set before calling Start and defaults to nil (disabled)
+	Dedup *BloomConfig
+
+	// DisableHistory turns off our HistoryStack entirely, for workloads where conflicts are impossible by construction
+	// (CRDT style payloads, for instance). In this mode every remote Message is processed without consulting the
+	// Manager's ShouldProcess, nothing is written to (or even opened for) the history, and CheckRemoteState has
+	// nothing to clear. When combined with Dedup there's no history to double check the bloom filter against, so
+	// Messages it "maybe" saw are processed anyway. This should be set before calling Start
+	DisableHistory bool
+
+	// HistoryArchive optionally receives every Message before it's cleared out of our history, turning what would be
+	// transient conflict resolution history into a durable event log. HistoryArchivePolicy decides what happens when
+	// the archive fails: under ArchiveAbort (the default) our history is kept and we'll try again the next time we're
+	// aligned with a remote. These should be set before calling Start
+	HistoryArchive       ArchiveSink
+	HistoryArchivePolicy ArchivePolicy
+
+	// CompressHistory compresses each Message we write to our history, for nodes that have to retain a lot of it. This
+	// is on top of the block compression LevelDB already applies, which works across records but does poorly on the
+	// highly repetitive payloads within them. It's independent of any at-rest encryption (compression happens first).
+	// This should be set before calling Start
+	CompressHistory bool
+
+	// AtRestKey turns on AES-GCM encryption of the Messages and state we persist to disk, so that they're protected if
+	// the disk itself is stolen. It must be 16, 24 or 32 bytes (selecting AES-128, AES-192 or AES-256).
+	//
+	// Only our persisted form is encrypted. Messages are decrypted as they're read, so what our components send over
+	// the wire is the plain serialized Message and it's up to the transport to protect it. Records written before a key
+	// was set remain readable, and each encrypted record notes which key it was written with, which is what makes
+	// rotation possible: set the new key as AtRestKey and every key that may still have records on disk as
+	// PreviousAtRestKeys. New records are always written with AtRestKey, and once the queue and history have turned over
+	// (and the state has been saved again) the previous keys can be dropped. Leaving AtRestKey nil stops encrypting new
+	// records, while still decrypting with PreviousAtRestKeys. These should be set before calling Start
+	AtRestKey          []byte
+	PreviousAtRestKeys [][]byte
+
+	// MaxPayloadSize is the largest payload, in bytes, that a Message may carry. It's enforced on Messages created
+	// through our NewMessage, on every Message handed to us locally (HandleNewMessage, RelayMessage) and on every remote
+	// Message, so that a misbehaving peer can't push oversized Messages onto us. Messages we've already persisted are
+	// still read regardless, so lowering the limit never strands anything in our queue. Zero (the default) means there's
+	// no limit
+	MaxPayloadSize int
+
+	// VerifyMessageIDs has us check that every remote Message has an ID that matches its content, rejecting any that
+	// don't with ErrMessageIDMismatch. A Message's ID is derived from its timestamp and payload, so this cheaply catches
+	// corruption and tampering on the wire without the need for signatures. Messages read back from our own disk aren't
+	// checked, as they may have been created by a version of Accord whose IDs can't be derived again. This should be set
+	// before calling Start and defaults to off
+	VerifyMessageIDs bool
+
+	// AllowZeroIDs lets Messages with a zero ID through. By default they're rejected with ErrZeroMessageID wherever a
+	// Message enters us (HandleNewMessage, RelayMessage, HandleRemoteMessage) or our persistence (our sync queue and
+	// history), as a zero ID contributes nothing to our state and can't be told apart from an uninitialized Message, so
+	// letting one through would silently throw off our state and deduplication. This is only needed by applications
+	// that assign their own IDs and use zero as one of them. This should be set before calling Start
+	AllowZeroIDs bool
+
+	// IDStrategy chooses what our NewMessage derives the IDs of new Messages from, and defaults to IDSequenced.
+	// Messages that have already been created keep their IDs, whatever the strategy, as everything that goes into them
+	// travels with the Message
+	IDStrategy IDStrategy
+
+	// IDHash replaces the hash Message IDs are derived from, which defaults to SHA-256 (nil), so that a deployment can
+	// route it through a FIPS validated module, or trade it for something faster (FNV, say) as the ID doesn't need to be
+	// cryptographically strong. Only the first eight bytes of the sum are used, and shorter sums are padded with zeros,
+	// so a hash of at least 64 bits should be used to keep IDs from colliding. It's used by our NewMessage and wherever
+	// we verify IDs (see VerifyMessageIDs), so every node in a cluster must use the same hash. Changing it doesn't change
+	// the IDs of Messages that already exist. This should be set before any Messages are created
+	IDHash func() hash.Hash
+
+	// TimestampPrecision truncates the Timestamp of every Message created through our NewMessage to a multiple of
+	// itself (time.Millisecond, for instance), for applications that store or compare them somewhere that can't keep
+	// nanoseconds. A Message's ID is derived from its Timestamp, so this should be the same on every node creating
+	// Messages. Zero (the default) keeps full precision
+	TimestampPrecision time.Duration
+
+	// HistoryLockBuckets are the upper bounds, in ascending order, of the histogram in Status.HistoryLock, which sorts
+	// conflict resolutions by how long they held our history locked. Defaults to DefaultHistoryLockBuckets. This should
+	// be set before calling Start
+	HistoryLockBuckets []time.Duration
+
+	// HistoryBatchSize has our history hold on to the Messages pushed onto it, writing them out together once this
+	// many have built up, and HistoryBatchInterval (if set) writes out whatever's waiting every so often regardless. This
+	// cuts down on writes, and time spent holding our history locked, at high message rates. Anything waiting when we
+	// crash is lost from our history, though it has already been processed and queued to be synced, so the cost is a
+	// conflict resolved without it (see HistoryStack.SetBatching). Zero (the default) writes every Message as it's
+	// pushed. This should be set before calling Start
+	HistoryBatchSize     int
+	HistoryBatchInterval time.Duration
+
+	// StateBatchSize has our state kept in memory and written out once this many updates have built up, rather than on
+	// every single Message, and StateBatchInterval (if set) writes it out every so often regardless. It's always written
+	// out when we stop. Our state is a single record that changes with every Message, so this cuts write amplification
+	// on our hottest store. Updates waiting when we crash are lost, leaving our state behind what we processed, which
+	// shows up as a divergence and can be recomputed from our history and sync queue (see State.SetBatching). Under
+	// PersistSync only what's been written out is synced. Zero (the default) writes every update. This should be set
+	// before calling Start
+	StateBatchSize     int
+	StateBatchInterval time.Duration
+
+	// ShouldProcessTimeout is how long our Manager's ShouldProcess may take before we log a warning naming the Message
+	// it's stuck on, as ShouldProcess holds up all processing (and our history) while it runs. ShouldProcessTimeoutPolicy
+	// decides whether we also step in: under TimeoutWarn (the default) we only warn, otherwise the Manager's
+	// HistoryIterator stops returning Messages once the timeout is up and its decision is overridden by the policy.
+	// A Manager that's slow without iterating can't be cut short, but is still warned about. Zero (the default)
+	// disables the watchdog. These should be set before calling Start
+	ShouldProcessTimeout       time.Duration
+	ShouldProcessTimeoutPolicy TimeoutPolicy
+
+	// ExpirySweepInterval is how often we sweep expired Messages out of our sync queue. Zero (the default) disables
+	// sweeping, although expired Messages arriving from a remote are still never processed. This should be set before
+	// calling Start
+	ExpirySweepInterval time.Duration
+
+	// QueueUsageInterval is how often we check on our sync queue's footprint (see SyncQueue.Usage), warning when its
+	// item numbers are running out or it's taking up far more disk than what it holds would suggest. Either is a sign
+	// that it should be cleared (see SyncQueue.Clear) the next time it drains. Zero (the default) disables the check,
+	// although the numbers are still reported through Status. This should be set before calling Start
+	QueueUsageInterval time.Duration
+
+	// MaxDiskBytes caps how much disk our sync queue, history and state may take up between them (see StorageStats),
+	// for devices where filling the disk underneath LevelDB would corrupt it. It's measured every DiskCheckInterval
+	// (defaulting to a minute), and once it's exceeded DiskLimitAction decides what we do about it; DiskReject (the
+	// default) turns away new Messages until we're back under. Our usage is reported through Status. Zero (the default)
+	// means there's no limit. These should be set before calling Start
+	MaxDiskBytes      uint64
+	DiskCheckInterval time.Duration
+	DiskLimitAction   DiskLimitAction
+
+	// IdleShutdownTimeout shuts us down, with ShutdownIdle as the reason, once we've sat idle for this long: no new
+	// local Messages, no remote ones, and nothing left in (or leaving) our sync queue. This suits short lived workers
+	// that spin up, sync a batch and should then go away. It's checked every tenth of the timeout, so the shutdown may
+	// come up to that much late. Zero (the default) means we never shut ourselves down. This should be set before
+	// calling Start
+	IdleShutdownTimeout time.Duration
+
+	// GoroutineCheckInterval is how often we check on the goroutines we and our Components own (see Goroutines),
+	// warning should their number keep growing from one check to the next, which is a sign that something is leaking
+	// them. Zero (the default) disables the check, although they're still counted and reported through Status. This
+	// should be set before calling Start
+	GoroutineCheckInterval time.Duration
+
+	// StopGracePeriod is how long Stop waits for a Message that's in the middle of being processed (our Manager's
+	// Process running for HandleNewMessage, say) to finish before closing our stores. Once it's up we log a warning and
+	// close them anyway, out from under the Message. Zero (the default) waits as long as it takes
+	StopGracePeriod time.Duration
+
+	// CheckpointPeers names every peer that must acknowledge a checkpoint before our history is pruned up to it (see
+	// Checkpoint), and CheckpointInterval (if set) takes a checkpoint every so often. Unlike clearing our history when
+	// we happen to align with a peer, this bounds it while states keep moving, without dropping anything a peer could
+	// still need. Leave CheckpointPeers empty (the default) to never prune to a checkpoint. These should be set before
+	// calling Start
+	CheckpointPeers    []string
+	CheckpointInterval time.Duration
+
+	// OnTrace is optionally called with a Trace of how we decided what to do with a sample of the remote Messages we
+	// handle, TraceSampleRate of them (from 0 to 1), for tracking down drift in production without tracing everything.
+	// It's called once the Message has been handled, and must not call back into Accord. A TraceSampleRate of zero (the
+	// default) traces nothing. These should be set before calling Start
+	OnTrace         func(Trace)
+	TraceSampleRate float64
+
+	// ForceQuit exits the process straight away, through os.Exit, should another signal come in while Listen is
+	// already stopping us, so that an operator can hit Ctrl-C a second time to get out of a shutdown that's hung. It also
+	// covers a signal arriving while we're stopping after a Shutdown. Nothing is flushed or closed on the way out, so
+	// anything our stores were holding in memory is lost. Without it further signals are ignored until we've stopped
+	ForceQuit bool
+
+	// OnDivergence is optionally called every time a remote Message arrives while our state has diverged from the
+	// remote's, so that drifting nodes can be alerted on. It's called while we're processing the Message, so it must
+	// return quickly and must not call back into Accord. This should be set before calling Start
+	OnDivergence func(DivergenceEvent)
+
+	// ReconcileInterval is how often components that hear about our peers' states (such as GossipComponent) reconcile
+	// ours against theirs through Reconcile, pruning our history whenever we're aligned rather than waiting to happen
+	// upon it during synchronization. Zero (the default) disables it. This should be set before calling Start
+	ReconcileInterval time.Duration
+
+	// OnReconcile is optionally called with the outcome of every reconciliation, so that a diverged peer can be acted
+	// on (by triggering a targeted re-sync, for instance). Like OnDivergence it must return quickly and must not call
+	// back into Accord. This should be set before calling Start
+	OnReconcile func(ReconcileResult)
+
+	// OnQueueNonEmpty and OnQueueEmpty are optionally called when our sync queue goes from empty to having something in
+	// it and back, for driving external schedulers (scaling a sync worker up while there's a backlog and down once it's
+	// drained, say) without polling. The two always alternate, and OnQueueNonEmpty is called as soon as we start if
+	// we're starting with a backlog. With a QueueTransitionDebounce a transition is only reported once the queue has
+	// stayed that way for that long, so that a Message that's synced straight away doesn't flap, and they're called from
+	// a timer of their own. Without one they're called as the transition happens, with our queue locked, so they must
+	// return quickly and must not call back into Accord. These should be set before calling Start
+	OnQueueNonEmpty         func()
+	OnQueueEmpty            func()
+	QueueTransitionDebounce time.Duration
+
+	// DeliveryReceipts turns on a persisted log of every Message a peer acknowledges, recording who acknowledged it and
+	// when, so that delivery can be proven after the fact. This should be set before calling Start
+	DeliveryReceipts bool
+
+	// ProcessingConfirmations turns on a persisted record of which of our Messages a peer has confirmed it processed,
+	// rather than just received (see RecordConfirmation and ConfirmationStatus). This should be set before calling Start
+	ProcessingConfirmations bool
+
+	// PersistSyncCursors durably records how far each sync target (see SyncQueue.RegisterTarget) has gotten through our
+	// sync queue, so that after a restart targets carry on where they left off instead of starting back at the head and
+	// being sent Messages they've already confirmed. This should be set before calling Start
+	PersistSyncCursors bool
+
+	// ProcessPriority decides whether local or remote Messages go first when both are waiting to be processed. The
+	// default, FairInterleave, favors neither; see LocalPriority and RemotePriority for how each can starve the other
+	// side. This should be set before calling Start
+	ProcessPriority ProcessPriority
+
+	// OrderedSubmission makes concurrent local submissions (HandleNewMessage, RelayMessage) get processed, and queued to
+	// be synced, strictly in the order they were submitted. By default they're let through in whatever order the
+	// scheduler happens to wake them, which is fine when the order of concurrent submissions doesn't matter (and is
+	// slightly cheaper), but means two Messages submitted back to back from different goroutines may be queued either
+	// way around. Remote Messages are unaffected. This should be set before calling Start
+	OrderedSubmission bool
+
+	// SchedulerSlots turns on a central TickScheduler, which shares processing out fairly (or by weight) between the
+	// components that opt into it through ComponentRunner.Schedule, letting at most this many of their ticks run at once.
+	// It's meant for nodes syncing with several peers, where otherwise one busy or slow peer can crowd out the rest.
+	// Zero (the default) disables it, leaving every component to tick as fast as it likes. This should be set before
+	// calling Start
+	SchedulerSlots int
+
+	// OutboundTransformer and InboundTransformer, if set, reshape Messages as they leave for and arrive from our peers
+	// (see each for details). Both should be set before calling Start
+	OutboundTransformer OutboundTransformer
+	InboundTransformer  InboundTransformer
+
+	// MaxHeadRetries is how many times a sync component may fail to deliver the same Message (see ReportSyncFailure)
+	// before we give up on it, move it to our dead letter queue and carry on with the rest of the queue. Zero (the
+	// default) retries forever. This should be set before calling Start
+	MaxHeadRetries int
+
+	// DependencyTimeout is how long we'll hold back a remote Message waiting on the Messages it DependsOn to be
+	// processed before giving up on it and moving it to our dead letter queue. A dependency counts as processed once
+	// it's in our history, so one we handled but chose not to process (or have since pruned) is never found, and
+	// dependencies are ignored altogether when DisableHistory is set. Held back Messages are handled as soon as a
+	// remote Message satisfies them, and also checked every tenth of the timeout. Zero (the default) holds them back
+	// for as long as it takes. This should be set before calling Start
+	DependencyTimeout time.Duration
+
+	// AutoMaintenanceInterval is how often we check whether we can tidy up after ourselves: once our sync queue has
+	// drained and every peer reconciling with us (see Reconcile, which GossipComponent does every ReconcileInterval) has
+	// recently reported the same state as ours, our history is cleared and our sync queue is recreated to reclaim its
+	// disk. Which peers we've heard from is only kept in memory, so one that goes away for good holds maintenance back
+	// until we're restarted. Zero (the default) disables it. This should be set before calling Start, or see
+	// EnableAutoMaintenance
+	AutoMaintenanceInterval time.Duration
+
+	// Schemas optionally checks every Message we take in, new or from a remote, against the Schema registered for its
+	// Type (see SchemaRegistry), turning away any that don't satisfy it before they're handled. Nil (the default)
+	// leaves payloads unchecked. This should be set before calling Start
+	Schemas *SchemaRegistry
+
+	// Backends chooses the persistence engine underneath our sync queue, history, state and conflict log. Any factory
+	// left nil (the default) uses our goque/LevelDB implementation. This should be set before calling Start
+	Backends Backends
+
 	// Path to the directory where data should be stored. This should be passed in
-	// so that the user can choose where the data ges stored
+	// so that the user can choose where the data ges stored. It's created by Start if it doesn't exist
 	dataDir string
 
+	// dataDirLock holds our lock on dataDir from Start until Stop
+	dataDirLock *os.File
+
 	// The Manager implmentation that should be used for domain specific logic. This should be passed in
 	// so that the user can add application specific logic
 	manager Manager
@@ -73,37 +535,122 @@ type Accord struct {
 	history *HistoryStack
 
 	// state is used to keep track of the internal state of our process so help detect divergence
-	// with other Accord processes. It's probably a bit of overkill to use a LevelDB database to keep
-	// track of our state but it's the easiest way of creating a persisted, thread safe piece of data.
-	// We're already using LevelDB for goque (which is why we're not going with Bolt) and it's very
-	// possible we'll want to keep track of more advanced data for our state, which this will support
+	// with other Accord processes
 	state *State
 
+	// conflicts is an audit trail of every decision we've made on a remote message that arrived while our state had
+	// diverged from the remote's
+	conflicts *ConflictLog
+
+	// scheduler shares processing out between our scheduled components. It is nil unless SchedulerSlots is set
+	scheduler *TickScheduler
+
+	// checkpoints tracks the checkpoints our peers are yet to acknowledge. It's protected by processMutex
+	checkpoints *checkpointTracker
+
+	// receipts is our log of acknowledged deliveries. It is nil unless DeliveryReceipts is set
+	receipts *ReceiptLog
+
+	// confirmations is our record of processing confirmations. It is nil unless ProcessingConfirmations is set
+	confirmations *ConfirmationLog
+
+	// atRest is the keyring built from AtRestKey and PreviousAtRestKeys, shared by all of our stores. It is nil if we
+	// have neither
+	atRest *atRestKeyring
+
+	// deadLetters holds the Messages that were blocking our sync queue, or whose dependencies never arrived. It is nil
+	// unless MaxHeadRetries or DependencyTimeout is set
+	deadLetters *DeadLetterQueue
+
+	// deferred holds the remote Messages waiting on their dependencies. It's protected by processMutex
+	deferred *DependencyQueue
+
+	// pending buffers the Messages we should have processed while paused is set. paused is protected by processMutex
+	pending *PendingQueue
+	paused  bool
+
+	// headFailures counts the consecutive failures to sync the Message each sync target is stuck on, keyed by target,
+	// and headDrops is how many Messages we've dead lettered as a result. Both are protected by headLock
+	headFailures map[string]headFailure
+	headDrops    uint64
+	headLock     *sync.Mutex
+
 	// shutdown is a channel that can be used to communicate to the Accord process from a goroutine that
 	// it should shutdown. This will generally be used by Components when they encounter an unrecoverable
 	// error and the only logical course of action is to shutdown the entire application
-	shutdown chan error
+	shutdown chan *ShutdownReason
+
+	// stopReason is why Listen returned
+	stopReason *ShutdownReason
+
+	// lastActivity is when (in Unix nanoseconds) we last took in a Message, and is accessed atomically. idleQueueSize is
+	// the size of our sync queue when checkIdle last looked, and idleFired is whether it has shut us down. Both are only
+	// touched by checkIdle
+	lastActivity  int64
+	idleQueueSize uint64
+	idleFired     bool
+
+	// diskBytes is how much disk our stores took up when checkDiskUsage last looked, and diskFull whether that was over
+	// MaxDiskBytes. Both are accessed atomically. diskShutdownFired is whether checkDiskUsage has shut us down, and is
+	// only touched by it
+	diskBytes         uint64
+	diskFull          int32
+	diskShutdownFired bool
 
 	// signalChannel is used to detect when a signal comes in from the operating system
 	signalChannel chan os.Signal
 
 	// We need to make sure that we don't process more than one message at a time or else our state might
 	// get messed up
-	processMutex *sync.Mutex
+	processMutex *processLock
+
+	// dedupStats keeps track of how our bloom filter is doing. Protected by processMutex
+	dedupStats DedupStats
+
+	// conflictStats counts the paths remote Messages take through conflict resolution. Protected by processMutex
+	conflictStats ConflictStats
+
+	// peerStates is the last state each peer reported to us through Reconcile, and lastMaintenance how our last
+	// maintenance cycle went. Both protected by processMutex
+	peerStates      map[string]peerReport
+	lastMaintenance *MaintenanceReport
+
+	// divergenceEvents and divergenceStreak back the Status fields of the same names. Protected by processMutex
+	divergenceEvents uint64
+	divergenceStreak uint64
+
+	// backgroundStop and backgroundDone are used to stop our periodic background tasks (flushing in PersistBatched
+	// mode, sweeping expired messages, etc...) and wait for them to finish
+	backgroundStop chan struct{}
+	backgroundDone *sync.WaitGroup
+
+	// goroutines counts the goroutines we and our Components own (see TrackGoroutine)
+	goroutines *goroutineTracker
+
+	// queueWatch reports our sync queue's transitions to OnQueueNonEmpty and OnQueueEmpty, if either is set
+	queueWatch *queueWatch
 }
 
 // NewAccord creates a new instance of Accord for you to use. This function accepts an implementation
 // of the Manager interface, which will be called upon to do application specific logic. A list of
 // Components, so that the user what kind of synchronization strategies to use (or write his/her own).
 // The path to the directory where Accord should store its data. And a Logrus entry, so that the user
-// has fine control over how exactly logs get executed (log output, log level, hooks, etc...)
-func NewAccord(manager Manager, components []Component, dataDir string, logger *logrus.Entry) *Accord {
-	return &Accord{
+// has fine control over how exactly logs get executed (log output, log level, hooks, etc...). Any
+// further configuration can be passed in as Options, everything not configured is left at its default
+func NewAccord(manager Manager, components []Component, dataDir string, logger *logrus.Entry, options ...Option) *Accord {
+	accord := &Accord{
 		Logger:     logger,
 		dataDir:    dataDir,
 		manager:    manager,
 		components: components,
+		goroutines: newGoroutineTracker(),
 	}
+
+	for _, option := range options {
+		option(accord)
+	}
+
+	return accord
 }
 
 // Start prepares the Accord struct and then starts up its processes. We
@@ -123,43 +670,281 @@ func (accord *Accord) Start(signals ...os.Signal) (err error) {
 
 	// Our first course of action should be to setup our interrupt signals, so that
 	// if one comes in during our setup process we don't get stopped in the middle
-	accord.signalChannel = make(chan os.Signal, 1)
+	//
+	// There's room for a second signal, so that one that comes in hot on the heels of the first is still around to
+	// force us to quit (see ForceQuit) rather than being dropped
+	accord.signalChannel = make(chan os.Signal, 2)
 	if len(signals) > 0 {
 		accord.Logger.WithField("signals", signals).Info("Registering shutdown signals")
-		accord.signalChannel = make(chan os.Signal, 1)
 		signal.Notify(accord.signalChannel, signals...)
 	}
 
+	err = prepareDataDir(accord.dataDir)
+	if err != nil {
+		accord.Logger.WithError(err).Error("Invalid data directory")
+		return err
+	}
+
+	accord.dataDirLock, err = lockDataDir(accord.dataDir)
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to lock data directory")
+		return err
+	}
+
+	// If we don't make it all the way through starting up there won't be a Stop to undo what we've done, so we do it
+	// ourselves: opened holds what's needed to stop or close everything we've started or opened so far, which we run in
+	// reverse before releasing our lock, so that nothing is left running against our data directory once it's unlocked
+	var opened []func()
+	defer func() {
+		if err != nil {
+			for i := len(opened) - 1; i >= 0; i-- {
+				opened[i]()
+			}
+			accord.unlockDataDir()
+		}
+	}()
+
 	// Setup our internal variables and components
-	accord.processMutex = &sync.Mutex{}
+	accord.processMutex = newProcessLock(accord.ProcessPriority, accord.OrderedSubmission)
+
+	backends := accord.Backends.withDefaults()
 
-	accord.ToBeSynced, err = OpenSyncQueue(path.Join(accord.dataDir, SyncFilename))
+	accord.atRest, err = newAtRestKeyring(accord.AtRestKey, accord.PreviousAtRestKeys...)
 	if err != nil {
-		accord.Logger.WithError(err).Error("Unable to load synchronization queue")
+		accord.Logger.WithError(err).Error("Invalid at-rest key")
 		return err
 	}
 
-	accord.history, err = OpenHistoryStack(path.Join(accord.dataDir, HistoryFilename))
+	queue, err := backends.Queue(path.Join(accord.dataDir, SyncFilename))
 	if err != nil {
-		accord.Logger.WithError(err).Error("Unable to load history stack")
+		accord.Logger.WithError(err).Error("Unable to load synchronization queue")
 		return err
 	}
+	accord.ToBeSynced = NewSyncQueue(queue)
+	opened = append(opened, accord.ToBeSynced.Close)
+	accord.ToBeSynced.keys = accord.atRest
+	accord.ToBeSynced.allowZeroIDs = accord.AllowZeroIDs
+
+	if accord.PersistSyncCursors {
+		cursors, err := backends.State(path.Join(accord.dataDir, CursorsFilename))
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load sync cursors")
+			return err
+		}
+		accord.ToBeSynced.PersistCursors(cursors)
+	}
+
+	if !accord.DisableHistory {
+		stack, err := backends.Stack(path.Join(accord.dataDir, HistoryFilename))
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load history stack")
+			return err
+		}
+		accord.history = NewHistoryStack(stack)
+		opened = append(opened, accord.history.Close)
+		accord.history.keys = accord.atRest
+		accord.history.allowZeroIDs = accord.AllowZeroIDs
+		if accord.HistoryArchive != nil {
+			accord.history.SetArchive(accord.HistoryArchive, accord.HistoryArchivePolicy)
+		}
+		accord.history.SetCompression(accord.CompressHistory)
+		if accord.HistoryLockBuckets != nil {
+			accord.history.SetLockBuckets(accord.HistoryLockBuckets)
+		}
+		accord.history.SetBatching(accord.HistoryBatchSize)
+	}
 
-	accord.state, err = OpenState(path.Join(accord.dataDir, StateFilename))
+	db, err := backends.State(path.Join(accord.dataDir, StateFilename))
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load state")
+		return err
+	}
+	opened = append(opened, func() { db.Close() })
+	accord.state, err = newState(db, accord.atRest)
 	if err != nil {
 		accord.Logger.WithError(err).Error("Unable to load state")
 		return err
 	}
+	accord.state.inUse = true
+	opened[len(opened)-1] = func() {
+		accord.state.inUse = false
+		accord.state.Close()
+	}
+	accord.state.SetBatching(accord.StateBatchSize)
+
+	conflicts, err := backends.Queue(path.Join(accord.dataDir, ConflictLogFilename))
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load conflict log")
+		return err
+	}
+	accord.conflicts = NewConflictLog(conflicts)
+	opened = append(opened, accord.conflicts.Close)
+
+	if accord.DeliveryReceipts {
+		receipts, err := backends.Queue(path.Join(accord.dataDir, ReceiptLogFilename))
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load receipt log")
+			return err
+		}
+		accord.receipts = NewReceiptLog(receipts)
+		opened = append(opened, accord.receipts.Close)
+	}
+
+	if accord.ProcessingConfirmations {
+		confirmations, err := backends.State(path.Join(accord.dataDir, ConfirmationsFilename))
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load processing confirmations")
+			return err
+		}
+		accord.confirmations = NewConfirmationLog(confirmations)
+		opened = append(opened, accord.confirmations.Close)
+	}
+
+	pending, err := backends.Queue(path.Join(accord.dataDir, PendingFilename))
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load pending queue")
+		return err
+	}
+	accord.pending = NewPendingQueue(pending)
+	opened = append(opened, accord.pending.Close)
+	accord.pending.keys = accord.atRest
+
+	// Anything still buffered must be processed before anything new, so we pick up where we left off
+	accord.paused = accord.pending.Size() > 0
+	if accord.paused {
+		accord.Logger.WithField("pending", accord.pending.Size()).Warn("Starting with processing paused, as messages are still waiting to be processed")
+	}
+
+	accord.headFailures = map[string]headFailure{}
+	accord.headLock = &sync.Mutex{}
+	deferred, err := backends.Queue(path.Join(accord.dataDir, DeferredFilename))
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load deferred queue")
+		return err
+	}
+	accord.deferred = NewDependencyQueue(deferred)
+	opened = append(opened, accord.deferred.Close)
+	accord.deferred.keys = accord.atRest
+
+	if accord.MaxHeadRetries > 0 || accord.DependencyTimeout > 0 {
+		deadLetters, err := backends.Queue(path.Join(accord.dataDir, DeadLetterFilename))
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load dead letter queue")
+			return err
+		}
+		accord.deadLetters = NewDeadLetterQueue(deadLetters)
+		opened = append(opened, accord.deadLetters.Close)
+		accord.deadLetters.keys = accord.atRest
+	}
+
+	if accord.Dedup != nil {
+		err = accord.state.EnableBloom(*accord.Dedup)
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to load bloom filter")
+			return err
+		}
+	}
+
+	accord.checkpoints = newCheckpointTracker()
+
+	accord.shutdown = make(chan *ShutdownReason, 1)
+
+	accord.backgroundStop = make(chan struct{})
+	accord.backgroundDone = &sync.WaitGroup{}
+	opened = append(opened, accord.stopBackground)
+
+	if accord.Persistence.interval > 0 {
+		accord.Logger.WithField("interval", accord.Persistence.interval).Info("Starting background flusher")
+		accord.runEvery(accord.Persistence.interval, accord.flush)
+	}
+
+	if accord.history != nil && accord.HistoryBatchSize > 1 && accord.HistoryBatchInterval > 0 {
+		accord.Logger.WithField("interval", accord.HistoryBatchInterval).Info("Starting history batch flusher")
+		accord.runEvery(accord.HistoryBatchInterval, accord.flushHistory)
+	}
+
+	if accord.StateBatchSize > 1 && accord.StateBatchInterval > 0 {
+		accord.Logger.WithField("interval", accord.StateBatchInterval).Info("Starting state batch saver")
+		accord.runEvery(accord.StateBatchInterval, accord.saveState)
+	}
+
+	if accord.ExpirySweepInterval > 0 {
+		accord.Logger.WithField("interval", accord.ExpirySweepInterval).Info("Starting expired message sweeper")
+		accord.runEvery(accord.ExpirySweepInterval, accord.sweepExpired)
+	}
 
-	accord.shutdown = make(chan error, 1)
+	if accord.QueueUsageInterval > 0 {
+		accord.Logger.WithField("interval", accord.QueueUsageInterval).Info("Starting sync queue usage checks")
+		accord.runEvery(accord.QueueUsageInterval, accord.checkQueueUsage)
+	}
+
+	if accord.CheckpointInterval > 0 {
+		accord.Logger.WithField("interval", accord.CheckpointInterval).Info("Starting checkpoints")
+		accord.runEvery(accord.CheckpointInterval, accord.takeCheckpoint)
+	}
+
+	atomic.StoreUint64(&accord.diskBytes, 0)
+	atomic.StoreInt32(&accord.diskFull, 0)
+	accord.diskShutdownFired = false
+	if accord.MaxDiskBytes > 0 {
+		if accord.DiskCheckInterval == 0 {
+			accord.DiskCheckInterval = time.Minute
+		}
+		accord.Logger.WithField("interval", accord.DiskCheckInterval).WithField("limit", accord.MaxDiskBytes).Info("Starting disk usage checks")
+
+		// Check straight away, so that we don't take on anything if we're starting out over our limit
+		accord.checkDiskUsage()
+		accord.runEvery(accord.DiskCheckInterval, accord.checkDiskUsage)
+	}
+
+	if accord.GoroutineCheckInterval > 0 {
+		accord.Logger.WithField("interval", accord.GoroutineCheckInterval).Info("Starting goroutine checks")
+		accord.runEvery(accord.GoroutineCheckInterval, accord.checkGoroutines)
+	}
+
+	if accord.DependencyTimeout > 0 {
+		accord.Logger.WithField("timeout", accord.DependencyTimeout).Info("Starting deferred message sweeper")
+		accord.runEvery(accord.DependencyTimeout/10, accord.sweepDeferred)
+	}
+
+	if accord.AutoMaintenanceInterval > 0 {
+		accord.Logger.WithField("interval", accord.AutoMaintenanceInterval).Info("Starting automatic maintenance")
+		accord.runEvery(accord.AutoMaintenanceInterval, accord.runMaintenance)
+	}
+
+	accord.touch()
+	accord.idleQueueSize = accord.ToBeSynced.Size()
+	accord.idleFired = false
+	if accord.IdleShutdownTimeout > 0 {
+		accord.Logger.WithField("timeout", accord.IdleShutdownTimeout).Info("Starting idle shutdown monitor")
+		accord.runEvery(accord.IdleShutdownTimeout/10+1, accord.checkIdle)
+	}
+
+	if accord.SchedulerSlots > 0 {
+		accord.scheduler = NewTickScheduler(accord.SchedulerSlots)
+	}
+
+	if accord.OnQueueNonEmpty != nil || accord.OnQueueEmpty != nil {
+		accord.queueWatch = newQueueWatch(accord.QueueTransitionDebounce, accord.OnQueueNonEmpty, accord.OnQueueEmpty)
+		accord.ToBeSynced.WatchEmpty(accord.queueWatch.transition)
+		opened = append(opened, accord.stopQueueWatch)
+	}
 
 	accord.Logger.Info("Starting components")
-	// Iterate over all of our passed in components and start them up one by one
+	// Iterate over all of our passed in components and start them up one by one. Should one fail, those we've already
+	// started are stopped again, latest first, before anything they depend on is closed
 	for _, comp := range accord.components {
-		err := comp.Start(accord)
+		err = comp.Start(accord)
 		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to start a component, stopping those already started")
 			return err
 		}
+
+		comp := comp
+		opened = append(opened, func() {
+			comp.Stop(0)
+			comp.WaitForStop()
+		})
 	}
 
 	return
@@ -179,91 +964,562 @@ func (accord *Accord) Stop() {
 		comp.WaitForStop()
 	}
 
+	accord.stopBackground()
+
+	// Make sure nobody is part way through processing a Message before we close our stores out from under them. We only
+	// do this once our components have stopped, as they may be waiting on the lock themselves
+	if accord.processMutex != nil {
+		if accord.processMutex.LockTimeout(accord.StopGracePeriod) {
+			defer accord.processMutex.Unlock()
+		} else {
+			accord.Logger.WithField("grace", accord.StopGracePeriod).
+				Warn("A message was still being processed when our stop grace period ran out, closing our stores anyway")
+		}
+	}
+
+	accord.stopQueueWatch()
+
 	accord.Logger.Info("Closing disk connections")
 	accord.ToBeSynced.Close()
-	accord.history.Close()
+	if accord.history != nil {
+		accord.history.Close()
+	}
+	accord.state.inUse = false
 	accord.state.Close()
+	accord.conflicts.Close()
+	accord.pending.Close()
+	accord.deferred.Close()
+	if accord.receipts != nil {
+		accord.receipts.Close()
+	}
+	if accord.confirmations != nil {
+		accord.confirmations.Close()
+	}
+	if accord.deadLetters != nil {
+		accord.deadLetters.Close()
+	}
+
+	accord.unlockDataDir()
 }
 
-// Listen simply listens on our interrupt channels and hangs until one comes in. If one does,
-// the Accord process is closed down cleanly
-func (accord *Accord) Listen() error {
-	select {
-	case <-accord.signalChannel:
-		accord.Logger.Info("Received OS signal")
-		accord.Stop()
-		return nil
+// stopBackground stops the background tasks started through runEvery and waits for them to finish, if they're running
+func (accord *Accord) stopBackground() {
+	if accord.backgroundStop == nil {
+		return
+	}
 
-	case err := <-accord.shutdown:
-		accord.Logger.WithError(err).Warn("Shutting down due to error")
-		accord.Stop()
-		return err
+	accord.Logger.Info("Stopping background tasks")
+	close(accord.backgroundStop)
+	accord.backgroundDone.Wait()
+	accord.backgroundStop = nil
+
+	// Make sure anything written since our last batch makes it out
+	if accord.Persistence.interval > 0 {
+		accord.flush()
 	}
 }
 
-// Shutdown provides a mechanism from which components and goroutines can trigger a shutdown
-// of Accord if they are in an unrecoverable state.
-func (accord *Accord) Shutdown(err error) {
-	accord.Logger.WithError(err).Warn("Accord is shutting down with error")
-	accord.shutdown <- err
-	accord.Logger.Debug("Accord sent shutdown signal")
+// stopQueueWatch stops reporting transitions of our sync queue (see OnQueueNonEmpty), if we are
+func (accord *Accord) stopQueueWatch() {
+	if accord.queueWatch == nil {
+		return
+	}
 
+	accord.ToBeSynced.WatchEmpty(nil)
+	accord.queueWatch.stop()
+	accord.queueWatch = nil
 }
 
-// StartAndListen is a wrapper around the Init and Start functions, allowing for
-// the user to completely begin the process with one function call
-func (accord *Accord) StartAndListen(signals ...os.Signal) error {
-	err := accord.Start(signals...)
-	if err != nil {
-		return err
+// unlockDataDir releases the lock on our data directory taken by Start, if we're holding it
+func (accord *Accord) unlockDataDir() {
+	if accord.dataDirLock != nil {
+		accord.dataDirLock.Close()
+		accord.dataDirLock = nil
 	}
+}
 
-	err = accord.Listen()
+// runEvery runs the passed in task in the background on the given interval until Stop is called
+func (accord *Accord) runEvery(interval time.Duration, task func()) {
+	accord.backgroundDone.Add(1)
+	release := accord.TrackGoroutine("accord")
+	go func() {
+		defer accord.backgroundDone.Done()
+		defer release()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				task()
+			case <-accord.backgroundStop:
+				return
+			}
+		}
+	}()
+}
+
+// flushHistory writes out whatever Messages our history is holding on to from a batch
+func (accord *Accord) flushHistory() {
+	err := accord.history.Flush()
 	if err != nil {
-		return err
+		accord.Logger.WithError(err).Warn("Could not write out our batched history")
 	}
-
-	return nil
 }
 
-// HandleNewMessage processes a newly created message and adds it to our queue to be
-// synchronized
-func (accord *Accord) HandleNewMessage(msg *Message) error {
+// saveState writes out whatever updates our state is holding on to from a batch
+func (accord *Accord) saveState() {
 	accord.processMutex.Lock()
 	defer accord.processMutex.Unlock()
 
-	accord.Logger.Debug("Processing a new message")
-	err := accord.manager.Process(*msg, false)
+	err := accord.state.Save()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not write out our batched state")
+	}
+}
+
+// sweepExpired takes any expired messages out of our sync queue so that we don't waste bandwidth sending them
+func (accord *Accord) sweepExpired() {
+	removed, err := accord.ToBeSynced.RemoveExpired(time.Now().UTC())
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not sweep expired messages from our sync queue")
+	}
+	if removed > 0 {
+		accord.Logger.WithField("count", removed).Info("Swept expired messages from our sync queue")
+	}
+}
+
+const (
+	// queueHighWaterWarning is the item number past which we warn that our sync queue is running out of them. goque's
+	// item numbers are a uint64, so this is a long way off, but once they run out the queue is unusable
+	queueHighWaterWarning = uint64(1) << 62
+
+	// We warn when our sync queue takes up more than queueFootprintRatio times the size of what it holds on disk, once
+	// it's big enough (queueFootprintFloor) for that to matter. LevelDB carries some overhead of its own, so a mostly
+	// empty queue is always many times bigger than its contents
+	queueFootprintRatio = 10
+	queueFootprintFloor = 64 << 20
+)
+
+// checkQueueUsage warns if our sync queue is approaching the end of its item numbers, or has grown far beyond what it
+// holds
+func (accord *Accord) checkQueueUsage() {
+	usage, err := accord.ToBeSynced.Usage()
+	if err == ErrQueueUnsupported {
+		return
+	}
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not check our sync queue's usage")
+		return
+	}
+
+	log := accord.Logger.WithFields(logrus.Fields{
+		"size":      usage.Length,
+		"bytes":     usage.Bytes,
+		"highWater": usage.HighWaterMark,
+		"diskBytes": usage.DiskBytes,
+	})
+	if usage.HighWaterMark >= queueHighWaterWarning {
+		log.Warn("Our sync queue is running out of item numbers, it should be cleared once it has drained")
+	}
+	if usage.DiskBytes >= queueFootprintFloor && usage.DiskBytes/queueFootprintRatio > usage.Bytes {
+		log.Warn("Our sync queue is taking up far more disk than it holds, it should be cleared once it has drained")
+	}
+}
+
+// touch records that something just happened, resetting our idle timer (see IdleShutdownTimeout)
+func (accord *Accord) touch() {
+	atomic.StoreInt64(&accord.lastActivity, time.Now().UnixNano())
+}
+
+// checkIdle shuts us down if we've been idle for IdleShutdownTimeout. Our sync queue changing size counts as activity,
+// as that's how we see Messages being synced to our peers, and we're never idle while it holds anything
+func (accord *Accord) checkIdle() {
+	if accord.idleFired {
+		return
+	}
+
+	size := accord.ToBeSynced.Size()
+	if size != accord.idleQueueSize {
+		accord.idleQueueSize = size
+		accord.touch()
+		return
+	}
+	if size > 0 {
+		return
+	}
+
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&accord.lastActivity)))
+	if idle < accord.IdleShutdownTimeout {
+		return
+	}
+
+	// We mustn't block here, as Stop waits for us. If a shutdown is already waiting to be picked up there's no need for
+	// another
+	accord.Logger.WithField("idle", idle).Info("Idle for too long, shutting down")
+	accord.idleFired = true
+	select {
+	case accord.shutdown <- &ShutdownReason{Category: ShutdownIdle}:
+	default:
+	}
+}
+
+// flush forces both our sync queue and our state out to stable storage. Failing to flush doesn't mean we've lost any
+// data (yet), so we only log the error rather than shutting down
+func (accord *Accord) flush() {
+	err := accord.ToBeSynced.Flush()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not flush our sync queue")
+	}
+
+	err = accord.state.Flush()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not flush our state")
+	}
+}
+
+// flushDurable is flush for HandleNewMessageDurable, which can't take a failure lightly. It also writes out anything
+// our state is holding on to from a batch. processMutex must be held by the caller
+func (accord *Accord) flushDurable() error {
+	err := accord.state.Save()
 	if err != nil {
-		accord.Logger.WithError(err).Warn("The manager had an error while processing a message. The safest thing to do is to blow ourselves up")
-		accord.Shutdown(err)
 		return err
 	}
 
-	err = accord.state.Update(msg)
+	err = accord.ToBeSynced.Flush()
 	if err != nil {
-		accord.Logger.WithError(err).Warn("We could not update our internal state. Blowing up our application")
-		accord.Shutdown(err)
 		return err
 	}
+	return accord.state.Flush()
+}
 
-	err = accord.ToBeSynced.Enqueue(msg)
+// Listen simply listens on our interrupt channels and hangs until one comes in. If one does,
+// the Accord process is closed down cleanly. If we were shut down through Shutdown the error we return
+// is a *ShutdownReason, describing what went wrong
+func (accord *Accord) Listen() error {
+	select {
+	case <-accord.signalChannel:
+		return accord.stopForSignal()
+
+	case reason := <-accord.shutdown:
+		return accord.stopFor(reason)
+	}
+}
+
+// ListenWithTick behaves just like Listen, but also calls fn every interval while we're waiting, giving embedders a
+// hook for periodic work (health checks, pushing metrics, etc...) without replicating our select loop. fn runs in the
+// same goroutine as Listen, so a slow fn delays our response to signals and shutdowns and should be avoided
+func (accord *Accord) ListenWithTick(interval time.Duration, fn func(*Accord)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-accord.signalChannel:
+			return accord.stopForSignal()
+
+		case reason := <-accord.shutdown:
+			return accord.stopFor(reason)
+
+		case <-ticker.C:
+			fn(accord)
+		}
+	}
+}
+
+// stopForSignal stops us after an OS signal has come in. We return nil, as being asked to stop isn't an error
+func (accord *Accord) stopForSignal() error {
+	accord.Logger.Info("Received OS signal")
+	accord.stopReason = &ShutdownReason{Category: ShutdownSignal}
+	accord.stopWatchingSignals()
+	return nil
+}
+
+// stopFor stops us after a Shutdown, returning the reason we were given
+func (accord *Accord) stopFor(reason *ShutdownReason) error {
+	accord.Logger.WithError(reason.Err).WithField("category", reason.Category.String()).WithField("trigger", reason.Component).Warn("Shutting down due to error")
+	accord.stopReason = reason
+	accord.stopWatchingSignals()
+	return reason
+}
+
+// exit is how ForceQuit leaves the process, swapped out by our tests
+var exit = os.Exit
+
+// stopWatchingSignals stops us, exiting on the spot should a signal come in while we do if ForceQuit is set
+func (accord *Accord) stopWatchingSignals() {
+	if !accord.ForceQuit {
+		accord.Stop()
+		return
+	}
+
+	done := make(chan struct{})
+	release := accord.TrackGoroutine("accord")
+	go func() {
+		defer release()
+		select {
+		case sig := <-accord.signalChannel:
+			accord.Logger.WithField("signal", sig).Warn("Received another signal while stopping, forcing our exit")
+			exit(1)
+		case <-done:
+		}
+	}()
+
+	accord.Stop()
+	close(done)
+}
+
+// StopReason returns why Listen returned, or nil if it hasn't. Unlike Listen's return this is also set when we were
+// stopped by an OS signal
+func (accord *Accord) StopReason() *ShutdownReason {
+	return accord.stopReason
+}
+
+// Shutdown provides a mechanism from which components and goroutines can trigger a shutdown
+// of Accord if they are in an unrecoverable state. If err is a *ShutdownReason it's passed along as is,
+// otherwise the shutdown is categorized as ShutdownUnknown. Prefer ShutdownWith where the cause is known
+func (accord *Accord) Shutdown(err error) {
+	reason, ok := err.(*ShutdownReason)
+	if !ok {
+		reason = &ShutdownReason{Category: ShutdownUnknown, Err: err}
+	}
+
+	accord.Logger.WithError(err).WithField("category", reason.Category.String()).Warn("Accord is shutting down with error")
+	accord.shutdown <- reason
+	accord.Logger.Debug("Accord sent shutdown signal")
+
+}
+
+// ShutdownWith is Shutdown along with what kind of failure triggered it and, if it was a Component, which one
+func (accord *Accord) ShutdownWith(category ShutdownCategory, component string, err error) {
+	accord.Shutdown(&ShutdownReason{Category: category, Err: err, Component: component})
+}
+
+// fail shuts us down over err and returns the *ShutdownReason, so that whoever called us can tell (with
+// errors.Is(err, ErrShuttingDown)) that we're going away rather than that their Message was rejected
+func (accord *Accord) fail(category ShutdownCategory, err error) error {
+	reason := &ShutdownReason{Category: category, Err: err}
+	accord.Shutdown(reason)
+	return reason
+}
+
+// StartAndListen is a wrapper around the Init and Start functions, allowing for
+// the user to completely begin the process with one function call
+func (accord *Accord) StartAndListen(signals ...os.Signal) error {
+	err := accord.Start(signals...)
 	if err != nil {
-		accord.Logger.WithError(err).Warn("Could not save new message to our queue")
-		accord.Shutdown(err)
 		return err
 	}
 
-	err = accord.history.Push(msg)
+	err = accord.Listen()
 	if err != nil {
-		accord.Logger.WithError(err).Warn("Could not save our new message in our stack")
-		accord.Shutdown(err)
 		return err
 	}
 
 	return nil
 }
 
+// HandleResult describes what became of a newly created Message
+type HandleResult struct {
+	// MessageID is the ID of the Message
+	MessageID uint64
+
+	// Duplicate is set when the Message wasn't handled at all because an identical one already had been, in which case
+	// the rest of the result describes the original. Accord handles every Message it's given, so this is only set by
+	// callers that deduplicate before handing Messages to us (such as WebReceiver, through its DedupWindow)
+	Duplicate bool
+
+	// State is our state once the Message was handled
+	State uint64
+
+	// QueuePosition is how many Messages were ahead of it in our sync queue once it was enqueued
+	QueuePosition uint64
+
+	// LocalOnly is set when our Manager's SyncFilter kept the Message out of our sync queue, in which case it has no
+	// QueuePosition
+	LocalOnly bool
+}
+
+// NewMessage crafts a new Message using the passed in payload, as the package level NewMessage does but following our
+// MaxPayloadSize, IDStrategy, IDHash and TimestampPrecision
+func (accord *Accord) NewMessage(payload []byte) (*Message, error) {
+	err := checkPayloadSize(payload, accord.MaxPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+	return newMessage(payload, accord.IDStrategy, accord.IDHash, accord.TimestampPrecision)
+}
+
+// HandleNewMessage processes a newly created message and adds it to our queue to be
+// synchronized
+func (accord *Accord) HandleNewMessage(msg *Message) error {
+	_, err := accord.handleLocal(msg, true, false)
+	return err
+}
+
+// HandleNewMessageWithResult is HandleNewMessage, also describing what became of the Message
+func (accord *Accord) HandleNewMessageWithResult(msg *Message) (HandleResult, error) {
+	return accord.handleLocal(msg, true, false)
+}
+
+// HandleNewMessageDurable is HandleNewMessage, only returning once the Message is on stable storage in both our state
+// and sync queue, whatever our Persistence, so that not even the machine going down straight after can lose it. Any
+// state updates we're holding on to from a batch (see StateBatchSize) are written out along with it. The price is a
+// disk flush of each store on every call, which holds up all other processing while it happens and can easily take
+// milliseconds (or far longer on a busy disk), so it's best kept for the Messages that need the guarantee. An error
+// flushing is returned, as the Message has been handled but may not have been made durable
+func (accord *Accord) HandleNewMessageDurable(msg *Message) error {
+	_, err := accord.handleLocal(msg, true, true)
+	return err
+}
+
+// RelayMessage adds a newly created message to our queue to be synchronized *without* processing it locally, for
+// relay or gateway nodes that forward commands without acting on them. Our state and history are still updated just as
+// if we had processed it, so that divergence tracking stays consistent with our peers
+func (accord *Accord) RelayMessage(msg *Message) error {
+	_, err := accord.handleLocal(msg, false, false)
+	return err
+}
+
+// RelayMessageWithResult is RelayMessage, also describing what became of the Message
+func (accord *Accord) RelayMessageWithResult(msg *Message) (HandleResult, error) {
+	return accord.handleLocal(msg, false, false)
+}
+
+// handleLocal is the shared implementation of HandleNewMessage and RelayMessage, only handing the message to our
+// Manager if process is set, and making sure it's on stable storage before we return if durable is
+func (accord *Accord) handleLocal(msg *Message, process, durable bool) (HandleResult, error) {
+	err := checkMessageID(msg, accord.AllowZeroIDs)
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Rejecting a new message")
+		return HandleResult{}, err
+	}
+
+	err = checkPayloadSize(msg.Payload, accord.MaxPayloadSize)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("size", len(msg.Payload)).Warn("Rejecting a new message")
+		return HandleResult{}, err
+	}
+
+	err = accord.CheckSchema(msg)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("type", msg.Type).Warn("Rejecting a new message that doesn't satisfy its schema")
+		return HandleResult{}, err
+	}
+
+	err = accord.checkDiskFull()
+	if err != nil {
+		accord.Logger.WithField("id", msg.ID).Debug("Rejecting a new message, we're over our disk limit")
+		return HandleResult{}, err
+	}
+
+	accord.touch()
+
+	accord.processMutex.LockLocal()
+	defer accord.processMutex.Unlock()
+
+	// Stamp the Message with our Lamport clock before anyone sees it. UpdateWith moves our clock up to match
+	msg.Clock = accord.state.GetClock() + 1
+
+	// Our Manager is the one thing we can't take back, so it goes first, and if it fails nothing else has been touched.
+	// From there each step is undone should a later one fail. Our sync queue goes last, as once a Message has been queued
+	// it may be sent to our peers at any moment
+	buffered := false
+	if process && accord.paused {
+		err := accord.buffer(msg, false)
+		if err != nil {
+			return HandleResult{}, err
+		}
+		buffered = true
+	} else if process {
+		accord.Logger.Debug("Processing a new message")
+		err := accord.manager.Process(*msg, false)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("The manager had an error while processing a message. The safest thing to do is to blow ourselves up")
+			return HandleResult{}, accord.fail(ShutdownManager, err)
+		}
+	} else {
+		accord.Logger.Debug("Relaying a new message")
+	}
+
+	undo, err := accord.state.update(msg, accord.stateDelta(msg))
+	if err != nil {
+		accord.Logger.WithError(err).Warn("We could not update our internal state. Blowing up our application")
+		accord.unwindLocal(msg, nil, false, buffered)
+		return HandleResult{}, accord.fail(ShutdownStorage, err)
+	}
+
+	if !accord.DisableHistory {
+		err = accord.history.Push(msg)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not save our new message in our stack")
+			accord.unwindLocal(msg, &undo, false, buffered)
+			return HandleResult{}, accord.fail(ShutdownStorage, err)
+		}
+	}
+
+	result := HandleResult{MessageID: msg.ID, State: accord.state.GetCurrent()}
+	if process && !accord.shouldSync(msg) {
+		accord.Logger.WithField("id", msg.ID).Debug("Keeping a new message to ourselves")
+		result.LocalOnly = true
+	} else {
+		err = accord.ToBeSynced.Enqueue(msg)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not save new message to our queue")
+			accord.unwindLocal(msg, &undo, !accord.DisableHistory, buffered)
+			return HandleResult{}, accord.fail(ShutdownStorage, err)
+		}
+
+		// Our Message may already have been synced by the time we look, in which case nothing is ahead of it
+		result.QueuePosition = accord.ToBeSynced.Size()
+		if result.QueuePosition > 0 {
+			result.QueuePosition--
+		}
+	}
+
+	if durable {
+		err = accord.flushDurable()
+		if err != nil {
+			accord.Logger.WithError(err).WithField("id", msg.ID).Warn("Could not make a durable message durable")
+			return result, err
+		}
+	} else if accord.Persistence.sync {
+		accord.flush()
+	}
+
+	return result, nil
+}
+
+// unwindLocal takes back whatever handleLocal had already done with a Message when one of its later steps fails, so
+// that our state, history and buffer all agree that it was never handled (our Manager, if it processed it, can't be
+// undone). If something can't be taken back we're left inconsistent, which we can only report, as we're about to shut
+// down anyway. processMutex must be held by the caller
+func (accord *Accord) unwindLocal(msg *Message, undo *stateUndo, pushed, buffered bool) {
+	logger := accord.Logger.WithField("id", msg.ID)
+
+	if pushed {
+		_, err := accord.history.Pop()
+		if err != nil {
+			logger.WithError(err).Error("Could not take a failed message back out of our history")
+		}
+	}
+
+	if undo != nil {
+		err := accord.state.revert(*undo)
+		if err != nil {
+			logger.WithError(err).Error("Could not take a failed message back out of our state, it no longer matches our history")
+		}
+	}
+
+	if buffered {
+		err := accord.pending.removeLast()
+		if err != nil {
+			logger.WithError(err).Error("Could not take a failed message back out of our buffer, it will be processed on resume")
+		}
+	}
+}
+
 // HandleRemoteMessage is responsible for taking a message from a remote client and updating ourselves
 // to be in sync with it. It does this by checking to see if we've diverged from the remote, checking
 // if our Manager thinks processing the message will cause some sort of collision with a change it has
@@ -271,66 +1527,283 @@ func (accord *Accord) HandleNewMessage(msg *Message) error {
 // internal state to indicate that we handled this specific message (which will help with detecting
 // divergences in the future)
 func (accord *Accord) HandleRemoteMessage(msg *Message) error {
-	accord.processMutex.Lock()
+	_, err := accord.HandleRemoteMessageWithResult(msg)
+	return err
+}
+
+// RemoteResult describes what became of a remote Message
+type RemoteResult struct {
+	// Processed is set when our Manager processed the Message. It isn't when we decided against processing it (it was
+	// a duplicate, had expired, or lost out in conflict resolution) or buffered it because processing is paused
+	Processed bool
+
+	// Deferred is set when we're holding the Message back until the Messages it DependsOn have been processed. It's
+	// handled (and, most likely, processed) once they have been
+	Deferred bool
+}
+
+// HandleRemoteMessageWithResult is HandleRemoteMessage, also describing what became of the Message
+func (accord *Accord) HandleRemoteMessageWithResult(msg *Message) (RemoteResult, error) {
+	if accord.OnTrace == nil || !accord.sampleTrace() {
+		return accord.handleRemote(msg, nil)
+	}
+
+	trace := &Trace{}
+	result, err := accord.handleRemote(msg, trace)
+	accord.OnTrace(*trace)
+	return result, err
+}
+
+// handleRemote is the shared implementation of HandleRemoteMessageWithResult and HandleRemoteMessageTraced, recording
+// each step of our decision in trace if we're given one
+func (accord *Accord) handleRemote(msg *Message, trace *Trace) (RemoteResult, error) {
+	// Filling in a trace is cheap enough to always do, but noting every Message our Manager examines is only worth it
+	// when somebody is going to look
+	traced := trace != nil
+	if !traced {
+		trace = &Trace{}
+	}
+
+	// These are checked against the Message as it arrived, before our InboundTransformer gets the chance to change it
+	err := checkPayloadSize(msg.Payload, accord.MaxPayloadSize)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("size", len(msg.Payload)).Warn("Rejecting a remote message")
+		return RemoteResult{}, err
+	}
+
+	if accord.VerifyMessageIDs {
+		err = msg.verifyID(accord.IDHash)
+		if err != nil {
+			accord.Logger.WithError(err).WithField("id", msg.ID).Warn("Rejecting a remote message")
+			return RemoteResult{}, err
+		}
+	}
+
+	msg, err = accord.transformInbound(msg)
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not transform a remote message, rejecting it")
+		return RemoteResult{}, err
+	}
+
+	trace.MessageID = msg.ID
+	trace.RemoteState = msg.StateAt
+
+	err = checkMessageID(msg, accord.AllowZeroIDs)
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Rejecting a remote message")
+		return RemoteResult{}, err
+	}
+
+	err = accord.CheckSchema(msg)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("type", msg.Type).Warn("Rejecting a remote message that doesn't satisfy its schema")
+		return RemoteResult{}, err
+	}
+
+	accord.touch()
+
+	accord.processMutex.LockRemote()
 	defer accord.processMutex.Unlock()
 
+	result, err := accord.applyRemote(msg, trace, traced)
+	if err != nil {
+		return result, err
+	}
+
+	// Whatever we just processed may be what a Message we've been holding back was waiting on
+	if result.Processed && accord.deferred.Size() > 0 {
+		err = accord.releaseDeferred()
+	}
+	return result, err
+}
+
+// applyRemote is the part of handleRemote that happens once we hold processMutex, and is also how Messages we've held
+// back are handled once their dependencies have been (see releaseDeferred). processMutex must be held by the caller
+func (accord *Accord) applyRemote(msg *Message, trace *Trace, traced bool) (RemoteResult, error) {
 	accord.Logger.Debug("Handling a remote message")
 
+	if accord.Dedup != nil {
+		duplicate, err := accord.isDuplicate(msg)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not search our history for a duplicate. Blowing up our application")
+			return RemoteResult{}, accord.fail(ShutdownStorage, err)
+		}
+		if duplicate {
+			accord.Logger.WithField("id", msg.ID).Debug("Dropping a remote message we've already handled")
+			trace.Path = TraceDuplicate
+			return RemoteResult{}, nil
+		}
+	}
+
+	// A Message that depends on others we're yet to process doesn't make sense yet, so it's held back, without
+	// touching our state, until they have been. Without a history we have no way of knowing what we've processed
+	if len(msg.DependsOn) > 0 && !accord.DisableHistory {
+		missing, err := accord.missingDependencies(msg)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not search our history for dependencies. Blowing up our application")
+			return RemoteResult{}, accord.fail(ShutdownStorage, err)
+		}
+		if len(missing) > 0 {
+			err = accord.deferMessage(msg, missing)
+			if err != nil {
+				return RemoteResult{}, err
+			}
+			trace.Path = TraceDeferred
+			return RemoteResult{Deferred: true}, nil
+		}
+	}
+
+	trace.LocalState = accord.state.GetCurrent()
+	relation := accord.compareStates(trace.LocalState, msg.StateAt)
+	trace.Relation = relation
+	if relation == StateDiverged {
+		accord.diverged(msg)
+	} else {
+		accord.divergenceStreak = 0
+	}
+
 	// We first need to determine if this is something we even *should* process
 	var shouldProcess bool
-	if accord.state.GetCurrent() == msg.StateAt {
+	if msg.Expired(time.Now().UTC()) {
+		// There's no point in applying a message that's past its expiration, but we still treat it as handled
+		// below so that our state stays aligned with the remote's
+		accord.Logger.WithField("id", msg.ID).Debug("Remote message has expired, choosing not to process it")
+		shouldProcess = false
+		trace.Path = TraceExpired
+		accord.conflictStats.Expired++
+	} else if relation == StateAligned {
 		// If our state matches the state the message was in when it was processed remotely than we automatically
 		// know we need to process it
 		accord.Logger.Debug("Our state and the remote state are synchronized, will perform the operation")
 		shouldProcess = true
+		trace.Path = TraceAligned
+		accord.conflictStats.Aligned++
+	} else if accord.DisableHistory {
+		// Without a history there are no conflicts to resolve, so we always process
+		accord.Logger.Debug("History is disabled, will perform the operation")
+		shouldProcess = true
+		trace.Path = TraceNoHistory
+		accord.conflictStats.NoHistory++
 	} else {
-		it := createHistoryIterator(accord.history)
-		if accord.manager.ShouldProcess(*msg, it) {
+		var reason string
+		shouldProcess, reason = accord.resolveConflict(msg, trace, traced)
+		trace.Path = TraceResolved
+		trace.Decision = shouldProcess
+		trace.Reason = reason
+
+		if shouldProcess {
 			// If our state has diverged from the remote than we need to ask our Manager if it thinks it's safe
 			// to process this message or it it will cause a collision with our update history
 			accord.Logger.Debug("Our manager told us this is a process that should be processed")
-			shouldProcess = true
+			accord.conflictStats.Accepted++
 		} else {
 			// If both the previous conditions failed than we just want to ignore this particular message
 			accord.Logger.Debug("Choosing not to process this message")
-			shouldProcess = false
+			accord.conflictStats.Skipped++
+		}
+
+		// Either way, we keep a record of the decision so that it can be audited later
+		err := accord.conflicts.Record(ConflictRecord{
+			MessageID:   msg.ID,
+			Processed:   shouldProcess,
+			Timestamp:   time.Now().UTC(),
+			LocalState:  accord.state.GetCurrent(),
+			RemoteState: msg.StateAt,
+			Reason:      reason,
+		})
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not record our conflict resolution. Blowing up our application")
+			return RemoteResult{}, accord.fail(ShutdownStorage, err)
 		}
-		it.close()
 	}
 
 	// If we determined that we want to process this message than send it over to the Manager to do some application
-	// specific operation with the data
-	if shouldProcess {
+	// specific operation with the data (or hold on to it until we're resumed, if we're paused)
+	buffered := false
+	if shouldProcess && accord.paused {
+		err := accord.buffer(msg, true)
+		if err != nil {
+			return RemoteResult{}, err
+		}
+		buffered = true
+	} else if shouldProcess {
 		accord.Logger.Debug("Processing remote message")
 		err := accord.manager.Process(*msg, true)
 		if err != nil {
 			accord.Logger.WithError(err).Warn("The manager had an error while processing a message. The safest thing to do is to blow ourselves up")
-			accord.Shutdown(err)
-			return err
+			return RemoteResult{}, accord.fail(ShutdownManager, err)
 		}
 	}
 
 	// Regardless of whether we actually processed the message or not we want to update our state to indicate that this specific message
 	// was handled
-	err := accord.state.Update(msg)
+	err := accord.state.UpdateWith(msg, accord.stateDelta(msg))
 	if err != nil {
 		accord.Logger.WithError(err).Warn("We could not update our internal state. Blowing up our application")
-		accord.Shutdown(err)
-		return err
+		return RemoteResult{}, accord.fail(ShutdownStorage, err)
 	}
 
 	// Our history stack really only makes sense for keeping track of those messages we actually processed, as we only use it to resolve
 	// conflicts and you should never have a conflict with a message you *didn't* perform
-	if shouldProcess {
+	if shouldProcess && !accord.DisableHistory {
 		err = accord.history.Push(msg)
 		if err != nil {
 			accord.Logger.WithError(err).Warn("Could not save our new message in our stack")
-			accord.Shutdown(err)
-			return err
+			return RemoteResult{}, accord.fail(ShutdownStorage, err)
 		}
 	}
 
-	return nil
+	if accord.Persistence.sync {
+		accord.flush()
+	}
+
+	trace.Buffered = buffered
+	trace.Processed = shouldProcess && !buffered
+	return RemoteResult{Processed: trace.Processed}, nil
+}
+
+// ConflictStats returns how our decisions about remote Messages have gone since we started (see ConflictStats)
+func (accord *Accord) ConflictStats() ConflictStats {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	return accord.conflictStats
+}
+
+// isDuplicate checks whether we've already handled the passed in remote message. Our bloom filter lets us skip straight
+// to processing messages we've definitely never seen, and anything it can't rule out falls back to a scan of our
+// history, which is authoritative. processMutex must be held by the caller
+func (accord *Accord) isDuplicate(msg *Message) (bool, error) {
+	accord.dedupStats.Checks++
+	if !accord.state.MaybeSeen(msg.ID) {
+		return false, nil
+	}
+	accord.dedupStats.MaybeSeen++
+
+	if accord.DisableHistory {
+		accord.dedupStats.FalsePositives++
+		return false, nil
+	}
+
+	it := createHistoryIterator(accord.history)
+	defer it.close()
+
+	for {
+		previous, err := it.Next()
+		if err != nil {
+			return false, err
+		}
+		if previous == nil {
+			break
+		}
+		if previous.ID == msg.ID {
+			accord.dedupStats.Duplicates++
+			return true, nil
+		}
+	}
+
+	accord.dedupStats.FalsePositives++
+	return false, nil
 }
 
 // Status returns some insight into the internal metrics of the Accord process
@@ -338,10 +1811,542 @@ func (accord *Accord) Status() Status {
 	accord.processMutex.Lock()
 	defer accord.processMutex.Unlock()
 
+	var historySize uint64
+	var historyLock HistoryLockStats
+	if !accord.DisableHistory {
+		historySize = accord.history.Size()
+		historyLock = accord.history.LockStats()
+	}
+
+	// A queue that can't report its usage (or fails to) just leaves those fields empty
+	usage, _ := accord.ToBeSynced.Usage()
+
+	scheduling := map[string]SchedulerShare{}
+	if accord.scheduler != nil {
+		scheduling = accord.scheduler.Shares()
+	}
+
 	return Status{
-		ToBeSyncedSize: accord.ToBeSynced.Size(),
-		HistorySize:    accord.history.Size(),
-		State:          accord.state.GetCurrent(),
+		ToBeSyncedSize:      accord.ToBeSynced.Size(),
+		HistorySize:         historySize,
+		State:               accord.state.GetCurrent(),
+		Dedup:               accord.dedupStats,
+		Conflicts:           accord.conflictStats,
+		DivergenceEvents:    accord.divergenceEvents,
+		CurrentDivergence:   accord.divergenceStreak,
+		ExpiredSwept:        accord.ToBeSynced.Swept(),
+		HeadOfLineDrops:     accord.headOfLineDrops(),
+		ToBeSyncedHighWater: usage.HighWaterMark,
+		ToBeSyncedDiskBytes: usage.DiskBytes,
+		HistoryLock:         historyLock,
+		ProcessingPaused:    accord.paused,
+		PendingProcess:      accord.pending.Size(),
+		Deferred:            accord.deferred.Size(),
+		Maintenance:         accord.lastMaintenance,
+		Scheduling:          scheduling,
+		Goroutines:          accord.Goroutines(),
+		DiskBytes:           atomic.LoadUint64(&accord.diskBytes),
+		MaxDiskBytes:        accord.MaxDiskBytes,
+		DiskFull:            atomic.LoadInt32(&accord.diskFull) == 1,
+	}
+}
+
+// PeerProgress reports how far each of our sync targets (see SyncQueue.RegisterTarget) has gotten through our sync
+// queue, and how far behind it is, by target. Components that consume our queue without a target (a PollListener
+// without a Target, for instance) don't show up here. If our queue can't be read we log why and report nothing
+func (accord *Accord) PeerProgress() map[string]PeerProgress {
+	progress, err := accord.ToBeSynced.Progress()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to read our sync targets' progress")
+		return map[string]PeerProgress{}
+	}
+	return progress
+}
+
+// StorageStats reports on the footprint of our sync queue, history and state stores, by filename (SyncFilename and so
+// on), for predicting disk growth and deciding when to compact. Stores whose backends can't report on themselves (see
+// StorageStatsReporter), and our history when it's disabled, are left out. It's purely read only
+func (accord *Accord) StorageStats() (map[string]StorageStats, error) {
+	backends := map[string]interface{}{
+		SyncFilename:  accord.ToBeSynced.queue,
+		StateFilename: accord.state.db,
+	}
+	if !accord.DisableHistory {
+		backends[HistoryFilename] = accord.history.stack
+	}
+
+	stats := map[string]StorageStats{}
+	for name, backend := range backends {
+		reporter, ok := backend.(StorageStatsReporter)
+		if !ok {
+			continue
+		}
+
+		storeStats, err := reporter.StorageStats()
+		if err != nil {
+			return nil, err
+		}
+		stats[name] = storeStats
+	}
+	return stats, nil
+}
+
+// FindComponent returns the registered Component with the given name, or nil if there isn't one. Only Components that
+// implement NamedComponent can be found this way
+func (accord *Accord) FindComponent(name string) Component {
+	for _, comp := range accord.components {
+		if named, ok := comp.(NamedComponent); ok && named.ComponentName() == name {
+			return comp
+		}
+	}
+	return nil
+}
+
+// Components describes every Component we were configured with, in the order they were given. Components that
+// implement DescribedComponent describe themselves; for the rest (and for anything a description leaves out) we fill in
+// their type, their name if they're a NamedComponent, and their health if they're a PausableComponent
+func (accord *Accord) Components() []ComponentInfo {
+	infos := make([]ComponentInfo, 0, len(accord.components))
+	for _, comp := range accord.components {
+		info := ComponentInfo{}
+		if described, ok := comp.(DescribedComponent); ok {
+			info = described.Describe()
+		}
+
+		if info.Type == "" {
+			info.Type = fmt.Sprintf("%T", comp)
+		}
+		if named, ok := comp.(NamedComponent); ok && info.Name == "" {
+			info.Name = named.ComponentName()
+		}
+		if pausable, ok := comp.(PausableComponent); ok && info.Health == nil {
+			health := pausable.Health()
+			info.Health = &health
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Conflicts returns up to limit records from our audit trail of conflict resolution decisions, starting at offset and
+// oldest first. A limit of 0 returns everything after offset
+func (accord *Accord) Conflicts(offset, limit uint64) ([]ConflictRecord, error) {
+	return accord.conflicts.Entries(offset, limit)
+}
+
+// History returns up to limit Messages from our history starting at offset, oldest first (see HistoryStack.Entries).
+// A limit of 0 returns everything after offset. It's always empty when DisableHistory is set
+func (accord *Accord) History(offset, limit uint64) ([]*Message, error) {
+	if accord.DisableHistory {
+		return []*Message{}, nil
+	}
+
+	return accord.history.Entries(offset, limit)
+}
+
+// ExportState serializes our current state, along with any named values, so that it can be used to quickly bootstrap
+// another node through ImportState without replaying any history
+func (accord *Accord) ExportState() ([]byte, error) {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	return accord.state.Export()
+}
+
+// ImportState seeds our persisted state with one produced by ExportState (likely on another, trusted, node). This can
+// only be done while we aren't running, either before Start or after Stop, and returns ErrStateInUse otherwise
+func (accord *Accord) ImportState(data []byte) error {
+	if accord.state != nil && accord.state.inUse {
+		return ErrStateInUse
+	}
+
+	err := prepareDataDir(accord.dataDir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockDataDir(accord.dataDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	keys, err := newAtRestKeyring(accord.AtRestKey, accord.PreviousAtRestKeys...)
+	if err != nil {
+		return err
+	}
+
+	db, err := accord.Backends.withDefaults().State(path.Join(accord.dataDir, StateFilename))
+	if err != nil {
+		return err
+	}
+
+	state, err := newState(db, keys)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	defer state.Close()
+
+	return state.Import(data)
+}
+
+// SyncNow blocks until every Message that is in our sync queue at the time of the call has been confirmed by the remote
+// (or, with multiple sync targets, by every one of them), or until the context is done, in which case its error is
+// returned. Messages handled after the call don't hold it up. This gives a synchronous barrier for workflows that must
+// not proceed until a peer has the data.
+//
+// Note that SyncNow doesn't make synchronization happen any faster, it only waits for it. It only makes sense with an
+// actively connected peer: if nobody is pulling from our queue it will wait until the context gives up
+func (accord *Accord) SyncNow(ctx context.Context) error {
+	done, cancel := accord.ToBeSynced.Barrier()
+	defer cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RecordDelivery notes that the given Message has been acknowledged by a peer. Components should call this once a remote
+// has confirmed a Message they sent it. It does nothing unless DeliveryReceipts is enabled
+func (accord *Accord) RecordDelivery(messageID uint64, peer string) error {
+	if accord.receipts == nil {
+		return nil
+	}
+
+	return accord.receipts.Record(DeliveryReceipt{
+		MessageID: messageID,
+		Peer:      peer,
+		AckedAt:   time.Now().UTC(),
+	})
+}
+
+// Receipts returns every delivery receipt we have for the given Message, oldest first. This scans the entire receipt
+// log. If DeliveryReceipts isn't enabled there's never anything to return
+func (accord *Accord) Receipts(messageID uint64) ([]DeliveryReceipt, error) {
+	if accord.receipts == nil {
+		return []DeliveryReceipt{}, nil
+	}
+
+	return accord.receipts.Find(messageID)
+}
+
+// AllReceipts returns up to limit delivery receipts starting at offset and oldest first. A limit of 0 returns everything
+// after offset
+func (accord *Accord) AllReceipts(offset, limit uint64) ([]DeliveryReceipt, error) {
+	if accord.receipts == nil {
+		return []DeliveryReceipt{}, nil
+	}
+
+	return accord.receipts.Entries(offset, limit)
+}
+
+// RecordConfirmation notes that a peer has confirmed it processed the given Message, which may well have been relayed
+// there through other peers. Components should call this when a peer sends one back. Only the first confirmation of a
+// Message is kept. It does nothing unless ProcessingConfirmations is enabled
+func (accord *Accord) RecordConfirmation(messageID uint64, peer string) error {
+	if accord.confirmations == nil {
+		return nil
+	}
+
+	accord.Logger.WithFields(logrus.Fields{"id": messageID, "peer": peer}).Debug("A peer confirmed processing a message")
+	return accord.confirmations.Record(messageID, time.Now())
+}
+
+// ConfirmationStatus returns whether a peer has confirmed it processed the given Message, and if so when we first heard
+// about it. If ProcessingConfirmations isn't enabled nothing is ever confirmed
+func (accord *Accord) ConfirmationStatus(messageID uint64) (confirmed bool, at time.Time) {
+	if accord.confirmations == nil {
+		return false, time.Time{}
+	}
+
+	confirmed, at, err := accord.confirmations.Status(messageID)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("id", messageID).Warn("Unable to read a processing confirmation")
+		return false, time.Time{}
+	}
+	return confirmed, at
+}
+
+// headFailure is the Message a sync target is stuck on and how many times in a row it has failed to sync
+type headFailure struct {
+	id    uint64
+	count int
+}
+
+// ReportSyncFailure lets a sync component tell us that it failed to deliver msg, the next Message in the queue for the
+// given target (empty if the component dequeues directly rather than tracking a target), and why, so that a single
+// Message our remote keeps rejecting can't stall synchronization forever. Once the same Message has failed
+// MaxHeadRetries times in a row it is moved to our dead letter queue, along with the last reason it failed, and skipped,
+// and we return true so that the component knows to move on. It does nothing unless MaxHeadRetries is set
+func (accord *Accord) ReportSyncFailure(msg *Message, target string, reason error) (bool, error) {
+	if accord.MaxHeadRetries <= 0 {
+		return false, nil
+	}
+
+	accord.headLock.Lock()
+	defer accord.headLock.Unlock()
+
+	failure := accord.headFailures[target]
+	if failure.id != msg.ID {
+		failure = headFailure{id: msg.ID}
+	}
+	failure.count++
+	accord.headFailures[target] = failure
+
+	if failure.count < accord.MaxHeadRetries {
+		return false, nil
+	}
+
+	log := accord.Logger.WithField("id", msg.ID).WithField("target", target).WithField("failures", failure.count)
+
+	// Dead letter before skipping, so that a crash in between leaves a duplicate behind rather than losing the Message
+	letter := DeadLetter{
+		Message:  msg,
+		Target:   target,
+		Failures: failure.count,
+		DeadAt:   time.Now(),
+	}
+	if reason != nil {
+		letter.Reason = reason.Error()
+	}
+
+	err := accord.deadLetters.Add(letter)
+	if err != nil {
+		log.WithError(err).Error("Could not move a Message that keeps failing to sync to the dead letter queue")
+		return false, err
+	}
+
+	skipped, err := accord.ToBeSynced.Skip(target, msg.ID)
+	if err != nil {
+		log.WithError(err).Error("Could not skip a Message that keeps failing to sync")
+		return false, err
+	}
+
+	delete(accord.headFailures, target)
+	if skipped {
+		accord.headDrops++
+		log.Error("A Message has been blocking our sync queue, it has been moved to the dead letter queue and skipped")
+	}
+	return skipped, nil
+}
+
+// DeadLetters returns up to limit entries from our dead letter queue, starting at offset and oldest first. A limit of
+// 0 returns everything after offset. If neither MaxHeadRetries nor DependencyTimeout is set there's never anything to
+// return
+func (accord *Accord) DeadLetters(offset, limit uint64) ([]DeadLetter, error) {
+	if accord.deadLetters == nil {
+		return []DeadLetter{}, nil
+	}
+
+	return accord.deadLetters.Entries(offset, limit)
+}
+
+// DeadLetterRequeue takes the Message with the given ID out of our dead letter queue and puts it back on the end of
+// our sync queue, to be tried again once whatever was wrong with it (or our remote) has been fixed. As it goes back on
+// the end of the queue, every sync target will be sent it again, including any that had already received it.
+// Returns ErrDeadLetterNotFound if there's no such Message
+func (accord *Accord) DeadLetterRequeue(id uint64) error {
+	if accord.deadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	accord.headLock.Lock()
+	defer accord.headLock.Unlock()
+
+	letters, err := accord.deadLetters.Entries(0, 0)
+	if err != nil {
+		return err
+	}
+
+	var msg *Message
+	for _, letter := range letters {
+		if letter.Message.ID == id {
+			msg = letter.Message
+			break
+		}
+	}
+	if msg == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	// Requeue before removing, so that a crash in between leaves a duplicate behind rather than losing the Message
+	err = accord.ToBeSynced.Enqueue(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = accord.deadLetters.Remove(id)
+	if err != nil {
+		return err
+	}
+
+	accord.Logger.WithField("id", id).Info("Requeued a dead lettered Message")
+	return nil
+}
+
+// ReEmit puts a Message we've already handled, found in our history, back on the end of our sync queue so that it's
+// synced to our peers again. It's a repair tool for a Message a peer is suspected to have never received, after it's
+// already left our sync queue. The Message goes straight onto the queue without being handled again, so it's neither
+// processed nor counted in our state a second time here, and a peer that did receive it the first time drops it as a
+// duplicate. Returns ErrNotInHistory if we no longer have the Message (or DisableHistory is set)
+func (accord *Accord) ReEmit(id uint64) error {
+	if accord.DisableHistory {
+		return ErrNotInHistory
+	}
+
+	msg, err := accord.history.Find(id)
+	if err != nil {
+		return err
+	}
+
+	err = accord.ToBeSynced.Enqueue(msg)
+	if err != nil {
+		return err
+	}
+
+	accord.Logger.WithField("id", id).Info("Re-emitted a Message to be synced again")
+	return nil
+}
+
+// DeadLetterDiscard drops the Message with the given ID from our dead letter queue for good. Returns
+// ErrDeadLetterNotFound if there's no such Message
+func (accord *Accord) DeadLetterDiscard(id uint64) error {
+	if accord.deadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	accord.headLock.Lock()
+	defer accord.headLock.Unlock()
+
+	_, err := accord.deadLetters.Remove(id)
+	if err != nil {
+		return err
+	}
+
+	accord.Logger.WithField("id", id).Info("Discarded a dead lettered Message")
+	return nil
+}
+
+// headOfLineDrops returns the number of Messages ReportSyncFailure has moved to our dead letter queue
+func (accord *Accord) headOfLineDrops() uint64 {
+	accord.headLock.Lock()
+	defer accord.headLock.Unlock()
+
+	return accord.headDrops
+}
+
+// TimeoutPolicy decides what happens when a Manager's ShouldProcess runs past our ShouldProcessTimeout
+type TimeoutPolicy int
+
+const (
+	// TimeoutWarn only logs a warning, leaving the Manager to finish and make its own decision. This is the default
+	TimeoutWarn TimeoutPolicy = iota
+
+	// TimeoutProcess cuts the Manager's history iteration short and processes the Message
+	TimeoutProcess
+
+	// TimeoutSkip cuts the Manager's history iteration short and doesn't process the Message
+	TimeoutSkip
+)
+
+// resolveConflict asks our Manager whether a Message that arrived while we were diverged should be processed, along
+// with its reason if it gives one, keeping an eye on how long it takes. How far it looked is noted in trace, along with
+// every Message it examined if examine is set. processMutex must be held by the caller
+func (accord *Accord) resolveConflict(msg *Message, trace *Trace, examine bool) (bool, string) {
+	it := createHistoryIterator(accord.history)
+	defer it.close()
+	if examine {
+		it.examined = &trace.Examined
+	}
+
+	if accord.ShouldProcessTimeout > 0 {
+		started := time.Now()
+		if accord.ShouldProcessTimeoutPolicy != TimeoutWarn {
+			it.deadline = started.Add(accord.ShouldProcessTimeout)
+		}
+
+		// We warn from a timer, rather than once the Manager returns, so that one that never does is still noticed
+		watchdog := time.AfterFunc(accord.ShouldProcessTimeout, func() {
+			accord.Logger.WithField("id", msg.ID).WithField("timeout", accord.ShouldProcessTimeout).
+				Warn("Our manager is taking too long to decide whether to process a message, all processing is blocked until it does")
+		})
+		defer watchdog.Stop()
+	}
+
+	var shouldProcess bool
+	var reason string
+	if resolver, ok := accord.manager.(StateAwareResolver); ok {
+		shouldProcess, reason = resolver.ShouldProcessWithState(*msg, it, accord.state)
+	} else if explainer, ok := accord.manager.(ConflictExplainer); ok {
+		shouldProcess, reason = explainer.ShouldProcessWithReason(*msg, it)
+	} else {
+		shouldProcess = accord.manager.ShouldProcess(*msg, it)
+	}
+
+	trace.Scanned = it.Scanned()
+	trace.TimedOut = it.TimedOut()
+	if it.TimedOut() {
+		shouldProcess = accord.ShouldProcessTimeoutPolicy == TimeoutProcess
+		reason = "timed out"
+		accord.Logger.WithField("id", msg.ID).WithField("process", shouldProcess).Warn("Our manager timed out deciding whether to process a message, overriding its decision")
+	}
+
+	return shouldProcess, reason
+}
+
+// compareStates decides how a remote state relates to our own, asking our Manager if it's a StateComparator
+func (accord *Accord) compareStates(local, remote uint64) StateRelation {
+	if comparator, ok := accord.manager.(StateComparator); ok {
+		return comparator.Compare(local, remote)
+	}
+	if local == remote {
+		return StateAligned
+	}
+	return StateDiverged
+}
+
+// shouldSync asks our Manager whether a new Message should be synced, if it's a SyncFilter
+func (accord *Accord) shouldSync(msg *Message) bool {
+	if filter, ok := accord.manager.(SyncFilter); ok {
+		return filter.ShouldSync(*msg)
+	}
+	return true
+}
+
+// stateDelta returns the value the given Message should contribute to our state, asking our Manager if it's a
+// StateContributor
+func (accord *Accord) stateDelta(msg *Message) uint64 {
+	if contributor, ok := accord.manager.(StateContributor); ok {
+		return contributor.StateDelta(*msg)
+	}
+	return msg.ID
+}
+
+// diverged records that a remote Message arrived while our state differed from the remote's, and lets OnDivergence
+// know. processMutex must be held by the caller
+func (accord *Accord) diverged(msg *Message) {
+	accord.divergenceEvents++
+	accord.divergenceStreak++
+
+	event := DivergenceEvent{
+		MessageID:   msg.ID,
+		LocalState:  accord.state.GetCurrent(),
+		RemoteState: msg.StateAt,
+		Streak:      accord.divergenceStreak,
+		Timestamp:   time.Now().UTC(),
+	}
+	if !accord.DisableHistory {
+		event.HistorySize = accord.history.Size()
+	}
+
+	accord.Logger.WithField("id", msg.ID).WithField("streak", event.Streak).Debug("Remote message arrived with a diverged state")
+
+	if accord.OnDivergence != nil {
+		accord.OnDivergence(event)
 	}
 }
 
@@ -349,22 +2354,82 @@ func (accord *Accord) Status() Status {
 // clean up our internal history using this information. If the states match we return true,
 // otherwise false
 func (accord *Accord) CheckRemoteState(remoteState uint64) (bool, error) {
-	accord.processMutex.Lock()
+	accord.processMutex.LockRemote()
 	defer accord.processMutex.Unlock()
 
 	if remoteState == accord.state.GetCurrent() {
-		if accord.history.Size() > 0 {
-			accord.Logger.Info("Accord processes are aligned. Clearing out history")
-			err := accord.history.Clear()
-
-			if err != nil {
-				accord.Logger.WithError(err).Error("Could not clear our history")
-				accord.Shutdown(err)
-				return true, err
-			}
+		err := accord.aligned()
+		if err != nil {
+			return true, err
 		}
 		return false, nil
 	}
 
 	return false, nil
 }
+
+// aligned is called once we know we're aligned with a remote, and clears out our history as nothing in it can conflict
+// with anything the remote sends us from here on. processMutex must be held by the caller
+func (accord *Accord) aligned() error {
+	accord.divergenceStreak = 0
+	if !accord.DisableHistory && accord.history.Size() > 0 {
+		accord.Logger.Info("Accord processes are aligned. Clearing out history")
+		err := accord.history.Clear()
+
+		if archiveErr, ok := err.(*ArchiveError); ok {
+			// Our history is still intact, so there's no harm done. We'll simply try again next time
+			accord.Logger.WithError(archiveErr).Warn("Could not archive our history, keeping it for now")
+		} else if err != nil {
+			accord.Logger.WithError(err).Error("Could not clear our history")
+			return accord.fail(ShutdownStorage, err)
+		}
+	}
+	return nil
+}
+
+// ReconcileResult is the outcome of reconciling our state with a peer's
+type ReconcileResult struct {
+	// Peer identifies who we reconciled with
+	Peer string
+
+	// LocalState and RemoteState are our state and the peer's at the time
+	LocalState  uint64
+	RemoteState uint64
+
+	// Aligned is whether the two states matched
+	Aligned bool
+}
+
+// Reconcile compares our state with one a peer has told us about, as part of the periodic reconciliation components
+// perform every ReconcileInterval (independently of any Messages flowing between us). If they match, our history is
+// pruned just as in CheckRemoteState. If they don't the gap is logged, and OnReconcile is given the chance to act on
+// it. Returns whether we were aligned
+func (accord *Accord) Reconcile(peer string, remoteState uint64) (bool, error) {
+	accord.processMutex.LockRemote()
+	defer accord.processMutex.Unlock()
+
+	result := ReconcileResult{
+		Peer:        peer,
+		LocalState:  accord.state.GetCurrent(),
+		RemoteState: remoteState,
+	}
+	result.Aligned = result.LocalState == result.RemoteState
+	accord.notePeerState(peer, remoteState)
+
+	log := accord.Logger.WithField("peer", peer)
+	if result.Aligned {
+		log.Debug("Reconciled with a peer, we're aligned")
+		err := accord.aligned()
+		if err != nil {
+			return true, err
+		}
+	} else {
+		log.WithField("local", result.LocalState).WithField("remote", result.RemoteState).Info("Reconciled with a peer, we've diverged")
+	}
+
+	if accord.OnReconcile != nil {
+		accord.OnReconcile(result)
+	}
+
+	return result.Aligned, nil
+}
@@ -1,10 +1,15 @@
 package accord
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -93,6 +98,45 @@ func TestAccordComponentStartError(t *testing.T) {
 	err := accord.Start()
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "Manufactured Error")
+
+	// Those that had started are stopped again, and our stores are closed before our data directory is released, so
+	// that another Accord can open them straight away
+	assert.True(t, comp1.stopped)
+	assert.True(t, comp2.stopped)
+	assert.False(t, comp3.stopped)
+	assert.False(t, accord.state.inUse)
+
+	again := DummyAccord()
+	err = again.Start()
+	assert.Nil(t, err)
+	again.Stop()
+}
+
+type orderedComponent struct {
+	noopComponent
+	name  string
+	order *[]string
+}
+
+func (ordered *orderedComponent) Stop(int) {
+	*ordered.order = append(*ordered.order, ordered.name)
+}
+
+func TestAccordComponentStartErrorOrder(t *testing.T) {
+	defer AccordCleanup()
+
+	var order []string
+	accord := DummyAccord()
+	accord.components = []Component{
+		&orderedComponent{name: "first", order: &order},
+		&orderedComponent{name: "second", order: &order},
+		&noopComponentError{},
+		&orderedComponent{name: "never started", order: &order},
+	}
+	assert.NotNil(t, accord.Start())
+
+	// The latest to start is the first to be stopped
+	assert.Equal(t, []string{"second", "first"}, order)
 }
 
 func TestAccordComponentStop(t *testing.T) {
@@ -141,7 +185,9 @@ func TestAccordStartOSShutdown(t *testing.T) {
 	err = proc.Signal(os.Interrupt)
 	assert.Nil(t, err)
 
-	<-done
+	// Being asked to stop isn't an error, but we still note why we stopped
+	assert.Nil(t, <-done)
+	assert.Equal(t, ShutdownSignal, accord.StopReason().Category)
 
 	assert.True(t, comp1.started)
 	assert.True(t, comp2.started)
@@ -185,6 +231,68 @@ func TestAccordStartErrorShutdown(t *testing.T) {
 
 }
 
+func TestAccordShutdownReason(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.Start()
+	assert.Nil(t, accord.StopReason())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- accord.Listen()
+	}()
+
+	accord.ShutdownWith(ShutdownStorage, "test component", errors.New("disk on fire"))
+	err := <-done
+
+	reason, ok := err.(*ShutdownReason)
+	assert.True(t, ok)
+	assert.Equal(t, ShutdownStorage, reason.Category)
+	assert.Equal(t, "test component", reason.Component)
+	assert.Equal(t, "disk on fire", reason.Error())
+	assert.Equal(t, reason, accord.StopReason())
+
+	// Plain errors are wrapped, without a category
+	accord.Shutdown(errors.New("test error"))
+	reason = (<-accord.shutdown)
+	assert.Equal(t, ShutdownUnknown, reason.Category)
+	assert.Equal(t, "shutdown: unknown", (&ShutdownReason{}).Error())
+}
+
+func TestAccordListenWithTick(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.Start()
+
+	ticks := make(chan *Accord, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- accord.ListenWithTick(time.Millisecond, func(acrd *Accord) {
+			select {
+			case ticks <- acrd:
+			default:
+			}
+		})
+	}()
+
+	// We should be ticked a few times while we're waiting
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, accord, <-ticks)
+	}
+
+	// And still shutdown promptly
+	accord.Shutdown(errors.New("test error"))
+	select {
+	case err := <-done:
+		assert.Equal(t, "test error", err.Error())
+	case <-time.After(time.Second):
+		t.Fatal("ListenWithTick did not return after a shutdown")
+	}
+}
+
 func TestAccordMultipleNewOperations(t *testing.T) {
 	defer AccordCleanup()
 	accord := DummyAccord()
@@ -218,6 +326,457 @@ func TestAccordMultipleNewOperations(t *testing.T) {
 	assert.Equal(t, uint64(5), accord.history.Size())
 }
 
+func TestAccordOrderedSubmission(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.OrderedSubmission = true
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	done := make(chan int, 5)
+
+	// Hold processing while the submissions arrive one after another, so that they all pile up waiting
+	accord.processMutex.Lock()
+	for i := 1; i <= 5; i++ {
+		go func(id uint64) {
+			accord.HandleNewMessage(&Message{ID: id})
+			done <- 0
+		}(uint64(i))
+
+		for j := 0; ; j++ {
+			accord.processMutex.lock.Lock()
+			ready := accord.processMutex.waitingLocal == i
+			accord.processMutex.lock.Unlock()
+			if ready {
+				break
+			}
+			if j == 100 {
+				t.Fatal("Submissions never started waiting to be processed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	accord.processMutex.Unlock()
+
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		msg, err := accord.ToBeSynced.Dequeue()
+		assert.Nil(t, err)
+		assert.Equal(t, i, msg.ID)
+	}
+}
+
+func TestAccordHandleNewMessageFailures(t *testing.T) {
+	failure := errors.New("disk on fire")
+
+	// Each case starts out having handled one Message, and then fails at a different step while handling another.
+	// processed is what our Manager is left having processed: it's called upon before anything is persisted and can't
+	// be undone, so it only misses the second Message when processing is what failed or never happened
+	cases := []struct {
+		name      string
+		pause     bool
+		fail      func(manager *orderManager, queue *memoryQueue, stack *memoryStack, state *memoryState)
+		processed []uint64
+	}{
+		{"process", false, func(manager *orderManager, _ *memoryQueue, _ *memoryStack, _ *memoryState) { manager.failOn = 2 }, []uint64{1}},
+		{"state", false, func(_ *orderManager, _ *memoryQueue, _ *memoryStack, state *memoryState) { state.fail = failure }, []uint64{1, 2}},
+		{"history", false, func(_ *orderManager, _ *memoryQueue, stack *memoryStack, _ *memoryState) { stack.fail = failure }, []uint64{1, 2}},
+		{"queue", false, func(_ *orderManager, queue *memoryQueue, _ *memoryStack, _ *memoryState) { queue.fail = failure }, []uint64{1, 2}},
+		{"queue while paused", true, func(_ *orderManager, queue *memoryQueue, _ *memoryStack, _ *memoryState) { queue.fail = failure }, []uint64{1}},
+	}
+
+	for _, c := range cases {
+		AccordCleanup()
+
+		queue := &memoryQueue{}
+		stack := &memoryStack{}
+		state := &memoryState{values: map[string][]byte{}}
+		manager := &orderManager{}
+
+		accord := DummyAccordManager(manager)
+		accord.Dedup = &BloomConfig{Capacity: 100}
+		accord.Backends = Backends{
+			Queue: func(path string) (QueueBackend, error) {
+				if path == SyncFilename {
+					return queue, nil
+				}
+				return &memoryQueue{}, nil
+			},
+			Stack: func(string) (StackBackend, error) { return stack, nil },
+			State: func(string) (StateBackend, error) { return state, nil },
+		}
+		err := accord.Start()
+		assert.Nil(t, err, c.name)
+
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}), c.name)
+		if c.pause {
+			accord.PauseProcessing()
+		}
+
+		c.fail(manager, queue, stack, state)
+		err = accord.HandleNewMessage(&Message{ID: 2})
+		assert.True(t, errors.Is(err, ErrShuttingDown), c.name)
+		reason := <-accord.shutdown
+		assert.NotNil(t, reason, c.name)
+
+		// Everything should agree that the second Message was never handled
+		state.fail = nil
+		assert.Equal(t, uint64(1), accord.state.GetCurrent(), c.name)
+		assert.Equal(t, uint64(1), accord.state.GetClock(), c.name)
+		assert.False(t, accord.state.MaybeSeen(2), c.name)
+		assert.Equal(t, uint64(1), accord.ToBeSynced.Size(), c.name)
+		assert.Equal(t, uint64(1), accord.history.Size(), c.name)
+		assert.Zero(t, accord.pending.Size(), c.name)
+		assert.Equal(t, c.processed, manager.processed, c.name)
+
+		// Including what we persisted
+		loaded, err := NewState(state)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, uint64(1), loaded.GetCurrent(), c.name)
+
+		accord.Stop()
+	}
+	AccordCleanup()
+}
+
+func TestAccordRejectsZeroIDs(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &DummyManager{}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Equal(t, ErrZeroMessageID, accord.HandleNewMessage(&Message{Payload: []byte{1}}))
+	assert.Equal(t, ErrZeroMessageID, accord.RelayMessage(&Message{Payload: []byte{1}}))
+	assert.Equal(t, ErrZeroMessageID, accord.HandleRemoteMessage(&Message{Payload: []byte{1}}))
+	assert.Equal(t, ErrZeroMessageID, accord.ToBeSynced.Enqueue(&Message{}))
+	assert.Equal(t, ErrZeroMessageID, accord.history.Push(&Message{}))
+
+	assert.Zero(t, manager.ProcessCount)
+	assert.Zero(t, accord.ToBeSynced.Size())
+	assert.Zero(t, accord.history.Size())
+
+	// Unless they've been explicitly allowed
+	accord.Stop()
+	AccordCleanup()
+	accord = DummyAccordManager(manager)
+	accord.AllowZeroIDs = true
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{Payload: []byte{1}}))
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+	assert.Nil(t, accord.history.Push(&Message{}))
+}
+
+func TestAccordRemoteMessageChecks(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &DummyManager{}
+	accord := DummyAccordManager(manager)
+	accord.MaxPayloadSize = 3
+	accord.VerifyMessageIDs = true
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	large, err := NewMessage([]byte{1, 2, 3, 4})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrPayloadTooLarge, accord.HandleNewMessage(large))
+	assert.Equal(t, ErrPayloadTooLarge, accord.HandleRemoteMessage(large))
+	_, err = accord.NewMessage(large.Payload)
+	assert.Equal(t, ErrPayloadTooLarge, err)
+
+	msg, err := accord.NewMessage([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	tampered := *msg
+	tampered.Payload = []byte{1, 2, 4}
+	err = accord.HandleRemoteMessage(&tampered)
+	assert.Equal(t, ErrMessageIDMismatch, err)
+	assert.False(t, errors.Is(err, ErrShuttingDown))
+	assert.Zero(t, manager.ProcessCount)
+
+	// Neither rejection shuts us down, so a good Message still gets through
+	assert.Nil(t, accord.HandleRemoteMessage(msg))
+	assert.Equal(t, 1, manager.ProcessCount)
+}
+
+func TestAccordInboundTransformer(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &DummyManager{}
+	accord := DummyAccordManager(manager)
+	accord.InboundTransformer = InboundFunc(func(msg Message) (Message, error) {
+		if string(msg.Payload) == "bad" {
+			return msg, errors.New("can't read that")
+		}
+		msg.Payload[0] = 'X'
+		return msg, nil
+	})
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg := &Message{ID: 1, Payload: []byte("abc")}
+	err = accord.HandleRemoteMessage(msg)
+	assert.Nil(t, err)
+
+	// Our Manager sees the transformed Message, but what we were handed is left alone
+	assert.Equal(t, []byte("Xbc"), manager.Remote[len(manager.Remote)-1].Payload)
+	assert.Equal(t, []byte("abc"), msg.Payload)
+
+	// A Message we can't transform is rejected, but that's no reason for us to shut down
+	err = accord.HandleRemoteMessage(&Message{ID: 2, Payload: []byte("bad")})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrShuttingDown))
+	assert.Equal(t, 1, manager.ProcessCount)
+}
+
+func TestAccordIdleShutdown(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.IdleShutdownTimeout = 50 * time.Millisecond
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- accord.Listen() }()
+
+	// Activity keeps pushing the shutdown back
+	start := time.Now()
+	for i := uint64(1); i <= 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+		_, err = accord.ToBeSynced.Dequeue()
+		assert.Nil(t, err)
+	}
+
+	select {
+	case err = <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Never shut down while idle")
+	}
+
+	assert.True(t, time.Since(start) >= 130*time.Millisecond)
+	reason, ok := err.(*ShutdownReason)
+	assert.True(t, ok)
+	assert.Equal(t, ShutdownIdle, reason.Category)
+	assert.Nil(t, reason.Err)
+}
+
+func TestAccordRelayMessage(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := DummyManager{}
+	accord := DummyAccordManager(&manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.RelayMessage(msg)
+	assert.Nil(t, err)
+
+	// We should be tracking the message just as if we had processed it, without ever having handed it to our manager
+	assert.Equal(t, 0, manager.ProcessCount)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Equal(t, msg.ID, accord.state.GetCurrent())
+}
+
+func TestAccordHandleResult(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	first, err := accord.HandleNewMessageWithResult(&Message{ID: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, HandleResult{MessageID: 1, State: 1, QueuePosition: 0}, first)
+
+	second, err := accord.RelayMessageWithResult(&Message{ID: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, HandleResult{MessageID: 2, State: 3, QueuePosition: 1}, second)
+}
+
+func TestAccordLamportClock(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	local := &Message{ID: 1}
+	assert.Nil(t, accord.HandleNewMessage(local))
+	assert.Equal(t, uint64(1), local.Clock)
+
+	// A remote that's further along pulls our clock forward, so whatever we create next comes after it
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, StateAt: 1, Clock: 10}))
+	local = &Message{ID: 3}
+	assert.Nil(t, accord.HandleNewMessage(local))
+	assert.Equal(t, uint64(11), local.Clock)
+
+	// One that's behind doesn't pull it back
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 4, StateAt: 4, Clock: 2}))
+	assert.Equal(t, uint64(11), accord.state.GetClock())
+	accord.Stop()
+
+	// Our clock survives a restart
+	accord = DummyAccord()
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+	assert.Equal(t, uint64(11), accord.state.GetClock())
+}
+
+func TestAccordReportSyncFailure(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.MaxHeadRetries = 3
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg1 := &Message{ID: 1}
+	msg2 := &Message{ID: 2}
+	accord.HandleNewMessage(msg1)
+	accord.HandleNewMessage(msg2)
+
+	// Failures only count while they're consecutive failures of the same Message
+	skipped, err := accord.ReportSyncFailure(msg1, "", nil)
+	assert.Nil(t, err)
+	assert.False(t, skipped)
+	skipped, err = accord.ReportSyncFailure(msg2, "", nil)
+	assert.Nil(t, err)
+	assert.False(t, skipped)
+
+	for i := 0; i < 2; i++ {
+		skipped, err = accord.ReportSyncFailure(msg1, "", nil)
+		assert.Nil(t, err)
+		assert.False(t, skipped)
+	}
+
+	skipped, err = accord.ReportSyncFailure(msg1, "", errors.New("rejected"))
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+
+	head, err := accord.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msg2.ID, head.ID)
+	assert.Equal(t, uint64(1), accord.Status().HeadOfLineDrops)
+
+	dead, err := accord.DeadLetters(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, msg1.ID, dead[0].Message.ID)
+	assert.Equal(t, 3, dead[0].Failures)
+	assert.Equal(t, "rejected", dead[0].Reason)
+	assert.False(t, dead[0].DeadAt.IsZero())
+}
+
+func TestAccordDeadLetterRequeueDiscard(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.MaxHeadRetries = 1
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg1 := &Message{ID: 1}
+	msg2 := &Message{ID: 2}
+	accord.HandleNewMessage(msg1)
+	accord.HandleNewMessage(msg2)
+
+	_, err = accord.ReportSyncFailure(msg1, "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+
+	// Requeuing puts the Message back on the end of our sync queue
+	err = accord.DeadLetterRequeue(msg1.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), accord.ToBeSynced.Size())
+
+	dead, err := accord.DeadLetters(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 0)
+	assert.Equal(t, ErrDeadLetterNotFound, accord.DeadLetterRequeue(msg1.ID))
+
+	// Discarding drops it for good
+	_, err = accord.ReportSyncFailure(msg2, "", nil)
+	assert.Nil(t, err)
+	err = accord.DeadLetterDiscard(msg2.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDeadLetterNotFound, accord.DeadLetterDiscard(msg2.ID))
+
+	dead, err = accord.DeadLetters(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 0)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+	head, err := accord.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msg1.ID, head.ID)
+}
+
+func TestAccordReEmit(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg, err := NewMessage([]byte("lost"))
+	assert.Nil(t, err)
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	// Our peer has confirmed it, as far as we know
+	_, err = accord.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	state := accord.state.GetCurrent()
+
+	err = accord.ReEmit(msg.ID)
+	assert.Nil(t, err)
+
+	// It's back in our queue, without being processed or counted again
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+	head, err := accord.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, head.ID)
+	assert.Equal(t, msg.Payload, head.Payload)
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, state, accord.state.GetCurrent())
+	assert.Equal(t, uint64(1), accord.history.Size())
+
+	assert.Equal(t, ErrNotInHistory, accord.ReEmit(msg.ID+1))
+}
+
 func TestAccordHandleRemoteOperation(t *testing.T) {
 	defer AccordCleanup()
 	accord := DummyAccord()
@@ -265,23 +824,862 @@ func TestAccordHandleRemoteOperation(t *testing.T) {
 	assert.Equal(t, uint64(20), accord.state.GetCurrent())
 }
 
-func TestAccordCheckRemoteState(t *testing.T) {
+func TestAccordConflictStats(t *testing.T) {
 	defer AccordCleanup()
-	accord := DummyAccord()
+	AccordCleanup()
 
+	manager := DummyManager{ShouldProcessRet: true}
+	accord := DummyAccordManager(&manager)
 	accord.Start()
 	defer accord.Stop()
 
-	accord.history.Push(&Message{ID: 1})
-	assert.Equal(t, uint64(1), accord.history.Size())
+	// The same scenarios as TestAccordHandleRemoteOperation, each counted under the path it took
+	accord.state.cached = 1
+	err := accord.HandleRemoteMessage(&Message{ID: 4, StateAt: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, ConflictStats{Aligned: 1}, accord.ConflictStats())
 
-	val, err := accord.CheckRemoteState(accord.state.GetCurrent() + 1)
+	err = accord.HandleRemoteMessage(&Message{ID: 10, StateAt: 100})
 	assert.Nil(t, err)
-	assert.False(t, val)
-	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Equal(t, ConflictStats{Aligned: 1, Accepted: 1}, accord.ConflictStats())
 
-	val, err = accord.CheckRemoteState(accord.state.GetCurrent())
+	manager.ShouldProcessRet = false
+	err = accord.HandleRemoteMessage(&Message{ID: 5, StateAt: 30})
 	assert.Nil(t, err)
-	assert.False(t, val)
-	assert.Equal(t, uint64(0), accord.history.Size())
+	assert.Equal(t, ConflictStats{Aligned: 1, Accepted: 1, Skipped: 1}, accord.ConflictStats())
+
+	err = accord.HandleRemoteMessage(&Message{ID: 6, StateAt: 30, ExpiresAt: time.Now().Add(-time.Hour)})
+	assert.Nil(t, err)
+	assert.Equal(t, ConflictStats{Aligned: 1, Accepted: 1, Skipped: 1, Expired: 1}, accord.ConflictStats())
+
+	// And they're reported through our Status
+	assert.Equal(t, accord.ConflictStats(), accord.Status().Conflicts)
+}
+
+func TestAccordConflictStatsNoHistory(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.DisableHistory = true
+	accord.Start()
+	defer accord.Stop()
+
+	err := accord.HandleRemoteMessage(&Message{ID: 10, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, ConflictStats{NoHistory: 1}, accord.ConflictStats())
+}
+
+func TestAccordDivergence(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	events := []DivergenceEvent{}
+	accord := DummyAccordManager(&DummyManager{ShouldProcessRet: true})
+	accord.OnDivergence = func(event DivergenceEvent) {
+		events = append(events, event)
+	}
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Aligned messages aren't divergences
+	err = accord.HandleRemoteMessage(&Message{ID: 1, StateAt: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), accord.Status().DivergenceEvents)
+	assert.Empty(t, events)
+
+	err = accord.HandleRemoteMessage(&Message{ID: 2, StateAt: 100})
+	assert.Nil(t, err)
+	err = accord.HandleRemoteMessage(&Message{ID: 3, StateAt: 200})
+	assert.Nil(t, err)
+
+	status := accord.Status()
+	assert.Equal(t, uint64(2), status.DivergenceEvents)
+	assert.Equal(t, uint64(2), status.CurrentDivergence)
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(3), events[1].MessageID)
+	assert.Equal(t, uint64(3), events[1].LocalState)
+	assert.Equal(t, uint64(200), events[1].RemoteState)
+	assert.Equal(t, uint64(2), events[1].Streak)
+	assert.Equal(t, uint64(2), events[1].HistorySize)
+
+	// Once we're known to be aligned again our current divergence resets, but the total doesn't
+	_, err = accord.CheckRemoteState(accord.state.GetCurrent())
+	assert.Nil(t, err)
+
+	status = accord.Status()
+	assert.Equal(t, uint64(2), status.DivergenceEvents)
+	assert.Equal(t, uint64(0), status.CurrentDivergence)
+}
+
+// versionManager contributes a fixed version bump for every Message, rather than its ID
+type versionManager struct {
+	DummyManager
+}
+
+func (manager *versionManager) StateDelta(msg Message) uint64 {
+	return 1
+}
+
+func TestAccordStateContributor(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &versionManager{DummyManager{ShouldProcessRet: true}}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	err = accord.HandleNewMessage(&Message{ID: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), accord.state.GetCurrent())
+
+	// A remote that's at the same version as us is aligned, no matter what its Message IDs summed to
+	msg := &Message{ID: 200, StateAt: 1}
+	err = accord.HandleRemoteMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, manager.ShouldProcessCount)
+	assert.Equal(t, uint64(2), accord.state.GetCurrent())
+}
+
+// comparatorManager relates every state the same way, whatever it is
+type comparatorManager struct {
+	DummyManager
+	relation StateRelation
+}
+
+func (manager *comparatorManager) Compare(local, remote uint64) StateRelation {
+	return manager.relation
+}
+
+func TestAccordStateComparator(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	// Always asking means our Manager is consulted even when our states match
+	manager := &comparatorManager{DummyManager{ShouldProcessRet: false}, StateDiverged}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	err = accord.HandleRemoteMessage(&Message{ID: 1, StateAt: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, manager.ShouldProcessCount)
+	assert.Equal(t, 0, manager.ProcessCount)
+	assert.Equal(t, uint64(1), accord.Status().DivergenceEvents)
+	accord.Stop()
+	AccordCleanup()
+
+	// While always processing means it never is, even when they don't
+	manager = &comparatorManager{DummyManager{ShouldProcessRet: false}, StateAligned}
+	accord = DummyAccordManager(manager)
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	err = accord.HandleRemoteMessage(&Message{ID: 1, StateAt: 1234})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, manager.ShouldProcessCount)
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, uint64(0), accord.Status().DivergenceEvents)
+}
+
+// warningHook collects the IDs logged with warnings, safely from any goroutine
+type warningHook struct {
+	lock sync.Mutex
+	ids  []interface{}
+}
+
+func (hook *warningHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+func (hook *warningHook) Fire(entry *logrus.Entry) error {
+	hook.lock.Lock()
+	defer hook.lock.Unlock()
+	hook.ids = append(hook.ids, entry.Data["id"])
+	return nil
+}
+
+func (hook *warningHook) warned() []interface{} {
+	hook.lock.Lock()
+	defer hook.lock.Unlock()
+	return append([]interface{}{}, hook.ids...)
+}
+
+// slowManager takes its time over every Message in our history
+type slowManager struct {
+	DummyManager
+	delay time.Duration
+}
+
+func (manager *slowManager) ShouldProcess(msg Message, history *HistoryIterator) bool {
+	manager.ShouldProcessCount++
+	for {
+		time.Sleep(manager.delay)
+		msg, _ := history.Next()
+		if msg == nil {
+			return true
+		}
+	}
+}
+
+// blockingManager holds up Process until it's released, letting us know once it has started
+type blockingManager struct {
+	DummyManager
+	started chan struct{}
+	release chan struct{}
+}
+
+func (manager *blockingManager) Process(msg Message, fromRemote bool) error {
+	close(manager.started)
+	<-manager.release
+	return manager.DummyManager.Process(msg, fromRemote)
+}
+
+// hangingComponent doesn't finish stopping until it's released
+type hangingComponent struct {
+	release chan struct{}
+}
+
+func (comp *hangingComponent) Start(*Accord) error { return nil }
+func (comp *hangingComponent) Stop(int)            {}
+func (comp *hangingComponent) WaitForStop()        { <-comp.release }
+
+func TestAccordForceQuit(t *testing.T) {
+	defer AccordCleanup()
+	defer func() { exit = os.Exit }()
+
+	comp := &hangingComponent{release: make(chan struct{})}
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		exited <- code
+		close(comp.release)
+	}
+
+	accord := DummyAccordComponents(comp)
+	accord.ForceQuit = true
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	// Two signals in quick succession, the second of which forces us out of our hung shutdown
+	accord.signalChannel <- os.Interrupt
+	accord.signalChannel <- os.Interrupt
+
+	listened := make(chan error)
+	go func() {
+		listened <- accord.Listen()
+	}()
+
+	select {
+	case code := <-exited:
+		assert.Equal(t, 1, code)
+	case <-time.After(time.Second):
+		t.Fatal("A second signal didn't force us to quit")
+	}
+	assert.Nil(t, <-listened)
+	AccordCleanup()
+
+	// Without ForceQuit the second signal is ignored, and we wait on our shutdown
+	comp = &hangingComponent{release: make(chan struct{})}
+	accord = DummyAccordComponents(comp)
+	err = accord.Start()
+	assert.Nil(t, err)
+
+	accord.signalChannel <- os.Interrupt
+	accord.signalChannel <- os.Interrupt
+	go func() {
+		listened <- accord.Listen()
+	}()
+
+	select {
+	case <-exited:
+		t.Fatal("We were forced to quit without ForceQuit")
+	case <-listened:
+		t.Fatal("We finished stopping before our component did")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(comp.release)
+	assert.Nil(t, <-listened)
+	assert.Empty(t, exited)
+}
+
+func TestAccordStopGracePeriod(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := &blockingManager{started: make(chan struct{}), release: make(chan struct{})}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	handled := make(chan error)
+	go func() {
+		handled <- accord.HandleNewMessage(&Message{ID: 1})
+	}()
+	<-manager.started
+
+	// Stop must wait for our in-flight Message before closing our stores
+	stopped := make(chan struct{})
+	go func() {
+		accord.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop closed our stores while a message was being processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(manager.release)
+	assert.Nil(t, <-handled)
+	<-stopped
+	assert.Equal(t, 1, manager.ProcessCount)
+	AccordCleanup()
+
+	// Unless our grace period runs out first
+	manager = &blockingManager{started: make(chan struct{}), release: make(chan struct{})}
+	accord = DummyAccordManager(manager)
+	accord.StopGracePeriod = 20 * time.Millisecond
+	err = accord.Start()
+	assert.Nil(t, err)
+
+	go func() {
+		handled <- accord.HandleNewMessage(&Message{ID: 1})
+	}()
+	<-manager.started
+
+	started := time.Now()
+	accord.Stop()
+	assert.True(t, time.Since(started) >= 20*time.Millisecond)
+
+	// Our straggler finds our stores closed
+	close(manager.release)
+	assert.NotNil(t, <-handled)
+}
+
+func TestAccordShouldProcessTimeout(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	hook := &warningHook{}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+
+	manager := &slowManager{delay: 20 * time.Millisecond}
+	accord := DummyAccordManager(manager)
+	accord.Logger = logger.WithFields(nil)
+	accord.ShouldProcessTimeout = 10 * time.Millisecond
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	err = accord.HandleNewMessage(&Message{ID: 1})
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(&Message{ID: 2})
+	assert.Nil(t, err)
+
+	// By default we only warn about the Message our manager is stuck on, and leave the decision to it
+	err = accord.HandleRemoteMessage(&Message{ID: 10})
+	assert.Nil(t, err)
+	assert.Contains(t, hook.warned(), uint64(10))
+	assert.Equal(t, 3, manager.ProcessCount)
+
+	// But we can cut it short and decide for it
+	accord.ShouldProcessTimeoutPolicy = TimeoutSkip
+	err = accord.HandleRemoteMessage(&Message{ID: 11})
+	assert.Nil(t, err)
+	assert.Contains(t, hook.warned(), uint64(11))
+	assert.Equal(t, 3, manager.ProcessCount)
+
+	records, err := accord.Conflicts(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.True(t, records[0].Processed)
+	assert.False(t, records[1].Processed)
+	assert.Equal(t, "timed out", records[1].Reason)
+}
+
+func TestAccordCheckRemoteState(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	accord.Start()
+	defer accord.Stop()
+
+	accord.history.Push(&Message{ID: 1})
+	assert.Equal(t, uint64(1), accord.history.Size())
+
+	val, err := accord.CheckRemoteState(accord.state.GetCurrent() + 1)
+	assert.Nil(t, err)
+	assert.False(t, val)
+	assert.Equal(t, uint64(1), accord.history.Size())
+
+	val, err = accord.CheckRemoteState(accord.state.GetCurrent())
+	assert.Nil(t, err)
+	assert.False(t, val)
+	assert.Equal(t, uint64(0), accord.history.Size())
+}
+
+func TestAccordReconcile(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	results := []ReconcileResult{}
+	accord := DummyAccord()
+	accord.OnReconcile = func(result ReconcileResult) {
+		results = append(results, result)
+	}
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	err = accord.HandleNewMessage(&Message{ID: 1})
+	assert.Nil(t, err)
+
+	// Diverged, so our history has to be kept
+	aligned, err := accord.Reconcile("peer", 100)
+	assert.Nil(t, err)
+	assert.False(t, aligned)
+	assert.Equal(t, uint64(1), accord.history.Size())
+
+	// Aligned, so it can be pruned
+	aligned, err = accord.Reconcile("peer", 1)
+	assert.Nil(t, err)
+	assert.True(t, aligned)
+	assert.Equal(t, uint64(0), accord.history.Size())
+
+	assert.Equal(t, []ReconcileResult{
+		{Peer: "peer", LocalState: 1, RemoteState: 100, Aligned: false},
+		{Peer: "peer", LocalState: 1, RemoteState: 1, Aligned: true},
+	}, results)
+}
+
+func TestAccordHandleRemoteDuplicate(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	manager := DummyManager{ShouldProcessRet: true}
+	accord.manager = &manager
+	accord.Dedup = &BloomConfig{Capacity: 100}
+
+	accord.Start()
+	defer accord.Stop()
+
+	msg := &Message{ID: 4, StateAt: 0}
+	err := accord.HandleRemoteMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, manager.ProcessCount)
+
+	// Sending the same message again should be caught by our dedup layer
+	msg = &Message{ID: 4, StateAt: 0}
+	err = accord.HandleRemoteMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, uint64(4), accord.state.GetCurrent())
+
+	// A new message goes straight through
+	err = accord.HandleRemoteMessage(&Message{ID: 9, StateAt: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, manager.ProcessCount)
+
+	status := accord.Status()
+	assert.Equal(t, uint64(3), status.Dedup.Checks)
+	assert.Equal(t, uint64(1), status.Dedup.Duplicates)
+	assert.Equal(t, status.Dedup.MaybeSeen, status.Dedup.Duplicates+status.Dedup.FalsePositives)
+}
+
+type explainingManager struct {
+	DummyManager
+}
+
+func (manager *explainingManager) ShouldProcessWithReason(msg Message, history *HistoryIterator) (bool, string) {
+	return manager.ShouldProcess(msg, history), "explained"
+}
+
+func TestAccordRecordsConflicts(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	manager := explainingManager{DummyManager{ShouldProcessRet: false}}
+	accord.manager = &manager
+
+	accord.Start()
+	defer accord.Stop()
+
+	// Messages at our own state aren't conflicts and aren't recorded
+	err := accord.HandleRemoteMessage(&Message{ID: 4, StateAt: 0})
+	assert.Nil(t, err)
+
+	err = accord.HandleRemoteMessage(&Message{ID: 10, StateAt: 100})
+	assert.Nil(t, err)
+
+	records, err := accord.Conflicts(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, uint64(10), records[0].MessageID)
+	assert.False(t, records[0].Processed)
+	assert.Equal(t, uint64(4), records[0].LocalState)
+	assert.Equal(t, uint64(100), records[0].RemoteState)
+	assert.Equal(t, "explained", records[0].Reason)
+}
+
+// rangeManager only processes a conflicting Message if its StateAt is within tolerance of our current state
+type rangeManager struct {
+	DummyManager
+	tolerance uint64
+}
+
+func (manager *rangeManager) ShouldProcessWithState(msg Message, history *HistoryIterator, state StateView) (bool, string) {
+	current := state.GetCurrent()
+	if msg.StateAt > current && msg.StateAt-current <= manager.tolerance ||
+		msg.StateAt <= current && current-msg.StateAt <= manager.tolerance {
+		return true, "in range"
+	}
+	return false, "out of range"
+}
+
+func TestAccordStateAwareResolver(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	// Even though our Manager would say no, being state aware takes precedence
+	manager := rangeManager{DummyManager: DummyManager{ShouldProcessRet: false}, tolerance: 5}
+	accord.manager = &manager
+
+	accord.Start()
+	defer accord.Stop()
+
+	err := accord.HandleRemoteMessage(&Message{ID: 4, StateAt: 0})
+	assert.Nil(t, err)
+
+	err = accord.HandleRemoteMessage(&Message{ID: 10, StateAt: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(14), accord.state.GetCurrent())
+
+	// A Message we decide against still moves our state along, it just isn't processed
+	err = accord.HandleRemoteMessage(&Message{ID: 20, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(34), accord.state.GetCurrent())
+
+	assert.Equal(t, 0, manager.ShouldProcessCount)
+	assert.Equal(t, 2, manager.ProcessCount)
+
+	records, err := accord.Conflicts(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.True(t, records[0].Processed)
+	assert.Equal(t, "in range", records[0].Reason)
+	assert.False(t, records[1].Processed)
+	assert.Equal(t, "out of range", records[1].Reason)
+}
+
+// localOnlyManager keeps "admin" Messages to itself
+type localOnlyManager struct {
+	DummyManager
+}
+
+func (manager *localOnlyManager) ShouldSync(msg Message) bool {
+	return string(msg.Payload) != "admin"
+}
+
+func TestAccordSyncFilter(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &localOnlyManager{DummyManager{ShouldProcessRet: true}}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	result, err := accord.HandleNewMessageWithResult(&Message{ID: 1, Payload: []byte("admin")})
+	assert.Nil(t, err)
+	assert.True(t, result.LocalOnly)
+
+	// It was processed, and advanced our state and history, without growing our sync queue
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, uint64(1), accord.state.GetCurrent())
+	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Equal(t, uint64(0), accord.ToBeSynced.Size())
+
+	// Everything else is synced as usual
+	result, err = accord.HandleNewMessageWithResult(&Message{ID: 2, Payload: []byte("abc")})
+	assert.Nil(t, err)
+	assert.False(t, result.LocalOnly)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+
+	// And relaying always syncs
+	err = accord.RelayMessage(&Message{ID: 3, Payload: []byte("admin")})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), accord.ToBeSynced.Size())
+	assert.Equal(t, uint64(6), accord.state.GetCurrent())
+}
+
+func TestAccordDisableHistory(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+	accord := DummyAccord()
+
+	manager := DummyManager{ShouldProcessRet: false}
+	accord.manager = &manager
+	accord.DisableHistory = true
+
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// We shouldn't have even created our history file
+	_, err = os.Stat(HistoryFilename)
+	assert.True(t, os.IsNotExist(err))
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// Diverged, but we should process without asking our manager
+	err = accord.HandleRemoteMessage(&Message{ID: 10, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, manager.ShouldProcessCount)
+	assert.Equal(t, 2, manager.ProcessCount)
+
+	assert.Equal(t, uint64(0), accord.Status().HistorySize)
+
+	_, err = accord.CheckRemoteState(accord.state.GetCurrent())
+	assert.Nil(t, err)
+}
+
+func TestAccordExpiredMessages(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+	accord := DummyAccord()
+
+	manager := DummyManager{ShouldProcessRet: true}
+	accord.manager = &manager
+	accord.ExpirySweepInterval = time.Millisecond
+
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Expired remote messages are handled but not processed
+	err = accord.HandleRemoteMessage(&Message{ID: 4, ExpiresAt: time.Now().Add(-time.Minute)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, manager.ProcessCount)
+	assert.Equal(t, uint64(4), accord.state.GetCurrent())
+
+	// And expired local messages get swept out of our queue
+	err = accord.HandleNewMessage(&Message{ID: 1, ExpiresAt: time.Now().Add(5 * time.Millisecond)})
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(&Message{ID: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), accord.Status().ToBeSyncedSize)
+
+	time.Sleep(20 * time.Millisecond)
+	status := accord.Status()
+	assert.Equal(t, uint64(1), status.ToBeSyncedSize)
+	assert.Equal(t, uint64(1), status.ExpiredSwept)
+}
+
+func TestAccordSyncNow(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// Nobody is syncing with us, so we should give up when our context does
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, accord.SyncNow(ctx))
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		accord.ToBeSynced.Dequeue()
+	}()
+	assert.Nil(t, accord.SyncNow(context.Background()))
+}
+
+func TestAccordStateBatching(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.StateBatchSize = 100
+	assert.Nil(t, accord.Start())
+
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+	assert.Equal(t, 3, accord.state.Unsaved())
+
+	// Stopping writes out what we were holding on to
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.StateBatchSize = 100
+	accord.StateBatchInterval = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(6), accord.Status().State)
+
+	// As does our interval
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4}))
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, accord.state.Unsaved())
+}
+
+func TestAccordExportImportState(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	data, err := accord.ExportState()
+	assert.Nil(t, err)
+
+	// We can't clobber our state while we're running
+	assert.Equal(t, ErrStateInUse, accord.ImportState(data))
+	accord.Stop()
+
+	AccordCleanup()
+
+	// But a fresh node can be seeded before it starts
+	accord = DummyAccord()
+	err = accord.ImportState(data)
+	assert.Nil(t, err)
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Equal(t, msg.ID, accord.Status().State)
+	assert.Zero(t, accord.Status().ToBeSyncedSize)
+}
+
+func TestAccordHistoryArchive(t *testing.T) {
+	defer AccordCleanup()
+
+	var archived []uint64
+	failing := true
+
+	accord := DummyAccord()
+	accord.HistoryArchive = ArchiveFunc(func(msg *Message) error {
+		if failing {
+			return errors.New("archive is down")
+		}
+		archived = append(archived, msg.ID)
+		return nil
+	})
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// Failing to archive shouldn't bring us down, we just hold on to our history
+	_, err = accord.CheckRemoteState(accord.Status().State)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), accord.Status().HistorySize)
+	assert.Len(t, accord.shutdown, 0)
+
+	failing = false
+	_, err = accord.CheckRemoteState(accord.Status().State)
+	assert.Nil(t, err)
+	assert.Zero(t, accord.Status().HistorySize)
+	assert.Equal(t, []uint64{msg.ID}, archived)
+}
+
+func TestAccordDataDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "accord")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	// Missing directories are created for us
+	accord := DummyAccord()
+	accord.dataDir = filepath.Join(root, "missing", "data")
+	err = accord.Start()
+	assert.Nil(t, err)
+	accord.Stop()
+
+	info, err := os.Stat(filepath.Join(root, "missing", "data", StateFilename))
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+
+	// But an empty one is refused rather than using our working directory
+	accord = DummyAccord()
+	accord.dataDir = ""
+	assert.Equal(t, ErrNoDataDir, accord.Start())
+
+	// As is something that isn't a directory
+	file := filepath.Join(root, "file")
+	err = ioutil.WriteFile(file, []byte{}, 0644)
+	assert.Nil(t, err)
+	accord = DummyAccord()
+	accord.dataDir = file
+	err = accord.Start()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not a directory")
+}
+
+func TestAccordDataDirNotWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+
+	root, err := ioutil.TempDir("", "accord")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	readOnly := filepath.Join(root, "readonly")
+	err = os.Mkdir(readOnly, 0555)
+	assert.Nil(t, err)
+
+	accord := DummyAccord()
+	accord.dataDir = readOnly
+	err = accord.Start()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not writable")
+}
+
+func TestAccordDataDirLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	first := DummyAccord()
+	first.dataDir = dir
+	err = first.Start()
+	assert.Nil(t, err)
+
+	// A second Accord on the same data is turned away before it touches anything
+	second := DummyAccord()
+	second.dataDir = dir
+	assert.Equal(t, ErrDataDirLocked, second.Start())
+	assert.Equal(t, ErrStateInUse, first.ImportState([]byte{}))
+
+	// Until the first one lets go
+	first.Stop()
+	err = second.Start()
+	assert.Nil(t, err)
+	second.Stop()
 }
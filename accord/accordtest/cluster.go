@@ -0,0 +1,315 @@
+// Package accordtest wires several Accord instances together in memory, so that synchronization between them (and in
+// particular how conflicts are resolved once they've diverged) can be tested end to end without sockets, ports or
+// timing. Messages travel between nodes over Links: in-memory Components that carry every Message one node creates to
+// one of its peers, exactly once and in order, but only when a test tells them to (or on their own, for a live Cluster).
+//
+// A typical conflict test creates a Message on each of two nodes before delivering either, so that both have moved on
+// from the state they last agreed on, and then delivers them to see what each node's Manager makes of the other's.
+package accordtest
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/sirupsen/logrus"
+)
+
+// Config describes the Cluster NewCluster should create
+type Config struct {
+	// Nodes names each node in the Cluster. Names must be unique
+	Nodes []string
+
+	// Resolve is given to each node's Manager as its ShouldProcess (see Manager). Leaving it nil processes everything
+	Resolve func(node string, msg accord.Message, history *accord.HistoryIterator) bool
+
+	// NewManager optionally creates each node's Manager in place of our recording Manager, for testing an
+	// application's own conflict resolution. Resolve is ignored when it's set
+	NewManager func(node string) accord.Manager
+
+	// Options are passed along to every node's Accord
+	Options []accord.Option
+
+	// Live starts every Link delivering Messages on its own, as a real sync component would, rather than waiting on
+	// Deliver. Use WaitForSync to wait for a live Cluster to catch up. Deliveries are no longer deterministic, so most
+	// conflict tests shouldn't be live
+	Live bool
+}
+
+// Cluster is a set of Accord instances linked together in memory. Every node has a Link to every other node
+type Cluster struct {
+	t     testing.TB
+	nodes []*Node
+	names map[string]*Node
+}
+
+// Node is a single Accord instance in a Cluster, with its own data directory
+type Node struct {
+	Name    string
+	Accord  *accord.Accord
+	Manager accord.Manager
+
+	t   testing.TB
+	dir string
+
+	// links are our outbound Links, by the name of the peer they deliver to
+	links map[string]*Link
+}
+
+// NewCluster creates and starts a Cluster as described by config, failing the test if any node can't be started. Close
+// should be deferred straight away
+func NewCluster(t testing.TB, config Config) *Cluster {
+	cluster := &Cluster{t: t, names: map[string]*Node{}}
+
+	logger := &logrus.Logger{
+		Out:       ioutil.Discard,
+		Formatter: new(logrus.TextFormatter),
+		Hooks:     make(logrus.LevelHooks),
+		Level:     logrus.DebugLevel,
+	}
+
+	for _, name := range config.Nodes {
+		if _, ok := cluster.names[name]; ok {
+			cluster.Close()
+			t.Fatalf("accordtest: duplicate node name %q", name)
+		}
+
+		node := &Node{Name: name, t: t, links: map[string]*Link{}}
+		if config.NewManager != nil {
+			node.Manager = config.NewManager(name)
+		} else {
+			manager := &Manager{}
+			if config.Resolve != nil {
+				resolve := config.Resolve
+				manager.Resolve = func(msg accord.Message, history *accord.HistoryIterator) bool {
+					return resolve(name, msg, history)
+				}
+			}
+			node.Manager = manager
+		}
+
+		cluster.nodes = append(cluster.nodes, node)
+		cluster.names[name] = node
+	}
+
+	// Links need both of their ends to exist before anything starts
+	for _, node := range cluster.nodes {
+		for _, peer := range cluster.nodes {
+			if peer != node {
+				node.links[peer.Name] = &Link{From: node, To: peer, Live: config.Live}
+			}
+		}
+	}
+
+	for _, node := range cluster.nodes {
+		dir, err := ioutil.TempDir("", "accordtest-"+node.Name)
+		if err != nil {
+			cluster.Close()
+			t.Fatalf("accordtest: could not create a data directory for %q: %v", node.Name, err)
+		}
+		node.dir = dir
+
+		components := []accord.Component{}
+		for _, peer := range node.peers() {
+			components = append(components, node.links[peer])
+		}
+
+		node.Accord = accord.NewAccord(node.Manager, components, dir, logger.WithField("node", node.Name), config.Options...)
+		err = node.Accord.Start()
+		if err != nil {
+			node.Accord = nil
+			cluster.Close()
+			t.Fatalf("accordtest: could not start %q: %v", node.Name, err)
+		}
+	}
+
+	return cluster
+}
+
+// Close stops every node and removes their data directories
+func (cluster *Cluster) Close() {
+	// Stop every live Link first, so that none of them deliver to a node that has already stopped
+	for _, node := range cluster.nodes {
+		if node.Accord == nil {
+			continue
+		}
+		for _, link := range node.links {
+			link.Stop(0)
+		}
+		for _, link := range node.links {
+			link.WaitForStop()
+		}
+	}
+
+	for _, node := range cluster.nodes {
+		if node.Accord != nil {
+			node.Accord.Stop()
+			node.Accord = nil
+		}
+		if node.dir != "" {
+			os.RemoveAll(node.dir)
+			node.dir = ""
+		}
+	}
+}
+
+// Node returns the node with the given name, failing the test if there isn't one
+func (cluster *Cluster) Node(name string) *Node {
+	node, ok := cluster.names[name]
+	if !ok {
+		cluster.t.Fatalf("accordtest: unknown node %q", name)
+	}
+	return node
+}
+
+// Nodes returns every node, in the order they were configured
+func (cluster *Cluster) Nodes() []*Node {
+	return append([]*Node{}, cluster.nodes...)
+}
+
+// Link returns the Link carrying Messages from one node to another
+func (cluster *Cluster) Link(from, to string) *Link {
+	link, ok := cluster.Node(from).links[to]
+	if !ok {
+		cluster.t.Fatalf("accordtest: no link from %q to %q", from, to)
+	}
+	return link
+}
+
+// Deliver carries the next Message waiting to go from one node to another, failing the test if it can't be handled.
+// Returns nil if there's nothing waiting, or the Link between them has been cut
+func (cluster *Cluster) Deliver(from, to string) *Delivery {
+	delivery, err := cluster.Link(from, to).Deliver()
+	if err != nil {
+		cluster.t.Fatalf("accordtest: delivering from %q to %q: %v", from, to, err)
+	}
+	return delivery
+}
+
+// DeliverAll carries everything waiting to go from one node to another, in order
+func (cluster *Cluster) DeliverAll(from, to string) []Delivery {
+	deliveries := []Delivery{}
+	for {
+		delivery := cluster.Deliver(from, to)
+		if delivery == nil {
+			return deliveries
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+}
+
+// Sync delivers everything waiting between every pair of nodes over any Link that hasn't been cut. Pairs are visited in
+// the order their nodes were configured, each delivering everything it has before moving on to the next, so that the
+// same test always sees the same deliveries
+func (cluster *Cluster) Sync() []Delivery {
+	deliveries := []Delivery{}
+	for _, node := range cluster.nodes {
+		for _, peer := range cluster.nodes {
+			if peer != node {
+				deliveries = append(deliveries, cluster.DeliverAll(node.Name, peer.Name)...)
+			}
+		}
+	}
+	return deliveries
+}
+
+// Partition cuts every Link between the two groups of nodes, in both directions, leaving the Links within each group
+// alone. Messages created while partitioned wait until Heal
+func (cluster *Cluster) Partition(group []string, rest []string) {
+	for _, a := range group {
+		for _, b := range rest {
+			cluster.Link(a, b).Cut()
+			cluster.Link(b, a).Cut()
+		}
+	}
+}
+
+// Heal restores every Link in the Cluster
+func (cluster *Cluster) Heal() {
+	for _, node := range cluster.nodes {
+		for _, link := range node.links {
+			link.Restore()
+		}
+	}
+}
+
+// Converged reports whether every node has handled everything sent to it and every node's state matches
+func (cluster *Cluster) Converged() bool {
+	var state uint64
+	for i, node := range cluster.nodes {
+		status := node.Accord.Status()
+		if status.ToBeSyncedSize != 0 {
+			return false
+		}
+		if i > 0 && status.State != state {
+			return false
+		}
+		state = status.State
+	}
+	return true
+}
+
+// WaitForSync waits up to timeout for a live Cluster to converge (see Converged), returning whether it did
+func (cluster *Cluster) WaitForSync(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cluster.Converged() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Create makes a new Message from payload and handles it on this node, queuing it for every peer. It fails the test if
+// the Message can't be handled
+func (node *Node) Create(payload []byte) *accord.Message {
+	msg, err := node.Accord.NewMessage(payload)
+	if err != nil {
+		node.t.Fatalf("accordtest: %q could not create a message: %v", node.Name, err)
+	}
+
+	err = node.Accord.HandleNewMessage(msg)
+	if err != nil {
+		node.t.Fatalf("accordtest: %q could not handle a new message: %v", node.Name, err)
+	}
+	return msg
+}
+
+// State returns this node's current state
+func (node *Node) State() uint64 {
+	return node.Accord.Status().State
+}
+
+// Processed returns every Message this node's Manager has processed, in order, if it's one of our recording Managers
+func (node *Node) Processed() []accord.Message {
+	manager, ok := node.Manager.(*Manager)
+	if !ok {
+		return nil
+	}
+	return manager.Processed()
+}
+
+// Conflicts returns every conflict resolution decision this node has made, oldest first
+func (node *Node) Conflicts() []accord.ConflictRecord {
+	records, err := node.Accord.Conflicts(0, 0)
+	if err != nil {
+		node.t.Fatalf("accordtest: %q could not read its conflicts: %v", node.Name, err)
+	}
+	return records
+}
+
+// peers returns the names of the nodes we link to, sorted so that our components are always in the same order
+func (node *Node) peers() []string {
+	peers := []string{}
+	for peer := range node.links {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
@@ -0,0 +1,152 @@
+package accordtest
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterAligned(t *testing.T) {
+	cluster := NewCluster(t, Config{Nodes: []string{"a", "b", "c"}})
+	defer cluster.Close()
+
+	msg := cluster.Node("a").Create([]byte("hello"))
+
+	// Nothing moves until we say so
+	assert.Empty(t, cluster.Node("b").Processed())
+	assert.False(t, cluster.Converged())
+
+	delivery := cluster.Deliver("a", "b")
+	assert.NotNil(t, delivery)
+	assert.Equal(t, msg.ID, delivery.Message.ID)
+	assert.True(t, delivery.Processed)
+	assert.Nil(t, cluster.Deliver("a", "b"))
+
+	// a holds on to the Message until c has it too
+	assert.Equal(t, uint64(1), cluster.Node("a").Accord.Status().ToBeSyncedSize)
+	assert.Len(t, cluster.Sync(), 1)
+	assert.True(t, cluster.Converged())
+
+	for _, node := range cluster.Nodes() {
+		processed := node.Processed()
+		assert.Len(t, processed, 1)
+		assert.Equal(t, msg.ID, processed[0].ID)
+		assert.Empty(t, node.Conflicts())
+	}
+}
+
+// versionedWrite is a "key=value@version" payload, as written by our conflict tests
+type versionedWrite struct {
+	key     string
+	value   string
+	version int
+}
+
+func parseWrite(payload []byte) versionedWrite {
+	write := versionedWrite{}
+	parts := strings.SplitN(string(payload), "=", 2)
+	write.key = parts[0]
+	parts = strings.SplitN(parts[1], "@", 2)
+	write.value = parts[0]
+	write.version, _ = strconv.Atoi(parts[1])
+	return write
+}
+
+// lastWriterWins only lets a write through if nothing we've already applied wrote the same key at a later version
+func lastWriterWins(node string, msg accord.Message, history *accord.HistoryIterator) bool {
+	incoming := parseWrite(msg.Payload)
+	for {
+		previous, err := history.Next()
+		if err != nil || previous == nil {
+			return err == nil
+		}
+		applied := parseWrite(previous.Payload)
+		if applied.key == incoming.key && applied.version > incoming.version {
+			return false
+		}
+	}
+}
+
+// finalValues replays what a node processed to find where each of its keys ended up
+func finalValues(node *Node) map[string]string {
+	values := map[string]string{}
+	for _, msg := range node.Processed() {
+		write := parseWrite(msg.Payload)
+		values[write.key] = write.value
+	}
+	return values
+}
+
+func TestClusterConflictLastWriterWins(t *testing.T) {
+	cluster := NewCluster(t, Config{Nodes: []string{"a", "b"}, Resolve: lastWriterWins})
+	defer cluster.Close()
+
+	// Start out agreeing on x
+	cluster.Node("a").Create([]byte("x=start@1"))
+	cluster.Sync()
+	assert.True(t, cluster.Converged())
+
+	// Then both write x before hearing from each other, with b's write the later of the two
+	cluster.Node("a").Create([]byte("x=fromA@2"))
+	cluster.Node("b").Create([]byte("x=fromB@3"))
+	assert.NotEqual(t, cluster.Node("a").State(), cluster.Node("b").State())
+
+	// a gives way to b's later write, while b ignores a's earlier one
+	toB := cluster.Deliver("a", "b")
+	assert.False(t, toB.Processed)
+	toA := cluster.Deliver("b", "a")
+	assert.True(t, toA.Processed)
+
+	assert.True(t, cluster.Converged())
+	assert.Equal(t, map[string]string{"x": "fromB"}, finalValues(cluster.Node("a")))
+	assert.Equal(t, map[string]string{"x": "fromB"}, finalValues(cluster.Node("b")))
+
+	// And both decisions were audited
+	conflicts := cluster.Node("b").Conflicts()
+	assert.Len(t, conflicts, 1)
+	assert.Equal(t, toB.Message.ID, conflicts[0].MessageID)
+	assert.False(t, conflicts[0].Processed)
+	conflicts = cluster.Node("a").Conflicts()
+	assert.Len(t, conflicts, 1)
+	assert.True(t, conflicts[0].Processed)
+}
+
+func TestClusterPartition(t *testing.T) {
+	cluster := NewCluster(t, Config{Nodes: []string{"a", "b", "c"}, Resolve: lastWriterWins})
+	defer cluster.Close()
+
+	cluster.Partition([]string{"a"}, []string{"b", "c"})
+
+	// b and c keep talking among themselves while a is cut off
+	cluster.Node("a").Create([]byte("y=fromA@5"))
+	cluster.Node("b").Create([]byte("y=fromB@4"))
+	assert.Len(t, cluster.Sync(), 1)
+	assert.Nil(t, cluster.Deliver("a", "b"))
+	assert.Equal(t, cluster.Node("b").State(), cluster.Node("c").State())
+	assert.False(t, cluster.Converged())
+
+	// Once healed a's later write wins everywhere, even though b and c had both moved on without it
+	cluster.Heal()
+	cluster.Sync()
+	assert.True(t, cluster.Converged())
+	for _, node := range cluster.Nodes() {
+		assert.Equal(t, map[string]string{"y": "fromA"}, finalValues(node))
+	}
+}
+
+func TestClusterLive(t *testing.T) {
+	cluster := NewCluster(t, Config{Nodes: []string{"a", "b"}, Live: true})
+	defer cluster.Close()
+
+	cluster.Node("a").Create([]byte("one"))
+	cluster.Node("b").Create([]byte("two"))
+
+	assert.True(t, cluster.WaitForSync(time.Second))
+	assert.Len(t, cluster.Node("a").Processed(), 2)
+	assert.Len(t, cluster.Node("b").Processed(), 2)
+	assert.Nil(t, cluster.Link("a", "b").Err())
+}
@@ -0,0 +1,175 @@
+package accordtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+// Delivery describes a single Message a Link carried to its peer
+type Delivery struct {
+	// From and To name the nodes the Message went between
+	From string
+	To   string
+
+	// Message is the Message as our peer received it
+	Message accord.Message
+
+	// Processed is whether our peer's Manager processed the Message, rather than deciding against it
+	Processed bool
+}
+
+// Link is an in-memory Component carrying the Messages one node creates to one of its peers, standing in for a real
+// sync component like a PollListener and PollRequestor pair. It follows its own sync target in the sending node's
+// queue, named after the peer, so that a node with several peers only lets go of a Message once all of them have it.
+// Messages are serialized and deserialized on the way, just as they would be over the wire
+type Link struct {
+	accord.ComponentRunner
+
+	From *Node
+	To   *Node
+
+	// Live delivers Messages from our own goroutine as soon as they're queued, rather than only on Deliver
+	Live bool
+
+	// lock keeps a live Link's deliveries from overlapping with a test's, and protects cut, err, stopping and waited
+	lock *sync.Mutex
+	cut  bool
+
+	// stopping and waited are whether we've been told to stop and waited for it. A Link is stopped both by Cluster.Close
+	// and its node's Accord, but our ComponentRunner can only be stopped and waited on once
+	stopping bool
+	waited   bool
+
+	// err is what stopped a live Link, if it failed
+	err error
+}
+
+// Start registers our sync target and, if we're live, starts delivering
+func (link *Link) Start(acrd *accord.Accord) error {
+	link.lock = &sync.Mutex{}
+
+	err := acrd.ToBeSynced.RegisterTarget(link.To.Name)
+	if err != nil {
+		return err
+	}
+
+	if link.Live {
+		link.ComponentRunner.Init(acrd, link.tick, nil, acrd.Logger.WithField("component", "Link").WithField("peer", link.To.Name))
+	}
+	return nil
+}
+
+// Stop stops a live Link. There's nothing to stop otherwise, or once we've already been stopped
+func (link *Link) Stop(sig int) {
+	link.lock.Lock()
+	stopping := link.stopping
+	link.stopping = true
+	link.lock.Unlock()
+
+	if link.Live && !stopping {
+		link.ComponentRunner.Stop(sig)
+	}
+}
+
+// WaitForStop waits for a live Link to stop, the first time it's called
+func (link *Link) WaitForStop() {
+	link.lock.Lock()
+	waited := link.waited
+	link.waited = true
+	link.lock.Unlock()
+
+	if link.Live && !waited {
+		link.ComponentRunner.WaitForStop()
+	}
+}
+
+// tick delivers whatever is waiting, resting a moment when nothing is
+func (link *Link) tick(acrd *accord.Accord) {
+	delivery, err := link.Deliver()
+	if err != nil {
+		// Nobody is listening for a shutdown in a test, so we simply stop and leave our error for Err
+		acrd.Logger.WithError(err).WithField("peer", link.To.Name).Error("Could not deliver a message, stopping")
+		link.lock.Lock()
+		link.err = err
+		link.lock.Unlock()
+		link.Stop(0)
+		return
+	}
+	if delivery == nil {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Deliver carries the next Message waiting for our peer over to it, moving past it once our peer has handled it.
+// Returns nil if there's nothing waiting or we've been cut. If our peer fails to handle the Message it stays where it
+// is, to be delivered again
+func (link *Link) Deliver() (*Delivery, error) {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	if link.cut {
+		return nil, nil
+	}
+
+	from := link.From.Accord
+	msg, err := from.ToBeSynced.PeekTarget(link.To.Name)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+
+	out, err := from.TransformOutbound(msg, link.To.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := out.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	received, err := accord.DeserializeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hold on to the Message as it arrived, as handling it stamps it with our peer's state
+	delivery := &Delivery{From: link.From.Name, To: link.To.Name, Message: *received}
+
+	result, err := link.To.Accord.HandleRemoteMessageWithResult(received)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Processed = result.Processed
+
+	_, err = from.ToBeSynced.ConfirmTargetMessage(link.To.Name, msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Err returns the error that stopped a live Link, if any
+func (link *Link) Err() error {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	return link.err
+}
+
+// Cut stops the Link from delivering anything until it's restored, as though the network between its nodes was down.
+// Messages keep queuing up in the meantime
+func (link *Link) Cut() {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	link.cut = true
+}
+
+// Restore undoes Cut
+func (link *Link) Restore() {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	link.cut = false
+}
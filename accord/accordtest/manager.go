@@ -0,0 +1,41 @@
+package accordtest
+
+import (
+	"sync"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+// Manager is an accord.Manager that records every Message it processes, leaving conflict resolution to Resolve
+type Manager struct {
+	// Resolve is our ShouldProcess. Leaving it nil processes everything
+	Resolve func(msg accord.Message, history *accord.HistoryIterator) bool
+
+	lock      sync.Mutex
+	processed []accord.Message
+}
+
+// Process records the Message
+func (manager *Manager) Process(msg accord.Message, fromRemote bool) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.processed = append(manager.processed, msg)
+	return nil
+}
+
+// ShouldProcess asks Resolve, if we have one
+func (manager *Manager) ShouldProcess(msg accord.Message, history *accord.HistoryIterator) bool {
+	if manager.Resolve == nil {
+		return true
+	}
+	return manager.Resolve(msg, history)
+}
+
+// Processed returns every Message we've processed, in order
+func (manager *Manager) Processed() []accord.Message {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	return append([]accord.Message{}, manager.processed...)
+}
@@ -0,0 +1,104 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ArchiveSink receives the Messages our history is about to discard, so that they can be kept somewhere permanent.
+// Accord only needs its history until peers are back in sync, but a sink turns it into a durable event log
+type ArchiveSink interface {
+	// Archive is given each Message, oldest first. Returning an error is handled according to our ArchivePolicy
+	Archive(msg *Message) error
+}
+
+// ArchiveFunc lets a plain function be used as an ArchiveSink
+type ArchiveFunc func(msg *Message) error
+
+// Archive implements ArchiveSink
+func (fn ArchiveFunc) Archive(msg *Message) error {
+	return fn(msg)
+}
+
+// ArchivePolicy decides what happens to our history when an ArchiveSink fails to take a Message
+type ArchivePolicy int
+
+const (
+	// ArchiveAbort keeps the entire history and abandons the clear, to be tried again next time. Nothing is ever
+	// discarded without being archived, but whatever the sink took before failing will be handed to it again. This is
+	// the default
+	ArchiveAbort ArchivePolicy = iota
+
+	// ArchiveSkip carries on clearing, discarding whatever the sink couldn't take. Skipped Messages are counted
+	ArchiveSkip
+)
+
+// ArchiveError is returned when our history can't be cleared because an ArchiveSink failed under ArchiveAbort
+type ArchiveError struct {
+	// MessageID is the Message the sink failed to take
+	MessageID uint64
+
+	// Err is what the sink returned
+	Err error
+}
+
+func (err *ArchiveError) Error() string {
+	return fmt.Sprintf("could not archive message %d: %v", err.MessageID, err.Err)
+}
+
+// writerArchive is an ArchiveSink that writes to an io.Writer
+type writerArchive struct {
+	writer io.Writer
+}
+
+// NewWriterArchive creates an ArchiveSink that writes each Message to the passed in writer, serialized and prefixed
+// with its length as a big endian uint32. ReadArchive can be used to read them back
+func NewWriterArchive(writer io.Writer) ArchiveSink {
+	return &writerArchive{writer: writer}
+}
+
+// Archive implements ArchiveSink
+func (archive *writerArchive) Archive(msg *Message) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	writeField(buf, data)
+	_, err = archive.writer.Write(buf.Bytes())
+	return err
+}
+
+// ReadArchive reads back every Message written by a sink created with NewWriterArchive, passing each to fn in the
+// order they were archived. Returning an error from fn stops the read and returns that error
+func ReadArchive(reader io.Reader, fn func(*Message) error) error {
+	for {
+		var length uint32
+		err := binary.Read(reader, binary.BigEndian, &length)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return err
+		}
+
+		msg, err := deserializeMessage(data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package accord
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// ErrAtRestKey is returned when reading a record that was encrypted at rest with a key we haven't been given
+var ErrAtRestKey = errors.New("no at-rest key for encrypted record")
+
+// atRestKeyIDSize is how many bytes of a key's fingerprint we store alongside each encrypted record, so that we know
+// which key to decrypt it with
+const atRestKeyIDSize = 4
+
+// atRestKeyring holds the keys we encrypt and decrypt our persisted records with (see Accord.AtRestKey). A nil keyring
+// means encryption at rest is off, and is safe to call every method on
+type atRestKeyring struct {
+	// current is the ID of the key new records are encrypted with. It is nil if we're only decrypting
+	current []byte
+
+	// keys are every key we can decrypt with, by ID
+	keys map[string]cipher.AEAD
+}
+
+// newAtRestKeyring builds a keyring that encrypts with key and decrypts with key and every one of previous. Either may be
+// nil, and if both are the keyring is too
+func newAtRestKeyring(key []byte, previous ...[]byte) (*atRestKeyring, error) {
+	ring := &atRestKeyring{keys: map[string]cipher.AEAD{}}
+
+	keys := append([][]byte{key}, previous...)
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		ring.keys[string(atRestKeyID(k))] = aead
+	}
+
+	if key != nil {
+		ring.current = atRestKeyID(key)
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, nil
+	}
+	return ring, nil
+}
+
+// atRestKeyID returns the fingerprint we identify a key by. It's a truncated hash, so it gives nothing away about the key
+func atRestKeyID(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	return hash[:atRestKeyIDSize]
+}
+
+// seal encrypts a record we're about to persist, if we have a key to do so. The encrypted format shares our
+// serialization marker, so that it can be told apart from anything else we write:
+//
+//	marker | serializationVersionSealed | key ID | nonce | ciphertext
+//
+// The header is authenticated along with the ciphertext
+func (ring *atRestKeyring) seal(data []byte) ([]byte, error) {
+	if ring == nil || ring.current == nil {
+		return data, nil
+	}
+	aead := ring.keys[string(ring.current)]
+
+	header := append([]byte{serializationMarker, serializationVersionSealed}, ring.current...)
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal doesn't allow our output to overlap the header we're authenticating, so it gets its own copy
+	sealed := make([]byte, 0, len(header)+len(nonce)+len(data)+aead.Overhead())
+	sealed = append(append(sealed, header...), nonce...)
+	return aead.Seal(sealed, nonce, data, header), nil
+}
+
+// isSealed returns whether a persisted record was written by seal
+func isSealed(data []byte) bool {
+	return len(data) >= 2 && data[0] == serializationMarker && data[1] == serializationVersionSealed
+}
+
+// open decrypts a record written by seal. Anything that wasn't encrypted is returned as is
+func (ring *atRestKeyring) open(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+
+	headerSize := 2 + atRestKeyIDSize
+	if len(data) < headerSize {
+		return nil, ErrMalformedMessage
+	}
+	header := data[:headerSize]
+
+	if ring == nil {
+		return nil, ErrAtRestKey
+	}
+	aead, ok := ring.keys[string(header[2:])]
+	if !ok {
+		return nil, ErrAtRestKey
+	}
+
+	if len(data) < headerSize+aead.NonceSize() {
+		return nil, ErrMalformedMessage
+	}
+	nonce := data[headerSize : headerSize+aead.NonceSize()]
+
+	return aead.Open(nil, nonce, data[headerSize+aead.NonceSize():], header)
+}
+
+// sealMessage serializes a Message for our own persistence, encrypting it if we have a key
+func (ring *atRestKeyring) sealMessage(msg *Message) ([]byte, error) {
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return ring.seal(data)
+}
+
+// openMessage is the inverse of sealMessage. It also reads Messages that were compressed before being sealed
+func (ring *atRestKeyring) openMessage(data []byte) (*Message, error) {
+	data, err := ring.open(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = decompressRecord(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return deserializeMessage(data)
+}
+
+// valueToMessage is a helper for turning a value read from one of our backends into a Message, translating an empty
+// store into a nil Message
+func (ring *atRestKeyring) valueToMessage(value []byte, err error) (*Message, error) {
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	return ring.openMessage(value)
+}
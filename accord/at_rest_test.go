@@ -0,0 +1,176 @@
+package accord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtRestRoundTrip(t *testing.T) {
+	msg, err := NewMessage([]byte("top secret"))
+	assert.Nil(t, err)
+
+	// Without a key we persist the plain serialized Message
+	var none *atRestKeyring
+	plain, err := none.sealMessage(msg)
+	assert.Nil(t, err)
+	serialized, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, serialized, plain)
+
+	ring, err := newAtRestKeyring(bytes.Repeat([]byte{1}, 32))
+	assert.Nil(t, err)
+
+	sealed, err := ring.sealMessage(msg)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(sealed, msg.Payload))
+
+	opened, err := ring.openMessage(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, opened.ID)
+	assert.Equal(t, msg.Payload, opened.Payload)
+
+	// Records persisted before we had a key are still readable
+	opened, err = ring.openMessage(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, opened.ID)
+
+	// The encrypted form must never be mistaken for a Message
+	_, err = DeserializeMessage(sealed)
+	assert.Equal(t, ErrMalformedMessage, err)
+
+	// Tampering is detected
+	sealed[len(sealed)-1] ^= 0xFF
+	_, err = ring.openMessage(sealed)
+	assert.NotNil(t, err)
+}
+
+func TestAtRestKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 16)
+	msg := &Message{ID: 1, Payload: []byte("abc")}
+
+	ring, err := newAtRestKeyring(oldKey)
+	assert.Nil(t, err)
+	sealedOld, err := ring.sealMessage(msg)
+	assert.Nil(t, err)
+
+	// Once rotated, records written with the old key need it to be kept around
+	ring, err = newAtRestKeyring(newKey)
+	assert.Nil(t, err)
+	_, err = ring.openMessage(sealedOld)
+	assert.Equal(t, ErrAtRestKey, err)
+
+	rotating, err := newAtRestKeyring(newKey, oldKey)
+	assert.Nil(t, err)
+	opened, err := rotating.openMessage(sealedOld)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, opened.Payload)
+
+	// New records use the new key
+	sealedNew, err := rotating.sealMessage(msg)
+	assert.Nil(t, err)
+	_, err = ring.openMessage(sealedNew)
+	assert.Nil(t, err)
+
+	// Dropping our key entirely leaves encrypted records unreadable
+	ring, err = newAtRestKeyring(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, ring)
+	_, err = ring.openMessage(sealedNew)
+	assert.Equal(t, ErrAtRestKey, err)
+
+	_, err = newAtRestKeyring([]byte("not a valid length"))
+	assert.NotNil(t, err)
+}
+
+func TestAtRestAccord(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	queue := &memoryQueue{}
+	stack := &memoryStack{}
+	state := &memoryState{values: map[string][]byte{}}
+
+	accord := DummyAccord()
+	accord.AtRestKey = bytes.Repeat([]byte{1}, 32)
+	accord.Backends = Backends{
+		Queue: func(path string) (QueueBackend, error) {
+			if path == SyncFilename {
+				return queue, nil
+			}
+			return &memoryQueue{}, nil
+		},
+		Stack: func(string) (StackBackend, error) { return stack, nil },
+		State: func(string) (StateBackend, error) { return state, nil },
+	}
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	msg, err := NewMessage([]byte("top secret"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+	err = accord.state.Set("secret", "also top secret")
+	assert.Nil(t, err)
+
+	// Nothing we've written should be readable without our key
+	queued, err := queue.PeekByOffset(0)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(queued, msg.Payload))
+	pushed, err := stack.PeekByOffset(0)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(pushed, msg.Payload))
+	record, err := state.Get([]byte(stateKey))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(record, []byte("also top secret")))
+
+	// But reading it back through Accord is transparent
+	head, err := accord.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, head.Payload)
+
+	// Our key is ours alone, so another Accord in the same process can go without one
+	dir, err := ioutil.TempDir("", "accord-at-rest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	otherQueue := &memoryQueue{}
+	other := NewAccord(NewDummerManager(), nil, dir, accord.Logger)
+	other.Backends = Backends{
+		Queue: func(path string) (QueueBackend, error) {
+			if path == filepath.Join(dir, SyncFilename) {
+				return otherQueue, nil
+			}
+			return &memoryQueue{}, nil
+		},
+		Stack: func(string) (StackBackend, error) { return &memoryStack{}, nil },
+		State: func(string) (StateBackend, error) { return &memoryState{values: map[string][]byte{}}, nil },
+	}
+	err = other.Start()
+	assert.Nil(t, err)
+	err = other.HandleNewMessage(msg)
+	assert.Nil(t, err)
+	queued, err = otherQueue.PeekByOffset(0)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(queued, msg.Payload))
+	other.Stop()
+
+	accord.Stop()
+
+	// Reading our state back takes our key
+	_, err = NewState(state)
+	assert.Equal(t, ErrAtRestKey, err)
+	keys, err := newAtRestKeyring(accord.AtRestKey)
+	assert.Nil(t, err)
+	loaded, err := newState(state, keys)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, loaded.GetCurrent())
+	value := ""
+	_, err = loaded.Get("secret", &value)
+	assert.Nil(t, err)
+	assert.Equal(t, "also top secret", value)
+}
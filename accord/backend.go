@@ -0,0 +1,181 @@
+package accord
+
+import (
+	"errors"
+)
+
+// ErrKeyNotFound is returned by a StateBackend when asked for a key it doesn't have
+var ErrKeyNotFound = errors.New("key not found")
+
+// QueueBackend is the persisted FIFO structure underneath our SyncQueue and ConflictLog. Our wrappers take care of
+// serialization, locking and book keeping, so a backend only needs to store opaque values in order and be safe for use
+// from multiple goroutines
+type QueueBackend interface {
+	// Enqueue adds a value to the end of the queue
+	Enqueue(value []byte) error
+
+	// Dequeue removes and returns the value at the head of the queue. Returns nil if the queue is empty
+	Dequeue() ([]byte, error)
+
+	// PeekByOffset returns the value at the given offset from the head of the queue without removing it. Returns nil
+	// if there is nothing at that offset
+	PeekByOffset(offset uint64) ([]byte, error)
+
+	// Length returns the number of values in the queue
+	Length() uint64
+
+	// Flush forces everything written so far out to stable storage
+	Flush() error
+
+	// Close releases any resources held by the backend
+	Close() error
+}
+
+// QueueUsage describes how much a QueueBackend is holding, and how much it has used up to hold it
+type QueueUsage struct {
+	// Length is the number of values in the queue and Bytes is their total size
+	Length uint64
+	Bytes  uint64
+
+	// HighWaterMark is the highest item number the backend has handed out. For backends (such as goque) that never
+	// reuse item numbers it climbs with every value ever enqueued, however few are left
+	HighWaterMark uint64
+
+	// DiskBytes is how much disk the backend is taking up
+	DiskBytes uint64
+}
+
+// QueueUsageReporter is implemented by QueueBackends that can report their QueueUsage (see SyncQueue.Usage)
+type QueueUsageReporter interface {
+	Usage() (QueueUsage, error)
+}
+
+// StorageStats describes a backend's footprint on disk, for capacity planning (see Accord.StorageStats)
+type StorageStats struct {
+	// Tables is the number of SSTable files the backend has written, and DiskBytes how much disk it takes up altogether
+	// (tables, journal and all)
+	Tables    int
+	DiskBytes uint64
+
+	// Levels breaks our tables down by LevelDB level, for backends that can see that far into LevelDB. Levels without
+	// any tables may be left out
+	Levels []LevelStats `json:",omitempty"`
+}
+
+// LevelStats is how many tables are in a single LevelDB level, and how large they are
+type LevelStats struct {
+	Level  int
+	Tables int
+	Bytes  uint64
+}
+
+// StorageStatsReporter is implemented by backends (of any kind) that can report their StorageStats
+type StorageStatsReporter interface {
+	StorageStats() (StorageStats, error)
+}
+
+// ClearableQueue is implemented by QueueBackends that can drop and recreate themselves, reclaiming their disk and item
+// numbers (see SyncQueue.Clear)
+type ClearableQueue interface {
+	Clear() error
+}
+
+// StackBackend is the persisted LIFO structure underneath our HistoryStack
+type StackBackend interface {
+	// Push adds a value to the top of the stack
+	Push(value []byte) error
+
+	// Pop removes and returns the value at the top of the stack. Returns nil if the stack is empty
+	Pop() ([]byte, error)
+
+	// PeekByOffset returns the value at the given offset from the top of the stack without removing it. Returns nil
+	// if there is nothing at that offset
+	PeekByOffset(offset uint64) ([]byte, error)
+
+	// Length returns the number of values in the stack
+	Length() uint64
+
+	// Clear removes every value from the stack, leaving it ready for use
+	Clear() error
+
+	// Close releases any resources held by the backend
+	Close() error
+}
+
+// BatchStack is implemented by StackBackends that can push several values in a single write (see
+// HistoryStack.SetBatching). Values are pushed in order, so the last ends up on top
+type BatchStack interface {
+	PushBatch(values [][]byte) error
+}
+
+// StateBackend is the persisted key/value store underneath our State
+type StateBackend interface {
+	// Get returns the value stored under key, or ErrKeyNotFound if there isn't one
+	Get(key []byte) ([]byte, error)
+
+	// Write applies every operation in the batch atomically
+	Write(batch *StateBatch) error
+
+	// Keys returns every key that begins with the given prefix
+	Keys(prefix []byte) ([][]byte, error)
+
+	// Flush forces everything written so far out to stable storage
+	Flush() error
+
+	// Close releases any resources held by the backend
+	Close() error
+}
+
+// StateBatch is a set of writes to be applied to a StateBackend atomically
+type StateBatch struct {
+	Puts    []StateEntry
+	Deletes [][]byte
+}
+
+// StateEntry is a single key/value pair to be written as part of a StateBatch
+type StateEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// Put adds a write of value under key to the batch
+func (batch *StateBatch) Put(key, value []byte) {
+	batch.Puts = append(batch.Puts, StateEntry{Key: key, Value: value})
+}
+
+// Delete adds a removal of key to the batch. Deletes are applied after Puts
+func (batch *StateBatch) Delete(key []byte) {
+	batch.Deletes = append(batch.Deletes, key)
+}
+
+// QueueFactory opens or creates a QueueBackend stored at the passed in path
+type QueueFactory func(path string) (QueueBackend, error)
+
+// StackFactory opens or creates a StackBackend stored at the passed in path
+type StackFactory func(path string) (StackBackend, error)
+
+// StateFactory opens or creates a StateBackend stored at the passed in path
+type StateFactory func(path string) (StateBackend, error)
+
+// Backends lets the persistence engine underneath Accord be swapped out (for something like BoltDB, in an embedded
+// deployment where a single file matters more than LevelDB's throughput). Any factory left nil falls back to our
+// goque/LevelDB implementation
+type Backends struct {
+	Queue QueueFactory
+	Stack StackFactory
+	State StateFactory
+}
+
+// withDefaults returns a copy of the Backends with any missing factories filled in
+func (backends Backends) withDefaults() Backends {
+	if backends.Queue == nil {
+		backends.Queue = OpenGoqueQueue
+	}
+	if backends.Stack == nil {
+		backends.Stack = OpenGoqueStack
+	}
+	if backends.State == nil {
+		backends.State = OpenLevelDBState
+	}
+	return backends
+}
@@ -0,0 +1,234 @@
+package accord
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQueue is a QueueBackend kept entirely in memory, to prove that Accord doesn't depend on goque. Setting fail
+// makes every write return it
+type memoryQueue struct {
+	values [][]byte
+	lock   sync.Mutex
+	fail   error
+}
+
+func (queue *memoryQueue) Enqueue(value []byte) error {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if queue.fail != nil {
+		return queue.fail
+	}
+	queue.values = append(queue.values, append([]byte{}, value...))
+	return nil
+}
+
+func (queue *memoryQueue) Dequeue() ([]byte, error) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.values) == 0 {
+		return nil, nil
+	}
+	value := queue.values[0]
+	queue.values = queue.values[1:]
+	return value, nil
+}
+
+func (queue *memoryQueue) PeekByOffset(offset uint64) ([]byte, error) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if offset >= uint64(len(queue.values)) {
+		return nil, nil
+	}
+	return queue.values[offset], nil
+}
+
+func (queue *memoryQueue) Length() uint64 {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return uint64(len(queue.values))
+}
+
+func (queue *memoryQueue) Flush() error { return nil }
+func (queue *memoryQueue) Close() error { return nil }
+
+type memoryStack struct {
+	memoryQueue
+}
+
+func (stack *memoryStack) Push(value []byte) error {
+	stack.lock.Lock()
+	defer stack.lock.Unlock()
+	if stack.fail != nil {
+		return stack.fail
+	}
+	stack.values = append([][]byte{append([]byte{}, value...)}, stack.values...)
+	return nil
+}
+
+func (stack *memoryStack) Pop() ([]byte, error) {
+	return stack.Dequeue()
+}
+
+func (stack *memoryStack) Clear() error {
+	stack.lock.Lock()
+	defer stack.lock.Unlock()
+	stack.values = nil
+	return nil
+}
+
+type memoryState struct {
+	values map[string][]byte
+	lock   sync.Mutex
+	fail   error
+}
+
+func (state *memoryState) Get(key []byte) ([]byte, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	value, ok := state.values[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (state *memoryState) Write(batch *StateBatch) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.fail != nil {
+		return state.fail
+	}
+	for _, entry := range batch.Puts {
+		state.values[string(entry.Key)] = append([]byte{}, entry.Value...)
+	}
+	for _, key := range batch.Deletes {
+		delete(state.values, string(key))
+	}
+	return nil
+}
+
+func (state *memoryState) Keys(prefix []byte) ([][]byte, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	var keys [][]byte
+	for key := range state.values {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys, nil
+}
+
+func (state *memoryState) Flush() error { return nil }
+func (state *memoryState) Close() error { return nil }
+
+func TestAccordCustomBackends(t *testing.T) {
+	defer AccordCleanup()
+
+	opened := map[string]bool{}
+	stack := &memoryStack{}
+	state := &memoryState{values: map[string][]byte{}}
+
+	accord := DummyAccord()
+	accord.Dedup = &BloomConfig{Capacity: 100}
+	accord.Backends = Backends{
+		Queue: func(path string) (QueueBackend, error) {
+			opened[path] = true
+			return &memoryQueue{}, nil
+		},
+		Stack: func(path string) (StackBackend, error) {
+			opened[path] = true
+			return stack, nil
+		},
+		State: func(path string) (StateBackend, error) {
+			opened[path] = true
+			return state, nil
+		},
+	}
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{
+		SyncFilename:        true,
+		HistoryFilename:     true,
+		StateFilename:       true,
+		ConflictLogFilename: true,
+		PendingFilename:     true,
+		DeferredFilename:    true,
+	}, opened)
+
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	status := accord.Status()
+	assert.Equal(t, uint64(1), status.ToBeSyncedSize)
+	assert.Equal(t, uint64(1), status.HistorySize)
+	assert.Equal(t, msg.ID, status.State)
+
+	// Everything should have landed in our backends rather than on disk
+	assert.Equal(t, uint64(1), stack.Length())
+	_, err = state.Get([]byte(stateKey))
+	assert.Nil(t, err)
+
+	accord.Stop()
+}
+
+func TestParseLevelDBStats(t *testing.T) {
+	stats := "Compactions\n" +
+		" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
+		"-------+------------+---------------+---------------+---------------+---------------\n" +
+		"   0   |          2 |       1.50000 |       0.00000 |       0.00000 |       0.00000\n" +
+		"   1   |          5 |      10.00000 |       0.01000 |       1.00000 |       1.00000\n"
+
+	assert.Equal(t, []LevelStats{
+		{Level: 0, Tables: 2, Bytes: 1572864},
+		{Level: 1, Tables: 5, Bytes: 10485760},
+	}, parseLevelDBStats(stats))
+	assert.Empty(t, parseLevelDBStats(""))
+}
+
+func TestAccordStorageStats(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := &DummyManager{ShouldProcessRet: true}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	for id := uint64(1); id <= 10; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id, Payload: bytes.Repeat([]byte{1}, 100)}))
+	}
+
+	stats, err := accord.StorageStats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 3)
+	for _, name := range []string{SyncFilename, HistoryFilename, StateFilename} {
+		assert.True(t, stats[name].DiskBytes > 0, "%s reported no disk usage", name)
+		assert.True(t, stats[name].Tables >= 0)
+	}
+	for _, level := range stats[StateFilename].Levels {
+		assert.True(t, level.Level >= 0 && level.Tables >= 0)
+	}
+	accord.Stop()
+
+	// Backends that can't report on themselves are left out
+	accord = DummyAccord()
+	accord.Backends = Backends{
+		Queue: func(string) (QueueBackend, error) { return &memoryQueue{}, nil },
+		State: func(string) (StateBackend, error) { return &memoryState{values: map[string][]byte{}}, nil },
+	}
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	stats, err = accord.StorageStats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	assert.Contains(t, stats, HistoryFilename)
+}
@@ -0,0 +1,370 @@
+package accord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ErrBackupFormat is returned by RestoreDataDir when what it's given isn't a backup written by BackupDataDir, or was
+// written by a newer version of Accord than us
+var ErrBackupFormat = errors.New("not a readable Accord backup")
+
+// ErrRestoreNotEmpty is returned by RestoreDataDir when the data directory already holds some of our data. We never
+// restore over the top of an existing node, as the two would be hopelessly mixed together
+var ErrRestoreNotEmpty = errors.New("data directory already holds Accord data")
+
+// backupMagic and backupVersion start every backup. The version must change whenever a previous version could no
+// longer read what we write
+const (
+	backupMagic   = "ACCORDBK"
+	backupVersion = 1
+)
+
+// backupKind identifies each section of a backup, and so how the store it holds is read and written
+type backupKind byte
+
+const (
+	backupQueue backupKind = 1
+	backupStack backupKind = 2
+	backupState backupKind = 3
+
+	// backupEnd marks the end of a backup, so that a truncated one can't pass for a complete one
+	backupEnd backupKind = 0xFF
+)
+
+// backupStores is every store we keep in our data directory, and what kind of store it is
+var backupStores = []struct {
+	name string
+	kind backupKind
+}{
+	{SyncFilename, backupQueue},
+	{HistoryFilename, backupStack},
+	{StateFilename, backupState},
+	{ConflictLogFilename, backupQueue},
+	{ReceiptLogFilename, backupQueue},
+	{ConfirmationsFilename, backupState},
+	{DeadLetterFilename, backupQueue},
+	{CursorsFilename, backupState},
+	{PendingFilename, backupQueue},
+	{DeferredFilename, backupQueue},
+}
+
+// BackupDataDir copies everything Accord keeps in dataDir (our sync queue, history, state, and the rest) into a single
+// portable archive written to w, which RestoreDataDir turns back into a data directory. It's meant for cold backups of
+// a stopped node, and takes our data directory lock to make sure nobody is using it. Unlike copying the raw LevelDB
+// directories it can't capture a store mid-compaction. Records are copied exactly as they're stored, so anything
+// encrypted at rest stays encrypted (see Accord.AtRestKey). Leaving backends empty uses our goque/LevelDB defaults
+func BackupDataDir(dataDir string, w io.Writer, backends Backends) error {
+	lock, err := lockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	backends = backends.withDefaults()
+	out := bufio.NewWriter(w)
+
+	out.WriteString(backupMagic)
+	out.WriteByte(backupVersion)
+
+	for _, store := range backupStores {
+		storePath := path.Join(dataDir, store.name)
+		if _, err := os.Stat(storePath); os.IsNotExist(err) {
+			continue
+		}
+
+		out.WriteByte(byte(store.kind))
+		writeBackupField(out, []byte(store.name))
+
+		switch store.kind {
+		case backupQueue:
+			err = backupQueueStore(out, storePath, backends)
+		case backupStack:
+			err = backupStackStore(out, storePath, backends)
+		case backupState:
+			err = backupStateStore(out, storePath, backends)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to back up %s: %v", store.name, err)
+		}
+	}
+
+	out.WriteByte(byte(backupEnd))
+	return out.Flush()
+}
+
+// backupQueueStore writes out every value in a queue, head first
+func backupQueueStore(out *bufio.Writer, storePath string, backends Backends) error {
+	queue, err := backends.Queue(storePath)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	length := queue.Length()
+	writeBackupCount(out, length)
+	for i := uint64(0); i < length; i++ {
+		value, err := queue.PeekByOffset(i)
+		if err != nil {
+			return err
+		}
+		writeBackupField(out, value)
+	}
+	return nil
+}
+
+// backupStackStore writes out every value in a stack, bottom first, so that they can be pushed back in the same order
+func backupStackStore(out *bufio.Writer, storePath string, backends Backends) error {
+	stack, err := backends.Stack(storePath)
+	if err != nil {
+		return err
+	}
+	defer stack.Close()
+
+	length := stack.Length()
+	writeBackupCount(out, length)
+	for i := length; i > 0; i-- {
+		value, err := stack.PeekByOffset(i - 1)
+		if err != nil {
+			return err
+		}
+		writeBackupField(out, value)
+	}
+	return nil
+}
+
+// backupStateStore writes out every key and value in a state store
+func backupStateStore(out *bufio.Writer, storePath string, backends Backends) error {
+	db, err := backends.State(storePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := db.Keys(nil)
+	if err != nil {
+		return err
+	}
+
+	writeBackupCount(out, uint64(len(keys)))
+	for _, key := range keys {
+		value, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		writeBackupField(out, key)
+		writeBackupField(out, value)
+	}
+	return nil
+}
+
+// RestoreDataDir recreates a data directory from an archive written by BackupDataDir. dataDir must not already hold
+// any of our data, and if the restore fails part way through whatever it had written is removed again. Leaving
+// backends empty uses our goque/LevelDB defaults
+func RestoreDataDir(r io.Reader, dataDir string, backends Backends) (err error) {
+	err = prepareDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	for _, store := range backupStores {
+		if _, err := os.Stat(path.Join(dataDir, store.name)); !os.IsNotExist(err) {
+			return ErrRestoreNotEmpty
+		}
+	}
+
+	// Don't leave a half restored data directory behind for somebody to start a node from
+	restored := []string{}
+	defer func() {
+		if err != nil {
+			for _, storePath := range restored {
+				os.RemoveAll(storePath)
+			}
+		}
+	}()
+
+	backends = backends.withDefaults()
+	in := bufio.NewReader(r)
+
+	header := make([]byte, len(backupMagic)+1)
+	_, err = io.ReadFull(in, header)
+	if err != nil || string(header[:len(backupMagic)]) != backupMagic || header[len(backupMagic)] > backupVersion {
+		return ErrBackupFormat
+	}
+
+	known := map[string]backupKind{}
+	for _, store := range backupStores {
+		known[store.name] = store.kind
+	}
+
+	for {
+		kind, err := in.ReadByte()
+		if err != nil {
+			return backupReadError(err)
+		}
+		if backupKind(kind) == backupEnd {
+			return nil
+		}
+
+		name, err := readBackupField(in)
+		if err != nil {
+			return err
+		}
+		if expected, ok := known[string(name)]; !ok || expected != backupKind(kind) {
+			return ErrBackupFormat
+		}
+		storePath := path.Join(dataDir, string(name))
+		restored = append(restored, storePath)
+
+		switch backupKind(kind) {
+		case backupQueue:
+			err = restoreQueueStore(in, storePath, backends)
+		case backupStack:
+			err = restoreStackStore(in, storePath, backends)
+		case backupState:
+			err = restoreStateStore(in, storePath, backends)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to restore %s: %v", name, err)
+		}
+	}
+}
+
+// restoreQueueStore reads back what backupQueueStore wrote
+func restoreQueueStore(in *bufio.Reader, storePath string, backends Backends) error {
+	queue, err := backends.Queue(storePath)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	count, err := readBackupCount(in)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		value, err := readBackupField(in)
+		if err != nil {
+			return err
+		}
+		err = queue.Enqueue(value)
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Flush()
+}
+
+// restoreStackStore reads back what backupStackStore wrote
+func restoreStackStore(in *bufio.Reader, storePath string, backends Backends) error {
+	stack, err := backends.Stack(storePath)
+	if err != nil {
+		return err
+	}
+	defer stack.Close()
+
+	count, err := readBackupCount(in)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		value, err := readBackupField(in)
+		if err != nil {
+			return err
+		}
+		err = stack.Push(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreStateStore reads back what backupStateStore wrote, writing it all in a single batch
+func restoreStateStore(in *bufio.Reader, storePath string, backends Backends) error {
+	db, err := backends.State(storePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := readBackupCount(in)
+	if err != nil {
+		return err
+	}
+	batch := &StateBatch{}
+	for i := uint64(0); i < count; i++ {
+		key, err := readBackupField(in)
+		if err != nil {
+			return err
+		}
+		value, err := readBackupField(in)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, value)
+	}
+
+	err = db.Write(batch)
+	if err != nil {
+		return err
+	}
+	return db.Flush()
+}
+
+// writeBackupCount writes out how many records a section holds
+func writeBackupCount(out *bufio.Writer, count uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	out.Write(buf[:binary.PutUvarint(buf, count)])
+}
+
+// writeBackupField writes out a length prefixed value
+func writeBackupField(out *bufio.Writer, value []byte) {
+	writeBackupCount(out, uint64(len(value)))
+	out.Write(value)
+}
+
+// readBackupCount reads what writeBackupCount wrote
+func readBackupCount(in *bufio.Reader) (uint64, error) {
+	count, err := binary.ReadUvarint(in)
+	if err != nil {
+		return 0, backupReadError(err)
+	}
+	return count, nil
+}
+
+// readBackupField reads what writeBackupField wrote
+func readBackupField(in *bufio.Reader) ([]byte, error) {
+	size, err := readBackupCount(in)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't trust a corrupted length to tell us how much to allocate up front
+	value := &bytes.Buffer{}
+	_, err = io.CopyN(value, in, int64(size))
+	if err != nil {
+		return nil, backupReadError(err)
+	}
+	return value.Bytes(), nil
+}
+
+// backupReadError turns running out of backup into ErrBackupFormat, as a backup always ends with backupEnd
+func backupReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBackupFormat
+	}
+	return err
+}
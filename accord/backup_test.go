@@ -0,0 +1,98 @@
+package accord
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	os.RemoveAll("backup-src")
+	os.RemoveAll("backup-dst")
+	defer os.RemoveAll("backup-src")
+	defer os.RemoveAll("backup-dst")
+
+	source := DummyAccord()
+	source.dataDir = "backup-src"
+	err := source.Start()
+	assert.Nil(t, err)
+
+	for i := byte(1); i <= 3; i++ {
+		msg, err := NewMessage([]byte{i})
+		assert.Nil(t, err)
+		assert.Nil(t, source.HandleNewMessage(msg))
+	}
+	_, err = source.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	assert.Nil(t, source.state.Set("name", "value"))
+
+	queued, err := source.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	history, err := source.History(0, 0)
+	assert.Nil(t, err)
+	state := source.state.GetCurrent()
+	source.Stop()
+
+	backup := &bytes.Buffer{}
+	err = BackupDataDir("backup-src", backup, Backends{})
+	assert.Nil(t, err)
+
+	err = RestoreDataDir(bytes.NewReader(backup.Bytes()), "backup-dst", Backends{})
+	assert.Nil(t, err)
+
+	restored := DummyAccord()
+	restored.dataDir = "backup-dst"
+	err = restored.Start()
+	assert.Nil(t, err)
+	defer restored.Stop()
+
+	assert.Equal(t, uint64(2), restored.ToBeSynced.Size())
+	head, err := restored.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, queued.ID, head.ID)
+
+	restoredHistory, err := restored.History(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(history), len(restoredHistory))
+	for i := range history {
+		assert.Equal(t, history[i].ID, restoredHistory[i].ID)
+	}
+
+	assert.Equal(t, state, restored.state.GetCurrent())
+	value := ""
+	found, err := restored.state.Get("name", &value)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "value", value)
+}
+
+func TestRestoreRejects(t *testing.T) {
+	os.RemoveAll("backup-src")
+	os.RemoveAll("backup-dst")
+	defer os.RemoveAll("backup-src")
+	defer os.RemoveAll("backup-dst")
+
+	source := DummyAccord()
+	source.dataDir = "backup-src"
+	assert.Nil(t, source.Start())
+	assert.Nil(t, source.HandleNewMessage(&Message{ID: 1}))
+	source.Stop()
+
+	backup := &bytes.Buffer{}
+	assert.Nil(t, BackupDataDir("backup-src", backup, Backends{}))
+
+	// Anything that isn't a backup, or is cut short, is refused without leaving anything behind
+	err := RestoreDataDir(bytes.NewReader([]byte("not a backup")), "backup-dst", Backends{})
+	assert.Equal(t, ErrBackupFormat, err)
+	err = RestoreDataDir(bytes.NewReader(backup.Bytes()[:backup.Len()-1]), "backup-dst", Backends{})
+	assert.Equal(t, ErrBackupFormat, err)
+	_, err = os.Stat(path.Join("backup-dst", SyncFilename))
+	assert.True(t, os.IsNotExist(err))
+
+	// As is restoring over the top of existing data
+	err = RestoreDataDir(bytes.NewReader(backup.Bytes()), "backup-src", Backends{})
+	assert.Equal(t, ErrRestoreNotEmpty, err)
+}
@@ -0,0 +1,129 @@
+package accord
+
+import (
+	"math"
+)
+
+// bloomBlockSize is the number of bytes in each chunk of our bloom filter that gets persisted individually. Keeping
+// these small means that adding an ID only rewrites a few hundred bytes on disk rather than the whole filter
+const bloomBlockSize = 64
+
+// BloomConfig configures the optional bloom filter Accord can use to cheaply detect remote Messages that it has
+// already handled
+type BloomConfig struct {
+	// Capacity is the number of Messages we expect to handle. Going past this is fine, but the false positive rate
+	// will creep up the further past it we go
+	Capacity uint64
+
+	// FalsePositiveRate is the rate at which we'll accept the filter telling us we've "maybe" seen a Message we
+	// haven't (which costs us a history scan to double check). Defaults to 1%
+	FalsePositiveRate float64
+}
+
+// bloomFilter is a simple bloom filter over Message IDs. Our IDs are already the result of a cryptographic hash, so we
+// don't need to hash them again; we derive our k indexes from the ID using double hashing
+type bloomFilter struct {
+	bits []byte
+
+	// m is the number of bits in our filter and k the number of indexes we set for each ID
+	m uint64
+	k uint64
+}
+
+// newBloomFilter creates a bloom filter sized for the passed in configuration
+func newBloomFilter(config BloomConfig) *bloomFilter {
+	m, k := bloomParameters(config)
+	return &bloomFilter{
+		bits: make([]byte, m/8),
+		m:    m,
+		k:    k,
+	}
+}
+
+// bloomParameters works out the optimal number of bits and indexes for the passed in configuration. Our bits are
+// always rounded up to a whole number of blocks
+func bloomParameters(config BloomConfig) (uint64, uint64) {
+	rate := config.FalsePositiveRate
+	if rate <= 0 || rate >= 1 {
+		rate = 0.01
+	}
+	capacity := config.Capacity
+	if capacity == 0 {
+		capacity = 1
+	}
+
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	blockBits := uint64(bloomBlockSize * 8)
+	m = ((m + blockBits - 1) / blockBits) * blockBits
+
+	k := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return m, k
+}
+
+// indexes returns the bit positions for the given ID
+func (bloom *bloomFilter) indexes(id uint64) []uint64 {
+	// splitmix64 finalizer, so that our second hash isn't trivially correlated with the first
+	h2 := id + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 = h2 ^ (h2 >> 31)
+
+	indexes := make([]uint64, bloom.k)
+	for i := uint64(0); i < bloom.k; i++ {
+		indexes[i] = (id + i*h2) % bloom.m
+	}
+	return indexes
+}
+
+// add sets the bits for the given ID and returns those that weren't already set
+func (bloom *bloomFilter) add(id uint64) []uint64 {
+	var changed []uint64
+	for _, index := range bloom.indexes(id) {
+		mask := byte(1) << (index % 8)
+		if bloom.bits[index/8]&mask == 0 {
+			bloom.bits[index/8] |= mask
+			changed = append(changed, index)
+		}
+	}
+	return changed
+}
+
+// unset clears the passed in bits, undoing an add
+func (bloom *bloomFilter) unset(indexes []uint64) {
+	for _, index := range indexes {
+		bloom.bits[index/8] &^= byte(1) << (index % 8)
+	}
+}
+
+// blocks returns the blocks holding the passed in bits, without duplicates
+func (bloom *bloomFilter) blocks(indexes []uint64) []uint64 {
+	var blocks []uint64
+	seen := map[uint64]bool{}
+	for _, index := range indexes {
+		block := index / 8 / bloomBlockSize
+		if !seen[block] {
+			seen[block] = true
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// test returns false if we have definitely never added the ID, and true if we may have
+func (bloom *bloomFilter) test(id uint64) bool {
+	for _, index := range bloom.indexes(id) {
+		if bloom.bits[index/8]&(byte(1)<<(index%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// block returns the bytes of the given block so that it can be persisted
+func (bloom *bloomFilter) block(index uint64) []byte {
+	return bloom.bits[index*bloomBlockSize : (index+1)*bloomBlockSize]
+}
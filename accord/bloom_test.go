@@ -0,0 +1,41 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	bloom := newBloomFilter(BloomConfig{Capacity: 1000, FalsePositiveRate: 0.01})
+	assert.Zero(t, bloom.m%(bloomBlockSize*8))
+
+	for id := uint64(0); id < 1000; id++ {
+		bloom.add(id * 7919)
+	}
+
+	// Bloom filters never give false negatives
+	for id := uint64(0); id < 1000; id++ {
+		assert.True(t, bloom.test(id*7919))
+	}
+
+	// And our false positive rate should be somewhere near what we asked for
+	falsePositives := 0
+	for id := uint64(1000); id < 11000; id++ {
+		if bloom.test(id*7919 + 1) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 300, "too many false positives: %d", falsePositives)
+}
+
+func TestBloomFilterUnset(t *testing.T) {
+	bloom := newBloomFilter(BloomConfig{Capacity: 10})
+
+	changed := bloom.add(42)
+	assert.NotEmpty(t, changed)
+	assert.True(t, bloom.test(42))
+
+	bloom.unset(changed)
+	assert.False(t, bloom.test(42))
+}
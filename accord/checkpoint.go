@@ -0,0 +1,145 @@
+package accord
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrUnknownCheckpoint is returned when a peer acknowledges a checkpoint we never took
+var ErrUnknownCheckpoint = errors.New("unknown checkpoint")
+
+// Checkpoint marks a point in our history that our peers can acknowledge having reached (see Accord.Checkpoint). Once
+// every one of our CheckpointPeers has, none of them can send us anything that conflicts with what our history held at
+// the time, so we no longer need it
+type Checkpoint struct {
+	// ID identifies the checkpoint. Each checkpoint we take has a higher ID than the last
+	ID uint64
+
+	// State is our state when the checkpoint was taken
+	State uint64
+
+	// Through is the ID of the newest Message in our history when the checkpoint was taken, or zero if it was empty
+	Through uint64
+
+	// Taken is when the checkpoint was taken
+	Taken time.Time
+}
+
+// checkpointTracker holds the checkpoints our history hasn't yet been pruned up to, oldest first, along with the
+// newest checkpoint each peer has acknowledged. It's protected by processMutex
+type checkpointTracker struct {
+	last  uint64
+	open  []Checkpoint
+	acked map[string]uint64
+}
+
+func newCheckpointTracker() *checkpointTracker {
+	return &checkpointTracker{acked: map[string]uint64{}}
+}
+
+// Checkpoint marks where our history currently stands, for our peers to acknowledge through AcknowledgeCheckpoint once
+// they've reached it. How a peer learns of our checkpoints, and decides it has reached one, is up to the application:
+// a peer has reached a checkpoint once it has handled every Message we had when we took it. Checkpoints are only kept
+// in memory, so those still waiting on acknowledgements when we stop are forgotten and our history stays as it is
+// until new ones are taken and acknowledged
+func (accord *Accord) Checkpoint() (Checkpoint, error) {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	checkpoint := Checkpoint{
+		ID:    accord.checkpoints.last + 1,
+		State: accord.state.GetCurrent(),
+		Taken: time.Now().UTC(),
+	}
+	if !accord.DisableHistory {
+		newest, err := accord.history.Peek()
+		if err != nil {
+			return Checkpoint{}, err
+		}
+		if newest != nil {
+			checkpoint.Through = newest.ID
+		}
+	}
+
+	accord.checkpoints.last = checkpoint.ID
+	accord.checkpoints.open = append(accord.checkpoints.open, checkpoint)
+	accord.Logger.WithField("checkpoint", checkpoint.ID).WithField("through", checkpoint.Through).Debug("Took a checkpoint")
+	return checkpoint, nil
+}
+
+// Checkpoints returns the checkpoints we've taken that our history hasn't yet been pruned up to, oldest first
+func (accord *Accord) Checkpoints() []Checkpoint {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	return append([]Checkpoint{}, accord.checkpoints.open...)
+}
+
+// AcknowledgeCheckpoint records that peer has reached the checkpoint with the given ID, and so won't need anything our
+// history held at the time to resolve a conflict with us. Once every one of our CheckpointPeers has acknowledged a
+// checkpoint our history is pruned up to it. Acknowledging a checkpoint older than one the peer already has is ignored
+func (accord *Accord) AcknowledgeCheckpoint(peer string, id uint64) error {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	if id == 0 || id > accord.checkpoints.last {
+		return ErrUnknownCheckpoint
+	}
+	if id > accord.checkpoints.acked[peer] {
+		accord.checkpoints.acked[peer] = id
+	}
+
+	return accord.pruneToCheckpoint()
+}
+
+// pruneToCheckpoint drops our history up to the newest checkpoint every one of our CheckpointPeers has acknowledged.
+// processMutex must be held by the caller
+func (accord *Accord) pruneToCheckpoint() error {
+	// Without knowing who our peers are we can never be sure they've all caught up
+	if len(accord.CheckpointPeers) == 0 {
+		return nil
+	}
+
+	reached := uint64(math.MaxUint64)
+	for _, peer := range accord.CheckpointPeers {
+		if accord.checkpoints.acked[peer] < reached {
+			reached = accord.checkpoints.acked[peer]
+		}
+	}
+
+	passed := 0
+	for passed < len(accord.checkpoints.open) && accord.checkpoints.open[passed].ID <= reached {
+		passed++
+	}
+	if passed == 0 {
+		return nil
+	}
+	checkpoint := accord.checkpoints.open[passed-1]
+
+	// If our history has been cleared since (we've aligned with a peer, say) our checkpoint's Message is already gone,
+	// along with everything older, and there's nothing left to prune
+	if !accord.DisableHistory && checkpoint.Through != 0 {
+		dropped, err := accord.history.TruncateThrough(checkpoint.Through)
+		if archiveErr, ok := err.(*ArchiveError); ok {
+			// Our history is still intact, so we'll simply try again on the next acknowledgement
+			accord.Logger.WithError(archiveErr).Warn("Could not archive our history, keeping it for now")
+			return nil
+		} else if err != nil {
+			accord.Logger.WithError(err).Error("Could not prune our history to a checkpoint")
+			return accord.fail(ShutdownStorage, err)
+		}
+		accord.Logger.WithField("checkpoint", checkpoint.ID).WithField("dropped", dropped).Info("Every peer has reached a checkpoint, pruned our history")
+	}
+
+	accord.checkpoints.open = accord.checkpoints.open[passed:]
+	return nil
+}
+
+// takeCheckpoint takes a checkpoint every CheckpointInterval
+func (accord *Accord) takeCheckpoint() {
+	_, err := accord.Checkpoint()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not take a checkpoint")
+	}
+}
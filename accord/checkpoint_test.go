@@ -0,0 +1,76 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordCheckpoints(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.CheckpointPeers = []string{"a", "b"}
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+	first, err := accord.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), first.ID)
+	assert.Equal(t, uint64(3), first.Through)
+	assert.Equal(t, uint64(6), first.State)
+
+	for id := uint64(4); id <= 5; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+	second, err := accord.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), second.ID)
+	assert.Equal(t, uint64(5), second.Through)
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 6}))
+
+	assert.Equal(t, ErrUnknownCheckpoint, accord.AcknowledgeCheckpoint("a", 3))
+
+	// One peer having caught up isn't enough, as the other could still need anything in our history
+	assert.Nil(t, accord.AcknowledgeCheckpoint("a", second.ID))
+	assert.Equal(t, uint64(6), accord.history.Size())
+
+	// Once both have reached our first checkpoint we can let go of everything up to it
+	assert.Nil(t, accord.AcknowledgeCheckpoint("b", first.ID))
+	assert.Equal(t, uint64(3), accord.history.Size())
+	assert.Equal(t, []Checkpoint{second}, accord.Checkpoints())
+
+	// An older acknowledgement doesn't take a peer backwards
+	assert.Nil(t, accord.AcknowledgeCheckpoint("a", first.ID))
+	assert.Nil(t, accord.AcknowledgeCheckpoint("b", second.ID))
+	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Empty(t, accord.Checkpoints())
+
+	newest, err := accord.history.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(6), newest.ID)
+}
+
+func TestAccordCheckpointsWithoutPeers(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	checkpoint, err := accord.Checkpoint()
+	assert.Nil(t, err)
+
+	// Without knowing our peers we never prune
+	assert.Nil(t, accord.AcknowledgeCheckpoint("a", checkpoint.ID))
+	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Len(t, accord.Checkpoints(), 1)
+}
@@ -2,6 +2,7 @@ package accord
 
 import (
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -21,6 +22,69 @@ type Component interface {
 	WaitForStop()
 }
 
+// NamedComponent is implemented by Components that can be addressed by name, allowing them to be looked up (and managed)
+// at runtime through Accord.FindComponent
+type NamedComponent interface {
+	Component
+
+	// ComponentName returns the name this Component was configured with. Names should be unique within an Accord process
+	ComponentName() string
+}
+
+// PausableComponent is implemented by Components that can temporarily halt their work without being fully stopped. This is
+// useful for things like halting outbound synchronization during an incident while keeping local ingestion running
+type PausableComponent interface {
+	Component
+
+	// Pause halts the Component's work without tearing down its resources
+	Pause()
+
+	// Resume picks back up where Pause left off
+	Resume()
+
+	// Health reports on the current condition of the Component
+	Health() Health
+}
+
+// Health is a small report a Component can give on its current condition
+type Health struct {
+	// Stopped is true once a Component's background process has finished
+	Stopped bool
+
+	// Paused is true when a Component has been temporarily halted through Pause
+	Paused bool
+}
+
+// DescribedComponent is implemented by Components that can describe themselves, so that operators can see what an
+// Accord process is actually running (see Accord.Components). Components that don't implement it are still listed,
+// just with whatever we can work out about them on our own
+type DescribedComponent interface {
+	Component
+
+	// Describe reports on the Component's type, where it's listening or connecting, its current state, and a summary
+	// of how it's configured
+	Describe() ComponentInfo
+}
+
+// ComponentInfo describes one of our Components, as reported by Accord.Components
+type ComponentInfo struct {
+	// Name is the Component's name, if it has one (see NamedComponent)
+	Name string
+
+	// Type is the Component's Go type, such as "*components.PollListener"
+	Type string
+
+	// Address is where the Component listens or connects, if anywhere
+	Address string `json:",omitempty"`
+
+	// Health is the Component's current state, if it's able to report one
+	Health *Health `json:",omitempty"`
+
+	// Config summarizes the settings the Component was configured with. It's meant for people to read, so values are
+	// formatted as strings and only the settings worth knowing about are included
+	Config map[string]string `json:",omitempty"`
+}
+
 // ComponentRunner is a helper that is meant to be embedded in a struct to give basic Compent functionality. It starts a goroutine
 // to execute in a loop and uses a "stop" and "done" channel to communicate with that goroutine.
 type ComponentRunner struct {
@@ -36,6 +100,22 @@ type ComponentRunner struct {
 
 	stopping bool
 
+	// paused tells our goroutine to stop calling tick until we're resumed, and resumeSignal is used to wake it back up
+	paused       bool
+	pauseLock    *sync.Mutex
+	resumeSignal chan struct{}
+
+	// synchronous keeps Init from starting our goroutine, leaving it to TickOnce to drive us (see Synchronous)
+	synchronous bool
+
+	// weight is our share of Accord's TickScheduler, if we've opted into it (see Schedule), and member is our place in it
+	weight int
+	member *scheduledMember
+
+	// tick and cleanup are the functions we were passed in Init
+	tick    func(*Accord)
+	cleanup func(*Accord)
+
 	// Allow users of ComponentRunner to specify custom fields to be logged
 	log *logrus.Entry
 
@@ -63,6 +143,8 @@ func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup
 	runner.stopped = false
 	runner.stopSignal = make(chan int, 1)
 	runner.doneSignal = sync.NewCond(&sync.Mutex{})
+	runner.pauseLock = &sync.Mutex{}
+	runner.resumeSignal = make(chan struct{}, 1)
 	runner.accord = accord
 
 	if log != nil {
@@ -71,20 +153,34 @@ func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup
 		runner.log = accord.Logger.WithFields(logrus.Fields{})
 	}
 
+	runner.tick = tick
+	runner.cleanup = cleanup
+
+	if runner.weight > 0 && accord.scheduler != nil {
+		name, _ := runner.log.Data["component"].(string)
+		runner.member = accord.scheduler.join(name, runner.weight)
+	}
+
+	if runner.synchronous {
+		runner.log.Info("Component is synchronous, waiting on TickOnce")
+		return
+	}
+
 	// All the real work that ComponentRunner does happens in a goroutine, this Init function is only
-	// responsible for initializing the variables and starting it
+	// responsible for initializing the variables and starting it. It's counted under our component's name, if we were
+	// given one in our log fields
+	owner, ok := runner.log.Data["component"].(string)
+	if !ok {
+		owner = "component"
+	}
+	release := accord.TrackGoroutine(owner)
 	go func() {
 
 		// Before this goroutine returns we need to set our internal state and broadcast out to our conditional
-		// variable to make anybody waiting wake up
-		defer func() {
-			runner.log.Info("Notifying that our goroutine is done")
-			runner.doneSignal.L.Lock()
-			runner.stopping = false
-			runner.stopped = true
-			runner.doneSignal.Broadcast()
-			runner.doneSignal.L.Unlock()
-		}()
+		// variable to make anybody waiting wake up. Our goroutine is released first, so that it's no longer counted
+		// by the time anybody waiting wakes up
+		defer runner.done()
+		defer release()
 
 		// In an infinite loop, we'll see if we have a message in our stopSignal channel and cleanup and close
 		// the thread if we do (currently we don't do anything with what our stopSignal actually *is* but it's in
@@ -94,24 +190,113 @@ func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup
 		// takes no responsibility for making sure we don't overrun the CPU or that the tick function returns
 		// often enough that we can handle our Stop signals. We just have to trust that our our implementations
 		// of the tick function are smart enough to handle this
+		//
+		// If we've been paused we don't tick at all, and instead simply wait to either be resumed or stopped
 		runner.log.Info("Starting component loop")
 		for {
 			select {
 			case <-runner.stopSignal:
-				runner.log.Info("Received stop signal")
-				if cleanup != nil {
-					runner.log.Info("Cleaning up")
-					cleanup(accord)
-				}
+				runner.stop()
 				return
 
 			default:
-				tick(accord)
+				if runner.isPaused() {
+					select {
+					case <-runner.stopSignal:
+						runner.stop()
+						return
+					case <-runner.resumeSignal:
+					}
+					continue
+				}
+				runner.runTick()
 			}
 		}
 	}()
 }
 
+// runTick calls our tick function, first waiting our turn if we're scheduled
+func (runner *ComponentRunner) runTick() {
+	if runner.member == nil {
+		runner.tick(runner.accord)
+		return
+	}
+
+	runner.accord.scheduler.acquire(runner.member)
+	started := time.Now()
+	defer func() {
+		runner.accord.scheduler.release(runner.member, time.Since(started))
+	}()
+	runner.tick(runner.accord)
+}
+
+// stop runs our cleanup function, if we were given one
+func (runner *ComponentRunner) stop() {
+	runner.log.Info("Received stop signal")
+	if runner.cleanup != nil {
+		runner.log.Info("Cleaning up")
+		runner.cleanup(runner.accord)
+	}
+}
+
+// done marks us as stopped and wakes up anybody waiting on WaitForStop
+func (runner *ComponentRunner) done() {
+	runner.log.Info("Notifying that our goroutine is done")
+	if runner.member != nil {
+		runner.accord.scheduler.leave(runner.member)
+	}
+
+	runner.doneSignal.L.Lock()
+	runner.stopping = false
+	runner.stopped = true
+	runner.doneSignal.Broadcast()
+	runner.doneSignal.L.Unlock()
+}
+
+// Synchronous is meant for tests only. Called before the component is started, it keeps Init from starting the
+// background loop, so that a test can instead step through the component's tick function deterministically with
+// TickOnce rather than racing against a goroutine. It has no effect on a component that's already been started
+func (runner *ComponentRunner) Synchronous() {
+	runner.synchronous = true
+}
+
+// Schedule opts us into Accord's TickScheduler (see Accord.SchedulerSlots) with the given weight, relative to the other
+// scheduled components: one with a weight of 2 gets roughly twice the time to tick of one with a weight of 1 when both
+// have work to do. Like Synchronous it must be called before the component is started, and it has no effect if Accord
+// isn't running a scheduler. A weight of zero (the default) leaves us unscheduled
+func (runner *ComponentRunner) Schedule(weight int) {
+	runner.weight = weight
+}
+
+// TickOnce is meant for tests only. It runs a single iteration of the loop on the calling goroutine: if we've been
+// stopped it cleans up and returns false, if we're paused it does nothing, and otherwise it calls tick once. It panics
+// unless the component was made Synchronous before it was started, as ticking alongside the background loop would race
+func (runner *ComponentRunner) TickOnce() bool {
+	if !runner.synchronous || runner.tick == nil {
+		panic("accord: TickOnce called on a component that isn't synchronous")
+	}
+
+	runner.doneSignal.L.Lock()
+	stopped := runner.stopped
+	runner.doneSignal.L.Unlock()
+	if stopped {
+		return false
+	}
+
+	select {
+	case <-runner.stopSignal:
+		runner.stop()
+		runner.done()
+		return false
+	default:
+	}
+
+	if !runner.isPaused() {
+		runner.runTick()
+	}
+	return true
+}
+
 // Stop implements Component's Stop method. Upon being called it will send a message to the running goroutine
 // that it should start shutting down. This function returns immediately but does *not* ensure that the thread
 // is actually stopped when it returns
@@ -127,11 +312,86 @@ func (runner *ComponentRunner) Stop(sig int) {
 	}
 }
 
+// Pause halts our tick loop without stopping the goroutine or cleaning up, so that the component's resources (sockets and
+// the like) stay intact. Any tick that's currently executing is allowed to finish. It is safe to call Pause multiple times.
+// A runner that hasn't been started yet has nothing to pause, so this does nothing
+func (runner *ComponentRunner) Pause() {
+	if runner.pauseLock == nil {
+		return
+	}
+
+	runner.pauseLock.Lock()
+	defer runner.pauseLock.Unlock()
+
+	if !runner.paused {
+		runner.log.Info("Pausing component")
+		runner.paused = true
+
+		// Drain any stale resume so that we don't immediately wake back up
+		select {
+		case <-runner.resumeSignal:
+		default:
+		}
+	}
+}
+
+// Resume restarts a tick loop halted by Pause. It is safe to call Resume on a component that isn't paused, or hasn't been
+// started
+func (runner *ComponentRunner) Resume() {
+	if runner.pauseLock == nil {
+		return
+	}
+
+	runner.pauseLock.Lock()
+	defer runner.pauseLock.Unlock()
+
+	if runner.paused {
+		runner.log.Info("Resuming component")
+		runner.paused = false
+
+		select {
+		case runner.resumeSignal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isPaused safely checks whether we've been paused
+func (runner *ComponentRunner) isPaused() bool {
+	runner.pauseLock.Lock()
+	defer runner.pauseLock.Unlock()
+
+	return runner.paused
+}
+
+// Health implements PausableComponent's Health method, reporting whether we've stopped or been paused. A runner that
+// hasn't been started yet reports neither
+func (runner *ComponentRunner) Health() Health {
+	if runner.doneSignal == nil {
+		return Health{}
+	}
+
+	runner.doneSignal.L.Lock()
+	stopped := runner.stopped
+	runner.doneSignal.L.Unlock()
+
+	return Health{
+		Stopped: stopped,
+		Paused:  runner.isPaused(),
+	}
+}
+
 // WaitForStop implements Component's WaitForStop method. It will hang until it gets a message from the running
 // goroutine that it has stopped. Make sure you only use this after having already called Init and Stop, otherwise
 // it will hang forever.
 func (runner *ComponentRunner) WaitForStop() {
 	runner.log.Info("Waiting for component to stop")
+
+	// With no goroutine to handle our stop signal, we handle it ourselves
+	if runner.synchronous {
+		runner.TickOnce()
+	}
+
 	runner.doneSignal.L.Lock()
 	if !runner.stopped || runner.stopping {
 		runner.doneSignal.Wait()
@@ -144,13 +404,23 @@ func (runner *ComponentRunner) WaitForStop() {
 // this specific component, but the *entire* Accord system. As such, it should only be used in cases where there is an
 // unrecoverable error and the only proper course of action is to panic and bring the app down
 func (runner *ComponentRunner) Shutdown(err error) {
+	runner.ShutdownWith(ShutdownUnknown, err)
+}
+
+// ShutdownWith is Shutdown along with what kind of failure triggered it. The component is identified by the "component"
+// field of the log passed to Init, which is how all of our components name themselves
+func (runner *ComponentRunner) ShutdownWith(category ShutdownCategory, err error) {
 	runner.log.WithError(err).Error("Component shutting down with error")
 	runner.Stop(1)
-	runner.accord.Shutdown(err)
+
+	component, _ := runner.log.Data["component"].(string)
+	runner.accord.ShutdownWith(category, component, err)
 }
 
 // ExpectedOrShutdown gives implementors a simpler way of performing error checking to see that an error is expected, otherwise
-// trigger a shutdown of the system. If we determine that it is an expected error we return true
+// trigger a shutdown of the system. If we determine that it is an expected error we return true. It's meant for checking the
+// errors of network operations (socket sends and receives that may time out), so unexpected errors are categorized as
+// ShutdownNetwork
 func (runner *ComponentRunner) ExpectedOrShutdown(real error, expected ...error) bool {
 	match := false
 
@@ -160,7 +430,7 @@ func (runner *ComponentRunner) ExpectedOrShutdown(real error, expected ...error)
 		}
 	}
 	if !match {
-		runner.Shutdown(real)
+		runner.ShutdownWith(ShutdownNetwork, real)
 	}
 	return match
 }
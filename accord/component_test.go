@@ -2,12 +2,10 @@ package accord
 
 import (
 	"errors"
-	"syscall"
+	"sync"
 	"testing"
 	"time"
 
-	zmq "github.com/pebbe/zmq4"
-
 	"github.com/stretchr/testify/assert"
 )
 
@@ -92,61 +90,6 @@ func TestComponentRunnerShutdownMultiple(t *testing.T) {
 	assert.Equal(t, comp.runCount, 1)
 }
 
-type testComponentZMQ struct {
-	ComponentRunner
-	t           *testing.T
-	sockSend    *zmq.Socket
-	sockReceive *zmq.Socket
-
-	runOnce bool
-}
-
-func (comp *testComponentZMQ) Start(accord *Accord) {
-	comp.runOnce = false
-
-	comp.sockSend, _ = zmq.NewSocket(zmq.REQ)
-	comp.sockReceive, _ = zmq.NewSocket(zmq.REP)
-
-	comp.sockSend.Bind("inproc://send")
-	comp.sockReceive.Bind("inproc://receive")
-
-	comp.sockSend.SetSndtimeo(time.Millisecond)
-	comp.sockReceive.SetRcvtimeo(time.Millisecond)
-
-	comp.ComponentRunner.Init(accord, comp.tick, comp.cleanup, nil)
-}
-
-func (comp *testComponentZMQ) tick(*Accord) {
-	comp.runOnce = true
-
-	i, err := comp.sockSend.Send("hello", 0)
-
-	assert.Equal(comp.t, i, -1)
-	assert.NotNil(comp.t, err)
-	assert.Equal(comp.t, err, zmq.Errno(syscall.EAGAIN))
-
-	s, err := comp.sockReceive.Recv(0)
-	assert.Empty(comp.t, s)
-	assert.NotNil(comp.t, err)
-}
-
-func (comp *testComponentZMQ) cleanup(*Accord) {
-	comp.sockSend.Close()
-	comp.sockReceive.Close()
-}
-
-// For my own sanity, let's just make sure that ZMQ actually works
-// with this system (as we should be using it a lot)
-func TestZMQRunnerStop(t *testing.T) {
-	comp := testComponentZMQ{t: t}
-	comp.Start(DummyAccord())
-	time.Sleep(time.Millisecond)
-	comp.Stop(0)
-	comp.WaitForStop()
-
-	assert.True(t, comp.runOnce)
-}
-
 var errKnown1 = errors.New("KNOWN ERROR1")
 var errKnown2 = errors.New("KNOWN ERROR2")
 
@@ -183,4 +126,137 @@ func TestComponentRunnerExpectedOrShutdown(t *testing.T) {
 	runner.WaitForStop()
 
 	assert.Equal(t, 3, runner.runCount)
+
+	// Unexpected errors are put down to the network
+	reason := <-acrd.shutdown
+	assert.Equal(t, ShutdownNetwork, reason.Category)
+	assert.Equal(t, "STRANGE ERROR", reason.Error())
+}
+
+func TestComponentRunnerShutdownWith(t *testing.T) {
+	AccordCleanup()
+	defer AccordCleanup()
+
+	acrd := DummyAccord()
+	acrd.Start()
+
+	runner := ComponentRunner{}
+	runner.Init(acrd, func(*Accord) {}, nil, acrd.Logger.WithField("component", "test"))
+	runner.ShutdownWith(ShutdownStorage, errors.New("test error"))
+	runner.WaitForStop()
+
+	reason := <-acrd.shutdown
+	assert.Equal(t, ShutdownStorage, reason.Category)
+	assert.Equal(t, "test", reason.Component)
+}
+
+func TestComponentRunnerPause(t *testing.T) {
+	ticks := 0
+	lock := &sync.Mutex{}
+	tick := func(*Accord) {
+		lock.Lock()
+		ticks++
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return ticks
+	}
+
+	// There's nothing to pause before we've been started
+	runner := ComponentRunner{}
+	runner.Pause()
+	runner.Resume()
+	assert.Equal(t, Health{}, runner.Health())
+
+	runner.Init(DummyAccord(), tick, nil, nil)
+	time.Sleep(5 * time.Millisecond)
+
+	runner.Pause()
+	assert.True(t, runner.Health().Paused)
+
+	// Give any in progress tick a chance to finish before we take our measurement
+	time.Sleep(5 * time.Millisecond)
+	paused := count()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, paused, count())
+
+	runner.Resume()
+	assert.False(t, runner.Health().Paused)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, count() > paused)
+
+	// We should still be able to stop while paused
+	runner.Pause()
+	runner.Stop(0)
+	runner.WaitForStop()
+	assert.True(t, runner.Health().Stopped)
+}
+
+func TestComponentRunnerTickOnce(t *testing.T) {
+	ticks := 0
+	cleanedUp := false
+
+	runner := ComponentRunner{}
+	runner.Synchronous()
+	runner.Init(DummyAccord(), func(*Accord) { ticks++ }, func(*Accord) { cleanedUp = true }, nil)
+
+	// Nothing happens until we're stepped
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, 0, ticks)
+
+	assert.True(t, runner.TickOnce())
+	assert.True(t, runner.TickOnce())
+	assert.Equal(t, 2, ticks)
+
+	runner.Pause()
+	assert.True(t, runner.TickOnce())
+	assert.Equal(t, 2, ticks)
+	runner.Resume()
+
+	runner.Stop(0)
+	runner.WaitForStop()
+	assert.True(t, cleanedUp)
+	assert.True(t, runner.Health().Stopped)
+	assert.False(t, runner.TickOnce())
+	assert.Equal(t, 2, ticks)
+}
+
+func TestComponentRunnerTickOnceNotSynchronous(t *testing.T) {
+	runner := ComponentRunner{}
+	runner.Init(DummyAccord(), func(*Accord) { time.Sleep(time.Millisecond) }, nil, nil)
+	defer runner.WaitForStop()
+	defer runner.Stop(0)
+
+	assert.Panics(t, func() { runner.TickOnce() })
+}
+
+// describedRunner is a namedRunner that describes itself, or at least part of itself
+type describedRunner struct {
+	namedRunner
+}
+
+func (runner *describedRunner) Describe() ComponentInfo {
+	return ComponentInfo{Address: "inproc://described", Config: map[string]string{"Setting": "on"}}
+}
+
+func TestAccordComponents(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	plain := &noopComponent{}
+	named := &namedRunner{name: "named"}
+	described := &describedRunner{namedRunner{name: "described"}}
+
+	accord := DummyAccordComponents(plain, named, described)
+
+	// Whatever a component doesn't tell us about itself is filled in for it
+	assert.Equal(t, []ComponentInfo{
+		{Type: "*accord.noopComponent"},
+		{Name: "named", Type: "*accord.namedRunner", Health: &Health{}},
+		{Name: "described", Type: "*accord.describedRunner", Address: "inproc://described", Health: &Health{},
+			Config: map[string]string{"Setting": "on"}},
+	}, accord.Components())
 }
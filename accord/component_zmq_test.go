@@ -0,0 +1,67 @@
+//go:build !noZMQ
+
+package accord
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testComponentZMQ struct {
+	ComponentRunner
+	t           *testing.T
+	sockSend    *zmq.Socket
+	sockReceive *zmq.Socket
+
+	runOnce bool
+}
+
+func (comp *testComponentZMQ) Start(accord *Accord) {
+	comp.runOnce = false
+
+	comp.sockSend, _ = zmq.NewSocket(zmq.REQ)
+	comp.sockReceive, _ = zmq.NewSocket(zmq.REP)
+
+	comp.sockSend.Bind("inproc://send")
+	comp.sockReceive.Bind("inproc://receive")
+
+	comp.sockSend.SetSndtimeo(time.Millisecond)
+	comp.sockReceive.SetRcvtimeo(time.Millisecond)
+
+	comp.ComponentRunner.Init(accord, comp.tick, comp.cleanup, nil)
+}
+
+func (comp *testComponentZMQ) tick(*Accord) {
+	comp.runOnce = true
+
+	i, err := comp.sockSend.Send("hello", 0)
+
+	assert.Equal(comp.t, i, -1)
+	assert.NotNil(comp.t, err)
+	assert.Equal(comp.t, err, zmq.Errno(syscall.EAGAIN))
+
+	s, err := comp.sockReceive.Recv(0)
+	assert.Empty(comp.t, s)
+	assert.NotNil(comp.t, err)
+}
+
+func (comp *testComponentZMQ) cleanup(*Accord) {
+	comp.sockSend.Close()
+	comp.sockReceive.Close()
+}
+
+// For my own sanity, let's just make sure that ZMQ actually works
+// with this system (as we should be using it a lot)
+func TestZMQRunnerStop(t *testing.T) {
+	comp := testComponentZMQ{t: t}
+	comp.Start(DummyAccord())
+	time.Sleep(time.Millisecond)
+	comp.Stop(0)
+	comp.WaitForStop()
+
+	assert.True(t, comp.runOnce)
+}
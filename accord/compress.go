@@ -0,0 +1,58 @@
+package accord
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// compressRecord compresses a serialized Message we're about to persist. Like an at-rest sealed record, the compressed
+// format shares our serialization marker so that it can be told apart from a plain Message:
+//
+//	marker | serializationVersionCompressed | DEFLATE stream
+//
+// Small or incompressible Messages can come out larger than they went in, in which case we simply keep them as they were
+func compressRecord(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(serializationMarker)
+	buf.WriteByte(serializationVersionCompressed)
+
+	writer, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// isCompressed returns whether a persisted record was written by compressRecord
+func isCompressed(data []byte) bool {
+	return len(data) >= 2 && data[0] == serializationMarker && data[1] == serializationVersionCompressed
+}
+
+// decompressRecord reverses compressRecord. Anything that wasn't compressed is returned as is
+func decompressRecord(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	reader := flate.NewReader(bytes.NewReader(data[2:]))
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, ErrMalformedMessage
+	}
+	return data, nil
+}
@@ -0,0 +1,85 @@
+package accord
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// ConfirmationLog is a persisted record of which of our Messages a peer has confirmed it actually processed (as opposed
+// to merely received, which is what a DeliveryReceipt proves). Only the first confirmation of each Message is kept, as
+// that's when the Message first took effect somewhere else. It's a thin wrapper around a StateBackend keyed by Message ID
+type ConfirmationLog struct {
+	db   StateBackend
+	lock *sync.Mutex
+}
+
+// OpenConfirmationLog opens or creates a ConfirmationLog stored at the passed in path using our default LevelDB backend
+func OpenConfirmationLog(path string) (*ConfirmationLog, error) {
+	db, err := OpenLevelDBState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConfirmationLog(db), nil
+}
+
+// NewConfirmationLog creates a ConfirmationLog on top of an already opened StateBackend
+func NewConfirmationLog(db StateBackend) *ConfirmationLog {
+	return &ConfirmationLog{db: db, lock: &sync.Mutex{}}
+}
+
+// Record notes that the given Message was processed by a peer at the given time. A Message that's already been
+// confirmed keeps its original time
+func (log *ConfirmationLog) Record(messageID uint64, at time.Time) error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	key := confirmationKey(messageID)
+	_, err := log.db.Get(key)
+	if err == nil {
+		return nil
+	}
+	if err != ErrKeyNotFound {
+		return err
+	}
+
+	value, err := at.UTC().MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	batch := &StateBatch{}
+	batch.Put(key, value)
+	return log.db.Write(batch)
+}
+
+// Status returns whether the given Message has been confirmed, and if so when
+func (log *ConfirmationLog) Status(messageID uint64) (bool, time.Time, error) {
+	value, err := log.db.Get(confirmationKey(messageID))
+	if err == ErrKeyNotFound {
+		return false, time.Time{}, nil
+	}
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	at := time.Time{}
+	err = at.UnmarshalBinary(value)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return true, at, nil
+}
+
+// Close closes the underlying connection to our persisted log
+func (log *ConfirmationLog) Close() {
+	log.db.Close()
+}
+
+// confirmationKey is how a Message ID is stored in our backend
+func confirmationKey(messageID uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, messageID)
+	return key
+}
@@ -0,0 +1,101 @@
+package accord
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmationLog(t *testing.T) {
+	os.RemoveAll("confirmation-test")
+	defer os.RemoveAll("confirmation-test")
+
+	log, err := OpenConfirmationLog("confirmation-test")
+	assert.Nil(t, err)
+
+	first := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, log.Record(1, first))
+	// Only the first confirmation counts
+	assert.Nil(t, log.Record(1, first.Add(time.Hour)))
+
+	confirmed, at, err := log.Status(1)
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+	assert.True(t, first.Equal(at))
+
+	confirmed, _, err = log.Status(2)
+	assert.Nil(t, err)
+	assert.False(t, confirmed)
+	log.Close()
+
+	// Our log should survive a reopen
+	log, err = OpenConfirmationLog("confirmation-test")
+	assert.Nil(t, err)
+	defer log.Close()
+	confirmed, at, err = log.Status(1)
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+	assert.True(t, first.Equal(at))
+}
+
+func TestAccordConfirmations(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	// Without confirmations enabled there's nothing to record
+	assert.Nil(t, accord.RecordConfirmation(1, "peer"))
+	confirmed, _ := accord.ConfirmationStatus(1)
+	assert.False(t, confirmed)
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.ProcessingConfirmations = true
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Nil(t, accord.RecordConfirmation(1, "peer"))
+	confirmed, at := accord.ConfirmationStatus(1)
+	assert.True(t, confirmed)
+	assert.False(t, at.IsZero())
+	confirmed, _ = accord.ConfirmationStatus(2)
+	assert.False(t, confirmed)
+}
+
+func TestAccordHandleRemoteResult(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := &DummyManager{ShouldProcessRet: true}
+	accord := DummyAccordManager(manager)
+	accord.Dedup = &BloomConfig{Capacity: 100}
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	result, err := accord.HandleRemoteMessageWithResult(&Message{ID: 1})
+	assert.Nil(t, err)
+	assert.True(t, result.Processed)
+
+	// A duplicate is handled without being processed
+	result, err = accord.HandleRemoteMessageWithResult(&Message{ID: 1})
+	assert.Nil(t, err)
+	assert.False(t, result.Processed)
+
+	// As is one we're holding on to while paused
+	accord.PauseProcessing()
+	result, err = accord.HandleRemoteMessageWithResult(&Message{ID: 2, StateAt: 1})
+	assert.Nil(t, err)
+	assert.False(t, result.Processed)
+	assert.Nil(t, accord.ResumeProcessing())
+
+	// And one the Manager decided against
+	manager.ShouldProcessRet = false
+	result, err = accord.HandleRemoteMessageWithResult(&Message{ID: 3, StateAt: 1})
+	assert.Nil(t, err)
+	assert.False(t, result.Processed)
+}
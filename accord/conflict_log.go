@@ -0,0 +1,101 @@
+package accord
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ConflictRecord is an audit entry describing how we resolved a remote Message that arrived while our state had
+// diverged from the remote's
+type ConflictRecord struct {
+	// MessageID is the ID of the remote Message
+	MessageID uint64
+
+	// Processed is whether our Manager decided the Message should be processed (true) or skipped (false)
+	Processed bool
+
+	// Timestamp is when we made the decision
+	Timestamp time.Time
+
+	// LocalState and RemoteState are our state and the Message's StateAt at the time of the decision
+	LocalState  uint64
+	RemoteState uint64
+
+	// Reason is an optional explanation supplied by a Manager that implements ConflictExplainer
+	Reason string
+}
+
+// ConflictExplainer can optionally be implemented by a Manager to explain its conflict resolution decisions. If it is,
+// Accord will call ShouldProcessWithReason in place of ShouldProcess and record the reason in its ConflictLog
+type ConflictExplainer interface {
+	ShouldProcessWithReason(msg Message, history *HistoryIterator) (bool, string)
+}
+
+// ConflictLog is a persisted, append only record of every conflict resolution decision we've made, so that "why didn't
+// this change apply?" can be answered after the fact. Like SyncQueue it's a thin wrapper around a QueueBackend
+type ConflictLog struct {
+	queue QueueBackend
+}
+
+// OpenConflictLog opens or creates a ConflictLog stored at the passed in path using our default goque backend
+func OpenConflictLog(path string) (*ConflictLog, error) {
+	queue, err := OpenGoqueQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConflictLog(queue), nil
+}
+
+// NewConflictLog creates a ConflictLog on top of an already opened QueueBackend
+func NewConflictLog(queue QueueBackend) *ConflictLog {
+	return &ConflictLog{queue: queue}
+}
+
+// Record appends a new ConflictRecord to the log
+func (log *ConflictLog) Record(record ConflictRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return log.queue.Enqueue(data)
+}
+
+// Entries returns up to limit records starting at offset, oldest first. A limit of 0 returns everything after offset
+func (log *ConflictLog) Entries(offset, limit uint64) ([]ConflictRecord, error) {
+	records := []ConflictRecord{}
+
+	for i := offset; i < log.queue.Length(); i++ {
+		if limit > 0 && uint64(len(records)) >= limit {
+			break
+		}
+
+		value, err := log.queue.PeekByOffset(i)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			break
+		}
+
+		record := ConflictRecord{}
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Size returns the number of records in the log
+func (log *ConflictLog) Size() uint64 {
+	return log.queue.Length()
+}
+
+// Close closes the underlying connection to our persisted log
+func (log *ConflictLog) Close() {
+	log.queue.Close()
+}
@@ -0,0 +1,46 @@
+package accord
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictLog(t *testing.T) {
+	os.RemoveAll("conflict-test")
+	defer os.RemoveAll("conflict-test")
+
+	log, err := OpenConflictLog("conflict-test")
+	assert.Nil(t, err)
+
+	for i := uint64(1); i <= 3; i++ {
+		err = log.Record(ConflictRecord{MessageID: i, Processed: i%2 == 0, Reason: "because"})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, uint64(3), log.Size())
+
+	records, err := log.Entries(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, uint64(1), records[0].MessageID)
+	assert.False(t, records[0].Processed)
+	assert.True(t, records[1].Processed)
+	assert.Equal(t, "because", records[2].Reason)
+
+	records, err = log.Entries(1, 1)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, uint64(2), records[0].MessageID)
+
+	records, err = log.Entries(5, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+	log.Close()
+
+	// Our log should survive a reopen
+	log, err = OpenConflictLog("conflict-test")
+	assert.Nil(t, err)
+	defer log.Close()
+	assert.Equal(t, uint64(3), log.Size())
+}
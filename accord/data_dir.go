@@ -0,0 +1,57 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoDataDir is returned by Start when Accord was created without a data directory. Rather than quietly scattering
+// our files across the current working directory we insist on being told where they belong ("." is fine, if that
+// really is what you want)
+var ErrNoDataDir = errors.New("no data directory configured")
+
+// ErrDataDirLocked is returned by Start when another Accord (most likely in another process) is already using our data
+// directory. Two processes working from the same data would quickly fall out of step with each other and with their
+// remotes, so we refuse to run rather than risk it
+var ErrDataDirLocked = errors.New("data directory is locked by another Accord")
+
+// prepareDataDir makes sure dir is somewhere we can store our data before we hand it to our backends, creating it if
+// it doesn't exist yet. Our backends tend to fail deep inside LevelDB with little to go on when it isn't, so we'd
+// rather fail early with an error that says what's actually wrong
+func prepareDataDir(dir string) error {
+	if dir == "" {
+		return ErrNoDataDir
+	}
+
+	// Look before creating anything, as MkdirAll's own complaint about a file being in the way says little about why
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("unable to create data directory %q: %v", dir, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to access data directory %q: %v", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("data directory %q is not a directory", dir)
+	}
+
+	// The only reliable way of knowing whether we can write somewhere is to try it
+	probe, err := ioutil.TempFile(dir, ".accord-write-check")
+	if err != nil {
+		return fmt.Errorf("data directory %q is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return nil
+}
+
+// lockDataDir takes an exclusive lock on dir, returning ErrDataDirLocked if somebody else already holds it. The lock is
+// released by closing the returned file
+func lockDataDir(dir string) (*os.File, error) {
+	return lockFile(filepath.Join(dir, LockFilename))
+}
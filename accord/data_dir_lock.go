@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package accord
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on the file at path, creating it if need be. The lock is held for as long as
+// the returned file is open, and is released by the operating system if we exit without closing it, so a crashed
+// process never leaves a stale lock behind
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrDataDirLocked
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
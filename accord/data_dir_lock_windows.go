@@ -0,0 +1,30 @@
+package accord
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is the error Windows gives us when a file is already open by somebody who won't share it
+const errorSharingViolation = syscall.Errno(32)
+
+// lockFile takes an exclusive lock on the file at path, creating it if need be. Windows has no advisory locks to speak
+// of, so we open the file without sharing it with anyone else, which works just as well for our purposes. The lock is
+// held for as long as the returned file is open
+func lockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, ErrDataDirLocked
+		}
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,188 @@
+package accord
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrDeadLetterNotFound is returned when asked to act on a Message that isn't in our dead letter queue
+var ErrDeadLetterNotFound = errors.New("message is not in the dead letter queue")
+
+// DeadLetter is a Message we've given up on synchronizing, along with why, so that it can be diagnosed
+type DeadLetter struct {
+	// Message is the Message that failed
+	Message *Message
+
+	// Target is the sync target the Message was stuck on (empty for components that don't track one)
+	Target string
+
+	// Failures is how many times in a row the Message failed to sync before we gave up on it
+	Failures int
+
+	// Reason is the error reported for the last failure
+	Reason string
+
+	// DeadAt is when the Message was moved to the dead letter queue
+	DeadAt time.Time
+}
+
+// deadLetterRecord is how a DeadLetter is persisted. The Message is stored as it is in our other queues, so that it's
+// protected by any at-rest key just the same
+type deadLetterRecord struct {
+	Message  []byte
+	Target   string
+	Failures int
+	Reason   string
+	DeadAt   time.Time
+}
+
+// DeadLetterQueue is a persisted holding area for Messages we've given up on synchronizing, so that they can be
+// inspected, and then either requeued or discarded, by a human. Like SyncQueue it's a thin wrapper around a QueueBackend
+type DeadLetterQueue struct {
+	queue QueueBackend
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
+}
+
+// OpenDeadLetterQueue opens or creates a DeadLetterQueue stored at the passed in path using our default goque backend
+func OpenDeadLetterQueue(path string) (*DeadLetterQueue, error) {
+	queue, err := OpenGoqueQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDeadLetterQueue(queue), nil
+}
+
+// NewDeadLetterQueue creates a DeadLetterQueue on top of an already opened QueueBackend
+func NewDeadLetterQueue(queue QueueBackend) *DeadLetterQueue {
+	return &DeadLetterQueue{queue: queue}
+}
+
+// Add appends a DeadLetter to the queue
+func (dead *DeadLetterQueue) Add(letter DeadLetter) error {
+	msg, err := dead.keys.sealMessage(letter.Message)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(deadLetterRecord{
+		Message:  msg,
+		Target:   letter.Target,
+		Failures: letter.Failures,
+		Reason:   letter.Reason,
+		DeadAt:   letter.DeadAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return dead.queue.Enqueue(data)
+}
+
+// Entries returns up to limit DeadLetters starting at offset, oldest first. A limit of 0 returns everything after
+// offset
+func (dead *DeadLetterQueue) Entries(offset, limit uint64) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+
+	for i := offset; i < dead.queue.Length(); i++ {
+		if limit > 0 && uint64(len(letters)) >= limit {
+			break
+		}
+
+		value, err := dead.queue.PeekByOffset(i)
+		if err != nil {
+			return letters, err
+		}
+		if value == nil {
+			break
+		}
+
+		letter, err := dead.decode(value)
+		if err != nil {
+			return letters, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// Remove takes the oldest DeadLetter for the Message with the given ID out of the queue and returns it, or returns
+// ErrDeadLetterNotFound if there isn't one. Our backend can only be dequeued from the front, so this rewrites the
+// entire queue
+func (dead *DeadLetterQueue) Remove(id uint64) (*DeadLetter, error) {
+	var removed *DeadLetter
+
+	size := dead.queue.Length()
+	for i := uint64(0); i < size; i++ {
+		value, err := dead.queue.PeekByOffset(0)
+		if err != nil {
+			return nil, err
+		}
+
+		letter, err := dead.decode(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if removed == nil && letter.Message.ID == id {
+			removed = &letter
+		} else {
+			err = dead.queue.Enqueue(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = dead.queue.Dequeue()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if removed == nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	return removed, nil
+}
+
+// decode reads a persisted DeadLetter. Entries written before we recorded why a Message failed are just the
+// Message itself, which we can tell apart by our serialization marker
+func (dead *DeadLetterQueue) decode(value []byte) (DeadLetter, error) {
+	if len(value) > 0 && value[0] == serializationMarker {
+		msg, err := dead.keys.openMessage(value)
+		return DeadLetter{Message: msg}, err
+	}
+
+	record := deadLetterRecord{}
+	err := json.Unmarshal(value, &record)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	msg, err := dead.keys.openMessage(record.Message)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	return DeadLetter{
+		Message:  msg,
+		Target:   record.Target,
+		Failures: record.Failures,
+		Reason:   record.Reason,
+		DeadAt:   record.DeadAt,
+	}, nil
+}
+
+// Size returns the number of Messages in the queue
+func (dead *DeadLetterQueue) Size() uint64 {
+	return dead.queue.Length()
+}
+
+// Close closes the underlying connection to our persisted queue
+func (dead *DeadLetterQueue) Close() {
+	dead.queue.Close()
+}
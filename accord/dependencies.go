@@ -0,0 +1,281 @@
+package accord
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeferredMessage is a remote Message we're holding back until the Messages it DependsOn have been processed
+type DeferredMessage struct {
+	// Message is the Message being held back
+	Message *Message
+
+	// DeferredAt is when we first held it back
+	DeferredAt time.Time
+}
+
+// deferredRecord is how a DeferredMessage is persisted. The Message is stored as it is in our other queues, so that
+// it's protected by any at-rest key just the same
+type deferredRecord struct {
+	Message    []byte
+	DeferredAt time.Time
+}
+
+// DependencyQueue is a persisted holding area for remote Messages whose dependencies we're yet to process (see
+// Message.DependsOn), in the order they arrived. Like SyncQueue it's a thin wrapper around a QueueBackend
+type DependencyQueue struct {
+	queue QueueBackend
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
+}
+
+// OpenDependencyQueue opens or creates a DependencyQueue stored at the passed in path using our default goque backend
+func OpenDependencyQueue(path string) (*DependencyQueue, error) {
+	queue, err := OpenGoqueQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDependencyQueue(queue), nil
+}
+
+// NewDependencyQueue creates a DependencyQueue on top of an already opened QueueBackend
+func NewDependencyQueue(queue QueueBackend) *DependencyQueue {
+	return &DependencyQueue{queue: queue}
+}
+
+// Add appends a DeferredMessage to the queue
+func (deferred *DependencyQueue) Add(entry DeferredMessage) error {
+	msg, err := deferred.keys.sealMessage(entry.Message)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(deferredRecord{Message: msg, DeferredAt: entry.DeferredAt})
+	if err != nil {
+		return err
+	}
+
+	return deferred.queue.Enqueue(data)
+}
+
+// Entries returns up to limit DeferredMessages starting at offset, oldest first. A limit of 0 returns everything after
+// offset
+func (deferred *DependencyQueue) Entries(offset, limit uint64) ([]DeferredMessage, error) {
+	entries := []DeferredMessage{}
+
+	for i := offset; i < deferred.queue.Length(); i++ {
+		if limit > 0 && uint64(len(entries)) >= limit {
+			break
+		}
+
+		value, err := deferred.queue.PeekByOffset(i)
+		if err != nil {
+			return entries, err
+		}
+		if value == nil {
+			break
+		}
+
+		entry, err := deferred.decode(value)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Remove takes the Messages with the given IDs out of the queue, leaving the rest in order. Our backend can only be
+// dequeued from the front, so this rewrites the entire queue
+func (deferred *DependencyQueue) Remove(ids map[uint64]bool) error {
+	size := deferred.queue.Length()
+	for i := uint64(0); i < size; i++ {
+		value, err := deferred.queue.PeekByOffset(0)
+		if err != nil {
+			return err
+		}
+
+		entry, err := deferred.decode(value)
+		if err != nil {
+			return err
+		}
+
+		if !ids[entry.Message.ID] {
+			err = deferred.queue.Enqueue(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = deferred.queue.Dequeue()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decode reads a persisted DeferredMessage
+func (deferred *DependencyQueue) decode(value []byte) (DeferredMessage, error) {
+	record := deferredRecord{}
+	err := json.Unmarshal(value, &record)
+	if err != nil {
+		return DeferredMessage{}, err
+	}
+
+	msg, err := deferred.keys.openMessage(record.Message)
+	if err != nil {
+		return DeferredMessage{}, err
+	}
+
+	return DeferredMessage{Message: msg, DeferredAt: record.DeferredAt}, nil
+}
+
+// Size returns the number of Messages in the queue
+func (deferred *DependencyQueue) Size() uint64 {
+	return deferred.queue.Length()
+}
+
+// Close closes the underlying connection to our persisted queue
+func (deferred *DependencyQueue) Close() {
+	deferred.queue.Close()
+}
+
+// missingDependencies returns which of the Messages msg DependsOn we're yet to process, which is to say those that
+// aren't in our history. processMutex must be held by the caller
+func (accord *Accord) missingDependencies(msg *Message) ([]uint64, error) {
+	missing := []uint64{}
+	for _, id := range msg.DependsOn {
+		_, err := accord.history.Find(id)
+		if err == ErrNotInHistory {
+			missing = append(missing, id)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// deferMessage holds back a remote Message until its dependencies have been processed. A Message we're already
+// holding back (our remote sent it again, say) isn't held twice. processMutex must be held by the caller
+func (accord *Accord) deferMessage(msg *Message, missing []uint64) error {
+	logger := accord.Logger.WithField("id", msg.ID).WithField("missing", missing)
+
+	entries, err := accord.deferred.Entries(0, 0)
+	if err != nil {
+		logger.WithError(err).Warn("Could not read our deferred messages. Blowing up our application")
+		return accord.fail(ShutdownStorage, err)
+	}
+	for _, entry := range entries {
+		if entry.Message.ID == msg.ID {
+			logger.Debug("Already holding back this remote message")
+			return nil
+		}
+	}
+
+	logger.Debug("Holding back a remote message until its dependencies have been processed")
+	err = accord.deferred.Add(DeferredMessage{Message: msg, DeferredAt: time.Now().UTC()})
+	if err != nil {
+		logger.WithError(err).Warn("Could not hold back a remote message. Blowing up our application")
+		return accord.fail(ShutdownStorage, err)
+	}
+	return nil
+}
+
+// releaseDeferred handles every Message we've been holding back whose dependencies have since been processed, in the
+// order they arrived. Handling one may satisfy another (a chain of dependencies arriving backwards), so we keep going
+// until nothing more can be released. Messages that have been waiting longer than our DependencyTimeout are moved to
+// our dead letter queue. processMutex must be held by the caller
+func (accord *Accord) releaseDeferred() error {
+	for accord.deferred.Size() > 0 {
+		entries, err := accord.deferred.Entries(0, 0)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not read our deferred messages. Blowing up our application")
+			return accord.fail(ShutdownStorage, err)
+		}
+
+		now := time.Now().UTC()
+		ready := []*Message{}
+		expired := []DeferredMessage{}
+		for _, entry := range entries {
+			missing, err := accord.missingDependencies(entry.Message)
+			if err != nil {
+				accord.Logger.WithError(err).Warn("Could not search our history for dependencies. Blowing up our application")
+				return accord.fail(ShutdownStorage, err)
+			}
+
+			if len(missing) == 0 {
+				ready = append(ready, entry.Message)
+			} else if accord.DependencyTimeout > 0 && now.Sub(entry.DeferredAt) >= accord.DependencyTimeout {
+				expired = append(expired, entry)
+			}
+		}
+		if len(ready) == 0 && len(expired) == 0 {
+			return nil
+		}
+
+		// Dead letter before letting go, so that a crash in between leaves a duplicate behind rather than losing the
+		// Message
+		for _, entry := range expired {
+			missing, _ := accord.missingDependencies(entry.Message)
+			err = accord.deadLetters.Add(DeadLetter{
+				Message: entry.Message,
+				Reason:  fmt.Sprintf("dependencies were never processed: %v", missing),
+				DeadAt:  now,
+			})
+			if err != nil {
+				accord.Logger.WithError(err).Warn("Could not dead letter a message whose dependencies never arrived. Blowing up our application")
+				return accord.fail(ShutdownStorage, err)
+			}
+			accord.Logger.WithField("id", entry.Message.ID).WithField("missing", missing).Error("A remote message's dependencies never arrived, it has been moved to the dead letter queue")
+		}
+
+		// Take everything we're letting go of out of the queue before handling it, so that handling it can't find it
+		// still held back. Our remote has long since been told it was handled, so a crash in between loses it, much as
+		// it would have had we handled it straight away and crashed before saving our state
+		done := map[uint64]bool{}
+		for _, msg := range ready {
+			done[msg.ID] = true
+		}
+		for _, entry := range expired {
+			done[entry.Message.ID] = true
+		}
+		err = accord.deferred.Remove(done)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not remove messages we were holding back. Blowing up our application")
+			return accord.fail(ShutdownStorage, err)
+		}
+
+		for _, msg := range ready {
+			accord.Logger.WithField("id", msg.ID).Debug("A remote message's dependencies have been processed, handling it")
+			_, err = accord.applyRemote(msg, &Trace{MessageID: msg.ID, RemoteState: msg.StateAt}, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sweepDeferred releases any Messages we've been holding back that are ready, and dead letters those that have waited
+// too long, for when nothing else is arriving to trigger it
+func (accord *Accord) sweepDeferred() {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	accord.releaseDeferred()
+}
+
+// Deferred returns up to limit of the remote Messages we're holding back until their dependencies have been processed,
+// starting at offset and oldest first. A limit of 0 returns everything after offset
+func (accord *Accord) Deferred(offset, limit uint64) ([]DeferredMessage, error) {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	return accord.deferred.Entries(offset, limit)
+}
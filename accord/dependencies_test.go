@@ -0,0 +1,153 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dependentMessages creates a chain of Messages, each depending on the one before it
+func dependentMessages(t *testing.T, payloads ...string) []*Message {
+	msgs := []*Message{}
+	for _, payload := range payloads {
+		msg, err := NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		if len(msgs) > 0 {
+			msg.DependsOn = []uint64{msgs[len(msgs)-1].ID}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestAccordDependsOn(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	manager.ShouldProcessRet = true
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msgs := dependentMessages(t, "first", "second", "third")
+
+	// Delivered backwards, everything waits on the first
+	for _, i := range []int{2, 1} {
+		result, err := accord.HandleRemoteMessageWithResult(msgs[i])
+		assert.Nil(t, err)
+		assert.Equal(t, RemoteResult{Deferred: true}, result)
+	}
+
+	// Being sent the same Message again doesn't hold it back twice
+	result, err := accord.HandleRemoteMessageWithResult(msgs[1])
+	assert.Nil(t, err)
+	assert.True(t, result.Deferred)
+
+	assert.Equal(t, 0, manager.ProcessCount)
+	assert.Equal(t, uint64(0), accord.state.GetCurrent())
+	assert.Equal(t, uint64(2), accord.Status().Deferred)
+
+	deferred, err := accord.Deferred(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, deferred, 2)
+	assert.Equal(t, msgs[2].ID, deferred[0].Message.ID)
+
+	// Once it arrives, the rest follow in order, each processed once
+	result, err = accord.HandleRemoteMessageWithResult(msgs[0])
+	assert.Nil(t, err)
+	assert.Equal(t, RemoteResult{Processed: true}, result)
+
+	assert.Equal(t, 3, manager.ProcessCount)
+	processed := []uint64{}
+	for _, msg := range manager.Remote[1:] {
+		processed = append(processed, msg.ID)
+	}
+	assert.Equal(t, []uint64{msgs[0].ID, msgs[1].ID, msgs[2].ID}, processed)
+	assert.Equal(t, uint64(3), accord.history.Size())
+	assert.Equal(t, uint64(0), accord.Status().Deferred)
+}
+
+func TestAccordDependsOnSatisfied(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	manager.ShouldProcessRet = true
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Delivered in order, nothing is held back
+	for _, msg := range dependentMessages(t, "first", "second") {
+		result, err := accord.HandleRemoteMessageWithResult(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, RemoteResult{Processed: true}, result)
+	}
+	assert.Equal(t, 2, manager.ProcessCount)
+}
+
+func TestAccordDependsOnRestart(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	msgs := dependentMessages(t, "first", "second")
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+	_, err = accord.HandleRemoteMessageWithResult(msgs[1])
+	assert.Nil(t, err)
+	accord.Stop()
+
+	// What we were holding back is still held back after a restart
+	manager := NewDummerManager()
+	manager.ShouldProcessRet = true
+	accord = DummyAccordManager(manager)
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+	assert.Equal(t, uint64(1), accord.Status().Deferred)
+
+	_, err = accord.HandleRemoteMessageWithResult(msgs[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, manager.ProcessCount)
+	assert.Equal(t, msgs[1].ID, manager.Remote[2].ID)
+}
+
+func TestAccordDependencyTimeout(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	accord := DummyAccordManager(manager)
+	accord.DependencyTimeout = time.Hour
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	msgs := dependentMessages(t, "first", "second")
+	_, err = accord.HandleRemoteMessageWithResult(msgs[1])
+	assert.Nil(t, err)
+
+	// Nothing happens before the timeout
+	accord.sweepDeferred()
+	assert.Equal(t, uint64(1), accord.Status().Deferred)
+
+	// But after it, a Message whose dependencies never arrived is given up on
+	accord.DependencyTimeout = time.Nanosecond
+	accord.sweepDeferred()
+	assert.Equal(t, uint64(0), accord.Status().Deferred)
+	assert.Equal(t, 0, manager.ProcessCount)
+
+	letters, err := accord.DeadLetters(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 1)
+	if len(letters) == 1 {
+		assert.Equal(t, msgs[1].ID, letters[0].Message.ID)
+		assert.Contains(t, letters[0].Reason, "dependencies were never processed")
+	}
+}
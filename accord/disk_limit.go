@@ -0,0 +1,135 @@
+package accord
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDiskFull is returned for new Messages while our stores are over MaxDiskBytes and our DiskLimitAction is DiskReject
+var ErrDiskFull = errors.New("accord is over its disk limit")
+
+// DiskLimitAction is what we do once our stores take up more than MaxDiskBytes
+type DiskLimitAction int
+
+const (
+	// DiskReject turns away new local Messages (HandleNewMessage, RelayMessage and the like) with ErrDiskFull until
+	// we're back under the limit, pushing back on whoever is producing them. Remote Messages are still handled, as
+	// turning them away would leave us diverged from our peers
+	DiskReject DiskLimitAction = iota
+
+	// DiskPruneHistory clears our history (handing it to our HistoryArchive first, if we have one), as it's the one
+	// store we can make room in by ourselves. Conflicts that arrive afterwards are resolved without the Messages we
+	// dropped, so this trades the quality of conflict resolution for disk
+	DiskPruneHistory
+
+	// DiskWarn only logs a warning, leaving it to an operator (or whatever watches our logs or Status) to act
+	DiskWarn
+
+	// DiskShutdown shuts us down, with ShutdownDiskFull as the reason, before the disk can fill up underneath LevelDB
+	DiskShutdown
+)
+
+// String returns a short, human readable, name for the action
+func (action DiskLimitAction) String() string {
+	switch action {
+	case DiskReject:
+		return "reject"
+	case DiskPruneHistory:
+		return "prune-history"
+	case DiskWarn:
+		return "warn"
+	case DiskShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
+// diskUsage adds up how much disk our sync queue, history and state are taking up (see StorageStats)
+func (accord *Accord) diskUsage() (uint64, error) {
+	stats, err := accord.StorageStats()
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, store := range stats {
+		total += store.DiskBytes
+	}
+	return total, nil
+}
+
+// checkDiskUsage measures our stores against MaxDiskBytes, taking our DiskLimitAction if they've gone over
+func (accord *Accord) checkDiskUsage() {
+	usage, err := accord.diskUsage()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not measure our disk usage")
+		return
+	}
+	atomic.StoreUint64(&accord.diskBytes, usage)
+
+	over := usage > accord.MaxDiskBytes
+	wasOver := atomic.LoadInt32(&accord.diskFull) == 1
+	if over {
+		atomic.StoreInt32(&accord.diskFull, 1)
+	} else {
+		atomic.StoreInt32(&accord.diskFull, 0)
+		if wasOver {
+			accord.Logger.WithField("diskBytes", usage).WithField("limit", accord.MaxDiskBytes).Info("Back under our disk limit")
+		}
+		return
+	}
+
+	log := accord.Logger.WithFields(logrus.Fields{
+		"diskBytes": usage,
+		"limit":     accord.MaxDiskBytes,
+		"action":    accord.DiskLimitAction.String(),
+	})
+
+	switch accord.DiskLimitAction {
+	case DiskReject:
+		if !wasOver {
+			log.Warn("Over our disk limit, turning away new messages")
+		}
+
+	case DiskPruneHistory:
+		if accord.DisableHistory {
+			log.Warn("Over our disk limit, but we have no history to prune")
+			return
+		}
+
+		accord.processMutex.Lock()
+		err := accord.history.Clear()
+		accord.processMutex.Unlock()
+		if archiveErr, ok := err.(*ArchiveError); ok {
+			// Our history is still intact, so we'll simply try again on our next check
+			log.WithError(archiveErr).Warn("Over our disk limit, but could not archive our history to prune it")
+		} else if err != nil {
+			log.WithError(err).Error("Over our disk limit, and could not prune our history")
+			accord.ShutdownWith(ShutdownStorage, "", err)
+		} else {
+			log.Warn("Over our disk limit, pruned our history")
+		}
+
+	case DiskWarn:
+		log.Warn("Over our disk limit")
+
+	case DiskShutdown:
+		// Our shutdown channel only has room for one reason, so we only ever send ours once
+		if !accord.diskShutdownFired {
+			accord.diskShutdownFired = true
+			log.Error("Over our disk limit, shutting down")
+			accord.ShutdownWith(ShutdownDiskFull, "", ErrDiskFull)
+		}
+	}
+}
+
+// checkDiskFull returns ErrDiskFull if we're turning away new Messages because we're over our disk limit
+func (accord *Accord) checkDiskFull() error {
+	if accord.DiskLimitAction == DiskReject && atomic.LoadInt32(&accord.diskFull) == 1 {
+		return ErrDiskFull
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordDiskLimitReject(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	// Our stores take up far more than a byte as soon as they're opened, so we start out over our limit
+	accord := DummyAccord()
+	accord.MaxDiskBytes = 1
+	accord.DiskCheckInterval = time.Hour
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	status := accord.Status()
+	assert.True(t, status.DiskFull)
+	assert.True(t, status.DiskBytes > 1)
+	assert.Equal(t, uint64(1), status.MaxDiskBytes)
+
+	assert.Equal(t, ErrDiskFull, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Equal(t, ErrDiskFull, accord.RelayMessage(&Message{ID: 2}))
+	assert.Equal(t, uint64(0), accord.ToBeSynced.Size())
+
+	// Remote Messages are still handled
+	processed, err := accord.HandleRemoteMessageWithResult(&Message{ID: 3})
+	assert.Nil(t, err)
+	assert.True(t, processed.Processed)
+
+	// Once there's room again we take new Messages back on
+	accord.MaxDiskBytes = status.DiskBytes << 10
+	accord.checkDiskUsage()
+	assert.False(t, accord.Status().DiskFull)
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+}
+
+func TestAccordDiskLimitPruneHistory(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	archived := []uint64{}
+	accord := DummyAccord()
+	accord.DiskLimitAction = DiskPruneHistory
+	accord.DiskCheckInterval = time.Hour
+	accord.HistoryArchive = ArchiveFunc(func(msg *Message) error {
+		archived = append(archived, msg.ID)
+		return nil
+	})
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+	assert.Equal(t, uint64(3), accord.history.Size())
+
+	// We keep taking on new Messages, making room by letting go of our history instead
+	accord.MaxDiskBytes = 1
+	accord.checkDiskUsage()
+	assert.Equal(t, uint64(0), accord.history.Size())
+	assert.Equal(t, []uint64{1, 2, 3}, archived)
+	assert.True(t, accord.Status().DiskFull)
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4}))
+}
+
+func TestAccordDiskLimitShutdown(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.MaxDiskBytes = 1
+	accord.DiskCheckInterval = time.Hour
+	accord.DiskLimitAction = DiskShutdown
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	reason := <-accord.shutdown
+	assert.Equal(t, ShutdownDiskFull, reason.Category)
+	assert.Equal(t, ErrDiskFull, reason.Err)
+	assert.Equal(t, "disk", reason.Category.String())
+
+	// We only ever ask to be shut down once
+	accord.checkDiskUsage()
+	assert.Len(t, accord.shutdown, 0)
+
+	// And as we were never told to, we haven't turned anything away in the meantime
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+}
@@ -0,0 +1,355 @@
+package accord
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// itemValue is a helper for turning the result of a goque read into a plain value, translating an empty structure (or
+// an offset past its end) into a nil value
+func itemValue(item *goque.Item, err error) ([]byte, error) {
+	if err != nil {
+		if err == goque.ErrEmpty || err == goque.ErrOutOfBounds {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+// goqueQueue is our default QueueBackend, built atop goque's LevelDB backed queue
+type goqueQueue struct {
+	queue *goque.Queue
+
+	// We keep our path around so that we can find our journal when we need to flush it, and measure our footprint
+	path string
+
+	// usageLock protects highWater and bytes, which back Usage
+	usageLock *sync.Mutex
+
+	// highWater is the item number goque assigned to the last value we enqueued. goque never reuses item numbers, so
+	// this only ever climbs until the queue is reopened empty
+	highWater uint64
+
+	// bytes is the total size of the values currently in the queue
+	bytes uint64
+}
+
+// OpenGoqueQueue is a QueueFactory that opens or creates a goque queue stored at the passed in path
+func OpenGoqueQueue(path string) (QueueBackend, error) {
+	queue, err := goque.OpenQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &goqueQueue{queue: queue, path: path, usageLock: &sync.Mutex{}}
+	err = backend.measure()
+	if err != nil {
+		queue.Close()
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+// measure works out our high-water mark and size from whatever is already in the queue
+func (backend *goqueQueue) measure() error {
+	backend.highWater = 0
+	backend.bytes = 0
+
+	for i := uint64(0); i < backend.queue.Length(); i++ {
+		item, err := backend.queue.PeekByOffset(i)
+		if err != nil {
+			return err
+		}
+		backend.highWater = item.ID
+		backend.bytes += uint64(len(item.Value))
+	}
+
+	return nil
+}
+
+func (backend *goqueQueue) Enqueue(value []byte) error {
+	item, err := backend.queue.Enqueue(value)
+	if err != nil {
+		return err
+	}
+
+	backend.usageLock.Lock()
+	backend.highWater = item.ID
+	backend.bytes += uint64(len(value))
+	backend.usageLock.Unlock()
+	return nil
+}
+
+func (backend *goqueQueue) Dequeue() ([]byte, error) {
+	value, err := itemValue(backend.queue.Dequeue())
+	if value != nil {
+		backend.usageLock.Lock()
+		backend.bytes -= uint64(len(value))
+		backend.usageLock.Unlock()
+	}
+	return value, err
+}
+
+func (backend *goqueQueue) PeekByOffset(offset uint64) ([]byte, error) {
+	return itemValue(backend.queue.PeekByOffset(offset))
+}
+
+func (backend *goqueQueue) Length() uint64 {
+	return backend.queue.Length()
+}
+
+func (backend *goqueQueue) Flush() error {
+	return fsyncJournal(backend.path)
+}
+
+// Usage implements QueueUsageReporter
+func (backend *goqueQueue) Usage() (QueueUsage, error) {
+	disk, err := dirSize(backend.path)
+	if err != nil {
+		return QueueUsage{}, err
+	}
+
+	backend.usageLock.Lock()
+	defer backend.usageLock.Unlock()
+
+	return QueueUsage{
+		Length:        backend.queue.Length(),
+		Bytes:         backend.bytes,
+		HighWaterMark: backend.highWater,
+		DiskBytes:     disk,
+	}, nil
+}
+
+// Clear implements ClearableQueue by dropping our queue entirely and recreating it, which resets goque's item numbers
+// and hands LevelDB's files back to the operating system
+func (backend *goqueQueue) Clear() (err error) {
+	backend.queue.Drop()
+	backend.queue, err = goque.OpenQueue(backend.path)
+	if err != nil {
+		return err
+	}
+
+	backend.usageLock.Lock()
+	defer backend.usageLock.Unlock()
+	return backend.measure()
+}
+
+// Close implements QueueBackend. The goque we're pinned to doesn't report errors from closing, so neither can we
+func (backend *goqueQueue) Close() error {
+	backend.queue.Close()
+	return nil
+}
+
+// dirSize returns the total size of the files in a directory, which for LevelDB is its footprint on disk
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// LevelDB may have compacted a file away while we were walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// levelDBDiskStats counts up the SSTables in a LevelDB directory and measures its footprint on disk. LevelDB names its
+// tables .ldb, although older versions used .sst
+func levelDBDiskStats(dir string) (StorageStats, error) {
+	disk, err := dirSize(dir)
+	if err != nil {
+		return StorageStats{}, err
+	}
+
+	stats := StorageStats{DiskBytes: disk}
+	for _, pattern := range []string{"*.ldb", "*.sst"} {
+		tables, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return StorageStats{}, err
+		}
+		stats.Tables += len(tables)
+	}
+	return stats, nil
+}
+
+// parseLevelDBStats pulls each level's table count and size out of LevelDB's "leveldb.stats" property, which is a table
+// along the lines of:
+//
+//	 Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)
+//	-------+------------+---------------+---------------+---------------+---------------
+//	   0   |          2 |       0.00123 |       0.00000 |       0.00000 |       0.00000
+//
+// Anything that isn't a row of that table is skipped
+func parseLevelDBStats(stats string) []LevelStats {
+	levels := []LevelStats{}
+	for _, line := range strings.Split(stats, "\n") {
+		columns := strings.Split(line, "|")
+		if len(columns) < 3 {
+			continue
+		}
+
+		level, err := strconv.Atoi(strings.TrimSpace(columns[0]))
+		if err != nil {
+			continue
+		}
+		tables, err := strconv.Atoi(strings.TrimSpace(columns[1]))
+		if err != nil {
+			continue
+		}
+		megabytes, err := strconv.ParseFloat(strings.TrimSpace(columns[2]), 64)
+		if err != nil {
+			continue
+		}
+
+		levels = append(levels, LevelStats{Level: level, Tables: tables, Bytes: uint64(megabytes * 1024 * 1024)})
+	}
+	return levels
+}
+
+// StorageStats implements StorageStatsReporter. goque keeps its LevelDB to itself, so we can only go by what's on disk
+func (backend *goqueQueue) StorageStats() (StorageStats, error) {
+	return levelDBDiskStats(backend.path)
+}
+
+// goqueStack is our default StackBackend, built atop goque's LevelDB backed stack
+type goqueStack struct {
+	stack *goque.Stack
+
+	// We maintain a reference to our path so that we can easily drop and recreate our stack when cleared
+	path string
+}
+
+// OpenGoqueStack is a StackFactory that opens or creates a goque stack stored at the passed in path
+func OpenGoqueStack(path string) (StackBackend, error) {
+	stack, err := goque.OpenStack(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goqueStack{stack: stack, path: path}, nil
+}
+
+func (backend *goqueStack) Push(value []byte) error {
+	_, err := backend.stack.Push(value)
+	return err
+}
+
+func (backend *goqueStack) Pop() ([]byte, error) {
+	return itemValue(backend.stack.Pop())
+}
+
+func (backend *goqueStack) PeekByOffset(offset uint64) ([]byte, error) {
+	return itemValue(backend.stack.PeekByOffset(offset))
+}
+
+func (backend *goqueStack) Length() uint64 {
+	return backend.stack.Length()
+}
+
+// Clear drops our stack entirely and recreates it, which is far quicker than popping everything off of it
+func (backend *goqueStack) Clear() (err error) {
+	backend.stack.Drop()
+	backend.stack, err = goque.OpenStack(backend.path)
+	return err
+}
+
+// Close implements StackBackend. Like goqueQueue, there's no error for us to report
+func (backend *goqueStack) Close() error {
+	backend.stack.Close()
+	return nil
+}
+
+// StorageStats implements StorageStatsReporter. Like goqueQueue, we can only go by what's on disk
+func (backend *goqueStack) StorageStats() (StorageStats, error) {
+	return levelDBDiskStats(backend.path)
+}
+
+// levelDBState is our default StateBackend, a plain LevelDB database
+type levelDBState struct {
+	db *leveldb.DB
+
+	// We keep our path around so that we can find our journal when we need to flush it
+	path string
+}
+
+// OpenLevelDBState is a StateFactory that opens or creates a LevelDB database stored at the passed in path
+func OpenLevelDBState(path string) (StateBackend, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelDBState{db: db, path: path}, nil
+}
+
+func (backend *levelDBState) Get(key []byte) ([]byte, error) {
+	value, err := backend.db.Get(key, nil)
+	if err == errors.ErrNotFound {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+func (backend *levelDBState) Write(batch *StateBatch) error {
+	levelBatch := new(leveldb.Batch)
+	for _, entry := range batch.Puts {
+		levelBatch.Put(entry.Key, entry.Value)
+	}
+	for _, key := range batch.Deletes {
+		levelBatch.Delete(key)
+	}
+
+	return backend.db.Write(levelBatch, nil)
+}
+
+func (backend *levelDBState) Keys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+
+	iter := backend.db.NewIterator(util.BytesPrefix(prefix), nil)
+	for iter.Next() {
+		keys = append(keys, append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+
+	return keys, iter.Error()
+}
+
+func (backend *levelDBState) Flush() error {
+	return fsyncJournal(backend.path)
+}
+
+// StorageStats implements StorageStatsReporter, asking LevelDB for its per level breakdown
+func (backend *levelDBState) StorageStats() (StorageStats, error) {
+	stats, err := levelDBDiskStats(backend.path)
+	if err != nil {
+		return StorageStats{}, err
+	}
+
+	property, err := backend.db.GetProperty("leveldb.stats")
+	if err != nil {
+		return StorageStats{}, err
+	}
+	stats.Levels = parseLevelDBStats(property)
+	return stats, nil
+}
+
+func (backend *levelDBState) Close() error {
+	return backend.db.Close()
+}
@@ -0,0 +1,88 @@
+package accord
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// goroutineLeakChecks is how many checks in a row (see GoroutineCheckInterval) the number of goroutines we own has to
+// have grown before we warn that it looks like a leak. A burst of work can account for a rise or two, but nothing we
+// start should keep on accumulating
+const goroutineLeakChecks = 5
+
+// goroutineTracker counts the goroutines Accord and its Components have started and not yet seen exit, by owner
+type goroutineTracker struct {
+	lock   *sync.Mutex
+	counts map[string]int
+
+	// last is our total at our previous check, and rising how many checks in a row it has gone up. Both are only
+	// touched by checkGoroutines
+	last   int
+	rising int
+}
+
+func newGoroutineTracker() *goroutineTracker {
+	return &goroutineTracker{lock: &sync.Mutex{}, counts: map[string]int{}}
+}
+
+// TrackGoroutine records that owner (a Component's name, say) is about to start a goroutine that Accord is
+// responsible for, returning the function to call as it exits, so that our count of them (see Goroutines) shows up any
+// that never do. The returned function is safe to call more than once, only the first call counts
+func (accord *Accord) TrackGoroutine(owner string) func() {
+	tracker := accord.goroutines
+	tracker.lock.Lock()
+	tracker.counts[owner]++
+	tracker.lock.Unlock()
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			tracker.lock.Lock()
+			defer tracker.lock.Unlock()
+
+			tracker.counts[owner]--
+			if tracker.counts[owner] <= 0 {
+				delete(tracker.counts, owner)
+			}
+		})
+	}
+}
+
+// Goroutines returns how many goroutines we and our Components are running, by owner (see TrackGoroutine). Owners
+// without any running are left out, so it's empty once we've fully stopped
+func (accord *Accord) Goroutines() map[string]int {
+	accord.goroutines.lock.Lock()
+	defer accord.goroutines.lock.Unlock()
+
+	counts := map[string]int{}
+	for owner, count := range accord.goroutines.counts {
+		counts[owner] = count
+	}
+	return counts
+}
+
+// checkGoroutines warns if the number of goroutines we own has kept growing for goroutineLeakChecks checks in a row
+func (accord *Accord) checkGoroutines() {
+	counts := accord.Goroutines()
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	tracker := accord.goroutines
+	if total > tracker.last {
+		tracker.rising++
+	} else {
+		tracker.rising = 0
+	}
+	tracker.last = total
+
+	if tracker.rising >= goroutineLeakChecks {
+		fields := logrus.Fields{"total": total, "checks": tracker.rising}
+		for owner, count := range counts {
+			fields["owner."+owner] = count
+		}
+		accord.Logger.WithFields(fields).Warn("The number of goroutines we own keeps growing, something may be leaking them")
+	}
+}
@@ -0,0 +1,88 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedRunner is a ComponentRunner that idles along under a name
+type namedRunner struct {
+	ComponentRunner
+	name string
+}
+
+func (runner *namedRunner) Start(accord *Accord) error {
+	runner.ComponentRunner.Init(accord, func(*Accord) { time.Sleep(time.Millisecond) }, nil, accord.Logger.WithField("component", runner.name))
+	return nil
+}
+
+func (runner *namedRunner) ComponentName() string {
+	return runner.name
+}
+
+func TestAccordGoroutines(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	first := &namedRunner{name: "first"}
+	second := &namedRunner{name: "second"}
+
+	for cycle := 0; cycle < 2; cycle++ {
+		accord := DummyAccordComponents(first, second)
+		accord.ExpirySweepInterval = time.Hour
+		accord.GoroutineCheckInterval = time.Hour
+		assert.Empty(t, accord.Goroutines())
+
+		err := accord.Start()
+		assert.Nil(t, err)
+
+		// One for each of our background tasks, and one for each component
+		assert.Equal(t, map[string]int{"accord": 2, "first": 1, "second": 1}, accord.Goroutines())
+		assert.Equal(t, accord.Goroutines(), accord.Status().Goroutines)
+
+		// Everything is let go of by the time we've stopped, however many times our components are started over
+		accord.Stop()
+		assert.Empty(t, accord.Goroutines())
+	}
+}
+
+func TestAccordTrackGoroutine(t *testing.T) {
+	accord := DummyAccord()
+
+	first := accord.TrackGoroutine("test")
+	second := accord.TrackGoroutine("test")
+	assert.Equal(t, map[string]int{"test": 2}, accord.Goroutines())
+
+	// Releasing twice doesn't count twice
+	first()
+	first()
+	assert.Equal(t, map[string]int{"test": 1}, accord.Goroutines())
+	second()
+	assert.Empty(t, accord.Goroutines())
+}
+
+func TestAccordCheckGoroutines(t *testing.T) {
+	accord := DummyAccord()
+
+	// A steady count is never a leak
+	release := accord.TrackGoroutine("steady")
+	for i := 0; i < goroutineLeakChecks*2; i++ {
+		accord.checkGoroutines()
+	}
+	assert.Equal(t, 0, accord.goroutines.rising)
+
+	// One that keeps growing is
+	for i := 0; i < goroutineLeakChecks; i++ {
+		accord.TrackGoroutine("leaky")
+		accord.checkGoroutines()
+	}
+	assert.Equal(t, goroutineLeakChecks, accord.goroutines.rising)
+	assert.Equal(t, goroutineLeakChecks+1, accord.goroutines.last)
+
+	// Until it levels off
+	release()
+	accord.checkGoroutines()
+	assert.Equal(t, 0, accord.goroutines.rising)
+}
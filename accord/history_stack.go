@@ -1,11 +1,14 @@
 package accord
 
 import (
+	"errors"
 	"sync"
-
-	"github.com/beeker1121/goque"
+	"time"
 )
 
+// ErrNotInHistory is returned when asked for a Message our history doesn't (or no longer) hold
+var ErrNotInHistory = errors.New("message is not in our history")
+
 // HistoryStack holds the history of messages we've processed until so that we can mitigate application specific
 // message conflicts (such as database update collisions), until such a time that we're confident we don't need
 // them anymore. As the name implies, it works as a Stack in a LIFO behavior so that the latest operations appear
@@ -17,49 +20,72 @@ import (
 // But we should always be on the lookout for clever ways we can keep this pruned down to a manageable state. As such, I imagine
 // this will be a bit more "full featured" compared to SyncQueue just to accommodate those tricks
 type HistoryStack struct {
-	// Our main structure that actually holds our stack and persists it to disk using Goque and LevelDB
-	stack *goque.Stack
+	// Our main structure that actually holds our stack and persists it to disk (using Goque and LevelDB by default)
+	stack StackBackend
+
+	// archive optionally receives every Message before it's discarded, and archivePolicy says what to do when it fails
+	archive       ArchiveSink
+	archivePolicy ArchivePolicy
+
+	// archiveSkipped counts the Messages discarded without being archived under ArchiveSkip
+	archiveSkipped uint64
+
+	// compress tells us to compress each Message we push (see SetCompression)
+	compress bool
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
 
-	// We maintain a reference to our path so that we can easily drop and recreate our stack when requested
-	path string
+	// allowZeroIDs lets Messages with a zero ID in (see Accord.AllowZeroIDs)
+	allowZeroIDs bool
 
-	// While goque gives us thread safety for each individual call, to perform our helper functions we may need to perform
+	// batchSize is how many pushes we buffer before writing them out (see SetBatching), and pending are the encoded
+	// Messages we're holding on to, oldest first, along with the Messages themselves so they can be read back
+	batchSize   int
+	pending     [][]byte
+	pendingMsgs []*Message
+
+	// lockStats records how long our HistoryIterators hold stackLock (see LockStats)
+	lockStats *historyLockRecorder
+
+	// While our backend gives us thread safety for each individual call, to perform our helper functions we may need to perform
 	// multiple calls and we don't want to have the data changed under us in the middle of an operation, so we need to
 	// perform our own thread synchronization
 	stackLock *sync.Mutex
 }
 
-// OpenHistoryStack opens or creates our LIFO stack stored at the passed in path
+// OpenHistoryStack opens or creates our LIFO stack stored at the passed in path using our default goque backend
 func OpenHistoryStack(path string) (*HistoryStack, error) {
-	stack, err := goque.OpenStack(path)
+	stack, err := OpenGoqueStack(path)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewHistoryStack(stack), nil
+}
+
+// NewHistoryStack creates a HistoryStack on top of an already opened StackBackend
+func NewHistoryStack(stack StackBackend) *HistoryStack {
 	return &HistoryStack{
 		stack:     stack,
-		path:      path,
 		stackLock: &sync.Mutex{},
-	}, nil
+		lockStats: newHistoryLockRecorder(DefaultHistoryLockBuckets),
+	}
 }
 
-// peek is a helper for Peek and PeekByOffset
+// peek is a helper for Peek and PeekByOffset. Anything we're holding on to from a batch is on top of our stack
 func (history *HistoryStack) peek(offset uint64) (*Message, error) {
-	item, err := history.stack.PeekByOffset(offset)
-	if err != nil {
-		if err == goque.ErrEmpty {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	msg := Message{}
-	err = item.ToObject(&msg)
-	if err != nil {
-		return nil, err
+	buffered := uint64(len(history.pendingMsgs))
+	if offset < buffered {
+		msg := history.pendingMsgs[buffered-1-offset].copy()
+		return &msg, nil
 	}
+	return history.keys.valueToMessage(history.stack.PeekByOffset(offset - buffered))
+}
 
-	return &msg, nil
+// length is how many Messages are in our stack, including those we're holding on to from a batch
+func (history *HistoryStack) length() uint64 {
+	return history.stack.Length() + uint64(len(history.pendingMsgs))
 }
 
 // Peek returns the next Message *without* actually taking it off the stack. Returns nil if the stack is empty
@@ -80,16 +106,48 @@ func (history *HistoryStack) PeekByOffset(offset uint64) (*Message, error) {
 
 // Push adds a new Message to the top of our stack in a LIFO manner
 func (history *HistoryStack) Push(msg *Message) error {
+	err := checkMessageID(msg, history.allowZeroIDs)
+	if err != nil {
+		return err
+	}
+
 	history.stackLock.Lock()
 	defer history.stackLock.Unlock()
 
-	bytes, err := msg.Serialize()
+	bytes, err := history.encode(msg)
 	if err != nil {
 		return err
 	}
 
-	_, err = history.stack.Push(bytes)
-	return err
+	if history.batchSize <= 1 {
+		return history.stack.Push(bytes)
+	}
+
+	pushed := msg.copy()
+	history.pending = append(history.pending, bytes)
+	history.pendingMsgs = append(history.pendingMsgs, &pushed)
+	if len(history.pending) >= history.batchSize {
+		return history.flush()
+	}
+	return nil
+}
+
+// encode turns a Message into what we store for it in our backend
+func (history *HistoryStack) encode(msg *Message) ([]byte, error) {
+	bytes, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	// We compress before sealing, as there's nothing left to compress once it's been encrypted
+	if history.compress {
+		bytes, err = compressRecord(bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return history.keys.seal(bytes)
 }
 
 // Pop takes the top most Message off of our stack and returns it. Returns nil if the stack is empty
@@ -97,56 +155,330 @@ func (history *HistoryStack) Pop() (*Message, error) {
 	history.stackLock.Lock()
 	defer history.stackLock.Unlock()
 
-	item, err := history.stack.Pop()
-	if err != nil {
-		if err == goque.ErrEmpty {
-			return nil, nil
+	if len(history.pendingMsgs) > 0 {
+		last := len(history.pendingMsgs) - 1
+		msg := history.pendingMsgs[last]
+		history.pending = history.pending[:last]
+		history.pendingMsgs = history.pendingMsgs[:last]
+		return msg, nil
+	}
+
+	return history.keys.valueToMessage(history.stack.Pop())
+}
+
+// Size returns the number of Messages in our stack
+func (history *HistoryStack) Size() uint64 {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	return history.length()
+}
+
+// SetBatching has Push hold on to Messages in memory and write them out together, once size of them have built up or
+// Flush is called, rather than writing each one to our backend as it's pushed. With a backend that supports it (see
+// BatchStack) a batch is a single write, and either way each Push spends less time holding our history locked, which
+// helps under high message rates. Messages waiting to be written are read back just the same as the rest of our
+// history, so ShouldProcess and everything else see no difference.
+//
+// The tradeoff is that anything still waiting when we crash is lost. Our history only exists to help resolve conflicts,
+// and a lost Message has already been processed and queued to be synced, so the worst that can happen is that a
+// conflict is resolved without it. Close writes out whatever's waiting. A size of 1 or less turns batching off,
+// writing out anything that's waiting
+func (history *HistoryStack) SetBatching(size int) error {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	history.batchSize = size
+	if size <= 1 {
+		return history.flush()
+	}
+	return nil
+}
+
+// Flush writes out any Messages Push is holding on to (see SetBatching)
+func (history *HistoryStack) Flush() error {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	return history.flush()
+}
+
+// flush does the work of Flush. stackLock must be held by the caller. Should a write fail, whatever hasn't been
+// written is kept so that it can be tried again
+func (history *HistoryStack) flush() error {
+	if len(history.pending) == 0 {
+		return nil
+	}
+
+	if batch, ok := history.stack.(BatchStack); ok {
+		err := batch.PushBatch(history.pending)
+		if err != nil {
+			return err
+		}
+	} else {
+		for len(history.pending) > 0 {
+			err := history.stack.Push(history.pending[0])
+			if err != nil {
+				return err
+			}
+			history.pending = history.pending[1:]
+			history.pendingMsgs = history.pendingMsgs[1:]
 		}
-		return nil, err
 	}
 
-	msg := Message{}
-	err = item.ToObject(&msg)
-	if err != nil {
-		return nil, err
+	history.pending = nil
+	history.pendingMsgs = nil
+	return nil
+}
+
+// SetArchive gives the history an ArchiveSink to hand every Message to, oldest first, before it's discarded by Clear,
+// along with what to do should the sink fail. Passing a nil sink turns archiving back off
+func (history *HistoryStack) SetArchive(sink ArchiveSink, policy ArchivePolicy) {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	history.archive = sink
+	history.archivePolicy = policy
+}
+
+// SetCompression turns compression of the Messages we push on or off, trading a little CPU for a smaller footprint on
+// disk when a long history has to be retained. It only affects Messages pushed from then on, and Messages are read back
+// the same whether they were compressed or not, so it can be safely toggled on an existing history
+func (history *HistoryStack) SetCompression(enabled bool) {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	history.compress = enabled
+}
+
+// ArchiveSkipped returns the number of Messages that have been discarded without being archived, because our sink
+// failed to take them under ArchiveSkip
+func (history *HistoryStack) ArchiveSkipped() uint64 {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	return history.archiveSkipped
+}
+
+// Entries returns up to limit Messages starting at offset, oldest first, with offset counted from the oldest Message.
+// A limit of 0 returns everything after offset. New Messages are pushed on top, so an offset stays pointing at the same
+// Message between calls until the history is cleared, which lets the history be read a piece at a time without
+// holding it locked throughout
+func (history *HistoryStack) Entries(offset, limit uint64) ([]*Message, error) {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	msgs := []*Message{}
+	size := history.length()
+	for i := offset; i < size; i++ {
+		if limit > 0 && uint64(len(msgs)) >= limit {
+			break
+		}
+
+		msg, err := history.peek(size - 1 - i)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
 	}
 
-	return &msg, nil
+	return msgs, nil
 }
 
-// Size returns the number of Messages in our stack
-func (history *HistoryStack) Size() uint64 {
+// Find returns the Message with the given ID, searching from the newest down, or ErrNotInHistory if we don't have it.
+// There's no index, so this may read the entire history
+func (history *HistoryStack) Find(id uint64) (*Message, error) {
 	history.stackLock.Lock()
 	defer history.stackLock.Unlock()
 
-	return history.stack.Length()
+	size := history.length()
+	for i := uint64(0); i < size; i++ {
+		msg, err := history.peek(i)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil && msg.ID == id {
+			return msg, nil
+		}
+	}
+	return nil, ErrNotInHistory
+}
+
+// SetLockBuckets changes the upper bounds, in ascending order, of the histogram LockStats sorts iterators into by how
+// long they held our history (DefaultHistoryLockBuckets by default). Anything already in the histogram is dropped
+func (history *HistoryStack) SetLockBuckets(buckets []time.Duration) {
+	history.lockStats.setBuckets(buckets)
 }
 
-// Clear drops all data from the history stack
-func (history *HistoryStack) Clear() (err error) {
+// LockStats returns how long HistoryIterators have kept our history locked, and how often they had to wait for it.
+// Unlike everything else here this doesn't wait on our lock, so it can be called while an iterator is open
+func (history *HistoryStack) LockStats() HistoryLockStats {
+	return history.lockStats.snapshot()
+}
+
+// Clear drops all data from the history stack. If we have an ArchiveSink every Message is handed to it first, and
+// should it fail under ArchiveAbort nothing is dropped and an *ArchiveError is returned
+func (history *HistoryStack) Clear() error {
 	history.stackLock.Lock()
 	defer history.stackLock.Unlock()
 
-	history.stack.Drop()
-	history.stack, err = goque.OpenStack(history.path)
+	if history.archive != nil {
+		size := history.length()
+		for i := size; i > 0; i-- {
+			msg, err := history.peek(i - 1)
+			if err != nil {
+				return err
+			}
+
+			err = history.archive.Archive(msg)
+			if err != nil {
+				if history.archivePolicy == ArchiveAbort {
+					return &ArchiveError{MessageID: msg.ID, Err: err}
+				}
+				history.archiveSkipped++
+			}
+		}
+	}
+
+	history.pending = nil
+	history.pendingMsgs = nil
+	return history.stack.Clear()
+}
+
+// TruncateThrough drops the Message with the given ID from our history, along with everything older than it, returning
+// how many Messages were dropped. Nothing is dropped if the Message isn't in our history. Like Clear, our ArchiveSink is
+// handed every dropped Message first, oldest first. Our backend can only be added to and taken from the top, so the
+// Messages we keep are set aside and pushed back once the rest have been cleared out: should we crash in between,
+// they're lost from our history along with the rest
+func (history *HistoryStack) TruncateThrough(id uint64) (uint64, error) {
+	history.stackLock.Lock()
+	defer history.stackLock.Unlock()
+
+	size := history.length()
+	kept := []*Message{}
+	for {
+		if uint64(len(kept)) >= size {
+			return 0, nil
+		}
+
+		msg, err := history.peek(uint64(len(kept)))
+		if err != nil {
+			return 0, err
+		}
+		if msg == nil {
+			return 0, nil
+		}
+		if msg.ID == id {
+			break
+		}
+		kept = append(kept, msg)
+	}
+
+	if history.archive != nil {
+		for i := size; i > uint64(len(kept)); i-- {
+			msg, err := history.peek(i - 1)
+			if err != nil {
+				return 0, err
+			}
+
+			err = history.archive.Archive(msg)
+			if err != nil {
+				if history.archivePolicy == ArchiveAbort {
+					return 0, &ArchiveError{MessageID: msg.ID, Err: err}
+				}
+				history.archiveSkipped++
+			}
+		}
+	}
+
+	// Encode everything we're keeping before touching our backend, so that a failure leaves our history as it was
+	values := make([][]byte, len(kept))
+	for i, msg := range kept {
+		value, err := history.encode(msg)
+		if err != nil {
+			return 0, err
+		}
+		values[len(kept)-1-i] = value
+	}
+
+	history.pending = nil
+	history.pendingMsgs = nil
+	err := history.stack.Clear()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+
+	if batch, ok := history.stack.(BatchStack); ok && len(values) > 0 {
+		err = batch.PushBatch(values)
+	} else {
+		for _, value := range values {
+			err = history.stack.Push(value)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return size - uint64(len(kept)), nil
 }
 
-// Close closes the underlying connection to our persisted stack
+// Close writes out any Messages we're holding on to from a batch and closes the underlying connection to our persisted
+// stack
 func (history *HistoryStack) Close() {
 	history.stackLock.Lock()
 	defer history.stackLock.Unlock()
 
+	// There's nobody left to tell, and our history is best effort anyway
+	history.flush()
 	history.stack.Close()
 }
 
+// HistoryOrder determines which direction a HistoryIterator walks the history stack
+type HistoryOrder int
+
+const (
+	// NewestFirst walks from the most recently pushed Message backwards. This is the default
+	NewestFirst HistoryOrder = iota
+
+	// OldestFirst walks from the oldest Message in our history forwards
+	OldestFirst
+)
+
+// HistoryIterator gives a Manager a way of looking through the Messages we've processed so that it can resolve
+// conflicts.
+//
+// A word on performance: the entire HistoryStack is locked for as long as the iterator is open, which means that
+// *all* other processing (new messages, remote messages, status requests) is blocked until the Manager returns from
+// ShouldProcess. Each call to Next is a LevelDB read, so walking the entire history is O(history size) for every
+// remote Message that arrives while we're diverged, and the history can grow large between synchronizations. Managers
+// should look only as deep as they need to: call Stop as soon as they have their answer, and use SetMaxScan to put a
+// hard cap on how many Messages they're willing to read.
 type HistoryIterator struct {
 	stack *HistoryStack
 	pos   uint64
 	size  uint64
+
+	// order is the direction we walk the stack
+	order HistoryOrder
+
+	// maxScan is the maximum number of Messages we'll return. Zero means there's no limit
+	maxScan uint64
+
+	// stopped is set once our user tells us they don't need anything else
+	stopped bool
+
+	// acquired is when we took the lock on our stack, for HistoryLockStats
+	acquired time.Time
+
+	// deadline optionally cuts the iteration short, and timedOut is set once it has. See Accord.ShouldProcessTimeout
+	deadline time.Time
+	timedOut bool
+
+	// examined optionally collects the ID of every Message we return, for a Trace
+	examined *[]uint64
 }
 
 // createHistoryIterator creates a new instance of a HistoryIterator for easier navigation of a HistoryStack. This call should *always*
@@ -154,25 +486,82 @@ type HistoryIterator struct {
 func createHistoryIterator(stack *HistoryStack) *HistoryIterator {
 	// We lock the underlying stack to make sure it doesn't change from under us. This lock is only release by the
 	// "close" method and it *must* be called
+	start := time.Now()
+	contended := !stack.stackLock.TryLock()
+	if contended {
+		stack.stackLock.Lock()
+	}
 	it := &HistoryIterator{
-		stack: stack,
-		pos:   0,
-		size:  stack.stack.Length(),
+		stack:    stack,
+		pos:      0,
+		size:     stack.length(),
+		acquired: time.Now(),
 	}
-	it.stack.stackLock.Lock()
+	stack.lockStats.acquired(contended, it.acquired.Sub(start))
 	return it
 }
 
 // close unlocks the underlying HistoryStack so that further operations can be performed upon it
 func (it *HistoryIterator) close() {
+	it.stack.lockStats.released(time.Since(it.acquired))
 	it.stack.stackLock.Unlock()
 }
 
-// Next returns the next element in the stack and moves its pointer forward. If there are no more items available it returns nil
+// SetOrder changes the direction the iterator walks the stack. This should be called before the first call to Next
+func (it *HistoryIterator) SetOrder(order HistoryOrder) {
+	it.order = order
+}
+
+// SetMaxScan caps the number of Messages Next will return. Once the cap is reached Next returns nil, just as if we
+// had run out of history. Zero means there's no limit
+func (it *HistoryIterator) SetMaxScan(max uint64) {
+	it.maxScan = max
+}
+
+// TimedOut returns whether the iteration was cut short because resolving the conflict took too long. Once it has,
+// whatever the Manager decides is overridden (see Accord.ShouldProcessTimeout)
+func (it *HistoryIterator) TimedOut() bool {
+	return it.timedOut
+}
+
+// Stop tells the iterator that we've found what we're looking for, after which Next will always return nil
+func (it *HistoryIterator) Stop() {
+	it.stopped = true
+}
+
+// Scanned returns the number of Messages the iterator has returned so far
+func (it *HistoryIterator) Scanned() uint64 {
+	return it.pos
+}
+
+// Size returns the total number of Messages in the history being iterated over
+func (it *HistoryIterator) Size() uint64 {
+	return it.size
+}
+
+// Next returns the next element in the stack and moves its pointer forward. If there are no more items available (or we've been
+// stopped or hit our maxScan) it returns nil
 func (it *HistoryIterator) Next() (*Message, error) {
+	if it.stopped || (it.maxScan > 0 && it.pos >= it.maxScan) {
+		return nil, nil
+	}
+
+	if !it.deadline.IsZero() && time.Now().After(it.deadline) {
+		it.timedOut = true
+		return nil, nil
+	}
+
 	if it.pos < it.size {
-		msg, err := it.stack.peek(it.pos)
+		offset := it.pos
+		if it.order == OldestFirst {
+			offset = it.size - 1 - it.pos
+		}
+
+		msg, err := it.stack.peek(offset)
 		it.pos++
+		if it.examined != nil && msg != nil {
+			*it.examined = append(*it.examined, msg.ID)
+		}
 		return msg, err
 	}
 	return nil, nil
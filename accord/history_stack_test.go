@@ -1,8 +1,11 @@
 package accord
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,11 +17,11 @@ func TestHistoryStack(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Zero(t, stack.Size())
-	err = stack.Push(&Message{Payload: []byte{1}})
+	err = stack.Push(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
-	err = stack.Push(&Message{Payload: []byte{2}})
+	err = stack.Push(&Message{ID: 2, Payload: []byte{2}})
 	assert.Nil(t, err)
-	err = stack.Push(&Message{Payload: []byte{3}})
+	err = stack.Push(&Message{ID: 3, Payload: []byte{3}})
 	assert.Nil(t, err)
 
 	msg, err := stack.Peek()
@@ -64,11 +67,11 @@ func TestHistoryStackPeekByOffset(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Zero(t, stack.Size())
-	err = stack.Push(&Message{Payload: []byte{1}})
+	err = stack.Push(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
-	err = stack.Push(&Message{Payload: []byte{2}})
+	err = stack.Push(&Message{ID: 2, Payload: []byte{2}})
 	assert.Nil(t, err)
-	err = stack.Push(&Message{Payload: []byte{3}})
+	err = stack.Push(&Message{ID: 3, Payload: []byte{3}})
 	assert.Nil(t, err)
 
 	msg, err = stack.PeekByOffset(0)
@@ -90,7 +93,7 @@ func TestHistoryStackClear(t *testing.T) {
 	stack, err := OpenHistoryStack("history.stack")
 	assert.Nil(t, err)
 
-	err = stack.Push(&Message{Payload: []byte{1}})
+	err = stack.Push(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
 
 	assert.Equal(t, uint64(1), stack.Size())
@@ -99,12 +102,51 @@ func TestHistoryStackClear(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Equal(t, uint64(0), stack.Size())
-	err = stack.Push(&Message{Payload: []byte{1}})
+	err = stack.Push(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
 	assert.Equal(t, uint64(1), stack.Size())
 
 }
 
+func TestHistoryStackEntries(t *testing.T) {
+	os.RemoveAll("history.stack")
+	defer os.RemoveAll("history.stack")
+	stack, err := OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+
+	for i := byte(1); i <= 5; i++ {
+		err = stack.Push(&Message{ID: uint64(i), Payload: []byte{i}})
+		assert.Nil(t, err)
+	}
+
+	payloads := func(msgs []*Message) []byte {
+		out := []byte{}
+		for _, msg := range msgs {
+			out = append(out, msg.Payload[0])
+		}
+		return out
+	}
+
+	msgs, err := stack.Entries(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5}, payloads(msgs))
+
+	msgs, err = stack.Entries(1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2, 3}, payloads(msgs))
+
+	// Offsets keep pointing at the same Message as more are pushed
+	err = stack.Push(&Message{ID: 6, Payload: []byte{6}})
+	assert.Nil(t, err)
+	msgs, err = stack.Entries(4, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{5, 6}, payloads(msgs))
+
+	msgs, err = stack.Entries(10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+}
+
 func TestHistoryIterator(t *testing.T) {
 	os.RemoveAll("history.stack")
 	defer os.RemoveAll("history.stack")
@@ -113,13 +155,13 @@ func TestHistoryIterator(t *testing.T) {
 	stack, err := OpenHistoryStack("history.stack")
 	assert.Nil(t, err)
 
-	err = stack.Push(&Message{Payload: []byte{1}})
+	err = stack.Push(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
 
-	err = stack.Push(&Message{Payload: []byte{2}})
+	err = stack.Push(&Message{ID: 2, Payload: []byte{2}})
 	assert.Nil(t, err)
 
-	err = stack.Push(&Message{Payload: []byte{3}})
+	err = stack.Push(&Message{ID: 3, Payload: []byte{3}})
 	assert.Nil(t, err)
 
 	it := createHistoryIterator(stack)
@@ -127,7 +169,7 @@ func TestHistoryIterator(t *testing.T) {
 	// Make sure our locks work
 	done := make(chan int, 1)
 	go func() {
-		err = stack.Push(&Message{Payload: []byte{4}})
+		err = stack.Push(&Message{ID: 4, Payload: []byte{4}})
 		assert.Nil(t, err)
 		done <- 1
 	}()
@@ -155,3 +197,348 @@ func TestHistoryIterator(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []byte{4}, msg.Payload)
 }
+
+func TestHistoryIteratorOptions(t *testing.T) {
+	os.RemoveAll("history.stack")
+	defer os.RemoveAll("history.stack")
+
+	stack, err := OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+	defer stack.Close()
+
+	for i := byte(1); i <= 4; i++ {
+		err = stack.Push(&Message{ID: uint64(i), Payload: []byte{i}})
+		assert.Nil(t, err)
+	}
+
+	collect := func(it *HistoryIterator) []byte {
+		var payloads []byte
+		for {
+			msg, err := it.Next()
+			assert.Nil(t, err)
+			if msg == nil {
+				return payloads
+			}
+			payloads = append(payloads, msg.Payload[0])
+		}
+	}
+
+	// Oldest first
+	it := createHistoryIterator(stack)
+	it.SetOrder(OldestFirst)
+	assert.Equal(t, []byte{1, 2, 3, 4}, collect(it))
+	assert.Equal(t, uint64(4), it.Scanned())
+	it.close()
+
+	// Capped
+	it = createHistoryIterator(stack)
+	it.SetMaxScan(2)
+	assert.Equal(t, []byte{4, 3}, collect(it))
+	assert.Equal(t, uint64(4), it.Size())
+	it.close()
+
+	// Stopped early
+	it = createHistoryIterator(stack)
+	msg, err := it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4}, msg.Payload)
+	it.Stop()
+	msg, err = it.Next()
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+	it.close()
+}
+
+func TestHistoryStackArchive(t *testing.T) {
+	os.RemoveAll("history.stack")
+	defer os.RemoveAll("history.stack")
+	stack, err := OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+	defer stack.Close()
+
+	push := func() {
+		for i := byte(1); i <= 3; i++ {
+			err = stack.Push(&Message{ID: uint64(i), Payload: []byte{i}})
+			assert.Nil(t, err)
+		}
+	}
+	push()
+
+	// A failing sink under ArchiveAbort should leave our history alone
+	failure := errors.New("archive is down")
+	stack.SetArchive(ArchiveFunc(func(msg *Message) error {
+		return failure
+	}), ArchiveAbort)
+	err = stack.Clear()
+	archiveErr, ok := err.(*ArchiveError)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), archiveErr.MessageID)
+	assert.Equal(t, failure, archiveErr.Err)
+	assert.Equal(t, uint64(3), stack.Size())
+
+	// A working sink should get everything, oldest first, before it's cleared
+	buf := &bytes.Buffer{}
+	stack.SetArchive(NewWriterArchive(buf), ArchiveAbort)
+	err = stack.Clear()
+	assert.Nil(t, err)
+	assert.Zero(t, stack.Size())
+
+	var archived []byte
+	err = ReadArchive(buf, func(msg *Message) error {
+		archived = append(archived, msg.Payload[0])
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, archived)
+
+	// Under ArchiveSkip we clear regardless, keeping count of what we lost
+	push()
+	stack.SetArchive(ArchiveFunc(func(msg *Message) error {
+		if msg.ID == 2 {
+			return failure
+		}
+		return nil
+	}), ArchiveSkip)
+	err = stack.Clear()
+	assert.Nil(t, err)
+	assert.Zero(t, stack.Size())
+	assert.Equal(t, uint64(1), stack.ArchiveSkipped())
+}
+
+func TestHistoryStackCompression(t *testing.T) {
+	backend := &memoryStack{}
+	stack := NewHistoryStack(backend)
+	compressible := bytes.Repeat([]byte("compress me "), 100)
+	newest := func() []byte {
+		value, err := backend.PeekByOffset(0)
+		assert.Nil(t, err)
+		return value
+	}
+
+	err := stack.Push(&Message{ID: 1, Payload: compressible})
+	assert.Nil(t, err)
+	plainSize := len(newest())
+
+	stack.SetCompression(true)
+	err = stack.Push(&Message{ID: 2, Payload: compressible})
+	assert.Nil(t, err)
+	compressed := newest()
+	assert.True(t, isCompressed(compressed))
+	assert.True(t, len(compressed) < plainSize/4)
+
+	// Tiny payloads aren't worth compressing, and are kept as they were
+	err = stack.Push(&Message{ID: 3, Payload: []byte{1}})
+	assert.Nil(t, err)
+	assert.False(t, isCompressed(newest()))
+
+	// Compression is layered beneath at-rest encryption
+	stack.keys, err = newAtRestKeyring(bytes.Repeat([]byte{1}, 32))
+	assert.Nil(t, err)
+	err = stack.Push(&Message{ID: 4, Payload: compressible})
+	assert.Nil(t, err)
+	assert.True(t, isSealed(newest()))
+	assert.True(t, len(newest()) < plainSize/4)
+
+	// Compressed or not, everything reads back the same
+	for i := uint64(0); i < 4; i++ {
+		msg, err := stack.PeekByOffset(i)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(4-i), msg.ID)
+	}
+	msg, err := stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, compressible, msg.Payload)
+	msg, err = stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, msg.Payload)
+	msg, err = stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, compressible, msg.Payload)
+
+	// Compressed records are never mistaken for a Message on the wire
+	_, err = DeserializeMessage(compressed)
+	assert.Equal(t, ErrMalformedMessage, err)
+}
+
+func TestHistoryIteratorLockStats(t *testing.T) {
+	history := NewHistoryStack(&memoryStack{})
+	history.SetLockBuckets([]time.Duration{time.Millisecond, time.Hour})
+
+	// A slow conflict resolution
+	it := createHistoryIterator(history)
+	time.Sleep(20 * time.Millisecond)
+
+	// Our stats can be read while it's holding the lock
+	assert.Equal(t, uint64(1), history.LockStats().Iterations)
+
+	// And someone else has to wait on it
+	waited := make(chan struct{})
+	go func() {
+		other := createHistoryIterator(history)
+		other.close()
+		close(waited)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	it.close()
+	<-waited
+
+	stats := history.LockStats()
+	assert.Equal(t, uint64(2), stats.Iterations)
+	assert.Equal(t, uint64(1), stats.Contended)
+	assert.True(t, stats.WaitTime >= 10*time.Millisecond)
+	assert.True(t, stats.MaxHeld >= 30*time.Millisecond)
+	assert.True(t, stats.HeldTime >= stats.MaxHeld)
+
+	// The slow one lands in our middle bucket, and our quick one in one of the first two
+	assert.Len(t, stats.Held, 3)
+	assert.True(t, stats.Held[1].Count >= 1)
+	assert.Equal(t, uint64(2), stats.Held[0].Count+stats.Held[1].Count)
+	assert.Zero(t, stats.Held[2].Count)
+	assert.Zero(t, stats.Held[2].UpTo)
+}
+
+// batchingStack is a memoryStack that also takes batches, counting each write it's given
+type batchingStack struct {
+	memoryStack
+	writes int
+}
+
+func (stack *batchingStack) Push(value []byte) error {
+	stack.writes++
+	return stack.memoryStack.Push(value)
+}
+
+func (stack *batchingStack) PushBatch(values [][]byte) error {
+	stack.writes++
+	for _, value := range values {
+		err := stack.memoryStack.Push(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHistoryStackBatching(t *testing.T) {
+	backend := &batchingStack{}
+	stack := NewHistoryStack(backend)
+	assert.Nil(t, stack.SetBatching(3))
+
+	for id := uint64(1); id <= 4; id++ {
+		assert.Nil(t, stack.Push(&Message{ID: id, Payload: []byte{byte(id)}}))
+	}
+
+	// The first three went out in a single write, and the fourth is waiting, though it reads back all the same
+	assert.Equal(t, 1, backend.writes)
+	assert.Equal(t, uint64(3), backend.Length())
+	assert.Equal(t, uint64(4), stack.Size())
+
+	msgs, err := stack.Entries(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	for i, msg := range msgs {
+		assert.Equal(t, uint64(i+1), msg.ID)
+	}
+
+	it := createHistoryIterator(stack)
+	msg, err := it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), msg.ID)
+	msg, err = it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+	it.close()
+
+	// Popping takes from what's waiting first
+	msg, err = stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), msg.ID)
+	assert.Nil(t, stack.Push(&Message{ID: 5}))
+	assert.Equal(t, uint64(3), backend.Length())
+
+	// Closing writes out whatever's waiting, on top of everything else
+	stack.Close()
+	assert.Equal(t, 2, backend.writes)
+	top, err := stack.keys.valueToMessage(backend.PeekByOffset(0))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), top.ID)
+	bottom, err := stack.keys.valueToMessage(backend.PeekByOffset(3))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), bottom.ID)
+}
+
+func TestHistoryStackBatchingUnbatchedBackend(t *testing.T) {
+	os.RemoveAll("history.stack")
+	defer os.RemoveAll("history.stack")
+	stack, err := OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+	assert.Nil(t, stack.SetBatching(10))
+
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, stack.Push(&Message{ID: id}))
+	}
+	assert.Nil(t, stack.Flush())
+
+	// Turning batching off writes out anything that's waiting, too
+	assert.Nil(t, stack.Push(&Message{ID: 4}))
+	assert.Nil(t, stack.SetBatching(0))
+	stack.Close()
+
+	stack, err = OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+	defer stack.Close()
+	msgs, err := stack.Entries(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	for i, msg := range msgs {
+		assert.Equal(t, uint64(i+1), msg.ID)
+	}
+}
+
+func TestHistoryStackTruncateThrough(t *testing.T) {
+	os.RemoveAll("history.stack")
+	defer os.RemoveAll("history.stack")
+	stack, err := OpenHistoryStack("history.stack")
+	assert.Nil(t, err)
+	defer stack.Close()
+	assert.Nil(t, stack.SetBatching(2))
+
+	for id := uint64(1); id <= 5; id++ {
+		assert.Nil(t, stack.Push(&Message{ID: id, Payload: []byte{byte(id)}}))
+	}
+
+	// Nothing happens for a Message we don't have
+	dropped, err := stack.TruncateThrough(99)
+	assert.Nil(t, err)
+	assert.Zero(t, dropped)
+	assert.Equal(t, uint64(5), stack.Size())
+
+	archived := []uint64{}
+	stack.SetArchive(ArchiveFunc(func(msg *Message) error {
+		archived = append(archived, msg.ID)
+		return nil
+	}), ArchiveAbort)
+
+	dropped, err = stack.TruncateThrough(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), dropped)
+	assert.Equal(t, []uint64{1, 2}, archived)
+
+	// What we kept is still in order, including what was waiting on a batch
+	msgs, err := stack.Entries(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 3)
+	for i, msg := range msgs {
+		assert.Equal(t, uint64(i+3), msg.ID)
+		assert.Equal(t, []byte{byte(i + 3)}, msg.Payload)
+	}
+
+	// A failing archive leaves our history alone
+	stack.SetArchive(ArchiveFunc(func(msg *Message) error {
+		return errors.New("archive down")
+	}), ArchiveAbort)
+	_, err = stack.TruncateThrough(4)
+	_, ok := err.(*ArchiveError)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), stack.Size())
+}
@@ -0,0 +1,108 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHistoryLockBuckets are the upper bounds of the buckets HistoryLockStats sorts iterators into by default
+var DefaultHistoryLockBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// HistoryLockStats describes how long HistoryIterators have kept our history locked, and so held up all other
+// processing, which is the first place to look when conflict resolution seems to be the bottleneck. These are only
+// kept in memory and start over when the process does
+type HistoryLockStats struct {
+	// Iterations is the number of HistoryIterators that have been opened
+	Iterations uint64
+
+	// Contended is how many of them had to wait for the lock because something else was holding it, and WaitTime is
+	// how long they waited in total
+	Contended uint64
+	WaitTime  time.Duration
+
+	// HeldTime is how long iterators have held the lock in total, and MaxHeld is the longest any one of them has
+	HeldTime time.Duration
+	MaxHeld  time.Duration
+
+	// Held is a histogram of how long each iterator held the lock
+	Held []HistogramBucket
+}
+
+// HistogramBucket counts the samples that fell within it. A bucket holds everything up to and including UpTo that
+// wasn't counted by an earlier bucket, and the last bucket, whose UpTo is zero, holds everything else
+type HistogramBucket struct {
+	UpTo  time.Duration
+	Count uint64
+}
+
+// historyLockRecorder gathers up HistoryLockStats. It has its own lock so that the stats can be read while an
+// iterator is holding our history
+type historyLockRecorder struct {
+	lock  *sync.Mutex
+	stats HistoryLockStats
+}
+
+func newHistoryLockRecorder(buckets []time.Duration) *historyLockRecorder {
+	recorder := &historyLockRecorder{lock: &sync.Mutex{}}
+	recorder.setBuckets(buckets)
+	return recorder
+}
+
+// setBuckets replaces our histogram with an empty one with the given upper bounds, which must be in ascending order
+func (recorder *historyLockRecorder) setBuckets(buckets []time.Duration) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.stats.Held = make([]HistogramBucket, 0, len(buckets)+1)
+	for _, upTo := range buckets {
+		recorder.stats.Held = append(recorder.stats.Held, HistogramBucket{UpTo: upTo})
+	}
+	recorder.stats.Held = append(recorder.stats.Held, HistogramBucket{})
+}
+
+// acquired records an iterator taking the lock, after having waited for it if contended
+func (recorder *historyLockRecorder) acquired(contended bool, wait time.Duration) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.stats.Iterations++
+	if contended {
+		recorder.stats.Contended++
+		recorder.stats.WaitTime += wait
+	}
+}
+
+// released records an iterator letting go of the lock after holding it for held
+func (recorder *historyLockRecorder) released(held time.Duration) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.stats.HeldTime += held
+	if held > recorder.stats.MaxHeld {
+		recorder.stats.MaxHeld = held
+	}
+
+	last := len(recorder.stats.Held) - 1
+	for i := range recorder.stats.Held {
+		if i == last || held <= recorder.stats.Held[i].UpTo {
+			recorder.stats.Held[i].Count++
+			break
+		}
+	}
+}
+
+// snapshot returns a copy of our stats
+func (recorder *historyLockRecorder) snapshot() HistoryLockStats {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	stats := recorder.stats
+	stats.Held = append([]HistogramBucket{}, recorder.stats.Held...)
+	return stats
+}
@@ -0,0 +1,130 @@
+package accord
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultMaintenanceInterval is how often EnableAutoMaintenance runs our maintenance cycle when it isn't given an
+// interval
+const DefaultMaintenanceInterval = 10 * time.Minute
+
+// MaintenanceReport is the outcome of one of our automatic maintenance cycles (see AutoMaintenanceInterval)
+type MaintenanceReport struct {
+	// At is when the cycle ran
+	At time.Time
+
+	// Succeeded is whether we were in a position to tidy up and did so. When it isn't set, Reason says why not
+	Succeeded bool
+	Reason    string `json:",omitempty"`
+
+	// HistoryCleared is how many Messages were cleared out of our history, and QueueCleared whether our sync queue
+	// was cleared (see SyncQueue.Clear), which it isn't when its backend doesn't support it
+	HistoryCleared uint64
+	QueueCleared   bool
+}
+
+// peerReport is the last state a peer reported to us through Reconcile, and when
+type peerReport struct {
+	state uint64
+	at    time.Time
+}
+
+// EnableAutoMaintenance turns on our automatic maintenance cycle, running it every interval (DefaultMaintenanceInterval
+// if it's zero). It's the same as setting AutoMaintenanceInterval, and must be done before calling Start
+func (accord *Accord) EnableAutoMaintenance(interval time.Duration) {
+	if interval == 0 {
+		interval = DefaultMaintenanceInterval
+	}
+	accord.AutoMaintenanceInterval = interval
+}
+
+// notePeerState records the state a peer has reported to us, for our maintenance cycle to check against.
+// processMutex must be held by the caller
+func (accord *Accord) notePeerState(peer string, state uint64) {
+	if accord.peerStates == nil {
+		accord.peerStates = map[string]peerReport{}
+	}
+	accord.peerStates[peer] = peerReport{state: state, at: time.Now()}
+}
+
+// runMaintenance runs a maintenance cycle, recording and logging how it went
+func (accord *Accord) runMaintenance() {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	report := accord.maintain(time.Now())
+	accord.lastMaintenance = &report
+
+	log := accord.Logger.WithField("historyCleared", report.HistoryCleared).WithField("queueCleared", report.QueueCleared)
+	if report.Succeeded {
+		log.Info("Completed maintenance")
+	} else {
+		log.WithField("reason", report.Reason).Debug("Skipped maintenance")
+	}
+}
+
+// maintain tidies up after ourselves once there's nothing left that could need our history or our sync queue's
+// backlog: our queue has drained and every peer that reports its state to us has recently told us it matches ours.
+// Then our history is cleared and our sync queue is cleared to reclaim its disk. processMutex must be held by the caller
+func (accord *Accord) maintain(now time.Time) MaintenanceReport {
+	report := MaintenanceReport{At: now}
+
+	if accord.ToBeSynced.Size() > 0 {
+		report.Reason = "sync queue is not empty"
+		return report
+	}
+
+	if len(accord.peerStates) == 0 {
+		report.Reason = "no peers have reported their state"
+		return report
+	}
+
+	// A peer we haven't heard from in a while may have moved on without telling us, and one that disagrees with us
+	// still has something to sync
+	state := accord.state.GetCurrent()
+	stale := now.Add(-2 * accord.AutoMaintenanceInterval)
+	peers := []string{}
+	for peer := range accord.peerStates {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		reported := accord.peerStates[peer]
+		if reported.at.Before(stale) {
+			report.Reason = fmt.Sprintf("peer %q has not reported its state recently", peer)
+			return report
+		}
+		if reported.state != state {
+			report.Reason = fmt.Sprintf("peer %q is not aligned with us", peer)
+			return report
+		}
+	}
+
+	if !accord.DisableHistory {
+		before := accord.history.Size()
+		err := accord.aligned()
+		if err != nil {
+			report.Reason = fmt.Sprintf("could not clear our history: %v", err)
+			return report
+		}
+		report.HistoryCleared = before - accord.history.Size()
+	}
+
+	err := accord.ToBeSynced.Clear()
+	switch err {
+	case nil:
+		report.QueueCleared = true
+	case ErrQueueUnsupported:
+	case ErrQueueNotEmpty:
+		// Something was enqueued since we looked, so it can wait for next time
+	default:
+		accord.Logger.WithError(err).Error("Could not clear our sync queue")
+		report.Reason = fmt.Sprintf("could not clear our sync queue: %v", err)
+		return report
+	}
+
+	report.Succeeded = true
+	return report
+}
@@ -0,0 +1,91 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordMaintenance(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.EnableAutoMaintenance(0)
+	assert.Equal(t, DefaultMaintenanceInterval, accord.AutoMaintenanceInterval)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	err = accord.HandleNewMessage(&Message{ID: 1})
+	assert.Nil(t, err)
+	err = accord.HandleNewMessage(&Message{ID: 2})
+	assert.Nil(t, err)
+	assert.Nil(t, accord.Status().Maintenance)
+
+	// Nobody has told us where they're at yet
+	accord.runMaintenance()
+	report := accord.Status().Maintenance
+	assert.False(t, report.Succeeded)
+	assert.Equal(t, "sync queue is not empty", report.Reason)
+
+	for i := 0; i < 2; i++ {
+		_, err = accord.ToBeSynced.Dequeue()
+		assert.Nil(t, err)
+	}
+
+	accord.runMaintenance()
+	report = accord.Status().Maintenance
+	assert.False(t, report.Succeeded)
+	assert.Equal(t, "no peers have reported their state", report.Reason)
+
+	// One peer has caught up with us but another hasn't
+	accord.processMutex.Lock()
+	accord.notePeerState("caught-up", accord.state.GetCurrent())
+	accord.notePeerState("behind", 1)
+	accord.processMutex.Unlock()
+
+	accord.runMaintenance()
+	report = accord.Status().Maintenance
+	assert.False(t, report.Succeeded)
+	assert.Equal(t, `peer "behind" is not aligned with us`, report.Reason)
+	assert.Equal(t, uint64(2), accord.history.Size())
+
+	// Once it has, there's nothing left to hold on to
+	accord.processMutex.Lock()
+	accord.notePeerState("behind", accord.state.GetCurrent())
+	accord.processMutex.Unlock()
+
+	accord.runMaintenance()
+	report = accord.Status().Maintenance
+	assert.True(t, report.Succeeded)
+	assert.Empty(t, report.Reason)
+	assert.Equal(t, uint64(2), report.HistoryCleared)
+	assert.True(t, report.QueueCleared)
+	assert.Equal(t, uint64(0), accord.history.Size())
+}
+
+func TestAccordMaintenanceStalePeer(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccord()
+	accord.AutoMaintenanceInterval = time.Hour
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// A peer agreeing with us through Reconcile counts, for as long as it keeps telling us
+	_, err = accord.Reconcile("peer", accord.Status().State)
+	assert.Nil(t, err)
+
+	accord.processMutex.Lock()
+	report := accord.maintain(time.Now())
+	assert.True(t, report.Succeeded)
+
+	report = accord.maintain(time.Now().Add(3 * time.Hour))
+	accord.processMutex.Unlock()
+	assert.False(t, report.Succeeded)
+	assert.Equal(t, `peer "peer" has not reported its state recently`, report.Reason)
+}
@@ -2,12 +2,146 @@ package accord
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"hash"
+	"io"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// serializationMarker is the first byte of every Message we serialize. A gob stream always begins with the (non zero)
+	// length of its first message, so a leading zero lets us tell our own encoding apart from Messages persisted by older
+	// versions of Accord, which we still need to be able to read
+	serializationMarker = 0x00
+
+	// serializationVersion is the second byte of every Message we serialize, so that we have room to change the format
+	// in the future
+	serializationVersion = 0x01
+
+	// serializationVersionTagged is used in place of serializationVersion when a Message has any optional fields set.
+	// It's the same format, followed by each optional field that is set as a tag byte and a length prefixed value. We
+	// only use it when we need to, so that Messages without optional fields can still be read by older versions of
+	// Accord
+	serializationVersionTagged = 0x02
+
+	// serializationVersionSealed marks a record we've encrypted at rest (see Accord.AtRestKey) rather than a Message. It's
+	// only ever found on our own disk, never on the wire, so DeserializeMessage rejects it
+	serializationVersionSealed = 0x80
+
+	// serializationVersionCompressed marks a Message we've compressed on disk (see HistoryStack.SetCompression). Like
+	// serializationVersionSealed, it's never found on the wire
+	serializationVersionCompressed = 0x81
+)
+
+// MessageCodec identifies the encoding produced by Serialize, so that peers can confirm they speak the same one before
+// exchanging Messages. It must change whenever a peer that only knows the current encoding could no longer read what we
+// produce
+const MessageCodec = "accord/2"
+
+// Tags for the optional fields in serializationVersionTagged. These must never be reused or renumbered. Fields are
+// always written in ascending tag order so that our encoding stays deterministic, and tags we don't recognize are
+// skipped when reading so that newer peers can add fields without breaking us
+const (
+	tagExpiresAt byte = 0x01
+	tagPriority  byte = 0x02
+	tagClock     byte = 0x03
+	tagSequence  byte = 0x04
+	tagDelivery  byte = 0x05
+	tagType      byte = 0x06
+	tagDependsOn byte = 0x07
+)
+
+// ErrMalformedMessage is returned when we're asked to deserialize data that isn't a valid Message
+var ErrMalformedMessage = errors.New("malformed message")
+
+// ErrPayloadTooLarge is returned when a Message's payload is larger than an Accord's MaxPayloadSize
+var ErrPayloadTooLarge = errors.New("message payload too large")
+
+// ErrZeroMessageID is returned when a Message with a zero ID is handed to Accord. NewMessage never creates one, so it
+// means the Message was never properly initialized, or was mangled along the way. See Accord.AllowZeroIDs
+var ErrZeroMessageID = errors.New("message has a zero ID")
+
+// ErrMessageIDMismatch is returned, when ID verification is on (see Accord.VerifyMessageIDs), for a remote Message whose
+// ID doesn't match its content
+var ErrMessageIDMismatch = errors.New("message ID does not match its content")
+
+// checkMessageID returns ErrZeroMessageID for a Message with a zero ID, unless allowZero is set (see
+// Accord.AllowZeroIDs)
+func checkMessageID(msg *Message, allowZero bool) error {
+	if msg.ID == 0 && !allowZero {
+		return ErrZeroMessageID
+	}
+	return nil
+}
+
+// IDStrategy chooses what NewMessage derives a Message's ID from
+type IDStrategy int32
+
+const (
+	// IDSequenced stamps every Message with the next value of a per-process counter (see Message.Sequence) and mixes
+	// it into the ID along with the Timestamp and Payload, so that Messages with identical payloads created within the
+	// same timestamp (two rapid "increment" commands, say) still get distinct IDs. This is the default
+	IDSequenced IDStrategy = iota
+
+	// IDByContent derives the ID from the Timestamp and Payload alone, as versions of Accord before Sequence did.
+	// Identical payloads created within the same timestamp get the same ID, and so collide in our queue and state. It's
+	// only meant for clusters with peers that verify IDs (see Accord.VerifyMessageIDs) but predate Sequence, as they'd
+	// drop it and then reject the Message
+	IDByContent
+)
+
+// DeliveryMode chooses when a sync component may let go of a Message it has sent to a remote
+type DeliveryMode uint8
+
+const (
+	// AtLeastOnce keeps a Message in our sync queue until our remote acknowledges it, so that a Message lost in transit
+	// (or a remote that crashes before handling it) is simply sent again. A Message may therefore arrive more than once.
+	// This is the default
+	AtLeastOnce DeliveryMode = iota
+
+	// AtMostOnce takes a Message off our sync queue as soon as it's sent, without waiting on an acknowledgement. It
+	// saves a round trip before the next Message can go out, at the cost of the Message being lost for good if it
+	// never arrives. Only use it for Messages that are cheap to lose, like metrics or presence updates
+	AtMostOnce
+)
+
+// messageSequence is the last Sequence NewMessage handed out. It starts from a random point so that separate processes
+// (or the same process after a restart) are unlikely to walk the same sequence in step
+var messageSequence = randomSequenceStart()
+
+// randomSequenceStart picks a random starting point for messageSequence, falling back to zero if we can't get one
+func randomSequenceStart() uint64 {
+	start := make([]byte, 8)
+	_, err := rand.Read(start)
+	if err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(start)
+}
+
+// nextSequence returns the next Sequence to stamp on a new Message, skipping zero as that means it doesn't have one
+func nextSequence() uint64 {
+	for {
+		sequence := atomic.AddUint64(&messageSequence, 1)
+		if sequence != 0 {
+			return sequence
+		}
+	}
+}
+
+// checkPayloadSize returns ErrPayloadTooLarge if the payload is over max. A max of zero means there's no limit
+func checkPayloadSize(payload []byte, max int) error {
+	if max > 0 && len(payload) > max {
+		return ErrPayloadTooLarge
+	}
+	return nil
+}
+
 // Message represents a an arbitrary message that should be propagated and synchronized throughout the system
 type Message struct {
 	// An identifier for this message that should be unique based both on the content of the message as well
@@ -15,29 +149,77 @@ type Message struct {
 	ID uint64
 
 	// The UTC timestamp that the message was created. Obviously in a distributed environment timestamps are more
-	// of a suggestion rather than a hard truth but it's the best we've got
+	// of a suggestion rather than a hard truth, which is what Clock is for. NewMessage strips the monotonic clock
+	// reading Go attaches to the current time, as it never survives serialization anyway
 	Timestamp time.Time
 
+	// Clock is a Lamport clock, stamped on the Message when it's first handled: one more than the highest Clock of any
+	// Message its originating node had handled by then. Unlike Timestamp it's comparable across nodes whatever their
+	// wall clocks say, as a Message always has a higher Clock than anything its node had seen when it was created (see
+	// OrderByClock). It doesn't affect the Message's ID
+	Clock uint64
+
+	// Sequence is a per-process counter stamped on the Message by NewMessage under IDSequenced (the default, see
+	// Accord.IDStrategy) so that Messages with identical payloads created within the same timestamp get distinct IDs.
+	// Unlike Clock and Priority it's part of the Message's ID, which is why it travels with the Message rather than
+	// being generated again. Zero means the Message doesn't have one
+	Sequence uint64
+
 	// StateAt represents the state of the Message's originating Accord process when it was processed
 	StateAt uint64
 
 	// The actual content of the message. Our system should make as little assumptions about this as possible
 	// and instead leave application specific logic to implementors
 	Payload []byte
+
+	// ExpiresAt optionally marks the point after which the Message is no longer worth delivering. Expired Messages
+	// are swept out of our sync queue and are not processed when they arrive from a remote. The zero value means the
+	// Message never expires
+	ExpiresAt time.Time
+
+	// Priority optionally lets a Message jump ahead of older, lower priority, Messages when components that support it
+	// (see PollListener.Prioritized) choose what to send next. Zero (the default) is the lowest priority. It doesn't
+	// affect the Message's ID
+	Priority uint8
+
+	// DeliveryMode chooses whether components that support it (see PollListener) wait on our remote's acknowledgement
+	// before taking the Message off our sync queue. The zero value is AtLeastOnce. It doesn't affect the Message's ID
+	DeliveryMode DeliveryMode
+
+	// Type optionally names what kind of payload the Message carries, so that it can be checked against (and decoded
+	// through) the Schema registered under that name in a SchemaRegistry. Empty (the default) means the payload is
+	// untyped. It doesn't affect the Message's ID
+	Type string
+
+	// DependsOn optionally lists the IDs of Messages that must be processed before this one makes sense. A remote
+	// Message whose dependencies we haven't processed yet is held back until we have (see Accord.DependencyTimeout),
+	// giving causal ordering on top of the order Messages happen to arrive in. It doesn't affect the Message's ID
+	DependsOn []uint64
 }
 
 // NewMessage crafts a new Message using the passed in payload. This should only be for creating *bew* Message
-// (*not* deserializing Messages that get passed over the network, for that look at DeserializeMessage)
+// (*not* deserializing Messages that get passed over the network, for that look at DeserializeMessage). It uses our
+// defaults: an IDSequenced ID hashed with SHA-256 and a full precision Timestamp. Accord.NewMessage creates a Message
+// the way a particular Accord has been configured to
 func NewMessage(payload []byte) (*Message, error) {
+	return newMessage(payload, IDSequenced, nil, 0)
+}
 
-	// Create our initial bundle of data
+// newMessage is NewMessage with the given IDStrategy, ID hash (nil for SHA-256) and Timestamp precision (zero for full
+// precision)
+func newMessage(payload []byte, strategy IDStrategy, newHash func() hash.Hash, precision time.Duration) (*Message, error) {
+	// Create our initial bundle of data. Truncate (like Round(0)) also strips our monotonic clock reading, so that
+	// our Timestamp is identical before and after a round trip through Serialize
 	msg := &Message{
-		Timestamp: time.Now().UTC(),
+		Timestamp: time.Now().UTC().Truncate(precision),
 		Payload:   payload,
 	}
+	if strategy == IDSequenced {
+		msg.Sequence = nextSequence()
+	}
 
 	// Use our bundle of data to generate our ID, which is dependant on the previous fields
-	err := msg.genID()
+	err := msg.genID(newHash)
 	if err != nil {
 		return nil, err
 	}
@@ -46,8 +228,19 @@ func NewMessage(payload []byte) (*Message, error) {
 }
 
 // DeserializeMessage takes a byte slice and parses it back into a Message struct. This should be used along
-// with the Serialize method to send Messages over the wire
+// with the Serialize method to send Messages over the wire. It doesn't check the Message's ID against its content, which
+// Accord does as it handles a remote Message if VerifyMessageIDs is set
 func DeserializeMessage(data []byte) (*Message, error) {
+	return deserializeMessage(data)
+}
+
+// deserializeMessage is DeserializeMessage for Messages we've persisted ourselves
+func deserializeMessage(data []byte) (*Message, error) {
+	if len(data) > 0 && data[0] == serializationMarker {
+		return decodeMessage(data)
+	}
+
+	// Anything without our marker must have been written by an older version of Accord using gob
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	msg := Message{}
 	err := decoder.Decode(&msg)
@@ -59,57 +252,289 @@ func DeserializeMessage(data []byte) (*Message, error) {
 	return &msg, nil
 }
 
-// genID takes a partially constructed Message and generates an identification using the present
-// fields
-func (msg *Message) genID() error {
-	buf := &bytes.Buffer{}
+// decodeMessage parses data written by Serialize
+func decodeMessage(data []byte) (*Message, error) {
+	reader := bytes.NewReader(data)
+
+	var header [2]byte
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil || (header[1] != serializationVersion && header[1] != serializationVersionTagged) {
+		return nil, ErrMalformedMessage
+	}
+
+	msg := Message{}
+
+	err = binary.Read(reader, binary.BigEndian, &msg.ID)
+	if err != nil {
+		return nil, ErrMalformedMessage
+	}
+
+	timestamp, err := readField(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = msg.Timestamp.UnmarshalBinary(timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &msg.StateAt)
+	if err != nil {
+		return nil, ErrMalformedMessage
+	}
+
+	msg.Payload, err = readField(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if header[1] == serializationVersionTagged {
+		for reader.Len() > 0 {
+			tag, _ := reader.ReadByte()
+			value, err := readField(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			switch tag {
+			case tagExpiresAt:
+				err = msg.ExpiresAt.UnmarshalBinary(value)
+				if err != nil {
+					return nil, err
+				}
+			case tagPriority:
+				if len(value) != 1 {
+					return nil, ErrMalformedMessage
+				}
+				msg.Priority = value[0]
+			case tagClock:
+				if len(value) != 8 {
+					return nil, ErrMalformedMessage
+				}
+				msg.Clock = binary.BigEndian.Uint64(value)
+			case tagSequence:
+				if len(value) != 8 {
+					return nil, ErrMalformedMessage
+				}
+				msg.Sequence = binary.BigEndian.Uint64(value)
+			case tagDelivery:
+				if len(value) != 1 {
+					return nil, ErrMalformedMessage
+				}
+				msg.DeliveryMode = DeliveryMode(value[0])
+			case tagType:
+				msg.Type = string(value)
+			case tagDependsOn:
+				if len(value) == 0 || len(value)%8 != 0 {
+					return nil, ErrMalformedMessage
+				}
+				for i := 0; i < len(value); i += 8 {
+					msg.DependsOn = append(msg.DependsOn, binary.BigEndian.Uint64(value[i:]))
+				}
+			}
+		}
+	}
+
+	if reader.Len() != 0 {
+		return nil, ErrMalformedMessage
+	}
+
+	return &msg, nil
+}
+
+// writeField writes a length prefixed byte slice to our buffer
+func writeField(buf *bytes.Buffer, field []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(field)))
+	buf.Write(field)
+}
+
+// readField reads a length prefixed byte slice written by writeField. Empty fields are returned as nil, which
+// matches the way gob used to treat them
+func readField(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	err := binary.Read(reader, binary.BigEndian, &length)
+	if err != nil || uint64(length) > uint64(reader.Len()) {
+		return nil, ErrMalformedMessage
+	}
 
-	encoder := gob.NewEncoder(buf)
+	if length == 0 {
+		return nil, nil
+	}
 
-	// Are there any other fields we should make our ID dependant on? We can't use StateAt because
-	// it doesn't get set until our message *actually* gets executed
-	err := encoder.Encode(struct {
-		Timestamp time.Time
-		Payload   []byte
-	}{msg.Timestamp, msg.Payload})
+	field := make([]byte, length)
+	reader.Read(field)
+	return field, nil
+}
 
+// copy returns a copy of the Message that shares nothing with it, so that it can be freely modified
+func (msg *Message) copy() Message {
+	dup := *msg
+	if msg.Payload != nil {
+		dup.Payload = append([]byte{}, msg.Payload...)
+	}
+	if msg.DependsOn != nil {
+		dup.DependsOn = append([]uint64(nil), msg.DependsOn...)
+	}
+	return dup
+}
+
+// genID takes a partially constructed Message and generates an identification using the present
+// fields, hashed with newHash (SHA-256 if it's nil)
+func (msg *Message) genID(newHash func() hash.Hash) error {
+	buf := &bytes.Buffer{}
+
+	// We can't use StateAt because it doesn't get set until our message *actually* gets executed. Sequence is
+	// only mixed in when it's set, so that Messages created without one keep the IDs they've always had
+	timestamp, err := msg.Timestamp.MarshalBinary()
 	if err != nil {
 		return err
 	}
+	writeField(buf, timestamp)
+	writeField(buf, msg.Payload)
+	if msg.Sequence != 0 {
+		binary.Write(buf, binary.BigEndian, msg.Sequence)
+	}
 
-	// Golang's encoder doesn't appear to be deterministic, it *seems* to carry around some global state
-	// based on prior calls to the function, from which it updates a little header (but not the underlying
-	// data, it seems). This obviously means that the ultimate hash will be different based on this internal
-	// state. That's okay for our purposes, as we're essentially just using this as a glorified PRNG but we'd
-	// do well to keep an eye on it, as we're also using go's gob decoder to serialize and deserialize over the
-	// network and to disk and any undefined behavior could really screw us up
-	hasher := sha256.New()
+	// We used to use gob here, which isn't deterministic (it carries around some global state based on
+	// prior calls, from which it updates a little header). Our hand rolled encoding doesn't have that problem,
+	// so the same timestamp and payload will always give us the same ID
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	hasher := newHash()
 	hasher.Write(buf.Bytes())
-	hash := hasher.Sum(nil)
+	sum := hasher.Sum(nil)
+	if len(sum) < 8 {
+		sum = append(sum, make([]byte, 8-len(sum))...)
+	}
 
 	// We could *technically* just use the hash as our ID but we don't really need 256 bits of entropy
 	// and it would just make some of our arithmetic down the road more complicated and slower, so for
 	// now let's save oursize a few bytes every message and make our lives a bit easier later
-	msg.ID = binary.LittleEndian.Uint64(hash)
+	msg.ID = binary.LittleEndian.Uint64(sum)
+
+	// A zero ID is reserved to mean the Message was never initialized (see ErrZeroMessageID), so on the vanishingly
+	// unlikely chance that our hash gives us one we nudge it along
+	if msg.ID == 0 {
+		msg.ID = 1
+	}
 
 	return nil
 }
 
-// Serialize encodes the Message into a byte slice so that it can be transported over a network or onto our disk.
-// The DeserializeMessage function can subsequently be used to recreate the Message
-func (msg *Message) Serialize() ([]byte, error) {
-	buf := &bytes.Buffer{}
-	encoder := gob.NewEncoder(buf)
+// VerifyID derives the Message's ID from its content again, returning ErrMessageIDMismatch if it doesn't match the ID
+// the Message carries. The ID is derived with SHA-256, as NewMessage does (see Accord.IDHash)
+func (msg *Message) VerifyID() error {
+	return msg.verifyID(nil)
+}
 
-	err := encoder.Encode(*msg)
+// verifyID is VerifyID with the given ID hash (SHA-256 if it's nil)
+func (msg *Message) verifyID(newHash func() hash.Hash) error {
+	derived := Message{Timestamp: msg.Timestamp, Payload: msg.Payload, Sequence: msg.Sequence}
+	err := derived.genID(newHash)
+	if err != nil {
+		return err
+	}
+
+	if derived.ID != msg.ID {
+		return ErrMessageIDMismatch
+	}
+	return nil
+}
 
+// Serialize encodes the Message into a byte slice so that it can be transported over a network or onto our disk.
+// The DeserializeMessage function can subsequently be used to recreate the Message.
+//
+// We encode by hand rather than using gob so that the same Message always produces the same bytes, regardless of
+// what's been encoded before it or which process is doing the encoding. The format is our marker and version bytes
+// followed by each field in a fixed order, big-endian, with variable length fields prefixed by a uint32 length:
+//
+//	marker | version | ID | len(Timestamp) | Timestamp | StateAt | len(Payload) | Payload [| tag | len(value) | value ...]
+func (msg *Message) Serialize() ([]byte, error) {
+	timestamp, err := msg.Timestamp.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
+	// Gather up any optional fields, in ascending tag order
+	tagged := &bytes.Buffer{}
+	if !msg.ExpiresAt.IsZero() {
+		expiresAt, err := msg.ExpiresAt.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		tagged.WriteByte(tagExpiresAt)
+		writeField(tagged, expiresAt)
+	}
+	if msg.Priority != 0 {
+		tagged.WriteByte(tagPriority)
+		writeField(tagged, []byte{msg.Priority})
+	}
+	if msg.Clock != 0 {
+		clock := make([]byte, 8)
+		binary.BigEndian.PutUint64(clock, msg.Clock)
+		tagged.WriteByte(tagClock)
+		writeField(tagged, clock)
+	}
+	if msg.Sequence != 0 {
+		sequence := make([]byte, 8)
+		binary.BigEndian.PutUint64(sequence, msg.Sequence)
+		tagged.WriteByte(tagSequence)
+		writeField(tagged, sequence)
+	}
+	if msg.DeliveryMode != AtLeastOnce {
+		tagged.WriteByte(tagDelivery)
+		writeField(tagged, []byte{byte(msg.DeliveryMode)})
+	}
+	if msg.Type != "" {
+		tagged.WriteByte(tagType)
+		writeField(tagged, []byte(msg.Type))
+	}
+	if len(msg.DependsOn) > 0 {
+		dependsOn := make([]byte, 8*len(msg.DependsOn))
+		for i, id := range msg.DependsOn {
+			binary.BigEndian.PutUint64(dependsOn[8*i:], id)
+		}
+		tagged.WriteByte(tagDependsOn)
+		writeField(tagged, dependsOn)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(serializationMarker)
+	if tagged.Len() > 0 {
+		buf.WriteByte(serializationVersionTagged)
+	} else {
+		buf.WriteByte(serializationVersion)
+	}
+	binary.Write(buf, binary.BigEndian, msg.ID)
+	writeField(buf, timestamp)
+	binary.Write(buf, binary.BigEndian, msg.StateAt)
+	writeField(buf, msg.Payload)
+	buf.Write(tagged.Bytes())
+
 	return buf.Bytes(), nil
 }
 
+// Expired returns whether the Message has an expiration and it has passed as of the given time
+func (msg Message) Expired(now time.Time) bool {
+	return !msg.ExpiresAt.IsZero() && now.After(msg.ExpiresAt)
+}
+
+// MessageOrdering chooses what NewerThanBy and OlderThanBy compare Messages by, so that a Manager can pick whichever
+// suits its conflict resolution
+type MessageOrdering int
+
+const (
+	// OrderByTimestamp compares Timestamps, as NewerThan and OlderThan do. It matches what people expect "newer" to
+	// mean, but is only as reliable as the synchronization of our nodes' wall clocks
+	OrderByTimestamp MessageOrdering = iota
+
+	// OrderByClock compares Clocks, which always puts a Message after everything its node had seen when it was created,
+	// however skewed the nodes' wall clocks are. Messages created concurrently on different nodes (neither having seen
+	// the other) have no real order, so ties are broken by ID, which every node agrees on
+	OrderByClock
+)
+
 // NewerThan is a helper function to quickly determine if the current message is newer than the referenced message
 func (msg Message) NewerThan(other Message) bool {
 	return msg.Timestamp.After(other.Timestamp)
@@ -119,3 +544,19 @@ func (msg Message) NewerThan(other Message) bool {
 func (msg Message) OlderThan(other Message) bool {
 	return msg.Timestamp.Before(other.Timestamp)
 }
+
+// NewerThanBy is NewerThan, comparing according to the given ordering
+func (msg Message) NewerThanBy(other Message, ordering MessageOrdering) bool {
+	if ordering == OrderByClock {
+		if msg.Clock != other.Clock {
+			return msg.Clock > other.Clock
+		}
+		return msg.ID > other.ID
+	}
+	return msg.NewerThan(other)
+}
+
+// OlderThanBy is OlderThan, comparing according to the given ordering
+func (msg Message) OlderThanBy(other Message, ordering MessageOrdering) bool {
+	return other.NewerThanBy(msg, ordering)
+}
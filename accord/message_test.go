@@ -1,6 +1,10 @@
 package accord
 
 import (
+	"bytes"
+	"encoding/gob"
+	"hash"
+	"hash/fnv"
 	"testing"
 	"time"
 
@@ -9,12 +13,12 @@ import (
 
 func TestMessageGenID(t *testing.T) {
 	msg1 := Message{Timestamp: time.Time{}, Payload: []byte{0}}
-	err := msg1.genID()
+	err := msg1.genID(nil)
 	assert.Nil(t, err)
 	assert.NotZero(t, msg1.ID)
 
 	msg2 := Message{Timestamp: time.Time{}, Payload: []byte{1}}
-	err = msg2.genID()
+	err = msg2.genID(nil)
 	assert.Nil(t, err)
 	assert.NotZero(t, msg2.ID)
 
@@ -23,7 +27,7 @@ func TestMessageGenID(t *testing.T) {
 
 func TestMessageSerializationAndDeserialization(t *testing.T) {
 	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, StateAt: 839}
-	msg.genID()
+	msg.genID(nil)
 
 	data, err := msg.Serialize()
 	assert.Nil(t, err)
@@ -89,11 +93,11 @@ func TestMessageGenIDMultipleTimes(t *testing.T) {
 	msg1 := Message{Timestamp: time.Time{}, Payload: []byte{0}}
 	msg2 := Message{Timestamp: time.Time{}, Payload: []byte{0}}
 
-	err := msg1.genID()
+	err := msg1.genID(nil)
 	assert.Nil(t, err)
 
 	msg2.Serialize()
-	err = msg2.genID()
+	err = msg2.genID(nil)
 	assert.Nil(t, err)
 
 	assert.Equal(t, msg1.ID, msg2.ID)
@@ -101,13 +105,63 @@ func TestMessageGenIDMultipleTimes(t *testing.T) {
 	_, err = msg1.Serialize()
 	assert.Nil(t, err)
 
-	err = msg1.genID()
+	err = msg1.genID(nil)
 	assert.Nil(t, err)
 
 	assert.Equal(t, msg1.ID, msg2.ID)
 
 }
 
+func TestMessageSerializationDeterministic(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, StateAt: 839, ID: 80}
+
+	data1, err := msg.Serialize()
+	assert.Nil(t, err)
+
+	// Encoding something else in between shouldn't have any effect
+	other := Message{Payload: []byte("something else entirely")}
+	_, err = other.Serialize()
+	assert.Nil(t, err)
+
+	data2, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, data1, data2)
+
+	// A message with the same fields should produce the same bytes
+	copied := msg
+	copied.Payload = []byte{123}
+	data3, err := copied.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, data1, data3)
+}
+
+func TestMessageDeserializeLegacyGob(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, StateAt: 839, ID: 80}
+
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(msg)
+	assert.Nil(t, err)
+
+	decoded, err := DeserializeMessage(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+}
+
+func TestMessageDeserializeMalformed(t *testing.T) {
+	msg := Message{Payload: []byte{1, 2, 3}}
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+
+	_, err = DeserializeMessage(data[:len(data)-1])
+	assert.Equal(t, ErrMalformedMessage, err)
+
+	_, err = DeserializeMessage(append(data, 0))
+	assert.Equal(t, ErrMalformedMessage, err)
+
+	_, err = DeserializeMessage([]byte{serializationMarker})
+	assert.Equal(t, ErrMalformedMessage, err)
+}
+
 func TestMessageNewerThan(t *testing.T) {
 	msg1 := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, StateAt: 839}
 	msg2 := Message{Timestamp: time.Date(1955, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, StateAt: 839}
@@ -123,3 +177,325 @@ func TestMessageOlderThan(t *testing.T) {
 	assert.False(t, msg1.OlderThan(msg2))
 	assert.True(t, msg2.OlderThan(msg1))
 }
+
+func TestMessageNewerThanBy(t *testing.T) {
+	// msg1 was created later by the wall clock, but by a node that hadn't seen msg2's Clock yet
+	msg1 := Message{ID: 1, Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Clock: 3}
+	msg2 := Message{ID: 2, Timestamp: time.Date(1955, time.October, 10, 26, 0, 0, 0, time.UTC), Clock: 7}
+
+	assert.True(t, msg1.NewerThanBy(msg2, OrderByTimestamp))
+	assert.False(t, msg1.NewerThanBy(msg2, OrderByClock))
+	assert.True(t, msg1.OlderThanBy(msg2, OrderByClock))
+
+	// Concurrent Messages fall back on their IDs
+	msg1.Clock = 7
+	assert.True(t, msg2.NewerThanBy(msg1, OrderByClock))
+	assert.False(t, msg1.NewerThanBy(msg1, OrderByClock))
+}
+
+func TestMessageTimestampRoundTrip(t *testing.T) {
+	msg, err := NewMessage([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	msg.Clock = 12
+
+	// The Message we get back should be identical, monotonic clock reading and all
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, *msg, *decoded)
+	assert.True(t, msg.Timestamp == decoded.Timestamp)
+
+	// And serializing it again gives us the same bytes
+	again, err := decoded.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, data, again)
+
+	accord := &Accord{TimestampPrecision: time.Millisecond}
+	msg, err = accord.NewMessage([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Zero(t, msg.Timestamp.Nanosecond()%int(time.Millisecond))
+	assert.Nil(t, msg.VerifyID())
+}
+
+func TestMessageExpiresAt(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, ID: 80}
+
+	// Without an expiration we stick to our original format
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersion), data[1])
+	assert.False(t, msg.Expired(time.Now()))
+
+	msg.ExpiresAt = time.Date(1985, time.October, 26, 1, 21, 0, 0, time.UTC)
+	data, err = msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersionTagged), data[1])
+
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+	assert.True(t, decoded.Expired(time.Now()))
+	assert.False(t, decoded.Expired(msg.Timestamp))
+
+	// Tags we don't know about should be skipped
+	unknown := append(append([]byte{}, data...), 0xFF, 0, 0, 0, 1, 42)
+	decoded, err = DeserializeMessage(unknown)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+}
+
+func TestMessagePriority(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, ID: 80}
+
+	msg.Priority = 9
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersionTagged), data[1])
+
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+
+	// Priority must not change our ID
+	withPriority := Message{Timestamp: msg.Timestamp, Payload: msg.Payload, Priority: 9}
+	err = withPriority.genID(nil)
+	assert.Nil(t, err)
+	without := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	err = without.genID(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, without.ID, withPriority.ID)
+
+	// A priority is exactly one byte
+	malformed := append(append([]byte{}, data[:len(data)-6]...), tagPriority, 0, 0, 0, 2, 1, 2)
+	_, err = DeserializeMessage(malformed)
+	assert.Equal(t, ErrMalformedMessage, err)
+}
+
+func TestMessageDeliveryMode(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, ID: 80}
+
+	// At least once is the default, and leaves our encoding alone
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersion), data[1])
+
+	msg.DeliveryMode = AtMostOnce
+	data, err = msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersionTagged), data[1])
+
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+
+	// A delivery mode must not change our ID
+	withMode := Message{Timestamp: msg.Timestamp, Payload: msg.Payload, DeliveryMode: AtMostOnce}
+	err = withMode.genID(nil)
+	assert.Nil(t, err)
+	without := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	err = without.genID(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, without.ID, withMode.ID)
+
+	// A delivery mode is exactly one byte
+	malformed := append(append([]byte{}, data[:len(data)-6]...), tagDelivery, 0, 0, 0, 2, 1, 1)
+	_, err = DeserializeMessage(malformed)
+	assert.Equal(t, ErrMalformedMessage, err)
+}
+
+func TestMessageType(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, ID: 80}
+	msg.Type = "order.placed"
+
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersionTagged), data[1])
+
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+
+	// Along with the other optional fields, in tag order
+	msg.DeliveryMode = AtMostOnce
+	msg.Priority = 3
+	data, err = msg.Serialize()
+	assert.Nil(t, err)
+	decoded, err = DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+
+	// A type must not change our ID
+	typed := Message{Timestamp: msg.Timestamp, Payload: msg.Payload, Type: msg.Type}
+	err = typed.genID(nil)
+	assert.Nil(t, err)
+	untyped := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	err = untyped.genID(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, untyped.ID, typed.ID)
+}
+
+func TestMessageVerifyIDs(t *testing.T) {
+	msg, err := NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Nil(t, msg.VerifyID())
+
+	tampered := *msg
+	tampered.Payload = []byte("abd")
+	assert.Equal(t, ErrMessageIDMismatch, tampered.VerifyID())
+	tamperedData, err := tampered.Serialize()
+	assert.Nil(t, err)
+
+	// Deserializing doesn't verify anything, that's left to Accord as it handles a remote Message
+	decoded, err := DeserializeMessage(tamperedData)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrMessageIDMismatch, decoded.VerifyID())
+
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	decoded, err = DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, decoded.ID)
+	assert.Nil(t, decoded.VerifyID())
+
+	// Our own persisted Messages are never checked
+	var keys *atRestKeyring
+	_, err = keys.openMessage(tamperedData)
+	assert.Nil(t, err)
+}
+
+func TestMessageMaxPayloadSize(t *testing.T) {
+	accord := &Accord{MaxPayloadSize: 3}
+
+	_, err := accord.NewMessage([]byte{1, 2, 3})
+	assert.Nil(t, err)
+
+	_, err = accord.NewMessage([]byte{1, 2, 3, 4})
+	assert.Equal(t, ErrPayloadTooLarge, err)
+
+	// Anything we already have can still be read back, but it's up to whoever is receiving it to check
+	data, err := (&Message{Payload: []byte{1, 2, 3, 4}}).Serialize()
+	assert.Nil(t, err)
+	msg, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrPayloadTooLarge, checkPayloadSize(msg.Payload, accord.MaxPayloadSize))
+
+	// The package level NewMessage has no limit, and neither does an Accord that hasn't been given one
+	_, err = NewMessage([]byte{1, 2, 3, 4})
+	assert.Nil(t, err)
+	_, err = (&Accord{}).NewMessage([]byte{1, 2, 3, 4})
+	assert.Nil(t, err)
+}
+
+func TestMessageSequencedIDs(t *testing.T) {
+	// Identical payloads created in quick succession must still get distinct IDs
+	ids := map[uint64]bool{}
+	for i := 0; i < 1000; i++ {
+		msg, err := NewMessage([]byte("increment"))
+		assert.Nil(t, err)
+		assert.NotZero(t, msg.Sequence)
+		assert.False(t, ids[msg.ID])
+		ids[msg.ID] = true
+
+		// Our Sequence travels with the Message, so its ID survives a round trip
+		data, err := msg.Serialize()
+		assert.Nil(t, err)
+		decoded, err := DeserializeMessage(data)
+		assert.Nil(t, err)
+		assert.Equal(t, *msg, *decoded)
+		assert.Nil(t, decoded.VerifyID())
+	}
+
+	// Messages without a Sequence keep the IDs they've always had
+	accord := &Accord{IDStrategy: IDByContent}
+	msg, err := accord.NewMessage([]byte("increment"))
+	assert.Nil(t, err)
+	assert.Zero(t, msg.Sequence)
+	legacy := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	assert.Nil(t, legacy.genID(nil))
+	assert.Equal(t, legacy.ID, msg.ID)
+
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(serializationVersion), data[1])
+
+	// A Sequence is exactly eight bytes
+	sequenced := Message{ID: 80, Sequence: 1}
+	data, err = sequenced.Serialize()
+	assert.Nil(t, err)
+	malformed := append(append([]byte{}, data[:len(data)-13]...), tagSequence, 0, 0, 0, 1, 1)
+	_, err = DeserializeMessage(malformed)
+	assert.Equal(t, ErrMalformedMessage, err)
+}
+
+func TestMessageIDHash(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}}
+	assert.Nil(t, msg.genID(nil))
+	defaultID := msg.ID
+
+	fnv64 := func() hash.Hash { return fnv.New64a() }
+	assert.Nil(t, msg.genID(fnv64))
+	assert.NotEqual(t, defaultID, msg.ID)
+
+	// Our custom hash is just as stable
+	again := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	assert.Nil(t, again.genID(fnv64))
+	assert.Equal(t, msg.ID, again.ID)
+	assert.Nil(t, msg.verifyID(fnv64))
+	assert.Equal(t, ErrMessageIDMismatch, msg.VerifyID())
+
+	// And still tells different content apart
+	other := Message{Timestamp: msg.Timestamp, Payload: []byte{124}}
+	assert.Nil(t, other.genID(fnv64))
+	assert.NotEqual(t, msg.ID, other.ID)
+
+	// Short sums are padded rather than overrun
+	assert.Nil(t, msg.genID(func() hash.Hash { return fnv.New32a() }))
+	assert.NotZero(t, msg.ID)
+
+	assert.Nil(t, msg.genID(nil))
+	assert.Equal(t, defaultID, msg.ID)
+
+	// An Accord creates its Messages with its own hash
+	accord := &Accord{IDHash: fnv64}
+	created, err := accord.NewMessage([]byte{123})
+	assert.Nil(t, err)
+	assert.Nil(t, created.verifyID(fnv64))
+	assert.Equal(t, ErrMessageIDMismatch, created.VerifyID())
+}
+
+func TestMessageDependsOn(t *testing.T) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte{123}, ID: 80}
+	msg.DependsOn = []uint64{1, 1 << 63, 42}
+	msg.Type = "order.shipped"
+
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	decoded, err := DeserializeMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, *decoded)
+
+	// A list of IDs has to be made up of whole IDs. Our dependencies are the last field, so we drop their last byte
+	// and shorten their length to match
+	truncated := append([]byte{}, data[:len(data)-1]...)
+	truncated[len(truncated)-24] = 23
+	_, err = DeserializeMessage(truncated)
+	assert.Equal(t, ErrMalformedMessage, err)
+
+	// And dependencies must not change our ID
+	dependent := Message{Timestamp: msg.Timestamp, Payload: msg.Payload, DependsOn: msg.DependsOn}
+	err = dependent.genID(nil)
+	assert.Nil(t, err)
+	independent := Message{Timestamp: msg.Timestamp, Payload: msg.Payload}
+	err = independent.genID(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, independent.ID, dependent.ID)
+
+	// A copy has its own dependencies, so changing them leaves the original alone
+	dup := msg.copy()
+	assert.Equal(t, msg, dup)
+	dup.DependsOn[0] = 7
+	dup.Payload[0] = 7
+	assert.Equal(t, uint64(1), msg.DependsOn[0])
+	assert.Equal(t, byte(123), msg.Payload[0])
+}
@@ -0,0 +1,319 @@
+package accord
+
+import (
+	"hash"
+	"time"
+)
+
+// Option configures an Accord as it's being created by NewAccord. Every Option is simply a shortcut for setting one
+// of Accord's exported configuration fields, so the two styles can be mixed freely, but Options keep all of our knobs
+// discoverable in one place
+type Option func(*Accord)
+
+// WithPersistence sets how aggressively our writes are flushed to stable storage (see PersistenceMode)
+func WithPersistence(mode PersistenceMode) Option {
+	return func(accord *Accord) {
+		accord.Persistence = mode
+	}
+}
+
+// WithDedup enables a bloom filter in front of our duplicate detection for remote Messages
+func WithDedup(config BloomConfig) Option {
+	return func(accord *Accord) {
+		accord.Dedup = &config
+	}
+}
+
+// WithoutHistory turns off our HistoryStack entirely (see DisableHistory)
+func WithoutHistory() Option {
+	return func(accord *Accord) {
+		accord.DisableHistory = true
+	}
+}
+
+// WithHistoryArchive sends every Message to sink before it's cleared out of our history, handling failures according
+// to policy
+func WithHistoryArchive(sink ArchiveSink, policy ArchivePolicy) Option {
+	return func(accord *Accord) {
+		accord.HistoryArchive = sink
+		accord.HistoryArchivePolicy = policy
+	}
+}
+
+// WithCompressedHistory compresses each Message we write to our history (see CompressHistory)
+func WithCompressedHistory() Option {
+	return func(accord *Accord) {
+		accord.CompressHistory = true
+	}
+}
+
+// WithAtRestKey encrypts what we persist to disk with key, while still reading records written with any of previous
+// (see AtRestKey)
+func WithAtRestKey(key []byte, previous ...[]byte) Option {
+	return func(accord *Accord) {
+		accord.AtRestKey = key
+		accord.PreviousAtRestKeys = previous
+	}
+}
+
+// WithMaxPayloadSize sets the largest payload, in bytes, that a Message may carry (see MaxPayloadSize)
+func WithMaxPayloadSize(n int) Option {
+	return func(accord *Accord) {
+		accord.MaxPayloadSize = n
+	}
+}
+
+// WithVerifyMessageIDs rejects remote Messages whose ID doesn't match their content (see VerifyMessageIDs)
+func WithVerifyMessageIDs() Option {
+	return func(accord *Accord) {
+		accord.VerifyMessageIDs = true
+	}
+}
+
+// WithZeroIDs lets Messages with a zero ID through (see AllowZeroIDs)
+func WithZeroIDs() Option {
+	return func(accord *Accord) {
+		accord.AllowZeroIDs = true
+	}
+}
+
+// WithIDStrategy chooses what new Messages derive their IDs from (see IDStrategy)
+func WithIDStrategy(strategy IDStrategy) Option {
+	return func(accord *Accord) {
+		accord.IDStrategy = strategy
+	}
+}
+
+// WithIDHash replaces the hash Message IDs are derived from (see IDHash)
+func WithIDHash(newHash func() hash.Hash) Option {
+	return func(accord *Accord) {
+		accord.IDHash = newHash
+	}
+}
+
+// WithTimestampPrecision truncates the Timestamp of new Messages to a multiple of precision (see TimestampPrecision)
+func WithTimestampPrecision(precision time.Duration) Option {
+	return func(accord *Accord) {
+		accord.TimestampPrecision = precision
+	}
+}
+
+// WithHistoryLockBuckets sets the histogram buckets used to report how long our history is held locked (see
+// HistoryLockBuckets)
+func WithHistoryLockBuckets(buckets ...time.Duration) Option {
+	return func(accord *Accord) {
+		accord.HistoryLockBuckets = buckets
+	}
+}
+
+// WithHistoryBatching writes the Messages pushed onto our history out in batches of size, and every interval if that's
+// non zero (see HistoryBatchSize)
+func WithHistoryBatching(size int, interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.HistoryBatchSize = size
+		accord.HistoryBatchInterval = interval
+	}
+}
+
+// WithStateBatching writes our state out once size updates have built up, and every interval if that's non zero (see
+// StateBatchSize)
+func WithStateBatching(size int, interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.StateBatchSize = size
+		accord.StateBatchInterval = interval
+	}
+}
+
+// WithShouldProcessTimeout warns when our Manager's ShouldProcess takes longer than timeout, stepping in according to
+// policy (see ShouldProcessTimeout)
+func WithShouldProcessTimeout(timeout time.Duration, policy TimeoutPolicy) Option {
+	return func(accord *Accord) {
+		accord.ShouldProcessTimeout = timeout
+		accord.ShouldProcessTimeoutPolicy = policy
+	}
+}
+
+// WithExpirySweep sweeps expired Messages out of our sync queue on the given interval
+func WithExpirySweep(interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.ExpirySweepInterval = interval
+	}
+}
+
+// WithQueueUsageCheck checks on our sync queue's footprint on the given interval (see QueueUsageInterval)
+func WithQueueUsageCheck(interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.QueueUsageInterval = interval
+	}
+}
+
+// WithDiskLimit caps how much disk our stores may take up, checked on the given interval, and what we do once they
+// go over (see MaxDiskBytes)
+func WithDiskLimit(max uint64, interval time.Duration, action DiskLimitAction) Option {
+	return func(accord *Accord) {
+		accord.MaxDiskBytes = max
+		accord.DiskCheckInterval = interval
+		accord.DiskLimitAction = action
+	}
+}
+
+// WithIdleShutdown shuts us down once we've been idle for the given timeout (see IdleShutdownTimeout)
+func WithIdleShutdown(timeout time.Duration) Option {
+	return func(accord *Accord) {
+		accord.IdleShutdownTimeout = timeout
+	}
+}
+
+// WithGoroutineCheck checks on the goroutines we own on the given interval, warning if they look to be leaking (see
+// GoroutineCheckInterval)
+func WithGoroutineCheck(interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.GoroutineCheckInterval = interval
+	}
+}
+
+// WithStopGracePeriod limits how long Stop waits for a Message that's being processed to finish (see StopGracePeriod)
+func WithStopGracePeriod(grace time.Duration) Option {
+	return func(accord *Accord) {
+		accord.StopGracePeriod = grace
+	}
+}
+
+// WithForceQuit exits the process straight away on a signal that comes in while we're already stopping (see ForceQuit)
+func WithForceQuit() Option {
+	return func(accord *Accord) {
+		accord.ForceQuit = true
+	}
+}
+
+// WithCheckpoints prunes our history up to each checkpoint once every one of peers has acknowledged it, taking a
+// checkpoint every interval if it's set (see CheckpointPeers)
+func WithCheckpoints(interval time.Duration, peers ...string) Option {
+	return func(accord *Accord) {
+		accord.CheckpointInterval = interval
+		accord.CheckpointPeers = peers
+	}
+}
+
+// WithTracing passes a Trace of how we handled rate (from 0 to 1) of the remote Messages we handle to fn (see OnTrace)
+func WithTracing(rate float64, fn func(Trace)) Option {
+	return func(accord *Accord) {
+		accord.TraceSampleRate = rate
+		accord.OnTrace = fn
+	}
+}
+
+// WithOnDivergence calls fn every time a remote Message arrives while our state has diverged from the remote's
+func WithOnDivergence(fn func(DivergenceEvent)) Option {
+	return func(accord *Accord) {
+		accord.OnDivergence = fn
+	}
+}
+
+// WithReconcile reconciles our state against our peers' on the given interval, calling fn (if it isn't nil) with each
+// outcome
+func WithReconcile(interval time.Duration, fn func(ReconcileResult)) Option {
+	return func(accord *Accord) {
+		accord.ReconcileInterval = interval
+		accord.OnReconcile = fn
+	}
+}
+
+// WithQueueTransitions calls onNonEmpty and onEmpty (either of which may be nil) as our sync queue goes from empty to
+// non-empty and back, once it has stayed that way for debounce (see OnQueueNonEmpty)
+func WithQueueTransitions(onNonEmpty, onEmpty func(), debounce time.Duration) Option {
+	return func(accord *Accord) {
+		accord.OnQueueNonEmpty = onNonEmpty
+		accord.OnQueueEmpty = onEmpty
+		accord.QueueTransitionDebounce = debounce
+	}
+}
+
+// WithDeliveryReceipts keeps a persisted log of every Message a peer acknowledges
+func WithDeliveryReceipts() Option {
+	return func(accord *Accord) {
+		accord.DeliveryReceipts = true
+	}
+}
+
+// WithProcessingConfirmations keeps a persisted record of which of our Messages a peer has confirmed it processed
+func WithProcessingConfirmations() Option {
+	return func(accord *Accord) {
+		accord.ProcessingConfirmations = true
+	}
+}
+
+// WithPersistedSyncCursors durably records each sync target's position in our sync queue (see PersistSyncCursors)
+func WithPersistedSyncCursors() Option {
+	return func(accord *Accord) {
+		accord.PersistSyncCursors = true
+	}
+}
+
+// WithProcessPriority decides whether local or remote Messages go first when both are waiting to be processed
+func WithProcessPriority(priority ProcessPriority) Option {
+	return func(accord *Accord) {
+		accord.ProcessPriority = priority
+	}
+}
+
+// WithOrderedSubmission processes concurrent local submissions strictly in the order they were made (see
+// OrderedSubmission)
+func WithOrderedSubmission() Option {
+	return func(accord *Accord) {
+		accord.OrderedSubmission = true
+	}
+}
+
+// WithScheduler shares processing out between the components that opt into it, letting at most slots of their ticks
+// run at once (see SchedulerSlots)
+func WithScheduler(slots int) Option {
+	return func(accord *Accord) {
+		accord.SchedulerSlots = slots
+	}
+}
+
+// WithTransformers reshapes Messages as they leave for and arrive from our peers. Either may be nil
+func WithTransformers(outbound OutboundTransformer, inbound InboundTransformer) Option {
+	return func(accord *Accord) {
+		accord.OutboundTransformer = outbound
+		accord.InboundTransformer = inbound
+	}
+}
+
+// WithMaxHeadRetries dead letters a Message once it has failed to sync the given number of times in a row
+func WithMaxHeadRetries(retries int) Option {
+	return func(accord *Accord) {
+		accord.MaxHeadRetries = retries
+	}
+}
+
+// WithDependencyTimeout gives up on remote Messages whose dependencies haven't been processed within the given timeout
+// (see DependencyTimeout)
+func WithDependencyTimeout(timeout time.Duration) Option {
+	return func(accord *Accord) {
+		accord.DependencyTimeout = timeout
+	}
+}
+
+// WithAutoMaintenance tidies up after ourselves every interval, once our sync queue has drained and our peers are
+// aligned with us (see AutoMaintenanceInterval)
+func WithAutoMaintenance(interval time.Duration) Option {
+	return func(accord *Accord) {
+		accord.AutoMaintenanceInterval = interval
+	}
+}
+
+// WithSchemas checks every Message we take in against the given SchemaRegistry (see Schemas)
+func WithSchemas(registry *SchemaRegistry) Option {
+	return func(accord *Accord) {
+		accord.Schemas = registry
+	}
+}
+
+// WithBackends swaps out the persistence engine underneath Accord
+func WithBackends(backends Backends) Option {
+	return func(accord *Accord) {
+		accord.Backends = backends
+	}
+}
@@ -0,0 +1,128 @@
+package accord
+
+import (
+	"hash"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAccordOptions(t *testing.T) {
+	archive := ArchiveFunc(func(*Message) error { return nil })
+	backends := Backends{Queue: OpenGoqueQueue}
+
+	accord := NewAccord(nil, nil, "", nil,
+		WithPersistence(PersistSync),
+		WithDedup(BloomConfig{Capacity: 100}),
+		WithoutHistory(),
+		WithHistoryArchive(archive, ArchiveSkip),
+		WithCompressedHistory(),
+		WithAtRestKey([]byte("new"), []byte("old")),
+		WithMaxPayloadSize(1024),
+		WithVerifyMessageIDs(),
+		WithZeroIDs(),
+		WithIDStrategy(IDByContent),
+		WithIDHash(func() hash.Hash { return fnv.New64a() }),
+		WithTimestampPrecision(time.Millisecond),
+		WithHistoryLockBuckets(time.Millisecond, time.Second),
+		WithHistoryBatching(10, time.Second),
+		WithStateBatching(20, time.Minute),
+		WithExpirySweep(time.Second),
+		WithQueueUsageCheck(time.Hour),
+		WithDiskLimit(1<<30, time.Second, DiskPruneHistory),
+		WithIdleShutdown(time.Minute),
+		WithGoroutineCheck(time.Minute),
+		WithStopGracePeriod(time.Second),
+		WithForceQuit(),
+		WithTracing(0.5, func(Trace) {}),
+		WithCheckpoints(time.Minute, "a", "b"),
+		WithShouldProcessTimeout(time.Minute, TimeoutSkip),
+		WithQueueTransitions(func() {}, nil, time.Second),
+		WithDeliveryReceipts(),
+		WithProcessingConfirmations(),
+		WithPersistedSyncCursors(),
+		WithProcessPriority(RemotePriority),
+		WithOrderedSubmission(),
+		WithScheduler(2),
+		WithTransformers(OutboundFunc(func(msg Message, _ string) (Message, error) { return msg, nil }), nil),
+		WithMaxHeadRetries(3),
+		WithDependencyTimeout(time.Hour),
+		WithAutoMaintenance(time.Hour),
+		WithSchemas(NewSchemaRegistry(UnknownReject)),
+		WithBackends(backends),
+	)
+
+	assert.Equal(t, PersistSync, accord.Persistence)
+	assert.Equal(t, &BloomConfig{Capacity: 100}, accord.Dedup)
+	assert.True(t, accord.DisableHistory)
+	assert.NotNil(t, accord.HistoryArchive)
+	assert.Equal(t, ArchiveSkip, accord.HistoryArchivePolicy)
+	assert.True(t, accord.CompressHistory)
+	assert.Equal(t, []byte("new"), accord.AtRestKey)
+	assert.Equal(t, [][]byte{[]byte("old")}, accord.PreviousAtRestKeys)
+	assert.Equal(t, 1024, accord.MaxPayloadSize)
+	assert.True(t, accord.VerifyMessageIDs)
+	assert.True(t, accord.AllowZeroIDs)
+	assert.Equal(t, IDByContent, accord.IDStrategy)
+	assert.NotNil(t, accord.IDHash)
+	assert.Equal(t, time.Millisecond, accord.TimestampPrecision)
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Second}, accord.HistoryLockBuckets)
+	assert.Equal(t, 10, accord.HistoryBatchSize)
+	assert.Equal(t, time.Second, accord.HistoryBatchInterval)
+	assert.Equal(t, 20, accord.StateBatchSize)
+	assert.Equal(t, time.Minute, accord.StateBatchInterval)
+	assert.Equal(t, time.Second, accord.ExpirySweepInterval)
+	assert.Equal(t, time.Hour, accord.QueueUsageInterval)
+	assert.Equal(t, uint64(1<<30), accord.MaxDiskBytes)
+	assert.Equal(t, time.Second, accord.DiskCheckInterval)
+	assert.Equal(t, DiskPruneHistory, accord.DiskLimitAction)
+	assert.Equal(t, time.Minute, accord.IdleShutdownTimeout)
+	assert.Equal(t, time.Minute, accord.GoroutineCheckInterval)
+	assert.Equal(t, time.Second, accord.StopGracePeriod)
+	assert.True(t, accord.ForceQuit)
+	assert.Equal(t, 0.5, accord.TraceSampleRate)
+	assert.NotNil(t, accord.OnTrace)
+	assert.Equal(t, time.Minute, accord.CheckpointInterval)
+	assert.Equal(t, []string{"a", "b"}, accord.CheckpointPeers)
+	assert.Equal(t, time.Minute, accord.ShouldProcessTimeout)
+	assert.Equal(t, TimeoutSkip, accord.ShouldProcessTimeoutPolicy)
+	assert.NotNil(t, accord.OnQueueNonEmpty)
+	assert.Nil(t, accord.OnQueueEmpty)
+	assert.Equal(t, time.Second, accord.QueueTransitionDebounce)
+	assert.True(t, accord.DeliveryReceipts)
+	assert.True(t, accord.ProcessingConfirmations)
+	assert.True(t, accord.PersistSyncCursors)
+	assert.Equal(t, RemotePriority, accord.ProcessPriority)
+	assert.True(t, accord.OrderedSubmission)
+	assert.Equal(t, 2, accord.SchedulerSlots)
+	assert.NotNil(t, accord.OutboundTransformer)
+	assert.Nil(t, accord.InboundTransformer)
+	assert.Equal(t, 3, accord.MaxHeadRetries)
+	assert.Equal(t, time.Hour, accord.DependencyTimeout)
+	assert.Equal(t, time.Hour, accord.AutoMaintenanceInterval)
+	assert.NotNil(t, accord.Schemas)
+	assert.NotNil(t, accord.Backends.Queue)
+}
+
+func TestNewAccordDefaults(t *testing.T) {
+	accord := NewAccord(nil, nil, "", nil)
+
+	assert.Equal(t, PersistAsync, accord.Persistence)
+	assert.Nil(t, accord.Dedup)
+	assert.False(t, accord.DisableHistory)
+	assert.Equal(t, FairInterleave, accord.ProcessPriority)
+	assert.False(t, accord.OrderedSubmission)
+	assert.Equal(t, 0, accord.SchedulerSlots)
+	assert.Zero(t, accord.MaxHeadRetries)
+	assert.Zero(t, accord.DependencyTimeout)
+	assert.Zero(t, accord.AutoMaintenanceInterval)
+	assert.Zero(t, accord.StopGracePeriod)
+	assert.False(t, accord.ForceQuit)
+	assert.Zero(t, accord.TraceSampleRate)
+	assert.Empty(t, accord.CheckpointPeers)
+	assert.Zero(t, accord.MaxDiskBytes)
+	assert.Equal(t, DiskReject, accord.DiskLimitAction)
+	assert.Nil(t, accord.Schemas)
+}
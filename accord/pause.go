@@ -0,0 +1,178 @@
+package accord
+
+// pendingLocal and pendingRemote prefix each entry in our PendingQueue, recording where its Message came from
+const (
+	pendingLocal  byte = 0
+	pendingRemote byte = 1
+)
+
+// PendingQueue is a persisted, in order, record of the Messages we've accepted but have yet to hand to our Manager
+// because processing is paused (see Accord.PauseProcessing). Like SyncQueue it's a thin wrapper around a QueueBackend
+type PendingQueue struct {
+	queue QueueBackend
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
+}
+
+// OpenPendingQueue opens or creates a PendingQueue stored at the passed in path using our default goque backend
+func OpenPendingQueue(path string) (*PendingQueue, error) {
+	queue, err := OpenGoqueQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPendingQueue(queue), nil
+}
+
+// NewPendingQueue creates a PendingQueue on top of an already opened QueueBackend
+func NewPendingQueue(queue QueueBackend) *PendingQueue {
+	return &PendingQueue{queue: queue}
+}
+
+// Add appends a Message to the end of the queue, along with whether it came from a remote
+func (pending *PendingQueue) Add(msg *Message, fromRemote bool) error {
+	data, err := pending.keys.sealMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	origin := pendingLocal
+	if fromRemote {
+		origin = pendingRemote
+	}
+
+	return pending.queue.Enqueue(append([]byte{origin}, data...))
+}
+
+// Peek returns the Message at the head of the queue, and whether it came from a remote, without removing it. Returns
+// nil if the queue is empty
+func (pending *PendingQueue) Peek() (*Message, bool, error) {
+	value, err := pending.queue.PeekByOffset(0)
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	if len(value) < 1 {
+		return nil, false, ErrMalformedMessage
+	}
+
+	msg, err := pending.keys.openMessage(value[1:])
+	return msg, value[0] == pendingRemote, err
+}
+
+// Remove takes the Message at the head of the queue out of it
+func (pending *PendingQueue) Remove() error {
+	_, err := pending.queue.Dequeue()
+	return err
+}
+
+// removeLast takes the most recently added Message back out of the queue, for when whatever it was buffered along with
+// failed. Our backend can only be dequeued from the front, so this rewrites the entire queue
+func (pending *PendingQueue) removeLast() error {
+	size := pending.queue.Length()
+	for i := uint64(0); i < size; i++ {
+		value, err := pending.queue.PeekByOffset(0)
+		if err != nil {
+			return err
+		}
+
+		if i < size-1 {
+			err = pending.queue.Enqueue(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = pending.queue.Dequeue()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Size returns the number of Messages in the queue
+func (pending *PendingQueue) Size() uint64 {
+	return pending.queue.Length()
+}
+
+// Close closes the underlying connection to our persisted queue
+func (pending *PendingQueue) Close() {
+	pending.queue.Close()
+}
+
+// PauseProcessing stops handing Messages to our Manager, for when whatever it's working with needs to be taken offline
+// for a while. Everything else carries on as usual: new and remote Messages are still accepted, synchronized and
+// tracked in our state and history (remote ones still go through conflict resolution as they arrive), but those that
+// would have been processed are buffered, in order, until ResumeProcessing is called. The buffer is persisted, and if
+// we're restarted while it holds anything we start back up paused. Pausing while already paused does nothing
+func (accord *Accord) PauseProcessing() {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	if !accord.paused {
+		accord.Logger.Info("Pausing processing")
+	}
+	accord.paused = true
+}
+
+// ResumeProcessing hands every Message buffered while we were paused to our Manager, in the order they arrived, and then
+// goes back to processing Messages as they come in. Nothing else is handled until the buffer has been worked through.
+// If our Manager fails on one of them we shut down just as we would if it had failed in the first place, leaving it
+// (and everything after it) buffered, and the error is returned
+func (accord *Accord) ResumeProcessing() error {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	if !accord.paused {
+		return nil
+	}
+
+	accord.Logger.WithField("pending", accord.pending.Size()).Info("Resuming processing")
+	for {
+		msg, fromRemote, err := accord.pending.Peek()
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not read a buffered message. Blowing up our application")
+			return accord.fail(ShutdownStorage, err)
+		}
+		if msg == nil {
+			break
+		}
+
+		err = accord.manager.Process(*msg, fromRemote)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("The manager had an error while processing a message. The safest thing to do is to blow ourselves up")
+			return accord.fail(ShutdownManager, err)
+		}
+
+		err = accord.pending.Remove()
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Could not remove a buffered message. Blowing up our application")
+			return accord.fail(ShutdownStorage, err)
+		}
+	}
+
+	accord.paused = false
+	return nil
+}
+
+// ProcessingPaused returns whether processing is paused (see PauseProcessing)
+func (accord *Accord) ProcessingPaused() bool {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	return accord.paused
+}
+
+// buffer holds on to a Message that should have been processed while we're paused. processMutex must be held by the
+// caller
+func (accord *Accord) buffer(msg *Message, fromRemote bool) error {
+	accord.Logger.WithField("id", msg.ID).Debug("Processing is paused, buffering message")
+	err := accord.pending.Add(msg, fromRemote)
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Could not buffer a message while paused. Blowing up our application")
+		return accord.fail(ShutdownStorage, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordPauseProcessing(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	accord.PauseProcessing()
+	assert.True(t, accord.ProcessingPaused())
+
+	// Everything is still accepted and tracked, just not processed
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, StateAt: 1}))
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 3}))
+	assert.Nil(t, accord.RelayMessage(&Message{ID: 4}))
+
+	assert.Equal(t, 0, manager.ProcessCount)
+	status := accord.Status()
+	assert.True(t, status.ProcessingPaused)
+	assert.Equal(t, uint64(3), status.PendingProcess)
+	assert.Equal(t, uint64(3), status.ToBeSyncedSize)
+	assert.Equal(t, uint64(10), status.State)
+
+	// Resuming works through the buffer in order, and then we're back to normal
+	err = accord.ResumeProcessing()
+	assert.Nil(t, err)
+	assert.False(t, accord.ProcessingPaused())
+	assert.Equal(t, 3, manager.ProcessCount)
+	assert.Equal(t, uint64(1), manager.Local[1].ID)
+	assert.Equal(t, uint64(3), manager.Local[2].ID)
+	assert.Equal(t, uint64(2), manager.Remote[1].ID)
+	assert.Equal(t, uint64(0), accord.Status().PendingProcess)
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 5}))
+	assert.Equal(t, 4, manager.ProcessCount)
+
+	// Resuming when we aren't paused does nothing
+	assert.Nil(t, accord.ResumeProcessing())
+}
+
+// orderManager records the order in which Messages are processed, failing on the one it's told to
+type orderManager struct {
+	DummyManager
+	processed []uint64
+	failOn    uint64
+}
+
+func (manager *orderManager) Process(msg Message, fromRemote bool) error {
+	if msg.ID == manager.failOn {
+		return errors.New("downstream is still down")
+	}
+	manager.processed = append(manager.processed, msg.ID)
+	return nil
+}
+
+func TestAccordPauseProcessingRestart(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &orderManager{failOn: 2}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	accord.PauseProcessing()
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+
+	// A failure part way through leaves it, and everything after it, buffered
+	err = accord.ResumeProcessing()
+	assert.NotNil(t, err)
+	assert.Equal(t, []uint64{1}, manager.processed)
+	assert.True(t, accord.ProcessingPaused())
+	reason := <-accord.shutdown
+	assert.Equal(t, ShutdownManager, reason.Category)
+	accord.Stop()
+
+	// Coming back up with Messages still buffered leaves us paused, so nothing can jump ahead of them
+	manager.failOn = 0
+	accord = DummyAccordManager(manager)
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+	assert.True(t, accord.ProcessingPaused())
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4}))
+	assert.Nil(t, accord.ResumeProcessing())
+	assert.Equal(t, []uint64{1, 2, 3, 4}, manager.processed)
+}
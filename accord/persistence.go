@@ -0,0 +1,73 @@
+package accord
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PersistenceMode controls how aggressively Accord forces its writes to the sync queue and state out to stable
+// storage. Every write we make lands in LevelDB's journal immediately, meaning that a crash of the *process* never
+// loses data in any mode. What the modes trade off is what happens if the *machine* goes down (power loss, kernel
+// panic, etc...) before the operating system has gotten around to flushing its file cache:
+//
+// PersistAsync leaves flushing entirely up to the operating system. This is the fastest mode but the crash window is
+// whatever your OS's writeback delay is (commonly up to around 30 seconds on Linux).
+//
+// PersistBatched flushes everything on a fixed interval in the background (and once more on shutdown). You trade
+// throughput for a bounded crash window: at most one interval's worth of messages can be lost.
+//
+// PersistSync flushes after every message is handled, before HandleNewMessage or HandleRemoteMessage returns. Nothing
+// that has been acknowledged can be lost, but every message pays for a disk flush.
+//
+// Whatever the mode, HandleNewMessageDurable gives a single Message PersistSync's guarantee.
+type PersistenceMode struct {
+	// sync forces a flush after every handled message
+	sync bool
+
+	// interval is how often we flush in the background. Zero means we don't
+	interval time.Duration
+}
+
+var (
+	// PersistAsync leaves flushing our writes to the operating system
+	PersistAsync = PersistenceMode{}
+
+	// PersistSync flushes our writes after every message we handle
+	PersistSync = PersistenceMode{sync: true}
+)
+
+// PersistBatched flushes our writes in the background on the given interval
+func PersistBatched(interval time.Duration) PersistenceMode {
+	return PersistenceMode{interval: interval}
+}
+
+// fsyncJournal forces the LevelDB journal files in the given directory out to stable storage. goque doesn't give us a
+// way to pass write options down to its LevelDB instance, so rather than relying on LevelDB's sync writes we fsync its
+// journal ourselves (fsync applies to the file itself, not just the descriptor we opened, so this is as good as if
+// LevelDB had done it)
+func fsyncJournal(dir string) error {
+	journals, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return err
+	}
+
+	for _, journal := range journals {
+		file, err := os.Open(journal)
+		if err != nil {
+			// LevelDB may have rotated the journal out from under us, in which case it has already been synced
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		err = file.Sync()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistenceModes(t *testing.T) {
+	modes := []PersistenceMode{PersistAsync, PersistSync, PersistBatched(time.Millisecond)}
+
+	for _, mode := range modes {
+		AccordCleanup()
+
+		accord := DummyAccord()
+		accord.Persistence = mode
+		err := accord.Start()
+		assert.Nil(t, err)
+
+		msg, err := NewMessage([]byte("abc"))
+		assert.Nil(t, err)
+		err = accord.HandleNewMessage(msg)
+		assert.Nil(t, err)
+
+		// Give our background flusher a chance to run
+		time.Sleep(5 * time.Millisecond)
+		accord.Stop()
+
+		// Whatever the mode, our data should be there when we come back
+		accord = DummyAccord()
+		err = accord.Start()
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), accord.Status().ToBeSyncedSize)
+		assert.Equal(t, msg.ID, accord.Status().State)
+		accord.Stop()
+	}
+
+	AccordCleanup()
+}
+
+func TestFsyncJournal(t *testing.T) {
+	defer AccordCleanup()
+
+	queue, err := OpenSyncQueue(SyncFilename)
+	assert.Nil(t, err)
+	defer queue.Close()
+
+	err = queue.Enqueue(&Message{ID: 1, Payload: []byte{1}})
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Flush())
+
+	// A directory that doesn't exist simply has nothing to flush
+	assert.Nil(t, fsyncJournal("does-not-exist"))
+}
+
+// flushCountingQueue and flushCountingState count how many times they're flushed to stable storage
+type flushCountingQueue struct {
+	memoryQueue
+	flushes int
+}
+
+func (queue *flushCountingQueue) Flush() error {
+	queue.flushes++
+	return nil
+}
+
+type flushCountingState struct {
+	memoryState
+	flushes int
+}
+
+func (state *flushCountingState) Flush() error {
+	state.flushes++
+	return nil
+}
+
+func TestAccordHandleNewMessageDurable(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	queue := &flushCountingQueue{}
+	state := &flushCountingState{memoryState: memoryState{values: map[string][]byte{}}}
+
+	accord := DummyAccord()
+	accord.StateBatchSize = 10
+	accord.Backends = Backends{
+		Queue: func(path string) (QueueBackend, error) {
+			if path == SyncFilename {
+				return queue, nil
+			}
+			return &memoryQueue{}, nil
+		},
+		Stack: func(path string) (StackBackend, error) { return &memoryStack{}, nil },
+		State: func(path string) (StateBackend, error) { return state, nil },
+	}
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Under PersistAsync a normal Message is left for the operating system to flush
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Zero(t, queue.flushes)
+	assert.Zero(t, state.flushes)
+	assert.Equal(t, 1, accord.state.Unsaved())
+
+	// While a durable one is flushed before we return, along with any batched state
+	assert.Nil(t, accord.HandleNewMessageDurable(&Message{ID: 2}))
+	assert.Equal(t, 1, queue.flushes)
+	assert.Equal(t, 1, state.flushes)
+	assert.Zero(t, accord.state.Unsaved())
+
+	// A failure to make it durable is returned
+	state.fail = errors.New("disk full")
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 3}))
+	assert.NotNil(t, accord.HandleNewMessageDurable(&Message{ID: 4}))
+	state.fail = nil
+}
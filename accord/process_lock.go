@@ -0,0 +1,145 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// ProcessPriority decides who gets to go first when local and remote Messages are contending to be processed
+type ProcessPriority int
+
+const (
+	// FairInterleave gives neither side precedence, behaving just like a plain mutex. This is the default
+	FairInterleave ProcessPriority = iota
+
+	// LocalPriority lets a waiting local Message (HandleNewMessage, RelayMessage) jump ahead of any waiting remote
+	// ones, so that catching up on a remote backlog doesn't block interactive commands. A steady enough stream of local
+	// Messages will starve remote processing entirely, and our sync queue will grow until it lets up
+	LocalPriority
+
+	// RemotePriority lets waiting remote Messages (HandleRemoteMessage, CheckRemoteState) jump ahead of local ones,
+	// so that we converge with our peers as quickly as possible. A long remote backlog will block local ingestion
+	// until it's been worked through
+	RemotePriority
+)
+
+// processLock is the mutex that keeps us from processing more than one Message at a time, with the added ability to
+// favor local or remote callers according to a ProcessPriority, and to let local callers through strictly in the order
+// they arrived
+type processLock struct {
+	priority ProcessPriority
+
+	// ordered hands local callers a ticket as they arrive and lets them through in ticket order (see OrderedSubmission)
+	ordered bool
+
+	lock *sync.Mutex
+	cond *sync.Cond
+	held bool
+
+	// waitingLocal and waitingRemote are the number of callers blocked on each side. Protected by lock
+	waitingLocal  int
+	waitingRemote int
+
+	// nextTicket is the ticket the next local caller to arrive will be handed, and serving is the ticket that is next
+	// in line to be let through. Only used when ordered is set. Protected by lock
+	nextTicket uint64
+	serving    uint64
+}
+
+func newProcessLock(priority ProcessPriority, ordered bool) *processLock {
+	lock := &sync.Mutex{}
+	return &processLock{
+		priority: priority,
+		ordered:  ordered,
+		lock:     lock,
+		cond:     sync.NewCond(lock),
+	}
+}
+
+// Lock acquires the lock for a caller that is neither local nor remote processing (reporting status, exporting
+// state, etc...). These callers never get precedence, but also never yield to anyone already waiting
+func (process *processLock) Lock() {
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	for process.held {
+		process.cond.Wait()
+	}
+	process.held = true
+}
+
+// LockTimeout is Lock, giving up and returning false if the lock can't be had within timeout. A timeout of zero (or
+// less) waits as long as it takes
+func (process *processLock) LockTimeout(timeout time.Duration) bool {
+	if timeout <= 0 {
+		process.Lock()
+		return true
+	}
+
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		process.lock.Lock()
+		expired = true
+		process.lock.Unlock()
+		process.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for process.held && !expired {
+		process.cond.Wait()
+	}
+	if process.held {
+		return false
+	}
+	process.held = true
+	return true
+}
+
+// LockLocal acquires the lock to process a local Message. When ordered, local callers are let through in the order
+// they called LockLocal
+func (process *processLock) LockLocal() {
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	ticket := process.nextTicket
+	if process.ordered {
+		process.nextTicket++
+	}
+
+	process.waitingLocal++
+	for process.held || (process.priority == RemotePriority && process.waitingRemote > 0) ||
+		(process.ordered && ticket != process.serving) {
+		process.cond.Wait()
+	}
+	process.waitingLocal--
+	process.held = true
+
+	if process.ordered {
+		process.serving++
+	}
+}
+
+// LockRemote acquires the lock to process a remote Message
+func (process *processLock) LockRemote() {
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	process.waitingRemote++
+	for process.held || (process.priority == LocalPriority && process.waitingLocal > 0) {
+		process.cond.Wait()
+	}
+	process.waitingRemote--
+	process.held = true
+}
+
+// Unlock releases the lock, no matter how it was acquired
+func (process *processLock) Unlock() {
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	process.held = false
+	process.cond.Broadcast()
+}
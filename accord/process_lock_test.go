@@ -0,0 +1,142 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contend holds the lock while a remote and then a local caller line up behind it, releases it, and returns the order
+// in which the two were let through
+func contend(t *testing.T, priority ProcessPriority) []string {
+	process := newProcessLock(priority, false)
+	order := make(chan string, 2)
+
+	waiting := func(local, remote int) {
+		for i := 0; i < 100; i++ {
+			process.lock.Lock()
+			ready := process.waitingLocal == local && process.waitingRemote == remote
+			process.lock.Unlock()
+			if ready {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("Callers never started waiting on the lock")
+	}
+
+	process.Lock()
+
+	go func() {
+		process.LockRemote()
+		order <- "remote"
+		process.Unlock()
+	}()
+	waiting(0, 1)
+
+	go func() {
+		process.LockLocal()
+		order <- "local"
+		process.Unlock()
+	}()
+	waiting(1, 1)
+
+	process.Unlock()
+
+	return []string{<-order, <-order}
+}
+
+func TestProcessLockFairInterleave(t *testing.T) {
+	order := contend(t, FairInterleave)
+	// Either may go first, but both need to get through
+	assert.NotEqual(t, order[0], order[1])
+}
+
+func TestProcessLockLocalPriority(t *testing.T) {
+	order := contend(t, LocalPriority)
+	assert.Equal(t, []string{"local", "remote"}, order)
+}
+
+func TestProcessLockRemotePriority(t *testing.T) {
+	order := contend(t, RemotePriority)
+	assert.Equal(t, []string{"remote", "local"}, order)
+}
+
+func TestProcessLockExclusive(t *testing.T) {
+	process := newProcessLock(LocalPriority, false)
+	process.LockRemote()
+
+	acquired := make(chan struct{})
+	go func() {
+		process.LockLocal()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Priority should never let a caller in while the lock is held")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	process.Unlock()
+	<-acquired
+	process.Unlock()
+}
+
+func TestProcessLockOrdered(t *testing.T) {
+	process := newProcessLock(FairInterleave, true)
+	order := make(chan int, 5)
+
+	process.Lock()
+
+	// Line each caller up behind the last, so that we know the order they arrived in
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			process.LockLocal()
+			order <- i
+			process.Unlock()
+		}(i)
+
+		for j := 0; ; j++ {
+			process.lock.Lock()
+			ready := process.waitingLocal == i+1
+			process.lock.Unlock()
+			if ready {
+				break
+			}
+			if j == 100 {
+				t.Fatal("Callers never started waiting on the lock")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	process.Unlock()
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, i, <-order)
+	}
+}
+
+func TestProcessLockTimeout(t *testing.T) {
+	process := newProcessLock(FairInterleave, false)
+	process.LockLocal()
+
+	// We give up once our timeout is up, without taking the lock
+	started := time.Now()
+	assert.False(t, process.LockTimeout(20*time.Millisecond))
+	assert.True(t, time.Since(started) >= 20*time.Millisecond)
+
+	// But get it as soon as it's released
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		process.Unlock()
+	}()
+	assert.True(t, process.LockTimeout(time.Second))
+	process.Unlock()
+
+	// Without a timeout we wait as long as it takes
+	assert.True(t, process.LockTimeout(0))
+	process.Unlock()
+}
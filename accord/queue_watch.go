@@ -0,0 +1,95 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// queueWatch turns our sync queue's transitions between empty and non-empty (see SyncQueue.WatchEmpty) into calls to
+// OnQueueEmpty and OnQueueNonEmpty. With a debounce, a transition is only reported once the queue has stayed put for
+// that long, so a Message that's enqueued and synced straight away doesn't flap an autoscaler. Reports always alternate
+type queueWatch struct {
+	lock *sync.Mutex
+
+	// calling is held while a debounced report is being made, so that reports made from our timers are still made one
+	// at a time and in order, without holding lock while our callbacks run
+	calling *sync.Mutex
+
+	debounce   time.Duration
+	onEmpty    func()
+	onNonEmpty func()
+
+	// current is whether the queue is empty right now, and reported whether it was when we last called anybody
+	current  bool
+	reported bool
+
+	// timer is the pending report, if we're waiting out our debounce
+	timer *time.Timer
+
+	// stopped is set once we've stopped, after which nothing more is reported
+	stopped bool
+}
+
+func newQueueWatch(debounce time.Duration, onNonEmpty, onEmpty func()) *queueWatch {
+	return &queueWatch{
+		lock:       &sync.Mutex{},
+		calling:    &sync.Mutex{},
+		debounce:   debounce,
+		onEmpty:    onEmpty,
+		onNonEmpty: onNonEmpty,
+		current:    true,
+		reported:   true,
+	}
+}
+
+// transition is our SyncQueue.WatchEmpty callback
+func (watch *queueWatch) transition(empty bool) {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+
+	watch.current = empty
+	if watch.debounce <= 0 {
+		watch.report()()
+		return
+	}
+
+	if watch.timer != nil {
+		watch.timer.Stop()
+	}
+	watch.timer = time.AfterFunc(watch.debounce, func() {
+		watch.calling.Lock()
+		defer watch.calling.Unlock()
+
+		watch.lock.Lock()
+		call := watch.report()
+		watch.lock.Unlock()
+		call()
+	})
+}
+
+// report works out which callback matches where the queue now is, unless that's where it was when we last reported,
+// returning it to be called (or a no-op if there's nothing to report). lock must be held by the caller
+func (watch *queueWatch) report() func() {
+	if watch.stopped || watch.current == watch.reported {
+		return func() {}
+	}
+
+	watch.reported = watch.current
+	if watch.reported && watch.onEmpty != nil {
+		return watch.onEmpty
+	} else if !watch.reported && watch.onNonEmpty != nil {
+		return watch.onNonEmpty
+	}
+	return func() {}
+}
+
+// stop drops any report we're waiting to make, and makes sure we never make another
+func (watch *queueWatch) stop() {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+
+	watch.stopped = true
+	if watch.timer != nil {
+		watch.timer.Stop()
+	}
+}
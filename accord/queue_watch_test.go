@@ -0,0 +1,109 @@
+package accord
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// transitionRecorder keeps track of the queue transitions reported to it
+type transitionRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (recorder *transitionRecorder) nonEmpty() {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.events = append(recorder.events, "non-empty")
+}
+
+func (recorder *transitionRecorder) empty() {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.events = append(recorder.events, "empty")
+}
+
+func (recorder *transitionRecorder) seen() []string {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return append([]string{}, recorder.events...)
+}
+
+func TestAccordQueueTransitions(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	recorder := &transitionRecorder{}
+	accord := DummyAccord()
+	accord.OnQueueNonEmpty = recorder.nonEmpty
+	accord.OnQueueEmpty = recorder.empty
+	assert.Nil(t, accord.Start())
+
+	// Starting empty is nothing to report
+	assert.Empty(t, recorder.seen())
+
+	// Only the first Message onto an empty queue is an edge
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 2}))
+	assert.Equal(t, []string{"non-empty"}, recorder.seen())
+
+	// As is only the last one off of it
+	_, err := accord.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"non-empty"}, recorder.seen())
+	_, err = accord.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"non-empty", "empty"}, recorder.seen())
+
+	// Draining through a target counts too
+	assert.Nil(t, accord.ToBeSynced.RegisterTarget("primary"))
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 3}))
+	assert.Nil(t, accord.ToBeSynced.ConfirmTarget("primary"))
+	assert.Equal(t, []string{"non-empty", "empty", "non-empty", "empty"}, recorder.seen())
+
+	// Starting with a backlog is reported straight away
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4}))
+	accord.Stop()
+
+	recorder = &transitionRecorder{}
+	accord = DummyAccord()
+	accord.OnQueueNonEmpty = recorder.nonEmpty
+	accord.OnQueueEmpty = recorder.empty
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, []string{"non-empty"}, recorder.seen())
+}
+
+func TestAccordQueueTransitionsDebounced(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	recorder := &transitionRecorder{}
+	accord := DummyAccord()
+	accord.OnQueueNonEmpty = recorder.nonEmpty
+	accord.OnQueueEmpty = recorder.empty
+	accord.QueueTransitionDebounce = 30 * time.Millisecond
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// A Message that's gone again before our debounce is up doesn't flap
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	_, err := accord.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	time.Sleep(60 * time.Millisecond)
+	assert.Empty(t, recorder.seen())
+
+	// But one that sticks around is reported once it has
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 2}))
+	assert.Empty(t, recorder.seen())
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, []string{"non-empty"}, recorder.seen())
+
+	_, err = accord.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, []string{"non-empty", "empty"}, recorder.seen())
+}
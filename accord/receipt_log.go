@@ -0,0 +1,110 @@
+package accord
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeliveryReceipt is proof that a Message was delivered to, and acknowledged by, a remote peer
+type DeliveryReceipt struct {
+	// MessageID is the ID of the Message that was delivered
+	MessageID uint64
+
+	// Peer identifies who acknowledged the Message. This is the sync target (or component name) of whatever delivered it
+	Peer string
+
+	// AckedAt is when we received the peer's acknowledgement
+	AckedAt time.Time
+}
+
+// ReceiptLog is a persisted, append only record of every Message our peers have acknowledged, so that delivery can be
+// proven after the fact. Like ConflictLog it's a thin wrapper around a QueueBackend
+type ReceiptLog struct {
+	queue QueueBackend
+}
+
+// OpenReceiptLog opens or creates a ReceiptLog stored at the passed in path using our default goque backend
+func OpenReceiptLog(path string) (*ReceiptLog, error) {
+	queue, err := OpenGoqueQueue(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReceiptLog(queue), nil
+}
+
+// NewReceiptLog creates a ReceiptLog on top of an already opened QueueBackend
+func NewReceiptLog(queue QueueBackend) *ReceiptLog {
+	return &ReceiptLog{queue: queue}
+}
+
+// Record appends a new DeliveryReceipt to the log
+func (log *ReceiptLog) Record(receipt DeliveryReceipt) error {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	return log.queue.Enqueue(data)
+}
+
+// Entries returns up to limit receipts starting at offset, oldest first. A limit of 0 returns everything after offset
+func (log *ReceiptLog) Entries(offset, limit uint64) ([]DeliveryReceipt, error) {
+	receipts := []DeliveryReceipt{}
+
+	err := log.scan(offset, func(receipt DeliveryReceipt) bool {
+		receipts = append(receipts, receipt)
+		return limit == 0 || uint64(len(receipts)) < limit
+	})
+
+	return receipts, err
+}
+
+// Find returns every receipt for the given Message, oldest first. There's no index, so this reads the entire log
+func (log *ReceiptLog) Find(messageID uint64) ([]DeliveryReceipt, error) {
+	receipts := []DeliveryReceipt{}
+
+	err := log.scan(0, func(receipt DeliveryReceipt) bool {
+		if receipt.MessageID == messageID {
+			receipts = append(receipts, receipt)
+		}
+		return true
+	})
+
+	return receipts, err
+}
+
+// scan passes every receipt from offset onwards to fn, until it runs out or fn returns false
+func (log *ReceiptLog) scan(offset uint64, fn func(DeliveryReceipt) bool) error {
+	for i := offset; i < log.queue.Length(); i++ {
+		value, err := log.queue.PeekByOffset(i)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			break
+		}
+
+		receipt := DeliveryReceipt{}
+		err = json.Unmarshal(value, &receipt)
+		if err != nil {
+			return err
+		}
+
+		if !fn(receipt) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Size returns the number of receipts in the log
+func (log *ReceiptLog) Size() uint64 {
+	return log.queue.Length()
+}
+
+// Close closes the underlying connection to our persisted log
+func (log *ReceiptLog) Close() {
+	log.queue.Close()
+}
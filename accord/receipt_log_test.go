@@ -0,0 +1,72 @@
+package accord
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptLog(t *testing.T) {
+	os.RemoveAll("receipt-test")
+	defer os.RemoveAll("receipt-test")
+
+	log, err := OpenReceiptLog("receipt-test")
+	assert.Nil(t, err)
+
+	for _, receipt := range []DeliveryReceipt{{MessageID: 1, Peer: "primary"}, {MessageID: 2, Peer: "primary"}, {MessageID: 1, Peer: "archive"}} {
+		err = log.Record(receipt)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, uint64(3), log.Size())
+
+	receipts, err := log.Find(1)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 2)
+	assert.Equal(t, "primary", receipts[0].Peer)
+	assert.Equal(t, "archive", receipts[1].Peer)
+
+	receipts, err = log.Find(3)
+	assert.Nil(t, err)
+	assert.Empty(t, receipts)
+
+	receipts, err = log.Entries(1, 1)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, uint64(2), receipts[0].MessageID)
+	log.Close()
+
+	// Our log should survive a reopen
+	log, err = OpenReceiptLog("receipt-test")
+	assert.Nil(t, err)
+	defer log.Close()
+	assert.Equal(t, uint64(3), log.Size())
+}
+
+func TestAccordReceipts(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	// Without receipts enabled there's nothing to record
+	assert.Nil(t, accord.RecordDelivery(1, "peer"))
+	receipts, err := accord.Receipts(1)
+	assert.Nil(t, err)
+	assert.Empty(t, receipts)
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.DeliveryReceipts = true
+	err = accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Nil(t, accord.RecordDelivery(1, "peer"))
+	receipts, err = accord.Receipts(1)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, "peer", receipts[0].Peer)
+	assert.False(t, receipts[0].AckedAt.IsZero())
+}
@@ -0,0 +1,157 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// TickScheduler shares out processing between the components that opt into it (see ComponentRunner.Schedule), so that
+// a node syncing with several peers gives each a fair, or deliberately weighted, share rather than letting whichever
+// goroutine the Go scheduler happens to favor dominate. Only a limited number of scheduled ticks run at once, and
+// whenever components are waiting on a free slot it goes to whichever has used the least of its share so far.
+//
+// Shares are measured in time spent ticking, divided by weight, so a component with twice the weight of another gets
+// roughly twice as much time to work with when both are busy. Charging by time rather than by tick keeps a peer whose
+// ticks are slow (a sluggish network, say) from crowding out a fast one. A component that sits idle doesn't bank its
+// unused share; it rejoins on equal footing with whoever has been busy
+type TickScheduler struct {
+	lock  *sync.Mutex
+	ready *sync.Cond
+
+	// slots is how many scheduled ticks may run at once, and running is how many are
+	slots   int
+	running int
+
+	// clock is the lowest usage any component has been granted a slot at, which idle components are brought up to
+	clock float64
+
+	members map[*scheduledMember]struct{}
+}
+
+// scheduledMember is a single component's place in a TickScheduler. Its fields are protected by the scheduler's lock
+type scheduledMember struct {
+	name    string
+	weight  int
+	usage   float64
+	waiting bool
+	ticks   uint64
+	busy    time.Duration
+}
+
+// SchedulerShare describes how much a single scheduled component has been given
+type SchedulerShare struct {
+	// Weight is the component's weight, relative to the other scheduled components
+	Weight int
+
+	// Ticks is how many times it has ticked, and Busy how long it has spent doing so, since it was started
+	Ticks uint64
+	Busy  time.Duration
+}
+
+// NewTickScheduler creates a TickScheduler letting up to slots scheduled ticks run at once. Anything less than one is
+// treated as one
+func NewTickScheduler(slots int) *TickScheduler {
+	if slots < 1 {
+		slots = 1
+	}
+
+	lock := &sync.Mutex{}
+	return &TickScheduler{
+		lock:    lock,
+		ready:   sync.NewCond(lock),
+		slots:   slots,
+		members: map[*scheduledMember]struct{}{},
+	}
+}
+
+// join adds a component with the given weight to the scheduler
+func (scheduler *TickScheduler) join(name string, weight int) *scheduledMember {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	member := &scheduledMember{name: name, weight: weight, usage: scheduler.clock}
+	scheduler.members[member] = struct{}{}
+	return member
+}
+
+// leave removes a component from the scheduler, for good
+func (scheduler *TickScheduler) leave(member *scheduledMember) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	delete(scheduler.members, member)
+	scheduler.ready.Broadcast()
+}
+
+// acquire waits until the member may tick: there's a free slot and no other waiting member has used less of its share
+func (scheduler *TickScheduler) acquire(member *scheduledMember) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	if member.usage < scheduler.clock {
+		member.usage = scheduler.clock
+	}
+
+	member.waiting = true
+	for scheduler.running >= scheduler.slots || !scheduler.next(member) {
+		scheduler.ready.Wait()
+	}
+	member.waiting = false
+
+	scheduler.running++
+	scheduler.clock = member.usage
+}
+
+// next checks whether the member is first in line among everybody waiting. Ties go to the heavier weight, and then to
+// whichever name sorts first
+func (scheduler *TickScheduler) next(member *scheduledMember) bool {
+	for other := range scheduler.members {
+		if other == member || !other.waiting {
+			continue
+		}
+		if other.usage < member.usage {
+			return false
+		}
+		if other.usage == member.usage && other.weight > member.weight {
+			return false
+		}
+		if other.usage == member.usage && other.weight == member.weight && other.name < member.name {
+			return false
+		}
+	}
+	return true
+}
+
+// release gives up the member's slot, charging it for the time it spent ticking
+func (scheduler *TickScheduler) release(member *scheduledMember, elapsed time.Duration) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	// Even an instant tick costs something, so that a component can't get ahead by doing nothing very quickly
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+
+	member.usage += float64(elapsed) / float64(member.weight)
+	member.ticks++
+	member.busy += elapsed
+
+	scheduler.running--
+	scheduler.ready.Broadcast()
+}
+
+// Shares reports on every scheduled component, by name
+func (scheduler *TickScheduler) Shares() map[string]SchedulerShare {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	shares := map[string]SchedulerShare{}
+	for member := range scheduler.members {
+		shares[member.name] = SchedulerShare{
+			Weight: member.weight,
+			Ticks:  member.ticks,
+			Busy:   member.busy,
+		}
+	}
+	return shares
+}
@@ -0,0 +1,118 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runScheduled runs a busy component for each of the passed in tick lengths and weights, all sharing a single scheduler
+// slot, and returns how they got on
+func runScheduled(t *testing.T, ticks []time.Duration, weights []int) []SchedulerShare {
+	accord := DummyAccord()
+	accord.scheduler = NewTickScheduler(1)
+
+	names := []string{}
+	runners := []*ComponentRunner{}
+	for i := range ticks {
+		length := ticks[i]
+		name := string(rune('a' + i))
+		names = append(names, name)
+
+		runner := &ComponentRunner{}
+		runner.Schedule(weights[i])
+		runner.Init(accord, func(*Accord) { time.Sleep(length) }, nil, accord.Logger.WithField("component", name))
+		runners = append(runners, runner)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	shares := accord.scheduler.Shares()
+
+	for _, runner := range runners {
+		runner.Stop(0)
+	}
+	for _, runner := range runners {
+		runner.WaitForStop()
+	}
+	assert.Empty(t, accord.scheduler.Shares())
+
+	results := []SchedulerShare{}
+	for _, name := range names {
+		results = append(results, shares[name])
+	}
+	return results
+}
+
+func TestTickSchedulerWeighted(t *testing.T) {
+	shares := runScheduled(t, []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, []int{1, 1, 2})
+
+	for _, share := range shares {
+		assert.True(t, share.Ticks > 0)
+	}
+
+	// Equal weights get roughly equal time, and double the weight roughly double
+	ratio := float64(shares[0].Busy) / float64(shares[1].Busy)
+	assert.True(t, ratio > 0.7 && ratio < 1.4, "equal weights got %v and %v", shares[0].Busy, shares[1].Busy)
+	ratio = float64(shares[2].Busy) / float64(shares[0].Busy+shares[1].Busy) * 2
+	assert.True(t, ratio > 1.5 && ratio < 2.6, "double weight got %v against %v and %v", shares[2].Busy, shares[0].Busy, shares[1].Busy)
+}
+
+func TestTickSchedulerSlowPeer(t *testing.T) {
+	shares := runScheduled(t, []time.Duration{5 * time.Millisecond, time.Millisecond}, []int{1, 1})
+
+	// A slow component's ticks cost it more of its share, so the fast one gets to tick that much more often rather
+	// than being held to one for one
+	assert.True(t, shares[1].Ticks > 3*shares[0].Ticks, "fast ticked %d times, slow %d", shares[1].Ticks, shares[0].Ticks)
+}
+
+func TestTickSchedulerIdle(t *testing.T) {
+	scheduler := NewTickScheduler(1)
+	busy := scheduler.join("busy", 1)
+	idle := scheduler.join("idle", 1)
+
+	for i := 0; i < 10; i++ {
+		scheduler.acquire(busy)
+		scheduler.release(busy, time.Millisecond)
+	}
+
+	// Having sat out, our idle member is brought up to date rather than getting ten turns in a row
+	scheduler.acquire(idle)
+	assert.Equal(t, scheduler.clock, idle.usage)
+	assert.True(t, idle.usage > 0)
+	scheduler.release(idle, time.Millisecond)
+
+	shares := scheduler.Shares()
+	assert.Equal(t, uint64(10), shares["busy"].Ticks)
+	assert.Equal(t, uint64(1), shares["idle"].Ticks)
+	assert.Equal(t, time.Millisecond, shares["idle"].Busy)
+}
+
+// scheduledComponent is a busy component that opts into our scheduler
+type scheduledComponent struct {
+	ComponentRunner
+}
+
+func (comp *scheduledComponent) Start(accord *Accord) error {
+	comp.ComponentRunner.Init(accord, func(*Accord) { time.Sleep(time.Millisecond) }, nil, accord.Logger.WithField("component", "peer"))
+	return nil
+}
+
+func TestAccordSchedulerStatus(t *testing.T) {
+	defer AccordCleanup()
+
+	comp := &scheduledComponent{}
+	comp.Schedule(3)
+
+	accord := DummyAccordComponents(comp)
+	accord.SchedulerSlots = 1
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	time.Sleep(10 * time.Millisecond)
+	share, ok := accord.Status().Scheduling["peer"]
+	assert.True(t, ok)
+	assert.Equal(t, 3, share.Weight)
+	assert.True(t, share.Ticks > 0)
+}
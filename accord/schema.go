@@ -0,0 +1,210 @@
+package accord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnknownType is returned for a Message whose Type has no Schema registered, when our SchemaRegistry rejects them
+// (see UnknownReject), or when asked to decode or create one
+var ErrUnknownType = errors.New("message type has no registered schema")
+
+// ErrSchemaRegistered is returned when registering a Schema under a type name that already has one
+var ErrSchemaRegistered = errors.New("a schema is already registered for this message type")
+
+// SchemaError is returned for a Message whose payload doesn't satisfy the Schema registered for its Type
+type SchemaError struct {
+	// Type is the Message's Type, and MessageID its ID
+	Type      string
+	MessageID uint64
+
+	// Err is what the Schema's Decode or Validate returned
+	Err error
+}
+
+// Error implements error
+func (err *SchemaError) Error() string {
+	return fmt.Sprintf("message %d is not a valid %q: %v", err.MessageID, err.Type, err.Err)
+}
+
+// Unwrap returns the underlying error
+func (err *SchemaError) Unwrap() error {
+	return err.Err
+}
+
+// Schema describes one type of Message payload, so that every handler doesn't have to parse (and sanity check) opaque
+// bytes for itself
+type Schema struct {
+	// Decode parses a payload into a value of the type, returning an error if it isn't one. It's required
+	Decode func(payload []byte) (interface{}, error)
+
+	// Encode turns a value of the type into a payload, for SchemaRegistry.NewMessage. Leaving it nil means Messages of
+	// the type can't be created through the registry
+	Encode func(value interface{}) ([]byte, error)
+
+	// Validate optionally checks a decoded value further (that fields are set, or within range, say), returning an
+	// error if it isn't acceptable
+	Validate func(value interface{}) error
+}
+
+// JSONSchema is a Schema for payloads that are the JSON encoding of prototype's type. Decode returns a pointer to a new
+// value of that type (a *Order for an Order prototype, or a pointer to one), and validate, if given, is handed the same
+func JSONSchema(prototype interface{}, validate func(value interface{}) error) Schema {
+	typ := reflect.TypeOf(prototype)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return Schema{
+		Decode: func(payload []byte) (interface{}, error) {
+			value := reflect.New(typ)
+			err := json.Unmarshal(payload, value.Interface())
+			if err != nil {
+				return nil, err
+			}
+			return value.Interface(), nil
+		},
+		Encode: func(value interface{}) ([]byte, error) {
+			return json.Marshal(value)
+		},
+		Validate: validate,
+	}
+}
+
+// UnknownTypePolicy decides what a SchemaRegistry makes of Messages whose Type has no Schema registered
+type UnknownTypePolicy int
+
+const (
+	// UnknownPassThrough lets them through unchecked, leaving the payload opaque as it always has been. This is the
+	// default
+	UnknownPassThrough UnknownTypePolicy = iota
+
+	// UnknownReject turns them away with ErrUnknownType. This includes Messages without a Type at all, unless a Schema
+	// is registered under the empty name
+	UnknownReject
+)
+
+// SchemaRegistry maps Message Types to the Schemas their payloads must satisfy. Given to Accord (see Accord.Schemas)
+// every Message we take in, whether new or from a remote, is checked against it before being handled, and a Manager
+// holding on to the same registry can use Decode in place of parsing payloads itself. Every node in a cluster should
+// register the same Schemas, or Messages one accepts will be rejected by another. It's safe for concurrent use
+type SchemaRegistry struct {
+	lock    *sync.RWMutex
+	unknown UnknownTypePolicy
+	schemas map[string]Schema
+}
+
+// NewSchemaRegistry creates an empty SchemaRegistry, treating Messages of types it doesn't know as unknown says
+func NewSchemaRegistry(unknown UnknownTypePolicy) *SchemaRegistry {
+	return &SchemaRegistry{
+		lock:    &sync.RWMutex{},
+		unknown: unknown,
+		schemas: map[string]Schema{},
+	}
+}
+
+// Register adds a Schema for Messages of the given Type, returning ErrSchemaRegistered if there already is one.
+// Registering the empty name gives untyped Messages a Schema
+func (registry *SchemaRegistry) Register(name string, schema Schema) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.schemas[name]; ok {
+		return ErrSchemaRegistered
+	}
+	registry.schemas[name] = schema
+	return nil
+}
+
+// Lookup returns the Schema registered for the given Type, if there is one
+func (registry *SchemaRegistry) Lookup(name string) (Schema, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	schema, ok := registry.schemas[name]
+	return schema, ok
+}
+
+// Validate checks a Message's payload against the Schema registered for its Type, returning a *SchemaError if it
+// doesn't satisfy it. A Message whose Type isn't registered is let through, or turned away with ErrUnknownType, as our
+// UnknownTypePolicy says
+func (registry *SchemaRegistry) Validate(msg Message) error {
+	schema, ok := registry.Lookup(msg.Type)
+	if !ok {
+		if registry.unknown == UnknownReject {
+			return ErrUnknownType
+		}
+		return nil
+	}
+
+	_, err := registry.decode(schema, msg)
+	return err
+}
+
+// Decode parses a Message's payload through the Schema registered for its Type, returning the decoded value (the type
+// of which is up to the Schema), ErrUnknownType if its Type isn't registered, or a *SchemaError if the payload doesn't
+// satisfy its Schema
+func (registry *SchemaRegistry) Decode(msg Message) (interface{}, error) {
+	schema, ok := registry.Lookup(msg.Type)
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	return registry.decode(schema, msg)
+}
+
+// decode is the shared implementation of Validate and Decode
+func (registry *SchemaRegistry) decode(schema Schema, msg Message) (interface{}, error) {
+	value, err := schema.Decode(msg.Payload)
+	if err != nil {
+		return nil, &SchemaError{Type: msg.Type, MessageID: msg.ID, Err: err}
+	}
+
+	if schema.Validate != nil {
+		err = schema.Validate(value)
+		if err != nil {
+			return nil, &SchemaError{Type: msg.Type, MessageID: msg.ID, Err: err}
+		}
+	}
+	return value, nil
+}
+
+// NewMessage encodes value through the Schema registered for the given Type and wraps it in a new Message of that
+// Type (see NewMessage), returning ErrUnknownType if the Type isn't registered or can't be encoded, or a *SchemaError
+// if the value doesn't satisfy its Schema. Its ID is derived with NewMessage's defaults, so an application whose Accord
+// sets its own IDStrategy, IDHash or TimestampPrecision should wrap the payload with Accord.NewMessage instead
+func (registry *SchemaRegistry) NewMessage(name string, value interface{}) (*Message, error) {
+	schema, ok := registry.Lookup(name)
+	if !ok || schema.Encode == nil {
+		return nil, ErrUnknownType
+	}
+
+	payload, err := schema.Encode(value)
+	if err != nil {
+		return nil, &SchemaError{Type: name, Err: err}
+	}
+
+	msg, err := NewMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Type = name
+
+	// Check what we've encoded just as it'll be checked when it's handled, so that a bad value is caught here
+	_, err = registry.decode(schema, *msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// CheckSchema checks a Message against our Schemas, just as it will be when it's handled, so that callers (such as
+// WebReceiver's dry runs) can find out whether it would be turned away. It always returns nil without Schemas
+func (accord *Accord) CheckSchema(msg *Message) error {
+	if accord.Schemas == nil {
+		return nil
+	}
+	return accord.Schemas.Validate(*msg)
+}
@@ -0,0 +1,113 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// order is the payload of our "order" test schema
+type order struct {
+	Item     string
+	Quantity int
+}
+
+// validateOrder only accepts orders for something
+func validateOrder(value interface{}) error {
+	if value.(*order).Quantity <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	return nil
+}
+
+func orderRegistry(t *testing.T, unknown UnknownTypePolicy) *SchemaRegistry {
+	registry := NewSchemaRegistry(unknown)
+	assert.Nil(t, registry.Register("order", JSONSchema(order{}, validateOrder)))
+	return registry
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := orderRegistry(t, UnknownPassThrough)
+	assert.Equal(t, ErrSchemaRegistered, registry.Register("order", JSONSchema(order{}, nil)))
+
+	// Typed Messages are created from values, and decode back to them
+	msg, err := registry.NewMessage("order", order{Item: "widget", Quantity: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, "order", msg.Type)
+	assert.Equal(t, `{"Item":"widget","Quantity":3}`, string(msg.Payload))
+	assert.Nil(t, registry.Validate(*msg))
+
+	value, err := registry.Decode(*msg)
+	assert.Nil(t, err)
+	assert.Equal(t, &order{Item: "widget", Quantity: 3}, value)
+
+	// Payloads that can't be decoded, or that don't pass validation, are turned away
+	for _, payload := range []string{`not json`, `{"Item":"widget","Quantity":0}`} {
+		bad := Message{ID: 7, Type: "order", Payload: []byte(payload)}
+		err = registry.Validate(bad)
+		schemaErr, ok := err.(*SchemaError)
+		assert.True(t, ok, payload)
+		if ok {
+			assert.Equal(t, "order", schemaErr.Type)
+			assert.Equal(t, uint64(7), schemaErr.MessageID)
+		}
+		_, err = registry.Decode(bad)
+		assert.IsType(t, &SchemaError{}, err, payload)
+	}
+
+	_, err = registry.NewMessage("order", order{Item: "widget"})
+	assert.IsType(t, &SchemaError{}, err)
+	_, err = registry.NewMessage("refund", order{})
+	assert.Equal(t, ErrUnknownType, err)
+
+	// Types we don't know are let through, but can't be decoded
+	unknown := Message{Type: "refund", Payload: []byte("anything")}
+	assert.Nil(t, registry.Validate(unknown))
+	assert.Nil(t, registry.Validate(Message{Payload: []byte("anything")}))
+	_, err = registry.Decode(unknown)
+	assert.Equal(t, ErrUnknownType, err)
+}
+
+func TestSchemaRegistryRejectUnknown(t *testing.T) {
+	registry := orderRegistry(t, UnknownReject)
+
+	assert.Equal(t, ErrUnknownType, registry.Validate(Message{Type: "refund", Payload: []byte("anything")}))
+	assert.Equal(t, ErrUnknownType, registry.Validate(Message{Payload: []byte("anything")}))
+
+	// Unless untyped Messages are given a schema of their own
+	assert.Nil(t, registry.Register("", Schema{Decode: func(payload []byte) (interface{}, error) { return payload, nil }}))
+	assert.Nil(t, registry.Validate(Message{Payload: []byte("anything")}))
+}
+
+func TestAccordSchemas(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := NewDummerManager()
+	accord := DummyAccordManager(manager)
+	accord.Schemas = orderRegistry(t, UnknownReject)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	good, err := accord.Schemas.NewMessage("order", order{Item: "widget", Quantity: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, accord.HandleNewMessage(good))
+
+	// New Messages that don't satisfy their schema never get anywhere
+	bad := &Message{ID: 2, Type: "order", Payload: []byte(`{"Quantity":-1}`)}
+	assert.Nil(t, accord.CheckSchema(good))
+	assert.IsType(t, &SchemaError{}, accord.CheckSchema(bad))
+	assert.IsType(t, &SchemaError{}, accord.HandleNewMessage(bad))
+	assert.IsType(t, &SchemaError{}, accord.RelayMessage(bad))
+	assert.Equal(t, ErrUnknownType, accord.HandleNewMessage(&Message{ID: 3, Payload: []byte("untyped")}))
+
+	// And neither do remote ones
+	assert.IsType(t, &SchemaError{}, accord.HandleRemoteMessage(&Message{ID: 4, StateAt: accord.state.GetCurrent(), Type: "order", Payload: []byte(`[]`)}))
+
+	assert.Equal(t, 1, manager.ProcessCount)
+	assert.Equal(t, uint64(1), accord.ToBeSynced.Size())
+	assert.Equal(t, uint64(1), accord.history.Size())
+	assert.Equal(t, good.ID, accord.state.GetCurrent())
+}
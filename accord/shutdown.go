@@ -0,0 +1,91 @@
+package accord
+
+import "errors"
+
+// ShutdownCategory classifies why Accord shut down, so that the program embedding it can decide what to do next (map it
+// to an exit code that tells an orchestrator whether a restart is worthwhile, for instance)
+type ShutdownCategory int
+
+const (
+	// ShutdownUnknown is used when whoever shut us down didn't say why
+	ShutdownUnknown ShutdownCategory = iota
+
+	// ShutdownSignal means we were asked to stop by the operating system
+	ShutdownSignal
+
+	// ShutdownNetwork means a component hit a network fault it couldn't recover from. These are often transient
+	ShutdownNetwork
+
+	// ShutdownManager means our Manager returned an error while processing a Message
+	ShutdownManager
+
+	// ShutdownStorage means we couldn't read or write our persisted data, which may mean it's been corrupted
+	ShutdownStorage
+
+	// ShutdownRemote means a remote told us it had hit an unrecoverable error, leaving the two of us unable to stay
+	// aligned
+	ShutdownRemote
+
+	// ShutdownIdle means we shut ourselves down after sitting idle for IdleShutdownTimeout. Nothing went wrong
+	ShutdownIdle
+
+	// ShutdownDiskFull means we shut ourselves down because our stores went over MaxDiskBytes (see DiskShutdown)
+	ShutdownDiskFull
+)
+
+// String returns a short, human readable, name for the category
+func (category ShutdownCategory) String() string {
+	switch category {
+	case ShutdownSignal:
+		return "signal"
+	case ShutdownNetwork:
+		return "network"
+	case ShutdownManager:
+		return "manager"
+	case ShutdownStorage:
+		return "storage"
+	case ShutdownRemote:
+		return "remote"
+	case ShutdownIdle:
+		return "idle"
+	case ShutdownDiskFull:
+		return "disk"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrShuttingDown is matched (with errors.Is) by any error we return because it has shut us down, as opposed to one
+// that only rejects whatever we were asked to do
+var ErrShuttingDown = errors.New("accord is shutting down")
+
+// ShutdownReason describes why Accord shut down. It's the error Listen returns when we shut down through Shutdown, and
+// reads just like the underlying error, so existing error handling keeps working
+type ShutdownReason struct {
+	// Category is the kind of failure that triggered the shutdown
+	Category ShutdownCategory
+
+	// Err is the underlying error. It is nil for ShutdownSignal
+	Err error
+
+	// Component is the name of the Component that triggered the shutdown, if it was one (and it has a name)
+	Component string
+}
+
+// Error implements error, returning the underlying error's message
+func (reason *ShutdownReason) Error() string {
+	if reason.Err == nil {
+		return "shutdown: " + reason.Category.String()
+	}
+	return reason.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (reason *ShutdownReason) Unwrap() error {
+	return reason.Err
+}
+
+// Is reports that every ShutdownReason is ErrShuttingDown
+func (reason *ShutdownReason) Is(target error) bool {
+	return target == ErrShuttingDown
+}
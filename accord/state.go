@@ -1,43 +1,105 @@
 package accord
 
 import (
+	"bytes"
 	"encoding/binary"
-
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/errors"
+	"encoding/json"
+	"errors"
+	"sync"
 )
 
 const (
 	stateKey = "state"
+
+	// stateVersion is the version of the record we store under stateKey. Version 0 is the original format, which
+	// was nothing more than our current state as a little endian uint64
+	stateVersion = 1
+
+	// bloomMetaKey holds the parameters of our persisted bloom filter, and each block of the filter itself is stored under
+	// bloomBlockPrefix followed by its index
+	bloomMetaKey     = "bloom"
+	bloomBlockPrefix = "bloom:"
+)
+
+var (
+	// ErrStateInUse is returned when trying to Import into a State that a running Accord is using
+	ErrStateInUse = errors.New("state is in use by a running Accord")
+
+	// ErrUnsupportedState is returned when trying to Import a state record written by a newer version of Accord
+	ErrUnsupportedState = errors.New("unsupported state record version")
 )
 
 // State represents the internal history of Accord. Our state is essentially just a cumulative function of
 // every Message we have have processed from which we can use to determine if we've diverged from our remote
 // client
 type State struct {
-	// db is where we store and persist all of our state data. It's probably a bit of overkill to use a
-	// LevelDB database to keep track of our state but it's the easiest way of creating a persisted, thread
-	// safe piece of data. We're already using LevelDB for goque (which is why we're not going with Bolt)
-	// and it's very possible we'll want to keep track of more advanced data for our state, which this will
-	// help us support
-	db *leveldb.DB
+	// db is where we store and persist all of our state data. By default this is a LevelDB database; it's
+	// probably a bit of overkill to use one to keep track of our state but it's the easiest way of creating a
+	// persisted, thread safe piece of data. We're already using LevelDB for goque and it's very possible we'll
+	// want to keep track of more advanced data for our state, which this will help us support
+	db StateBackend
 
 	// Theres no point for us to go to the disk everytime we want to know our state as long as we can ensure
 	// we're the only ones updating it
 	cached uint64
+
+	// clock is our Lamport clock: the highest Clock of any Message that has gone through Update (see Message.Clock)
+	clock uint64
+
+	// values holds any additional named pieces of state, persisted alongside our current state in the same record.
+	// These are kept as raw JSON and decoded on demand by Get
+	values map[string]json.RawMessage
+
+	// valuesLock protects values, as unlike our current state they may be read and written from anywhere
+	valuesLock *sync.Mutex
+
+	// inUse is set while a running Accord owns this State, during which Import is refused
+	inUse bool
+
+	// bloom is an optional filter of every Message ID that has gone through Update, letting us cheaply tell when
+	// we've definitely never seen a Message. It is nil unless EnableBloom has been called
+	bloom *bloomFilter
+
+	// batchSize is how many updates we let build up in memory before writing them out (see SetBatching). unsaved is how
+	// many have built up, and dirty the bloom filter blocks they've changed. All three are protected by valuesLock
+	batchSize int
+	unsaved   int
+	dirty     map[uint64]struct{}
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
+}
+
+// StateView is a read-only view of our State, handed to a Manager implementing StateAwareResolver so that it can look
+// at, but not change, our current state, clock and additional named values
+type StateView interface {
+	GetCurrent() uint64
+	GetClock() uint64
+	Get(name string, value interface{}) (bool, error)
+	GetUint64(name string) (uint64, error)
 }
 
 // OpenState will open or create a LevelDB database that stores our state information and then load and cache
 // our data for reads. Will return an error if any occur during this process
 func OpenState(path string) (*State, error) {
-	db, err := leveldb.OpenFile(path, nil)
+	db, err := OpenLevelDBState(path)
 	if err != nil {
 		return nil, err
 	}
 
-	state := State{db: db}
+	return NewState(db)
+}
+
+// NewState creates a State on top of an already opened StateBackend, loading and caching our data for reads
+func NewState(db StateBackend) (*State, error) {
+	return newState(db, nil)
+}
 
-	err = state.loadFromDisk()
+// newState is NewState for a State that's encrypted at rest with the given keys
+func newState(db StateBackend, keys *atRestKeyring) (*State, error) {
+	state := State{db: db, values: map[string]json.RawMessage{}, valuesLock: &sync.Mutex{}, keys: keys}
+
+	err := state.loadFromDisk()
 	if err != nil {
 		return nil, err
 	}
@@ -45,39 +107,333 @@ func OpenState(path string) (*State, error) {
 	return &state, nil
 }
 
-// Close and clean up our LevelDB connection
+// Close writes out any updates we're holding on to (see SetBatching) and cleans up our LevelDB connection
 func (state *State) Close() {
+	// There's nobody left to tell, and anything lost can be recomputed
+	state.Save()
 	state.db.Close()
 }
 
+// SetBatching has Update keep our state in memory, writing it out once size updates have built up or Save is called,
+// rather than writing to our backend on every single Message. Our state is a single, constantly changing record, so
+// under high message rates this saves a great deal of write amplification (and LevelDB compaction churn) for very
+// little.
+//
+// The tradeoff is that updates still waiting when we crash are lost, leaving our persisted state behind what we
+// actually processed. That shows up as a divergence from our peers, so nothing is silently skipped, and the lost
+// updates can be recomputed from the Messages still in our history and sync queue. Close writes out anything that's
+// waiting. A size of 1 or less turns batching off, writing out anything that's waiting
+func (state *State) SetBatching(size int) error {
+	state.valuesLock.Lock()
+	state.batchSize = size
+	state.valuesLock.Unlock()
+
+	if size <= 1 {
+		return state.Save()
+	}
+	return nil
+}
+
+// Save writes out any updates we're holding on to (see SetBatching)
+func (state *State) Save() error {
+	state.valuesLock.Lock()
+	unsaved := state.unsaved
+	state.valuesLock.Unlock()
+
+	if unsaved == 0 {
+		return nil
+	}
+	return state.saveToDisk()
+}
+
+// Unsaved returns how many updates we're holding on to that have yet to be written out (see SetBatching)
+func (state *State) Unsaved() int {
+	state.valuesLock.Lock()
+	defer state.valuesLock.Unlock()
+
+	return state.unsaved
+}
+
+// Flush forces everything written to our state so far out to stable storage
+func (state *State) Flush() error {
+	return state.db.Flush()
+}
+
+// stateRecord is what we actually persist under our state key. It's versioned so that we can change its shape
+// in the future and still read what's already on disk
+type stateRecord struct {
+	Version int
+	Current uint64
+	Clock   uint64                     `json:",omitempty"`
+	Values  map[string]json.RawMessage `json:",omitempty"`
+}
+
 // loadFromDisk gets our data out of LevelDB and caches it in memory
 func (state *State) loadFromDisk() error {
-	val, err := state.db.Get([]byte(stateKey), nil)
+	val, err := state.db.Get([]byte(stateKey))
 
 	// This is a bit busy but essentially we're just checking to see if we got
 	// an error from our database read. If we did, but it's because the key could
 	// not be found, then use a default value, otherwise return the error. If we
-	// didn't get any error at all, then just use the value returned
+	// didn't get any error at all, then decode the value returned
 	if err != nil {
-		if err == errors.ErrNotFound {
+		if err == ErrKeyNotFound {
 			state.cached = 0
-		} else {
-			return err
+			return nil
 		}
-	} else {
+		return err
+	}
+
+	// Our original format was just the raw 8 bytes of our state. A JSON record can never be that short, so this is
+	// all we need to tell them apart. We'll write it back out in the new format the next time we save
+	if len(val) == 8 {
 		state.cached = binary.LittleEndian.Uint64(val)
+		return nil
+	}
+
+	val, err = state.keys.open(val)
+	if err != nil {
+		return err
+	}
+
+	record := stateRecord{}
+	err = json.Unmarshal(val, &record)
+	if err != nil {
+		return err
+	}
+
+	state.cached = record.Current
+	state.clock = record.Clock
+	if record.Values != nil {
+		state.values = record.Values
 	}
 
 	return nil
 }
 
 // saveToDisk saves our instance to disk as it currently is so that it can
-// be persisted
-func (state *State) saveToDisk() error {
-	data := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, state.cached)
+// be persisted. Any bloom filter blocks passed in (or changed by updates we've
+// been holding on to) are saved in the same batch so that our state and our
+// filter never disagree
+func (state *State) saveToDisk(bloomBlocks ...uint64) error {
+	state.valuesLock.Lock()
+	data, err := json.Marshal(stateRecord{
+		Version: stateVersion,
+		Current: state.cached,
+		Clock:   state.clock,
+		Values:  state.values,
+	})
+	for block := range state.dirty {
+		bloomBlocks = append(bloomBlocks, block)
+	}
+	state.valuesLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Our named values are as likely to be sensitive as any Message
+	data, err = state.keys.seal(data)
+	if err != nil {
+		return err
+	}
+
+	batch := &StateBatch{}
+	batch.Put([]byte(stateKey), data)
+	for _, block := range bloomBlocks {
+		batch.Put(bloomBlockKey(block), state.bloom.block(block))
+	}
+
+	err = state.db.Write(batch)
+	if err != nil {
+		return err
+	}
+
+	state.valuesLock.Lock()
+	state.unsaved = 0
+	state.dirty = nil
+	state.valuesLock.Unlock()
+	return nil
+}
+
+// Export serializes our state record (our current state along with any named values) so that it can be used to seed
+// another node through Import. Neither the history nor the sync queue are included, nor is the bloom filter
+func (state *State) Export() ([]byte, error) {
+	state.valuesLock.Lock()
+	defer state.valuesLock.Unlock()
+
+	return json.Marshal(stateRecord{
+		Version: stateVersion,
+		Current: state.cached,
+		Clock:   state.clock,
+		Values:  state.values,
+	})
+}
+
+// Import replaces our state record with one produced by Export and persists it. Anything we had before is lost. This
+// can't be done while a running Accord is using the State, as it would pull the rug out from under any Message being
+// processed
+func (state *State) Import(data []byte) error {
+	if state.inUse {
+		return ErrStateInUse
+	}
+
+	record := stateRecord{}
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return err
+	}
+	if record.Version > stateVersion {
+		return ErrUnsupportedState
+	}
+	if record.Values == nil {
+		record.Values = map[string]json.RawMessage{}
+	}
+
+	original, originalClock := state.cached, state.clock
+	state.valuesLock.Lock()
+	values := state.values
+	state.values = record.Values
+	state.valuesLock.Unlock()
+	state.cached = record.Current
+	state.clock = record.Clock
+
+	err = state.saveToDisk()
+	if err != nil {
+		state.cached = original
+		state.clock = originalClock
+		state.valuesLock.Lock()
+		state.values = values
+		state.valuesLock.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Get decodes the named piece of additional state into value (which should be a pointer, as with json.Unmarshal).
+// Returns false if nothing has been stored under that name
+func (state *State) Get(name string, value interface{}) (bool, error) {
+	state.valuesLock.Lock()
+	raw, ok := state.values[name]
+	state.valuesLock.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, value)
+}
+
+// Set stores value (which must be JSON encodable) as the named piece of additional state and persists it
+func (state *State) Set(name string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	state.valuesLock.Lock()
+	previous, existed := state.values[name]
+	state.values[name] = raw
+	state.valuesLock.Unlock()
+
+	err = state.saveToDisk()
+	if err != nil {
+		state.valuesLock.Lock()
+		if existed {
+			state.values[name] = previous
+		} else {
+			delete(state.values, name)
+		}
+		state.valuesLock.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// GetUint64 is a typed helper around Get for the common case of a counter or marker. Returns 0 if nothing has been
+// stored under that name
+func (state *State) GetUint64(name string) (uint64, error) {
+	var value uint64
+	_, err := state.Get(name, &value)
+	return value, err
+}
+
+// SetUint64 is a typed helper around Set for the common case of a counter or marker
+func (state *State) SetUint64(name string, value uint64) error {
+	return state.Set(name, value)
+}
+
+// bloomBlockKey returns the key a block of our bloom filter is stored under
+func bloomBlockKey(block uint64) []byte {
+	key := make([]byte, len(bloomBlockPrefix)+8)
+	copy(key, bloomBlockPrefix)
+	binary.BigEndian.PutUint64(key[len(bloomBlockPrefix):], block)
+	return key
+}
+
+// EnableBloom turns on our bloom filter of seen Message IDs, loading it from disk if we've previously persisted one
+// with the same parameters. If the parameters have changed we start over with an empty filter, as there's no way to
+// resize a bloom filter. Note that IDs are only added as Messages go through Update, so Messages handled before the
+// filter was enabled will always be reported as unseen
+func (state *State) EnableBloom(config BloomConfig) error {
+	bloom := newBloomFilter(config)
+
+	meta := make([]byte, 16)
+	binary.LittleEndian.PutUint64(meta[:8], bloom.m)
+	binary.LittleEndian.PutUint64(meta[8:], bloom.k)
+
+	persisted, err := state.db.Get([]byte(bloomMetaKey))
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+
+	if err == nil && bytes.Equal(persisted, meta) {
+		blocks := uint64(len(bloom.bits) / bloomBlockSize)
+		for block := uint64(0); block < blocks; block++ {
+			data, err := state.db.Get(bloomBlockKey(block))
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			copy(bloom.block(block), data)
+		}
+
+		state.bloom = bloom
+		return nil
+	}
 
-	return state.db.Put([]byte(stateKey), data, nil)
+	// Either we've never had a filter or its parameters changed, so clear out anything old and start fresh
+	keys, err := state.db.Keys([]byte(bloomBlockPrefix))
+	if err != nil {
+		return err
+	}
+
+	batch := &StateBatch{}
+	for _, key := range keys {
+		batch.Delete(key)
+	}
+	batch.Put([]byte(bloomMetaKey), meta)
+
+	err = state.db.Write(batch)
+	if err != nil {
+		return err
+	}
+
+	state.bloom = bloom
+	return nil
+}
+
+// MaybeSeen consults our bloom filter to see if a Message with the given ID has gone through Update. A false means we
+// have definitely never seen it, while a true means we *may* have. If the bloom filter isn't enabled we can't rule
+// anything out, so we always return true
+func (state *State) MaybeSeen(id uint64) bool {
+	if state.bloom == nil {
+		return true
+	}
+	return state.bloom.test(id)
 }
 
 // GetCurrent returns our current state
@@ -85,21 +441,94 @@ func (state *State) GetCurrent() uint64 {
 	return state.cached
 }
 
+// GetClock returns our Lamport clock, the highest Clock of any Message we've handled. A new Message should be stamped
+// with the one after it
+func (state *State) GetClock() uint64 {
+	return state.clock
+}
+
 // Update updates our current state to signify that a message has been
 // processed by our system. We also set the Message's "StateAt" field
 // to make sure it's correct
 func (state *State) Update(msg *Message) error {
-	original := state.cached
+	return state.UpdateWith(msg, msg.ID)
+}
+
+// UpdateWith is Update, but folds delta into our state in place of the Message's ID (see StateContributor). The
+// Message's ID is still what's recorded in our bloom filter
+func (state *State) UpdateWith(msg *Message, delta uint64) error {
+	_, err := state.update(msg, delta)
+	return err
+}
+
+// stateUndo is everything needed to take back a single update
+type stateUndo struct {
+	cached  uint64
+	clock   uint64
+	changed []uint64
+}
+
+// update does the work of UpdateWith, also returning what revert needs to take it back again. If our save fails the
+// update is taken back before we return
+func (state *State) update(msg *Message, delta uint64) (stateUndo, error) {
+	undo := stateUndo{cached: state.cached, clock: state.clock}
 
 	msg.StateAt = state.cached
 
-	state.cached += msg.ID
+	state.cached += delta
+	if msg.Clock > state.clock {
+		state.clock = msg.Clock
+	}
+
+	var blocks []uint64
+	if state.bloom != nil {
+		undo.changed = state.bloom.add(msg.ID)
+		blocks = state.bloom.blocks(undo.changed)
+	}
+
+	// When we're batching we only write once enough updates have built up
+	state.valuesLock.Lock()
+	if state.batchSize > 1 {
+		state.unsaved++
+		if state.dirty == nil {
+			state.dirty = map[uint64]struct{}{}
+		}
+		for _, block := range blocks {
+			state.dirty[block] = struct{}{}
+		}
+		if state.unsaved < state.batchSize {
+			state.valuesLock.Unlock()
+			return undo, nil
+		}
+	}
+	state.valuesLock.Unlock()
 
-	err := state.saveToDisk()
+	err := state.saveToDisk(blocks...)
 	if err != nil {
-		state.cached = original
-		return err
+		state.restore(undo)
+		return stateUndo{}, err
 	}
 
-	return nil
+	return undo, nil
+}
+
+// revert takes back an update, for when something that had to happen along with it failed. Nothing else may have
+// changed our state in between
+func (state *State) revert(undo stateUndo) error {
+	state.restore(undo)
+
+	var blocks []uint64
+	if state.bloom != nil {
+		blocks = state.bloom.blocks(undo.changed)
+	}
+	return state.saveToDisk(blocks...)
+}
+
+// restore puts our in memory state back to how it was before an update
+func (state *State) restore(undo stateUndo) {
+	state.cached = undo.cached
+	state.clock = undo.clock
+	if state.bloom != nil {
+		state.bloom.unset(undo.changed)
+	}
 }
@@ -1,6 +1,7 @@
 package accord
 
 import (
+	"encoding/binary"
 	"os"
 	"testing"
 
@@ -88,3 +89,240 @@ func TestStateUpdate(t *testing.T) {
 // 	err = state1.Update(Message{ID: 40})
 // 	assert.Nil(t, err)
 // }
+
+func TestStateRevert(t *testing.T) {
+	backend := &memoryState{values: map[string][]byte{}}
+	state, err := NewState(backend)
+	assert.Nil(t, err)
+	err = state.EnableBloom(BloomConfig{Capacity: 100})
+	assert.Nil(t, err)
+
+	assert.Nil(t, state.Update(&Message{ID: 5, Clock: 1}))
+	undo, err := state.update(&Message{ID: 7, Clock: 2}, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12), state.GetCurrent())
+
+	err = state.revert(undo)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), state.GetCurrent())
+	assert.Equal(t, uint64(1), state.GetClock())
+	assert.False(t, state.MaybeSeen(7))
+	assert.True(t, state.MaybeSeen(5))
+
+	loaded, err := NewState(backend)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), loaded.GetCurrent())
+	assert.Equal(t, uint64(1), loaded.GetClock())
+}
+
+func TestStateBloom(t *testing.T) {
+	stateFile := "state-test"
+	defer os.RemoveAll(stateFile)
+	os.RemoveAll(stateFile)
+
+	config := BloomConfig{Capacity: 100, FalsePositiveRate: 0.001}
+
+	state1, err := OpenState(stateFile)
+	assert.Nil(t, err)
+
+	// Without a bloom filter we can't rule anything out
+	assert.True(t, state1.MaybeSeen(123))
+
+	err = state1.EnableBloom(config)
+	assert.Nil(t, err)
+	assert.False(t, state1.MaybeSeen(123))
+
+	err = state1.Update(&Message{ID: 123})
+	assert.Nil(t, err)
+	assert.True(t, state1.MaybeSeen(123))
+	state1.Close()
+
+	// Our filter should survive a reopen
+	state2, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	err = state2.EnableBloom(config)
+	assert.Nil(t, err)
+	assert.True(t, state2.MaybeSeen(123))
+	state2.Close()
+
+	// But changing its parameters starts us over
+	state3, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	err = state3.EnableBloom(BloomConfig{Capacity: 1000})
+	assert.Nil(t, err)
+	assert.False(t, state3.MaybeSeen(123))
+	state3.Close()
+}
+
+func TestStateMigrateLegacyFormat(t *testing.T) {
+	stateFile := "state-test"
+	defer os.RemoveAll(stateFile)
+	os.RemoveAll(stateFile)
+
+	// Write our state out the way older versions did, as nothing more than a uint64
+	state1, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, 77)
+	batch := &StateBatch{}
+	batch.Put([]byte(stateKey), data)
+	err = state1.db.Write(batch)
+	assert.Nil(t, err)
+	state1.Close()
+
+	state2, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(77), state2.GetCurrent())
+
+	// Our next save should write the new format, which should load just the same
+	err = state2.Update(&Message{ID: 3})
+	assert.Nil(t, err)
+	state2.Close()
+
+	state3, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	defer state3.Close()
+	assert.Equal(t, uint64(80), state3.GetCurrent())
+
+	raw, err := state3.db.Get([]byte(stateKey))
+	assert.Nil(t, err)
+	assert.NotEqual(t, 8, len(raw))
+}
+
+func TestStateValues(t *testing.T) {
+	stateFile := "state-test"
+	defer os.RemoveAll(stateFile)
+	os.RemoveAll(stateFile)
+
+	state1, err := OpenState(stateFile)
+	assert.Nil(t, err)
+
+	value, err := state1.GetUint64("counter")
+	assert.Nil(t, err)
+	assert.Zero(t, value)
+
+	err = state1.SetUint64("counter", 12)
+	assert.Nil(t, err)
+
+	err = state1.Set("peers", map[string]uint64{"a": 1, "b": 2})
+	assert.Nil(t, err)
+	state1.Close()
+
+	state2, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	defer state2.Close()
+
+	value, err = state2.GetUint64("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12), value)
+
+	peers := map[string]uint64{}
+	found, err := state2.Get("peers", &peers)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, map[string]uint64{"a": 1, "b": 2}, peers)
+
+	found, err = state2.Get("missing", &peers)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestStateExportImport(t *testing.T) {
+	stateFile := "state-test"
+	defer os.RemoveAll(stateFile)
+	os.RemoveAll(stateFile)
+	otherFile := "state-test-other"
+	defer os.RemoveAll(otherFile)
+	os.RemoveAll(otherFile)
+
+	state1, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	defer state1.Close()
+	err = state1.Update(&Message{ID: 42})
+	assert.Nil(t, err)
+	err = state1.Set("name", "value")
+	assert.Nil(t, err)
+
+	data, err := state1.Export()
+	assert.Nil(t, err)
+
+	state2, err := OpenState(otherFile)
+	assert.Nil(t, err)
+	err = state2.Update(&Message{ID: 7})
+	assert.Nil(t, err)
+	err = state2.Set("stale", 1)
+	assert.Nil(t, err)
+
+	err = state2.Import(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), state2.GetCurrent())
+	state2.Close()
+
+	// Our import should have been persisted, and replaced anything we had before
+	state3, err := OpenState(otherFile)
+	assert.Nil(t, err)
+	defer state3.Close()
+	assert.Equal(t, uint64(42), state3.GetCurrent())
+	var value string
+	found, err := state3.Get("name", &value)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "value", value)
+	found, err = state3.Get("stale", &value)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	// We shouldn't accept records from the future, or garbage
+	assert.Equal(t, ErrUnsupportedState, state3.Import([]byte(`{"Version": 99}`)))
+	assert.NotNil(t, state3.Import([]byte("garbage")))
+	assert.Equal(t, uint64(42), state3.GetCurrent())
+
+	state3.inUse = true
+	assert.Equal(t, ErrStateInUse, state3.Import(data))
+}
+
+func TestStateBatching(t *testing.T) {
+	db := &memoryState{values: map[string][]byte{}}
+	persisted := func() uint64 {
+		reopened, err := NewState(db)
+		assert.Nil(t, err)
+		return reopened.GetCurrent()
+	}
+
+	state, err := NewState(db)
+	assert.Nil(t, err)
+	assert.Nil(t, state.EnableBloom(BloomConfig{Capacity: 100}))
+	assert.Nil(t, state.SetBatching(3))
+
+	// Our updates are kept in memory until enough have built up
+	assert.Nil(t, state.Update(&Message{ID: 10}))
+	assert.Nil(t, state.Update(&Message{ID: 20}))
+	assert.Equal(t, uint64(30), state.GetCurrent())
+	assert.Equal(t, 2, state.Unsaved())
+	assert.Equal(t, uint64(0), persisted())
+
+	assert.Nil(t, state.Update(&Message{ID: 30}))
+	assert.Zero(t, state.Unsaved())
+	assert.Equal(t, uint64(60), persisted())
+
+	// Along with the bloom filter blocks they changed
+	reopened, err := NewState(db)
+	assert.Nil(t, err)
+	assert.Nil(t, reopened.EnableBloom(BloomConfig{Capacity: 100}))
+	assert.True(t, reopened.MaybeSeen(10))
+	assert.True(t, reopened.MaybeSeen(30))
+
+	// And whatever's left is written out when we close
+	assert.Nil(t, state.Update(&Message{ID: 40}))
+	assert.Equal(t, uint64(60), persisted())
+	state.Close()
+	assert.Equal(t, uint64(100), persisted())
+
+	// Turning batching off writes out anything that's waiting
+	state, err = NewState(db)
+	assert.Nil(t, err)
+	assert.Nil(t, state.SetBatching(10))
+	assert.Nil(t, state.Update(&Message{ID: 5}))
+	assert.Nil(t, state.SetBatching(0))
+	assert.Equal(t, uint64(105), persisted())
+}
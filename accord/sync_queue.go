@@ -1,88 +1,820 @@
 package accord
 
-import "github.com/beeker1121/goque"
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownTarget is returned when a cursor operation is attempted for a sync target that was never registered
+	ErrUnknownTarget = errors.New("unknown sync target")
+
+	// ErrQueueNotEmpty is returned when asked to clear a sync queue that still has Messages waiting to be synced
+	ErrQueueNotEmpty = errors.New("sync queue is not empty")
+
+	// ErrQueueUnsupported is returned when our QueueBackend doesn't support the operation asked of it
+	ErrQueueUnsupported = errors.New("not supported by the queue backend")
+)
 
 // SyncQueue is responsible for holding all of the messages we've executed and need to be synchronized
-// remotely. It must work in a FIFO manner and be thread safe. Our underlying structure is goque
-// as it gives us both of these things, but we're wrapping it so that we can limit the kinds of operations
-// that can be executed as well as hidding the book keeping serialization and filesystem tasks (it also gives
-// us the ability to easily swap out for something different later if we so choose)
+// remotely. It must work in a FIFO manner and be thread safe. Our underlying structure is a QueueBackend
+// (goque by default) as it gives us both of these things, but we're wrapping it so that we can limit the kinds
+// of operations that can be executed as well as hidding the book keeping serialization and filesystem tasks (it
+// also gives us the ability to easily swap out for something different, through Accord.Backends)
 type SyncQueue struct {
-	//queue is our underlying structure where we actually store and persist our data. Currently we're
+	//queue is our underlying structure where we actually store and persist our data. By default we're
 	// using goque, which is built atop LevelDB, so simplify our lives and give us a thread safe FIFO data
 	// structure
-	queue *goque.Queue
+	queue QueueBackend
+
+	// cursors lets multiple independent sync targets (a primary peer and an archive peer, for instance) consume
+	// from the same queue. Each cursor is an offset from the head of the queue marking how many messages that
+	// target has confirmed. The head is only dequeued once *every* registered target has moved past it, so a slow
+	// or dead target will hold messages on disk but will never block the others from progressing. Unless we've been
+	// given a cursorStore, cursors are only kept in memory, so after a restart every target starts back at the head,
+	// which at worst means a target sees a message it already confirmed (something our protocol already has to tolerate)
+	cursors map[string]uint64
+
+	// confirmed is the last Message each target moved past, and when it did, for reporting on its progress. Like
+	// cursors it's protected by queueLock. A target that hasn't moved past anything since we started isn't in here,
+	// unless we found where it had gotten to in our cursorStore
+	confirmed map[string]confirmedMessage
+
+	// cursorStore optionally persists each target's position (see PersistCursors)
+	cursorStore StateBackend
+
+	// keys encrypt what we write at rest, if Accord has been given a key (see Accord.AtRestKey)
+	keys *atRestKeyring
+
+	// allowZeroIDs lets Messages with a zero ID in (see Accord.AllowZeroIDs)
+	allowZeroIDs bool
+
+	// queueLock protects our cursors and makes sure that operations spanning multiple backend calls (a confirm and its
+	// resulting dequeues, or sweeping out expired messages) happen atomically with respect to everything else
+	queueLock *sync.Mutex
+
+	// swept is the total number of expired Messages RemoveExpired has taken out of the queue
+	swept uint64
+
+	// barriers are everybody waiting on the Messages that were in the queue at some point to leave it. Protected by
+	// queueLock
+	barriers []*barrier
+
+	// waiting are the channels handed out by Notify, to be closed on our next Enqueue. Protected by queueLock
+	waiting []chan struct{}
+
+	// onTransition is optionally called whenever we go from empty to non-empty or back (see WatchEmpty), and empty is
+	// which of the two we were last at. Both are protected by queueLock
+	onTransition func(empty bool)
+	empty        bool
+}
+
+// confirmedMessage is the last Message a target moved past
+type confirmedMessage struct {
+	id        uint64
+	timestamp time.Time
+	at        time.Time
 }
 
-// OpenSyncQueue opens or creates a FIFO queue stored at the passed in path
+// PeerProgress describes how far a sync target (a peer, usually) has gotten through our sync queue
+type PeerProgress struct {
+	// LastConfirmedID and LastConfirmedTimestamp are the ID and Timestamp of the last Message the target confirmed (or
+	// skipped), and ConfirmedAt is when it did. They're all zero if it hasn't confirmed anything since we started,
+	// and ConfirmedAt is zero if we only know where it had gotten to from its persisted cursor
+	LastConfirmedID        uint64
+	LastConfirmedTimestamp time.Time
+	ConfirmedAt            time.Time
+
+	// Behind is how many Messages the target has yet to confirm
+	Behind uint64
+
+	// Lag is how far behind the target is in time: the difference between the Timestamp of the newest Message in the
+	// queue and that of the last Message the target confirmed (or, if we don't know what that was, the oldest Message
+	// it has yet to). It's zero when the target is caught up
+	Lag time.Duration
+}
+
+// barrier tracks how many of the Messages that were in the queue when it was created have yet to leave it. As the queue
+// is FIFO these are always the first remaining Messages in the queue
+type barrier struct {
+	remaining uint64
+	done      chan struct{}
+}
+
+// OpenSyncQueue opens or creates a FIFO queue stored at the passed in path using our default goque backend
 func OpenSyncQueue(path string) (*SyncQueue, error) {
-	queue, err := goque.OpenQueue(path)
+	queue, err := OpenGoqueQueue(path)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewSyncQueue(queue), nil
+}
+
+// NewSyncQueue creates a SyncQueue on top of an already opened QueueBackend
+func NewSyncQueue(queue QueueBackend) *SyncQueue {
 	return &SyncQueue{
-		queue: queue,
-	}, nil
+		queue:     queue,
+		cursors:   map[string]uint64{},
+		confirmed: map[string]confirmedMessage{},
+		queueLock: &sync.Mutex{},
+	}
 }
 
 // Peek returns the next Message in the queue but does *not* actually take it out
 // of the queue. Returns nil if the queue is empty
 func (sync *SyncQueue) Peek() (*Message, error) {
-	item, err := sync.queue.Peek()
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	return sync.keys.valueToMessage(sync.queue.PeekByOffset(0))
+}
+
+// Enqueue adds a new Message to the end of the queue
+func (sync *SyncQueue) Enqueue(msg *Message) error {
+	err := checkMessageID(msg, sync.allowZeroIDs)
 	if err != nil {
-		if err == goque.ErrEmpty {
-			return nil, nil
-		}
-		return nil, err
+		return err
 	}
 
-	msg := Message{}
-	err = item.ToObject(&msg)
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	bytes, err := sync.keys.sealMessage(msg)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &msg, nil
-}
-
-// Enqueue adds a new Message to the end of the queue
-func (sync *SyncQueue) Enqueue(msg *Message) error {
-	bytes, err := msg.Serialize()
+	err = sync.queue.Enqueue(bytes)
 	if err != nil {
 		return err
 	}
 
-	_, err = sync.queue.Enqueue(bytes)
-	return err
+	for _, waiting := range sync.waiting {
+		close(waiting)
+	}
+	sync.waiting = nil
+	return nil
+}
+
+// Notify returns a channel that is closed the next time a Message is enqueued, letting a component wait on new Messages
+// rather than polling for them. The returned cancel function must be called if the caller stops waiting before then.
+//
+// Notifications only cover what's enqueued while somebody is waiting, and aren't persisted. Whatever was enqueued
+// before a component started (including everything left over from before a crash or restart) has to be found by
+// looking at the queue itself, so a component driven by Notify should call Notify first and then check HasPending,
+// working through the backlog before it waits. Checking the other way around leaves a gap in which a Message can be
+// enqueued without the component hearing about it
+func (sync *SyncQueue) Notify() (<-chan struct{}, func()) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	waiting := make(chan struct{})
+	sync.waiting = append(sync.waiting, waiting)
+
+	cancel := func() {
+		sync.queueLock.Lock()
+		defer sync.queueLock.Unlock()
+
+		for i, other := range sync.waiting {
+			if other == waiting {
+				sync.waiting = append(sync.waiting[:i], sync.waiting[i+1:]...)
+				return
+			}
+		}
+	}
+	return waiting, cancel
+}
+
+// WatchEmpty calls fn whenever we go from empty to non-empty (with false) or from non-empty to empty (with true), as
+// worked out under the same lock that guards our contents, so that every edge is seen exactly once and in order. fn is
+// called straight away, with false, if we already have a backlog. It's called with our lock held, so it must return
+// quickly and must not call back into the queue. Only one watcher is kept; passing nil removes it
+func (sync *SyncQueue) WatchEmpty(fn func(empty bool)) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	sync.onTransition = fn
+	sync.empty = true
+	sync.noteLength()
+}
+
+// noteLength lets our watcher know if we've gone from empty to non-empty, or back, since it was last told. queueLock
+// must be held by the caller
+func (sync *SyncQueue) noteLength() {
+	empty := sync.queue.Length() == 0
+	if empty == sync.empty {
+		return
+	}
+
+	sync.empty = empty
+	if sync.onTransition != nil {
+		sync.onTransition(empty)
+	}
 }
 
 // Dequeue pops the next Message off of the queue in a FIFO manner and returns it.
 // Returns nil if the queue is empty
 func (sync *SyncQueue) Dequeue() (*Message, error) {
-	item, err := sync.queue.Dequeue()
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	return sync.keys.valueToMessage(sync.dropHead())
+}
+
+// dropHead dequeues the Message at the head of the queue, returning its value (nil if the queue is empty). Any target
+// that had confirmed it has its cursor moved back so that it keeps pointing at the same Message, and a target that
+// hadn't is left pointing at the new head. queueLock must be held by the caller
+func (sync *SyncQueue) dropHead() ([]byte, error) {
+	value, err := sync.queue.Dequeue()
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	sync.release(0)
+	for name, cursor := range sync.cursors {
+		if cursor > 0 {
+			sync.cursors[name] = cursor - 1
+		}
+	}
+	return value, nil
+}
+
+// cursorKeyPrefix is prepended to a target's name to make the key its position is stored under in our cursorStore
+const cursorKeyPrefix = "cursor:"
+
+// PersistCursors durably records each target's position in store as it confirms Messages, so that a target registered
+// after a restart picks up where it left off rather than back at the head of the queue. Positions are recorded as the
+// ID of the last Message the target confirmed rather than as an offset, as offsets shift whenever the queue is
+// dequeued and the two can't be written atomically. This should be called before any targets are registered, and the
+// store is flushed and closed along with the queue
+func (sync *SyncQueue) PersistCursors(store StateBackend) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	sync.cursorStore = store
+}
+
+// RegisterTarget adds a new named sync target. Its cursor starts at the head of the queue or, if we're persisting
+// cursors and the target has been registered before, just past the last Message it confirmed. Registering a target
+// that already exists leaves its cursor where it is
+func (sync *SyncQueue) RegisterTarget(target string) error {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	if _, ok := sync.cursors[target]; ok {
+		return nil
+	}
+
+	cursor, err := sync.restoreCursor(target)
+	if err != nil {
+		return err
+	}
+
+	sync.cursors[target] = cursor
+	return nil
+}
+
+// restoreCursor finds where a target left off from our cursorStore. If the last Message it confirmed is no longer in
+// the queue every target has moved past it, so the target is back at the head. queueLock must be held by the caller
+func (sync *SyncQueue) restoreCursor(target string) (uint64, error) {
+	if sync.cursorStore == nil {
+		return 0, nil
+	}
+
+	value, err := sync.cursorStore.Get([]byte(cursorKeyPrefix + target))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
 	if err != nil {
-		if err == goque.ErrEmpty {
-			return nil, nil
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, ErrMalformedMessage
+	}
+	confirmed := binary.BigEndian.Uint64(value)
+
+	for i := uint64(0); i < sync.queue.Length(); i++ {
+		msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(i))
+		if err != nil {
+			return 0, err
 		}
-		return nil, err
+		if msg == nil {
+			break
+		}
+		if msg.ID == confirmed {
+			sync.confirmed[target] = confirmedMessage{id: msg.ID, timestamp: msg.Timestamp}
+			return i + 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// saveCursor records that a target has confirmed the given Message, if we're persisting cursors. queueLock must be
+// held by the caller
+func (sync *SyncQueue) saveCursor(target string, msg *Message) error {
+	if sync.cursorStore == nil {
+		return nil
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, msg.ID)
+
+	batch := &StateBatch{}
+	batch.Put([]byte(cursorKeyPrefix+target), value)
+	return sync.cursorStore.Write(batch)
+}
+
+// PeekTarget returns the next Message the given target has yet to confirm, without moving its cursor. Returns nil
+// if the target has confirmed everything currently in the queue
+func (sync *SyncQueue) PeekTarget(target string) (*Message, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	cursor, ok := sync.cursors[target]
+	if !ok {
+		return nil, ErrUnknownTarget
+	}
+
+	if cursor >= sync.queue.Length() {
+		return nil, nil
+	}
+
+	return sync.keys.valueToMessage(sync.queue.PeekByOffset(cursor))
+}
+
+// ConfirmTarget moves the given target's cursor past the Message it was last handed. If that makes every registered
+// target past the head of the queue we dequeue until the slowest target is back at the head
+func (sync *SyncQueue) ConfirmTarget(target string) error {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	cursor, ok := sync.cursors[target]
+	if !ok {
+		return ErrUnknownTarget
+	}
+
+	// Guard against a target confirming more than we have (say, a duplicated "ok")
+	if cursor >= sync.queue.Length() {
+		return nil
+	}
+
+	return sync.advance(target, cursor)
+}
+
+// ConfirmTargetMessage is ConfirmTarget for when the target knows which Message it was handed. Its cursor is only
+// moved if the Message with the given ID is still the next one it has yet to confirm, so that a Message taken out of
+// the queue while the target had it (swept out as expired, say) doesn't lead to the one behind it being confirmed
+// without ever having been sent. Returns false, having done nothing, if it isn't
+func (sync *SyncQueue) ConfirmTargetMessage(target string, id uint64) (bool, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	cursor, ok := sync.cursors[target]
+	if !ok {
+		return false, ErrUnknownTarget
+	}
+
+	msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(cursor))
+	if err != nil || msg == nil || msg.ID != id {
+		return false, err
 	}
 
-	msg := Message{}
-	err = item.ToObject(&msg)
+	return true, sync.advance(target, cursor)
+}
+
+// advance moves the given target's cursor forward from where it currently sits and dequeues anything every target has
+// now moved past. queueLock must be held by the caller
+func (sync *SyncQueue) advance(target string, cursor uint64) error {
+	msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(cursor))
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// Record our new position before anything is dequeued, so that the Message we record is still there to be found
+	// should we crash in between
+	if msg != nil {
+		err = sync.saveCursor(target, msg)
+		if err != nil {
+			return err
+		}
+		sync.confirmed[target] = confirmedMessage{id: msg.ID, timestamp: msg.Timestamp, at: time.Now().UTC()}
 	}
 
-	return &msg, nil
+	sync.cursors[target] = cursor + 1
+
+	slowest := sync.slowestCursor()
+	for i := uint64(0); i < slowest; i++ {
+		_, err := sync.dropHead()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Skip moves past the Message with the given ID without it having been confirmed, so that a Message our remote can't
+// handle doesn't hold up everything behind it. With an empty target the Message must be at the head of the queue and
+// is dequeued, otherwise it must be the next Message for that target and the target's cursor is moved past it. Returns
+// false, having done nothing, if the Message isn't where we expect it (someone has already moved past it)
+func (sync *SyncQueue) Skip(target string, id uint64) (bool, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	var cursor uint64
+	if target != "" {
+		var ok bool
+		cursor, ok = sync.cursors[target]
+		if !ok {
+			return false, ErrUnknownTarget
+		}
+	}
+
+	msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(cursor))
+	if err != nil || msg == nil || msg.ID != id {
+		return false, err
+	}
+
+	if target != "" {
+		return true, sync.advance(target, cursor)
+	}
+
+	_, err = sync.dropHead()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// slowestCursor returns the smallest offset of all our registered targets. queueLock must be held by the caller
+func (sync *SyncQueue) slowestCursor() uint64 {
+	first := true
+	var slowest uint64
+	for _, cursor := range sync.cursors {
+		if first || cursor < slowest {
+			slowest = cursor
+			first = false
+		}
+	}
+	return slowest
+}
+
+// TargetSize returns the number of Messages the given target has yet to confirm
+func (sync *SyncQueue) TargetSize(target string) (uint64, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	cursor, ok := sync.cursors[target]
+	if !ok {
+		return 0, ErrUnknownTarget
+	}
+
+	return sync.behind(cursor), nil
+}
+
+// behind returns how many Messages a target with the given cursor has yet to confirm. queueLock must be held by the
+// caller
+func (sync *SyncQueue) behind(cursor uint64) uint64 {
+	length := sync.queue.Length()
+	if cursor >= length {
+		return 0
+	}
+	return length - cursor
+}
+
+// Progress reports how far each registered target has gotten through the queue, by target
+func (sync *SyncQueue) Progress() (map[string]PeerProgress, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	length := sync.queue.Length()
+	var newest *Message
+	if length > 0 {
+		var err error
+		newest, err = sync.keys.valueToMessage(sync.queue.PeekByOffset(length - 1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	progress := map[string]PeerProgress{}
+	for target, cursor := range sync.cursors {
+		confirmed := sync.confirmed[target]
+		peer := PeerProgress{
+			LastConfirmedID:        confirmed.id,
+			LastConfirmedTimestamp: confirmed.timestamp,
+			ConfirmedAt:            confirmed.at,
+			Behind:                 sync.behind(cursor),
+		}
+
+		if peer.Behind > 0 && newest != nil {
+			since := confirmed.timestamp
+			if confirmed.id == 0 {
+				oldest, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(cursor))
+				if err != nil {
+					return nil, err
+				}
+				if oldest != nil {
+					since = oldest.Timestamp
+				}
+			}
+			if newest.Timestamp.After(since) {
+				peer.Lag = newest.Timestamp.Sub(since)
+			}
+		}
+
+		progress[target] = peer
+	}
+	return progress, nil
 }
 
 // Size returns the number of elements currently enqueued
 func (sync *SyncQueue) Size() uint64 {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
 	return sync.queue.Length()
 }
 
-// Close closes the underlying connection to our persisted queue
+// HasPending reports whether there's anything in the queue waiting to be synced. It's how a component that waits on
+// Notify finds the backlog it wasn't notified about (see Notify)
+func (sync *SyncQueue) HasPending() bool {
+	return sync.Size() > 0
+}
+
+// Usage reports how much our backend is holding and how much it has used up to hold it, or ErrQueueUnsupported if it
+// can't tell us. goque numbers every item it's ever been given and LevelDB is slow to hand back the space taken by
+// dequeued items, so a long lived queue with a lot of churn keeps growing on disk (and climbing in item numbers) even
+// while its Size stays flat. The only way to reclaim that is Clear
+func (sync *SyncQueue) Usage() (QueueUsage, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	reporter, ok := sync.queue.(QueueUsageReporter)
+	if !ok {
+		return QueueUsage{}, ErrQueueUnsupported
+	}
+	return reporter.Usage()
+}
+
+// Clear drops and recreates our backend, which resets goque's item numbers and reclaims the disk left behind by
+// everything that has passed through the queue. Nothing is ever thrown away: if any Messages are still waiting to be
+// synced ErrQueueNotEmpty is returned, so this is best called once every target has caught up. Returns
+// ErrQueueUnsupported if our backend can't be cleared
+func (sync *SyncQueue) Clear() error {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	clearable, ok := sync.queue.(ClearableQueue)
+	if !ok {
+		return ErrQueueUnsupported
+	}
+	if sync.queue.Length() > 0 {
+		return ErrQueueNotEmpty
+	}
+
+	return clearable.Clear()
+}
+
+// RemoveExpired sweeps through the queue taking out every Message that has expired as of now, returning how many were
+// removed. The live Messages are left in their original order (see rotate)
+func (sync *SyncQueue) RemoveExpired(now time.Time) (uint64, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	// Don't bother rotating the whole queue unless there's actually something to remove
+	size := sync.queue.Length()
+	expired := false
+	for i := uint64(0); i < size; i++ {
+		msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(i))
+		if err != nil {
+			return 0, err
+		}
+		if msg != nil && msg.Expired(now) {
+			expired = true
+			break
+		}
+	}
+	if !expired {
+		return 0, nil
+	}
+
+	removed, err := sync.rotate(func(msg *Message) bool {
+		return msg.Expired(now)
+	})
+	sync.swept += removed
+
+	return removed, err
+}
+
+// PeekHighestPriority returns the Message in the queue with the highest Priority, without taking it out of the queue.
+// Messages of the same Priority are returned in FIFO order. Returns nil if the queue is empty.
+//
+// Strict priority lets a steady stream of high priority Messages starve lower priority ones indefinitely. A non zero
+// aging guards against that by raising each Message's effective Priority by one for every aging it has been waiting
+// (going by its Timestamp), so that everything is eventually sent. This has to look at every Message in the queue, so
+// it gets slower as the queue grows, and it ignores sync targets entirely; a Message sent this way should be taken out
+// of the queue with Remove rather than Dequeue
+func (sync *SyncQueue) PeekHighestPriority(aging time.Duration) (*Message, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	now := time.Now().UTC()
+
+	var highest *Message
+	var highestPriority uint64
+	size := sync.queue.Length()
+	for i := uint64(0); i < size; i++ {
+		msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(i))
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			break
+		}
+
+		priority := effectivePriority(msg, aging, now)
+		if highest == nil || priority > highestPriority {
+			highest = msg
+			highestPriority = priority
+		}
+	}
+
+	return highest, nil
+}
+
+// effectivePriority is a Message's Priority, raised by one for every aging it has been waiting as of now
+func effectivePriority(msg *Message, aging time.Duration, now time.Time) uint64 {
+	priority := uint64(msg.Priority)
+	if aging > 0 && now.After(msg.Timestamp) {
+		priority += uint64(now.Sub(msg.Timestamp) / aging)
+	}
+	return priority
+}
+
+// Remove takes the Message with the given ID out of the queue, wherever it is, returning whether it was found. This
+// has to rotate through the entire queue (see rotate), so Dequeue should be preferred whenever the Message is at the
+// head
+func (sync *SyncQueue) Remove(id uint64) (bool, error) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+	defer sync.noteLength()
+
+	// Our head is by far the most likely place to find it, and doesn't need a rotation
+	head, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(0))
+	if err != nil || head == nil {
+		return false, err
+	}
+	if head.ID == id {
+		_, err = sync.dropHead()
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	found := false
+	_, err = sync.rotate(func(msg *Message) bool {
+		if !found && msg.ID == id {
+			found = true
+			return true
+		}
+		return false
+	})
+	return found, err
+}
+
+// rotate takes every Message remove returns true for out of the queue, returning how many were removed. Our backend
+// can't remove items from the middle of a queue, so we rotate through it: each Message we're keeping is re-enqueued at
+// the tail before being dequeued from the head, and each one we're removing is simply dequeued. Having gone through
+// every Message exactly once, the ones we kept are left in their original order. Because we always enqueue before
+// dequeuing, a crash mid-rotation can at worst leave a duplicate behind, never lose a Message. Any target cursors are
+// adjusted so that they keep pointing at the same Message. queueLock must be held by the caller
+func (sync *SyncQueue) rotate(remove func(*Message) bool) (uint64, error) {
+	size := sync.queue.Length()
+
+	var removed uint64
+	cursors := map[string]uint64{}
+	for i := uint64(0); i < size; i++ {
+		value, err := sync.queue.PeekByOffset(0)
+		if err != nil {
+			return removed, err
+		}
+
+		msg, err := sync.keys.openMessage(value)
+		if err != nil {
+			return removed, err
+		}
+
+		if remove(msg) {
+			// Earlier removals have already shifted this Message closer to the head, as far as our barriers
+			// are concerned
+			sync.release(i - removed)
+			removed++
+		} else {
+			err = sync.queue.Enqueue(value)
+			if err != nil {
+				return removed, err
+			}
+
+			// Every target that had confirmed this Message should still be past it afterwards
+			for name, cursor := range sync.cursors {
+				if i < cursor {
+					cursors[name]++
+				}
+			}
+		}
+
+		_, err = sync.queue.Dequeue()
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	for name := range sync.cursors {
+		sync.cursors[name] = cursors[name]
+	}
+
+	return removed, nil
+}
+
+// Barrier returns a channel that is closed once every Message currently in the queue has left it, whether by being
+// dequeued, confirmed by every registered target, or swept out as expired. Messages enqueued afterwards don't hold it
+// up. The returned cancel function must be called once the caller is no longer waiting
+func (sync *SyncQueue) Barrier() (<-chan struct{}, func()) {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	b := &barrier{remaining: sync.queue.Length(), done: make(chan struct{})}
+	if b.remaining == 0 {
+		close(b.done)
+		return b.done, func() {}
+	}
+	sync.barriers = append(sync.barriers, b)
+
+	cancel := func() {
+		sync.queueLock.Lock()
+		defer sync.queueLock.Unlock()
+
+		for i, other := range sync.barriers {
+			if other == b {
+				sync.barriers = append(sync.barriers[:i], sync.barriers[i+1:]...)
+				return
+			}
+		}
+	}
+	return b.done, cancel
+}
+
+// release lets our barriers know that the Message at the given offset from the head of the queue has left it. Only
+// barriers still waiting on that Message are moved forward. queueLock must be held by the caller
+func (sync *SyncQueue) release(offset uint64) {
+	waiting := sync.barriers[:0]
+	for _, b := range sync.barriers {
+		if offset < b.remaining {
+			b.remaining--
+		}
+		if b.remaining == 0 {
+			close(b.done)
+			continue
+		}
+		waiting = append(waiting, b)
+	}
+	sync.barriers = waiting
+}
+
+// Swept returns the total number of expired Messages that have been removed by RemoveExpired since we were opened
+func (sync *SyncQueue) Swept() uint64 {
+	sync.queueLock.Lock()
+	defer sync.queueLock.Unlock()
+
+	return sync.swept
+}
+
+// Flush forces everything written to the queue (and our cursors, if we're persisting them) so far out to stable storage
+func (sync *SyncQueue) Flush() error {
+	err := sync.queue.Flush()
+	if err != nil || sync.cursorStore == nil {
+		return err
+	}
+
+	return sync.cursorStore.Flush()
+}
+
+// Close closes the underlying connection to our persisted queue, and our cursor store if we have one
 func (sync *SyncQueue) Close() {
 	sync.queue.Close()
+	if sync.cursorStore != nil {
+		sync.cursorStore.Close()
+	}
 }
@@ -2,7 +2,9 @@ package accord
 
 import (
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,11 +19,11 @@ func TestSyncQueue(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Zero(t, sync.Size())
-	err = sync.Enqueue(&Message{Payload: []byte{1}})
+	err = sync.Enqueue(&Message{ID: 1, Payload: []byte{1}})
 	assert.Nil(t, err)
-	err = sync.Enqueue(&Message{Payload: []byte{2}})
+	err = sync.Enqueue(&Message{ID: 2, Payload: []byte{2}})
 	assert.Nil(t, err)
-	err = sync.Enqueue(&Message{Payload: []byte{3}})
+	err = sync.Enqueue(&Message{ID: 3, Payload: []byte{3}})
 	assert.Nil(t, err)
 
 	msg, err := sync.Peek()
@@ -58,3 +60,653 @@ func TestSyncQueue(t *testing.T) {
 	assert.Equal(t, uint64(0), sync.Size())
 
 }
+
+func TestSyncQueueTargets(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	sync.RegisterTarget("primary")
+	sync.RegisterTarget("archive")
+
+	for i := byte(1); i <= 3; i++ {
+		err = sync.Enqueue(&Message{ID: uint64(i), Payload: []byte{i}})
+		assert.Nil(t, err)
+	}
+
+	// Our primary target is fast and confirms everything
+	for i := byte(1); i <= 3; i++ {
+		msg, err := sync.PeekTarget("primary")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{i}, msg.Payload)
+
+		err = sync.ConfirmTarget("primary")
+		assert.Nil(t, err)
+	}
+
+	msg, err := sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+
+	// Nothing can be dequeued until our archive has caught up
+	assert.Equal(t, uint64(3), sync.Size())
+	size, err := sync.TargetSize("primary")
+	assert.Nil(t, err)
+	assert.Zero(t, size)
+	size, err = sync.TargetSize("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), size)
+
+	// Our archive is slower, but each confirmation now lets the head go
+	msg, err = sync.PeekTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, msg.Payload)
+	err = sync.ConfirmTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), sync.Size())
+
+	// New messages show up for both targets
+	err = sync.Enqueue(&Message{ID: 4, Payload: []byte{4}})
+	assert.Nil(t, err)
+
+	msg, err = sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4}, msg.Payload)
+	err = sync.ConfirmTarget("primary")
+	assert.Nil(t, err)
+
+	// A duplicated confirmation shouldn't move us past the end of the queue
+	err = sync.ConfirmTarget("primary")
+	assert.Nil(t, err)
+	size, err = sync.TargetSize("primary")
+	assert.Nil(t, err)
+	assert.Zero(t, size)
+
+	for i := byte(2); i <= 4; i++ {
+		msg, err = sync.PeekTarget("archive")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{i}, msg.Payload)
+		err = sync.ConfirmTarget("archive")
+		assert.Nil(t, err)
+	}
+
+	assert.Zero(t, sync.Size())
+
+	_, err = sync.PeekTarget("unknown")
+	assert.Equal(t, ErrUnknownTarget, err)
+	assert.Equal(t, ErrUnknownTarget, sync.ConfirmTarget("unknown"))
+}
+
+func TestSyncQueueSkip(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	for i := uint64(1); i <= 3; i++ {
+		err = sync.Enqueue(&Message{ID: i})
+		assert.Nil(t, err)
+	}
+
+	// Only the head can be skipped
+	skipped, err := sync.Skip("", 2)
+	assert.Nil(t, err)
+	assert.False(t, skipped)
+
+	skipped, err = sync.Skip("", 1)
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+	assert.Equal(t, uint64(2), sync.Size())
+
+	// With targets it's the target's next Message that's skipped, and only dequeued once everyone is past it
+	sync.RegisterTarget("primary")
+	sync.RegisterTarget("archive")
+
+	skipped, err = sync.Skip("primary", 2)
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+	assert.Equal(t, uint64(2), sync.Size())
+
+	msg, err := sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+
+	skipped, err = sync.Skip("archive", 2)
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+	assert.Equal(t, uint64(1), sync.Size())
+
+	_, err = sync.Skip("unknown", 3)
+	assert.Equal(t, ErrUnknownTarget, err)
+}
+
+func TestSyncQueueUntargetedWithTargets(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	sync.RegisterTarget("primary")
+	sync.RegisterTarget("archive")
+	for i := uint64(1); i <= 4; i++ {
+		err = sync.Enqueue(&Message{ID: i})
+		assert.Nil(t, err)
+	}
+
+	// Our primary has been sent, and confirmed, the first two Messages and our archive nothing at all
+	for i := 0; i < 2; i++ {
+		err = sync.ConfirmTarget("primary")
+		assert.Nil(t, err)
+	}
+
+	// Taking the head out from under both of them leaves each pointing at the Message it should be sent next
+	msg, err := sync.Dequeue()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), msg.ID)
+
+	msg, err = sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+	msg, err = sync.PeekTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+
+	skipped, err := sync.Skip("", 2)
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+
+	msg, err = sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+	msg, err = sync.PeekTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+
+	size, err := sync.TargetSize("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), size)
+	size, err = sync.TargetSize("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), size)
+
+	// As does removing the head by ID
+	err = sync.ConfirmTarget("primary")
+	assert.Nil(t, err)
+	found, err := sync.Remove(3)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	msg, err = sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), msg.ID)
+	msg, err = sync.PeekTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), msg.ID)
+
+	// Emptying the queue leaves nobody with anything to confirm
+	for sync.Size() > 0 {
+		_, err = sync.Dequeue()
+		assert.Nil(t, err)
+	}
+	size, err = sync.TargetSize("primary")
+	assert.Nil(t, err)
+	assert.Zero(t, size)
+	progress, err := sync.Progress()
+	assert.Nil(t, err)
+	assert.Zero(t, progress["primary"].Behind)
+}
+
+func TestSyncQueuePriority(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	msg, err := sync.PeekHighestPriority(0)
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+
+	now := time.Now().UTC()
+	for i, priority := range []uint8{0, 1, 0, 5, 5} {
+		err = sync.Enqueue(&Message{ID: uint64(i + 1), Priority: priority, Timestamp: now})
+		assert.Nil(t, err)
+	}
+
+	// Highest priority first, FIFO within a priority, and lowest last
+	for _, id := range []uint64{4, 5, 2, 1, 3} {
+		msg, err = sync.PeekHighestPriority(0)
+		assert.Nil(t, err)
+		assert.Equal(t, id, msg.ID)
+
+		removed, err := sync.Remove(msg.ID)
+		assert.Nil(t, err)
+		assert.True(t, removed)
+	}
+	assert.Zero(t, sync.Size())
+
+	removed, err := sync.Remove(1)
+	assert.Nil(t, err)
+	assert.False(t, removed)
+
+	// With aging an old enough low priority Message catches up
+	err = sync.Enqueue(&Message{ID: 1, Priority: 0, Timestamp: now.Add(-time.Hour)})
+	assert.Nil(t, err)
+	err = sync.Enqueue(&Message{ID: 2, Priority: 2, Timestamp: now})
+	assert.Nil(t, err)
+
+	msg, err = sync.PeekHighestPriority(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+	msg, err = sync.PeekHighestPriority(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), msg.ID)
+}
+
+func TestSyncQueueNotify(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	enqueued, cancel := sync.Notify()
+	cancelled, cancelIt := sync.Notify()
+	cancelIt()
+	defer cancel()
+
+	select {
+	case <-enqueued:
+		t.Fatal("Notified before anything was enqueued")
+	default:
+	}
+
+	err = sync.Enqueue(&Message{ID: 1})
+	assert.Nil(t, err)
+
+	select {
+	case <-enqueued:
+	default:
+		t.Fatal("Not notified of an enqueue")
+	}
+
+	select {
+	case <-cancelled:
+		t.Fatal("Notified after cancelling")
+	default:
+	}
+}
+
+// pushComponent stands in for an event driven sync component, pushing out Messages as it's notified of them
+type pushComponent struct {
+	ComponentRunner
+	lock   *sync.Mutex
+	pushed []uint64
+}
+
+func (comp *pushComponent) Start(accord *Accord) error {
+	comp.lock = &sync.Mutex{}
+	comp.ComponentRunner.Init(accord, comp.tick, nil, nil)
+	return nil
+}
+
+func (comp *pushComponent) tick(accord *Accord) {
+	enqueued, cancel := accord.ToBeSynced.Notify()
+	defer cancel()
+
+	if !accord.ToBeSynced.HasPending() {
+		select {
+		case <-enqueued:
+		case <-time.After(time.Millisecond):
+			return
+		}
+	}
+
+	for {
+		msg, err := accord.ToBeSynced.Dequeue()
+		if err != nil || msg == nil {
+			return
+		}
+		comp.lock.Lock()
+		comp.pushed = append(comp.pushed, msg.ID)
+		comp.lock.Unlock()
+	}
+}
+
+func (comp *pushComponent) Pushed() []uint64 {
+	comp.lock.Lock()
+	defer comp.lock.Unlock()
+	return append([]uint64{}, comp.pushed...)
+}
+
+func TestSyncQueueHasPending(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	// Messages enqueued before we went down won't ever be notified about
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	assert.False(t, accord.ToBeSynced.HasPending())
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: id}))
+	}
+	assert.True(t, accord.ToBeSynced.HasPending())
+	accord.Stop()
+
+	// But a component that checks for a backlog before waiting still picks them up after a restart
+	comp := &pushComponent{}
+	accord = DummyAccordComponents(comp)
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []uint64{1, 2, 3}, comp.Pushed())
+	assert.False(t, accord.ToBeSynced.HasPending())
+
+	// Along with anything enqueued afterwards
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []uint64{1, 2, 3, 4}, comp.Pushed())
+}
+
+func TestSyncQueueRemoveExpired(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	sync.RegisterTarget("fast")
+	sync.RegisterTarget("slow")
+
+	for i, expiresAt := range []time.Time{{}, past, future, past, {}} {
+		err = sync.Enqueue(&Message{ID: uint64(i + 1), Payload: []byte{byte(i)}, ExpiresAt: expiresAt})
+		assert.Nil(t, err)
+	}
+
+	// Our fast target has confirmed the first three messages (one of which is expired)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sync.ConfirmTarget("fast"))
+	}
+
+	// Nothing to do yet
+	removed, err := sync.RemoveExpired(past.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Zero(t, removed)
+	assert.Equal(t, uint64(5), sync.Size())
+
+	removed, err = sync.RemoveExpired(now)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), removed)
+	assert.Equal(t, uint64(2), sync.Swept())
+	assert.Equal(t, uint64(3), sync.Size())
+
+	// Our live messages should still be in order
+	var payloads []byte
+	for i := uint64(0); i < sync.Size(); i++ {
+		msg, err := sync.keys.valueToMessage(sync.queue.PeekByOffset(i))
+		assert.Nil(t, err)
+		payloads = append(payloads, msg.Payload[0])
+	}
+	assert.Equal(t, []byte{0, 2, 4}, payloads)
+
+	// And our targets should still be pointing at the same messages
+	msg, err := sync.PeekTarget("fast")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4}, msg.Payload)
+
+	msg, err = sync.PeekTarget("slow")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0}, msg.Payload)
+}
+
+func TestSyncQueueConfirmTargetMessage(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	now := time.Now().UTC()
+	sync.RegisterTarget("primary")
+	err = sync.Enqueue(&Message{ID: 1, ExpiresAt: now.Add(time.Minute)})
+	assert.Nil(t, err)
+	err = sync.Enqueue(&Message{ID: 2})
+	assert.Nil(t, err)
+
+	// Our target is handed the first Message, which expires and is swept out before it confirms it
+	msg, err := sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), msg.ID)
+	removed, err := sync.RemoveExpired(now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), removed)
+
+	// Its confirmation mustn't take the Message behind it along, which it was never sent
+	confirmed, err := sync.ConfirmTargetMessage("primary", msg.ID)
+	assert.Nil(t, err)
+	assert.False(t, confirmed)
+	assert.Equal(t, uint64(1), sync.Size())
+
+	msg, err = sync.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+	confirmed, err = sync.ConfirmTargetMessage("primary", msg.ID)
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+	assert.Equal(t, uint64(0), sync.Size())
+
+	_, err = sync.ConfirmTargetMessage("unknown", 3)
+	assert.Equal(t, ErrUnknownTarget, err)
+}
+
+func TestSyncQueueBarrier(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	defer sync.Close()
+
+	isDone := func(done <-chan struct{}) bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// An empty queue has nothing to wait on
+	done, cancel := sync.Barrier()
+	assert.True(t, isDone(done))
+	cancel()
+
+	now := time.Now().UTC()
+	for i, expiresAt := range []time.Time{now.Add(-time.Minute), {}, now.Add(-time.Minute)} {
+		err = sync.Enqueue(&Message{ID: uint64(i + 1), Payload: []byte{byte(i)}, ExpiresAt: expiresAt})
+		assert.Nil(t, err)
+	}
+
+	done, cancel = sync.Barrier()
+	defer cancel()
+
+	// Anything enqueued after our barrier shouldn't hold it up
+	err = sync.Enqueue(&Message{ID: 4, Payload: []byte{3}, ExpiresAt: now.Add(-time.Minute)})
+	assert.Nil(t, err)
+
+	removed, err := sync.RemoveExpired(now)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), removed)
+	assert.False(t, isDone(done))
+
+	msg, err := sync.Dequeue()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, msg.Payload)
+	assert.True(t, isDone(done))
+}
+
+func TestSyncQueuePersistCursors(t *testing.T) {
+	queue := &memoryQueue{}
+	store := &memoryState{values: map[string][]byte{}}
+
+	sync := NewSyncQueue(queue)
+	sync.PersistCursors(store)
+	assert.Nil(t, sync.RegisterTarget("primary"))
+	assert.Nil(t, sync.RegisterTarget("archive"))
+
+	for i := uint64(1); i <= 3; i++ {
+		err := sync.Enqueue(&Message{ID: i})
+		assert.Nil(t, err)
+	}
+
+	// Our primary confirms the first two and our archive just the first, which leaves it alone at the head
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, sync.ConfirmTarget("primary"))
+	}
+	assert.Nil(t, sync.ConfirmTarget("archive"))
+	assert.Equal(t, uint64(2), sync.Size())
+
+	// After a restart both carry on where they left off
+	restarted := NewSyncQueue(queue)
+	restarted.PersistCursors(store)
+	assert.Nil(t, restarted.RegisterTarget("primary"))
+	assert.Nil(t, restarted.RegisterTarget("archive"))
+	assert.Nil(t, restarted.RegisterTarget("new"))
+
+	msg, err := restarted.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), msg.ID)
+	msg, err = restarted.PeekTarget("archive")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+
+	// While a target we've never seen starts at the head
+	msg, err = restarted.PeekTarget("new")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+
+	// Without persistence everybody starts at the head
+	forgetful := NewSyncQueue(queue)
+	forgetful.RegisterTarget("primary")
+	msg, err = forgetful.PeekTarget("primary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+}
+
+func TestSyncQueueProgress(t *testing.T) {
+	queue := &memoryQueue{}
+	store := &memoryState{values: map[string][]byte{}}
+
+	sync := NewSyncQueue(queue)
+	sync.PersistCursors(store)
+	assert.Nil(t, sync.RegisterTarget("primary"))
+	assert.Nil(t, sync.RegisterTarget("archive"))
+	// A target that never confirms anything keeps everything in the queue
+	assert.Nil(t, sync.RegisterTarget("mirror"))
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := uint64(1); i <= 4; i++ {
+		err := sync.Enqueue(&Message{ID: i, Timestamp: start.Add(time.Duration(i) * time.Minute)})
+		assert.Nil(t, err)
+	}
+
+	// Nobody has confirmed anything yet, so we measure from the oldest Message each has yet to
+	progress, err := sync.Progress()
+	assert.Nil(t, err)
+	assert.Len(t, progress, 3)
+	assert.Equal(t, uint64(4), progress["primary"].Behind)
+	assert.Equal(t, 3*time.Minute, progress["primary"].Lag)
+	assert.Equal(t, uint64(0), progress["primary"].LastConfirmedID)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sync.ConfirmTarget("primary"))
+	}
+	assert.Nil(t, sync.ConfirmTarget("archive"))
+
+	progress, err = sync.Progress()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), progress["primary"].LastConfirmedID)
+	assert.Equal(t, start.Add(3*time.Minute), progress["primary"].LastConfirmedTimestamp)
+	assert.False(t, progress["primary"].ConfirmedAt.IsZero())
+	assert.Equal(t, uint64(1), progress["primary"].Behind)
+	assert.Equal(t, time.Minute, progress["primary"].Lag)
+	assert.Equal(t, uint64(1), progress["archive"].LastConfirmedID)
+	assert.Equal(t, uint64(3), progress["archive"].Behind)
+	assert.Equal(t, 3*time.Minute, progress["archive"].Lag)
+
+	// Once caught up there's no lag at all
+	assert.Nil(t, sync.ConfirmTarget("primary"))
+	progress, err = sync.Progress()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), progress["primary"].Behind)
+	assert.Equal(t, time.Duration(0), progress["primary"].Lag)
+
+	// After a restart we still know where a target had gotten to, if not when
+	restarted := NewSyncQueue(queue)
+	restarted.PersistCursors(store)
+	assert.Nil(t, restarted.RegisterTarget("archive"))
+	progress, err = restarted.Progress()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), progress["archive"].LastConfirmedID)
+	assert.True(t, progress["archive"].ConfirmedAt.IsZero())
+	assert.Equal(t, 3*time.Minute, progress["archive"].Lag)
+}
+
+func TestSyncQueueUsage(t *testing.T) {
+	os.RemoveAll("sync.queue")
+	defer os.RemoveAll("sync.queue")
+	sync, err := OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+
+	// Churning through the queue keeps it small, but goque's item numbers never stop climbing
+	for i := 0; i < 500; i++ {
+		err = sync.Enqueue(&Message{ID: uint64(i + 1), Payload: []byte{1, 2, 3}})
+		assert.Nil(t, err)
+		_, err = sync.Dequeue()
+		assert.Nil(t, err)
+	}
+	err = sync.Enqueue(&Message{ID: 500, Payload: []byte{1, 2, 3}})
+	assert.Nil(t, err)
+
+	usage, err := sync.Usage()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), usage.Length)
+	assert.Equal(t, uint64(501), usage.HighWaterMark)
+	assert.NotZero(t, usage.Bytes)
+	assert.True(t, usage.DiskBytes > usage.Bytes)
+
+	// Reopening picks up where we left off
+	sync.Close()
+	sync, err = OpenSyncQueue("sync.queue")
+	assert.Nil(t, err)
+	reopened, err := sync.Usage()
+	assert.Nil(t, err)
+	assert.Equal(t, usage.HighWaterMark, reopened.HighWaterMark)
+	assert.Equal(t, usage.Bytes, reopened.Bytes)
+
+	// Clearing only happens once the queue has drained, and starts our numbering over
+	assert.Equal(t, ErrQueueNotEmpty, sync.Clear())
+	_, err = sync.Dequeue()
+	assert.Nil(t, err)
+	assert.Nil(t, sync.Clear())
+
+	err = sync.Enqueue(&Message{ID: 501})
+	assert.Nil(t, err)
+	usage, err = sync.Usage()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), usage.HighWaterMark)
+	sync.Close()
+
+	// Backends that don't keep track just say so
+	sync = NewSyncQueue(&memoryQueue{})
+	_, err = sync.Usage()
+	assert.Equal(t, ErrQueueUnsupported, err)
+	assert.Equal(t, ErrQueueUnsupported, sync.Clear())
+}
@@ -11,6 +11,14 @@ func AccordCleanup() {
 	os.RemoveAll(SyncFilename)
 	os.RemoveAll(HistoryFilename)
 	os.RemoveAll(StateFilename)
+	os.RemoveAll(ConflictLogFilename)
+	os.RemoveAll(ReceiptLogFilename)
+	os.RemoveAll(ConfirmationsFilename)
+	os.RemoveAll(DeadLetterFilename)
+	os.RemoveAll(CursorsFilename)
+	os.RemoveAll(PendingFilename)
+	os.RemoveAll(DeferredFilename)
+	os.RemoveAll(LockFilename)
 }
 
 type DummyManager struct {
@@ -53,7 +61,7 @@ func DummyAccord() *Accord {
 		Level:     logrus.DebugLevel,
 	}
 
-	return NewAccord(NewDummerManager(), nil, "", blankLogger.WithFields(nil))
+	return NewAccord(NewDummerManager(), nil, ".", blankLogger.WithFields(nil))
 }
 
 func DummyAccordManager(manager Manager) *Accord {
@@ -61,3 +69,9 @@ func DummyAccordManager(manager Manager) *Accord {
 	accord.manager = manager
 	return accord
 }
+
+func DummyAccordComponents(components ...Component) *Accord {
+	accord := DummyAccord()
+	accord.components = components
+	return accord
+}
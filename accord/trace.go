@@ -0,0 +1,95 @@
+package accord
+
+import (
+	"math/rand"
+)
+
+// TracePath is the route a remote Message took to our decision about whether to process it
+type TracePath int
+
+const (
+	// TraceRejected means we never got as far as deciding, as the Message couldn't be handled at all (it failed its
+	// inbound transform or had a zero ID)
+	TraceRejected TracePath = iota
+
+	// TraceDuplicate means we'd already handled the Message (see Accord.Dedup), so it was dropped without a decision
+	TraceDuplicate
+
+	// TraceExpired means the Message was past its expiration, so it wasn't processed
+	TraceExpired
+
+	// TraceAligned means our state matched the Message's StateAt, so it was processed without asking our Manager
+	TraceAligned
+
+	// TraceNoHistory means our states differed but our history is disabled, so it was processed without asking
+	TraceNoHistory
+
+	// TraceResolved means our states differed and our Manager decided, having been shown our history
+	TraceResolved
+
+	// TraceDeferred means the Message depends on others we're yet to process, so it's being held back until we have
+	// (see Message.DependsOn)
+	TraceDeferred
+)
+
+func (path TracePath) String() string {
+	switch path {
+	case TraceDuplicate:
+		return "duplicate"
+	case TraceExpired:
+		return "expired"
+	case TraceAligned:
+		return "aligned"
+	case TraceNoHistory:
+		return "no history"
+	case TraceResolved:
+		return "resolved"
+	case TraceDeferred:
+		return "deferred"
+	default:
+		return "rejected"
+	}
+}
+
+// Trace records each step of how we decided what to do with a remote Message, so that a Message that was unexpectedly
+// skipped (or applied) can be explained. See HandleRemoteMessageTraced and Accord.OnTrace
+type Trace struct {
+	// MessageID is the ID of the Message, once it had been through our InboundTransformer
+	MessageID uint64
+
+	// LocalState is our state when the Message arrived, RemoteState is its StateAt, and Relation is how the two compared
+	LocalState  uint64
+	RemoteState uint64
+	Relation    StateRelation
+
+	// Path is how we came to our decision
+	Path TracePath
+
+	// Decision and Reason are what our Manager decided, and why if it said, when Path is TraceResolved. Examined is the
+	// ID of every Message from our history it looked at while deciding, in the order it looked at them, and Scanned is
+	// how many that was. TimedOut is set when it took too long and its decision was overridden (see
+	// ShouldProcessTimeout)
+	Decision bool
+	Reason   string
+	Examined []uint64
+	Scanned  uint64
+	TimedOut bool
+
+	// Processed is set when our Manager went on to process the Message, and Buffered when it would have but we're paused
+	Processed bool
+	Buffered  bool
+}
+
+// HandleRemoteMessageTraced is HandleRemoteMessage, returning a Trace of each step of our decision along the way. It's
+// meant for debugging: noting every Message of our history our Manager looks at isn't free, so use OnTrace with a
+// TraceSampleRate to trace in production
+func (accord *Accord) HandleRemoteMessageTraced(msg *Message) (Trace, error) {
+	trace := Trace{}
+	_, err := accord.handleRemote(msg, &trace)
+	return trace, err
+}
+
+// sampleTrace decides whether the next remote Message should be traced for OnTrace
+func (accord *Accord) sampleTrace() bool {
+	return accord.TraceSampleRate > 0 && rand.Float64() < accord.TraceSampleRate
+}
@@ -0,0 +1,103 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordTraced(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	manager := &DummyManager{ShouldProcessRet: true}
+	accord := DummyAccordManager(manager)
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Our states match, so our Manager isn't asked
+	trace, err := accord.HandleRemoteMessageTraced(&Message{ID: 1, StateAt: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, TraceAligned, trace.Path)
+	assert.Equal(t, StateAligned, trace.Relation)
+	assert.True(t, trace.Processed)
+	assert.Equal(t, 0, manager.ShouldProcessCount)
+
+	// Diverged, and our Manager says yes having looked through our history
+	trace, err = accord.HandleRemoteMessageTraced(&Message{ID: 2, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), trace.MessageID)
+	assert.Equal(t, uint64(1), trace.LocalState)
+	assert.Equal(t, uint64(100), trace.RemoteState)
+	assert.Equal(t, StateDiverged, trace.Relation)
+	assert.Equal(t, TraceResolved, trace.Path)
+	assert.True(t, trace.Decision)
+	assert.True(t, trace.Processed)
+	assert.Equal(t, 1, manager.ShouldProcessCount)
+
+	// And no
+	manager.ShouldProcessRet = false
+	trace, err = accord.HandleRemoteMessageTraced(&Message{ID: 3, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, TraceResolved, trace.Path)
+	assert.False(t, trace.Decision)
+	assert.False(t, trace.Processed)
+	assert.Equal(t, 2, manager.ShouldProcessCount)
+}
+
+// scanningManager looks through all of our history before deciding
+type scanningManager struct {
+	DummyManager
+}
+
+func (manager *scanningManager) ShouldProcessWithReason(msg Message, history *HistoryIterator) (bool, string) {
+	for {
+		previous, _ := history.Next()
+		if previous == nil {
+			return false, "scanned everything"
+		}
+	}
+}
+
+func TestAccordTraceExamined(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	accord := DummyAccordManager(&scanningManager{})
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 2}))
+
+	trace, err := accord.HandleRemoteMessageTraced(&Message{ID: 3, StateAt: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 1}, trace.Examined)
+	assert.Equal(t, uint64(2), trace.Scanned)
+	assert.Equal(t, "scanned everything", trace.Reason)
+	assert.False(t, trace.Processed)
+}
+
+func TestAccordTraceSampling(t *testing.T) {
+	defer AccordCleanup()
+	AccordCleanup()
+
+	traces := []Trace{}
+	accord := DummyAccord()
+	accord.OnTrace = func(trace Trace) { traces = append(traces, trace) }
+	err := accord.Start()
+	assert.Nil(t, err)
+	defer accord.Stop()
+
+	// Nothing is sampled until we've a rate
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 1}))
+	assert.Empty(t, traces)
+
+	accord.TraceSampleRate = 1
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, StateAt: 1}))
+	assert.Len(t, traces, 1)
+	assert.Equal(t, uint64(2), traces[0].MessageID)
+	assert.Equal(t, TraceAligned, traces[0].Path)
+}
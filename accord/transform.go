@@ -0,0 +1,60 @@
+package accord
+
+// OutboundTransformer reshapes Messages on their way out to a peer (stripping internal details, re-encrypting with a
+// key only that peer holds, adding routing metadata, etc...). It's handed a copy of each Message just before it's
+// serialized for the wire, along with the name of the peer it's being sent to, and whatever it returns is what the peer
+// receives. The copy in our sync queue is never touched, and the peer's acknowledgement of the transformed Message
+// confirms the queued one. A transformed Message should keep its ID and StateAt, as our peer relies on both to stay
+// aligned with us (and a changed Payload will fail ID verification, see Accord.VerifyMessageIDs)
+type OutboundTransformer interface {
+	Transform(msg Message, peer string) (Message, error)
+}
+
+// OutboundFunc lets a plain function be used as an OutboundTransformer
+type OutboundFunc func(msg Message, peer string) (Message, error)
+
+// Transform implements OutboundTransformer
+func (fn OutboundFunc) Transform(msg Message, peer string) (Message, error) {
+	return fn(msg, peer)
+}
+
+// InboundTransformer is the counterpart to OutboundTransformer, reshaping each remote Message before
+// HandleRemoteMessage does anything with it. It may be called concurrently
+type InboundTransformer interface {
+	Transform(msg Message) (Message, error)
+}
+
+// InboundFunc lets a plain function be used as an InboundTransformer
+type InboundFunc func(msg Message) (Message, error)
+
+// Transform implements InboundTransformer
+func (fn InboundFunc) Transform(msg Message) (Message, error) {
+	return fn(msg)
+}
+
+// TransformOutbound returns what should be sent to peer in place of msg, as decided by our OutboundTransformer. msg is
+// returned as is when we don't have one, and is never modified
+func (accord *Accord) TransformOutbound(msg *Message, peer string) (*Message, error) {
+	if accord.OutboundTransformer == nil {
+		return msg, nil
+	}
+
+	transformed, err := accord.OutboundTransformer.Transform(msg.copy(), peer)
+	if err != nil {
+		return nil, err
+	}
+	return &transformed, nil
+}
+
+// transformInbound is TransformOutbound for remote Messages, using our InboundTransformer
+func (accord *Accord) transformInbound(msg *Message) (*Message, error) {
+	if accord.InboundTransformer == nil {
+		return msg, nil
+	}
+
+	transformed, err := accord.InboundTransformer.Transform(msg.copy())
+	if err != nil {
+		return nil, err
+	}
+	return &transformed, nil
+}
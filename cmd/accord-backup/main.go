@@ -0,0 +1,53 @@
+// Command accord-backup copies a stopped Accord node's data directory into a single portable archive, which
+// accord-restore turns back into a data directory. Usage:
+//
+//	accord-backup <dataDir> <outfile>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: accord-backup <dataDir> <outfile>")
+		os.Exit(2)
+	}
+
+	err := backup(os.Args[1], os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup failed:", err)
+		os.Exit(1)
+	}
+}
+
+// backup writes the archive to a temporary file alongside outfile, only moving it into place once it's complete
+func backup(dataDir, outfile string) error {
+	out, err := os.Create(outfile + ".partial")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	err = accord.BackupDataDir(dataDir, out, accord.Backends{})
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), outfile)
+}
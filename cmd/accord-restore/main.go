@@ -0,0 +1,35 @@
+// Command accord-restore recreates an Accord data directory from an archive written by accord-backup. The data
+// directory must not already hold any Accord data. Usage:
+//
+//	accord-restore <infile> <dataDir>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: accord-restore <infile> <dataDir>")
+		os.Exit(2)
+	}
+
+	err := restore(os.Args[1], os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore failed:", err)
+		os.Exit(1)
+	}
+}
+
+func restore(infile, dataDir string) error {
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return accord.RestoreDataDir(in, dataDir, accord.Backends{})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/cj-dimaggio/accord/components"
+)
+
+// duration lets our config files spell out durations the way Go does ("500ms", "2s") rather than in nanoseconds
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return fmt.Errorf("durations must be strings such as \"500ms\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+// Config describes an entire Accord daemon
+type Config struct {
+	// DataDir is where Accord stores its data
+	DataDir string `json:"dataDir"`
+
+	// LogLevel is one of logrus' levels ("debug", "info", "warning", etc...). Defaults to "info"
+	LogLevel string `json:"logLevel"`
+
+	// IdleShutdown stops the daemon, exiting cleanly, once it has been idle this long (see
+	// accord.Accord.IdleShutdownTimeout). Leave it out to run until stopped
+	IdleShutdown duration `json:"idleShutdown"`
+
+	// ProcessingConfirmations records which of our Messages our peers confirm they processed (see
+	// accord.Accord.ProcessingConfirmations)
+	ProcessingConfirmations bool `json:"processingConfirmations"`
+
+	// SchedulerSlots shares processing out between our PollListeners, PollRequestors and Gossip components by their
+	// weight (see accord.Accord.SchedulerSlots). Leave it out to let every component tick as fast as it likes
+	SchedulerSlots int `json:"schedulerSlots"`
+
+	// Manager decides what happens to the Messages we process
+	Manager ManagerConfig `json:"manager"`
+
+	// Components are the components to run, in the order they should be started
+	Components []ComponentConfig `json:"components"`
+}
+
+// ManagerConfig configures our webhookManager
+type ManagerConfig struct {
+	// Webhook is the URL every processed Message is POSTed to. If it's empty Messages are only logged
+	Webhook string `json:"webhook"`
+
+	// OnConflict is what we do with a remote Message that arrives while we're diverged, either "process" or "skip"
+	// (the default)
+	OnConflict string `json:"onConflict"`
+}
+
+// ComponentConfig holds the settings for a single component. Type picks the component, and only the settings that
+// apply to it are used
+type ComponentConfig struct {
+	// Type is one of "WebReceiver", "PollListener", "PollRequestor" or "Gossip"
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	// WebReceiver
+	BindAddress     string   `json:"bindAddress"`
+	ShutdownTimeout duration `json:"shutdownTimeout"`
+
+	// PollListener and PollRequestor
+	Address       string   `json:"address"`
+	Bind          bool     `json:"bind"`
+	ListenTimeout duration `json:"listenTimeout"`
+	SendTimeout   duration `json:"sendTimeout"`
+	Handshake     bool     `json:"handshake"`
+
+	// PollListener
+	Target   string `json:"target"`
+	LongPoll bool   `json:"longPoll"`
+
+	// PollRequestor
+	WaitOnEmpty       duration `json:"waitOnEmpty"`
+	ConfirmProcessing bool     `json:"confirmProcessing"`
+
+	// PollListener, PollRequestor and Gossip
+	Weight int `json:"weight"` // share of our scheduler, see accord.ComponentRunner.Schedule
+
+	// Gossip
+	NodeID   string   `json:"nodeID"`
+	Peers    []string `json:"peers"`
+	Interval duration `json:"interval"`
+}
+
+// LoadConfig reads a JSON config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+
+	if config.DataDir == "" {
+		return nil, fmt.Errorf("%s doesn't set a dataDir", path)
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = "info"
+	}
+
+	return config, nil
+}
+
+// Build turns a ComponentConfig into the component it describes
+func (config ComponentConfig) Build() (accord.Component, error) {
+	switch config.Type {
+	case "WebReceiver":
+		return &components.WebReceiver{
+			BindAddress:     config.BindAddress,
+			ShutdownTimeout: time.Duration(config.ShutdownTimeout),
+		}, nil
+	}
+
+	// Everything else needs ZeroMQ, which may have been left out of this build
+	return buildZMQComponent(config)
+}
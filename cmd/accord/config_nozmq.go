@@ -0,0 +1,20 @@
+//go:build noZMQ
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+// buildZMQComponent stands in for the components this build left out
+func buildZMQComponent(config ComponentConfig) (accord.Component, error) {
+	switch config.Type {
+	case "PollListener", "PollRequestor", "Gossip":
+		return nil, fmt.Errorf("%s needs ZeroMQ, which this build of accord was built without (the noZMQ tag)",
+			config.Type)
+	}
+
+	return nil, fmt.Errorf("unknown component type %q", config.Type)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/cj-dimaggio/accord/components"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "accord-config")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	_, err = file.WriteString(contents)
+	assert.Nil(t, err)
+	return file.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"dataDir": "data",
+		"components": [
+			{"type": "WebReceiver", "bindAddress": "127.0.0.1:8080", "shutdownTimeout": "500ms"}
+		]
+	}`)
+	defer os.Remove(path)
+
+	config, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", config.DataDir)
+	assert.Equal(t, "info", config.LogLevel)
+	assert.Len(t, config.Components, 1)
+
+	comp, err := config.Components[0].Build()
+	assert.Nil(t, err)
+	receiver, ok := comp.(*components.WebReceiver)
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:8080", receiver.BindAddress)
+	assert.Equal(t, 500*time.Millisecond, receiver.ShutdownTimeout)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	_, err := LoadConfig("does-not-exist.json")
+	assert.NotNil(t, err)
+
+	path := writeConfig(t, `{"components": []}`)
+	defer os.Remove(path)
+	_, err = LoadConfig(path)
+	assert.NotNil(t, err)
+
+	badDuration := writeConfig(t, `{"dataDir": "data", "components": [{"type": "PollListener", "listenTimeout": 500}]}`)
+	defer os.Remove(badDuration)
+	_, err = LoadConfig(badDuration)
+	assert.NotNil(t, err)
+
+	_, err = ComponentConfig{Type: "Carrier Pigeon"}.Build()
+	assert.NotNil(t, err)
+}
+
+func TestWebhookManagerOnConflict(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+
+	_, err := newWebhookManager(ManagerConfig{OnConflict: "sometimes"}, log)
+	assert.NotNil(t, err)
+
+	manager, err := newWebhookManager(ManagerConfig{}, log)
+	assert.Nil(t, err)
+	assert.False(t, manager.ShouldProcess(accord.Message{}, nil))
+
+	manager, err = newWebhookManager(ManagerConfig{OnConflict: "process"}, log)
+	assert.Nil(t, err)
+	assert.True(t, manager.ShouldProcess(accord.Message{}, nil))
+}
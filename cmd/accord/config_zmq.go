@@ -0,0 +1,58 @@
+//go:build !noZMQ
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/cj-dimaggio/accord/components"
+)
+
+// buildZMQComponent builds the components that need ZeroMQ
+func buildZMQComponent(config ComponentConfig) (accord.Component, error) {
+	switch config.Type {
+	case "PollListener":
+		listener := &components.PollListener{
+			Name:          config.Name,
+			Address:       config.Address,
+			Bind:          config.Bind,
+			ListenTimeout: time.Duration(config.ListenTimeout),
+			SendTimeout:   time.Duration(config.SendTimeout),
+			Handshake:     config.Handshake,
+			Target:        config.Target,
+			LongPoll:      config.LongPoll,
+		}
+		listener.Schedule(config.Weight)
+		return listener, nil
+
+	case "PollRequestor":
+		requestor := &components.PollRequestor{
+			Name:              config.Name,
+			Address:           config.Address,
+			Bind:              config.Bind,
+			ListenTimeout:     time.Duration(config.ListenTimeout),
+			SendTimeout:       time.Duration(config.SendTimeout),
+			Handshake:         config.Handshake,
+			WaitOnEmpty:       time.Duration(config.WaitOnEmpty),
+			ConfirmProcessing: config.ConfirmProcessing,
+		}
+		requestor.Schedule(config.Weight)
+		return requestor, nil
+
+	case "Gossip":
+		gossip := &components.GossipComponent{
+			Name:          config.Name,
+			NodeID:        config.NodeID,
+			Address:       config.Address,
+			Peers:         config.Peers,
+			Interval:      time.Duration(config.Interval),
+			ListenTimeout: time.Duration(config.ListenTimeout),
+		}
+		gossip.Schedule(config.Weight)
+		return gossip, nil
+	}
+
+	return nil, fmt.Errorf("unknown component type %q", config.Type)
+}
@@ -0,0 +1,101 @@
+// Command accord runs Accord as a standalone daemon, wired together from a JSON config file. Processed Messages are
+// handed to a webhook, so that applications that can't embed Accord as a library can still use it. See
+// accord.sample.json for an example config
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/sirupsen/logrus"
+)
+
+// Our exit codes, so that whatever supervises us can decide whether restarting is worthwhile
+const (
+	exitOK = 0
+
+	// exitFailure is for anything that's unlikely to go away by itself
+	exitFailure = 1
+
+	// exitConfig means we were never able to start
+	exitConfig = 78
+
+	// exitTemporary is for network trouble, which is worth retrying
+	exitTemporary = 75
+)
+
+func main() {
+	configPath := flag.String("config", "accord.json", "path to our JSON config file")
+	flag.Parse()
+
+	os.Exit(run(*configPath))
+}
+
+// run starts Accord as described by the config at configPath and blocks until it stops, returning our exit code
+func run(configPath string) int {
+	logger := logrus.New()
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		logger.WithError(err).Error("Unable to load config")
+		return exitConfig
+	}
+
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		logger.WithError(err).Error("Invalid log level")
+		return exitConfig
+	}
+	logger.Level = level
+	log := logrus.NewEntry(logger)
+
+	comps := []accord.Component{}
+	for i, compConfig := range config.Components {
+		comp, err := compConfig.Build()
+		if err != nil {
+			log.WithError(err).WithField("component", i).Error("Invalid component")
+			return exitConfig
+		}
+		comps = append(comps, comp)
+	}
+
+	manager, err := newWebhookManager(config.Manager, log)
+	if err != nil {
+		log.WithError(err).Error("Invalid manager")
+		return exitConfig
+	}
+
+	options := []accord.Option{accord.WithIdleShutdown(time.Duration(config.IdleShutdown))}
+	if config.SchedulerSlots > 0 {
+		options = append(options, accord.WithScheduler(config.SchedulerSlots))
+	}
+	if config.ProcessingConfirmations {
+		options = append(options, accord.WithProcessingConfirmations())
+	}
+
+	acrd := accord.NewAccord(manager, comps, config.DataDir, log, options...)
+	err = acrd.Start(os.Interrupt, syscall.SIGTERM)
+	if err != nil {
+		log.WithError(err).Error("Unable to start Accord")
+		return exitFailure
+	}
+
+	return exitCode(acrd.Listen())
+}
+
+// exitCode picks our exit code for the error Listen returned
+func exitCode(err error) int {
+	if reason, ok := err.(*accord.ShutdownReason); err == nil || ok && reason.Category == accord.ShutdownIdle {
+		return exitOK
+	}
+
+	fmt.Fprintln(os.Stderr, "accord:", err)
+	if reason, ok := err.(*accord.ShutdownReason); ok && reason.Category == accord.ShutdownNetwork {
+		return exitTemporary
+	}
+	return exitFailure
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/cj-dimaggio/accord/components"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookManager hands every Message we process to an HTTP endpoint, which is where a standalone Accord's application
+// logic lives. Without a webhook Messages are only logged, which is handy for trying things out
+type webhookManager struct {
+	config ManagerConfig
+	policy *components.RetryPolicy
+	log    *logrus.Entry
+}
+
+func newWebhookManager(config ManagerConfig, log *logrus.Entry) (*webhookManager, error) {
+	if config.OnConflict == "" {
+		config.OnConflict = "skip"
+	}
+	if config.OnConflict != "skip" && config.OnConflict != "process" {
+		return nil, fmt.Errorf("unknown onConflict %q, expected \"process\" or \"skip\"", config.OnConflict)
+	}
+
+	return &webhookManager{
+		config: config,
+		policy: &components.RetryPolicy{},
+		log:    log.WithField("manager", "webhook"),
+	}, nil
+}
+
+// Process POSTs the Message's payload to our webhook, along with where it came from. A webhook that can't be reached
+// (even after retrying) is returned as an error, which shuts Accord down rather than letting it fall out of step
+func (manager *webhookManager) Process(msg accord.Message, fromRemote bool) error {
+	log := manager.log.WithField("id", msg.ID).WithField("remote", fromRemote)
+	if manager.config.Webhook == "" {
+		log.WithField("payload", string(msg.Payload)).Info("Processed message")
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", manager.config.Webhook, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Accord-Message-Id", strconv.FormatUint(msg.ID, 10))
+	req.Header.Set("X-Accord-Remote", strconv.FormatBool(fromRemote))
+	if msg.Type != "" {
+		req.Header.Set("X-Accord-Message-Type", msg.Type)
+	}
+
+	resp, err := components.DoWithRetry(req, manager.policy)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	log.Debug("Delivered message to webhook")
+	return nil
+}
+
+// ShouldProcess resolves conflicts according to our OnConflict setting
+func (manager *webhookManager) ShouldProcess(msg accord.Message, history *accord.HistoryIterator) bool {
+	return manager.config.OnConflict == "process"
+}
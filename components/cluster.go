@@ -0,0 +1,22 @@
+package components
+
+import (
+	"time"
+)
+
+// PeerStatus is what a node tells its peers about itself through gossip
+type PeerStatus struct {
+	NodeID         string
+	State          uint64
+	ToBeSyncedSize uint64
+
+	// LastSeen is when we last heard from the node (for ourselves, when we last published)
+	LastSeen time.Time
+}
+
+// ClusterView is implemented by components that collect a view of the whole cluster, such as GossipComponent. It's
+// what WebReceiver's /cluster endpoint reports on, and lets it do so without depending on any one transport
+type ClusterView interface {
+	// Cluster returns the latest status of every node we know of, ordered by node ID
+	Cluster() []PeerStatus
+}
@@ -0,0 +1,290 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	zmq "github.com/pebbe/zmq4"
+	"github.com/sirupsen/logrus"
+)
+
+// gossipKind is the first frame of every gossip message, and what our subscriptions filter on
+const gossipKind = "gossip"
+
+// GossipComponent periodically broadcasts a tiny status frame (our node ID, state and sync queue depth) to our peers
+// and collects theirs, building up a view of the whole cluster. This is entirely separate from message
+// synchronization and is purely for observability and coordination, such as working out who is furthest ahead. Frames
+// are sent over a ZeroMQ PUB socket that we bind, and collected over a SUB socket connected to each of our peers.
+//
+// When Accord's ReconcileInterval is set we also reconcile our state against each peer's on that interval, which keeps
+// our history pruned even when no Messages are flowing between us.
+//
+// Gossip is lossy by design: a frame that doesn't make it is simply superseded by the next one
+type GossipComponent struct {
+	accord.ComponentRunner
+
+	// Name identifies this component so that it can be looked up and managed at runtime. Defaults to "Gossip"
+	Name string
+
+	// NodeID is how we identify ourselves to our peers. Defaults to our hostname
+	NodeID string
+
+	// Address is the ZeroMQ address we publish our status on. This must follow the ZMQ addressing schema
+	// (transport://endpoint)
+	Address string
+
+	// Peers are the addresses of every peer we should collect status from
+	Peers []string
+
+	// Interval is how often we publish our status. Defaults to 5 seconds
+	Interval time.Duration
+
+	// ListenTimeout is how long we wait for a peer's frame before checking whether it's time to publish again (and
+	// whether we've been stopped). Defaults to 500ms
+	ListenTimeout time.Duration
+
+	pub *zmq.Socket
+	sub *zmq.Socket
+	log *logrus.Entry
+
+	lastSent time.Time
+
+	// lastReconciled is when we last reconciled our state against our peers'
+	lastReconciled time.Time
+
+	// cluster is our view of every node we've heard from, including ourselves, keyed by node ID
+	cluster     map[string]PeerStatus
+	clusterLock *sync.Mutex
+}
+
+// Start creates and connects our sockets and begins gossiping
+func (gossip *GossipComponent) Start(acrd *accord.Accord) (err error) {
+	if gossip.Name == "" {
+		gossip.Name = "Gossip"
+	}
+	gossip.log = acrd.Logger.WithField("component", gossip.Name)
+
+	if gossip.NodeID == "" {
+		gossip.NodeID, err = os.Hostname()
+		if err != nil {
+			gossip.log.WithError(err).Error("Could not determine our node ID")
+			return err
+		}
+	}
+	if gossip.Interval == 0 {
+		gossip.Interval = 5 * time.Second
+	}
+	if gossip.ListenTimeout == 0 {
+		gossip.ListenTimeout = 500 * time.Millisecond
+	}
+
+	gossip.cluster = map[string]PeerStatus{}
+	gossip.clusterLock = &sync.Mutex{}
+
+	gossip.log.WithField("address", gossip.Address).WithField("peers", gossip.Peers).Info("Starting Gossip")
+	err = checkZMQ()
+	if err != nil {
+		gossip.log.WithError(err).Error("ZeroMQ is unavailable")
+		return err
+	}
+	gossip.pub, err = zmq.NewSocket(zmq.PUB)
+	if err != nil {
+		gossip.log.WithError(err).Error("Could not create ZeroMQ socket")
+		return zmqInitError(err)
+	}
+	err = gossip.pub.Bind(gossip.Address)
+	if err != nil {
+		gossip.log.WithError(err).WithField("Address", gossip.Address).Error("Could not bind ZeroMQ socket")
+		return err
+	}
+	err = gossip.pub.SetLinger(0)
+	if err != nil {
+		gossip.log.WithError(err).Error("Could not set ZeroMQ linger timeout")
+		return err
+	}
+
+	gossip.sub, err = zmq.NewSocket(zmq.SUB)
+	if err != nil {
+		gossip.log.WithError(err).Error("Could not create ZeroMQ socket")
+		return zmqInitError(err)
+	}
+	for _, peer := range gossip.Peers {
+		err = gossip.sub.Connect(peer)
+		if err != nil {
+			gossip.log.WithError(err).WithField("Address", peer).Error("Could not connect ZeroMQ socket")
+			return err
+		}
+	}
+	err = gossip.sub.SetSubscribe(gossipKind)
+	if err != nil {
+		gossip.log.WithError(err).Error("Could not subscribe ZeroMQ socket")
+		return err
+	}
+	err = gossip.sub.SetRcvtimeo(gossip.ListenTimeout)
+	if err != nil {
+		gossip.log.WithError(err).Error("Could not set ZeroMQ receive timeout")
+		return err
+	}
+
+	gossip.ComponentRunner.Init(acrd, gossip.tick, gossip.cleanup, gossip.log)
+	return nil
+}
+
+// ComponentName implements accord.NamedComponent
+func (gossip *GossipComponent) ComponentName() string {
+	return gossip.Name
+}
+
+// Describe implements accord.DescribedComponent
+func (gossip *GossipComponent) Describe() accord.ComponentInfo {
+	health := gossip.Health()
+	return accord.ComponentInfo{
+		Name:    gossip.Name,
+		Type:    fmt.Sprintf("%T", gossip),
+		Address: gossip.Address,
+		Health:  &health,
+		Config: map[string]string{
+			"NodeID":        gossip.NodeID,
+			"Peers":         strings.Join(gossip.Peers, ","),
+			"Interval":      gossip.Interval.String(),
+			"ListenTimeout": gossip.ListenTimeout.String(),
+		},
+	}
+}
+
+// cleanup closes our sockets
+func (gossip *GossipComponent) cleanup(*accord.Accord) {
+	err := gossip.pub.Close()
+	if err != nil {
+		gossip.log.WithError(err).Warn("Error closing ZeroMQ socket")
+	}
+	err = gossip.sub.Close()
+	if err != nil {
+		gossip.log.WithError(err).Warn("Error closing ZeroMQ socket")
+	}
+}
+
+// tick publishes our status whenever our interval has passed, and otherwise waits on our peers' frames
+func (gossip *GossipComponent) tick(acrd *accord.Accord) {
+	if time.Since(gossip.lastSent) >= gossip.Interval {
+		gossip.publish(acrd)
+	}
+	if acrd.ReconcileInterval > 0 && time.Since(gossip.lastReconciled) >= acrd.ReconcileInterval {
+		gossip.reconcile(acrd)
+	}
+
+	data, err := gossip.sub.RecvMessageBytes(0)
+	if err != nil {
+		gossip.ExpectedOrShutdown(err, ZMQTimeout)
+		return
+	}
+
+	status, ok := decodeGossip(data)
+	if !ok {
+		gossip.log.WithField("frames", len(data)).Warn("Received a malformed gossip frame")
+		return
+	}
+	if status.NodeID == gossip.NodeID {
+		// A peer with our name is almost certainly a misconfiguration, and would make our view nonsense
+		gossip.log.Warn("Received gossip from a peer using our own node ID")
+		return
+	}
+
+	gossip.update(status)
+}
+
+// publish sends our current status out to our peers, and records it in our own view
+func (gossip *GossipComponent) publish(acrd *accord.Accord) {
+	gossip.lastSent = time.Now()
+
+	status := acrd.Status()
+	self := PeerStatus{
+		NodeID:         gossip.NodeID,
+		State:          status.State,
+		ToBeSyncedSize: status.ToBeSyncedSize,
+		LastSeen:       gossip.lastSent.UTC(),
+	}
+	gossip.update(self)
+
+	// Nobody may be listening, and that's fine. Our next publish will try again
+	_, err := gossip.pub.SendMessage(encodeGossip(self)...)
+	if err != nil {
+		gossip.log.WithError(err).Debug("Could not publish our status")
+	}
+}
+
+// reconcile hands the state of every peer we've heard from since we last reconciled to Accord, so that it can prune its
+// history if we're aligned. Peers we haven't heard from are skipped rather than reconciled against stale news
+func (gossip *GossipComponent) reconcile(acrd *accord.Accord) {
+	since := gossip.lastReconciled
+	gossip.lastReconciled = time.Now()
+
+	for _, peer := range gossip.Cluster() {
+		if peer.NodeID == gossip.NodeID || peer.LastSeen.Before(since) {
+			continue
+		}
+
+		_, err := acrd.Reconcile(peer.NodeID, peer.State)
+		if err != nil {
+			// Accord has already taken care of shutting itself down
+			gossip.log.WithError(err).WithField("peer", peer.NodeID).Error("Could not reconcile with peer")
+			return
+		}
+	}
+}
+
+// update records a node's status in our view of the cluster
+func (gossip *GossipComponent) update(status PeerStatus) {
+	gossip.clusterLock.Lock()
+	defer gossip.clusterLock.Unlock()
+
+	gossip.cluster[status.NodeID] = status
+}
+
+// Cluster returns the latest status we have for every node we've heard from, including ourselves, ordered by node ID
+func (gossip *GossipComponent) Cluster() []PeerStatus {
+	gossip.clusterLock.Lock()
+	defer gossip.clusterLock.Unlock()
+
+	cluster := make([]PeerStatus, 0, len(gossip.cluster))
+	for _, status := range gossip.cluster {
+		cluster = append(cluster, status)
+	}
+	sort.Slice(cluster, func(i, j int) bool {
+		return cluster[i].NodeID < cluster[j].NodeID
+	})
+	return cluster
+}
+
+// encodeGossip builds the frames of a gossip message: "gossip", our node ID, and then our state and queue depth as
+// little endian uint64s
+func encodeGossip(status PeerStatus) []interface{} {
+	state := make([]byte, 8)
+	binary.LittleEndian.PutUint64(state, status.State)
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, status.ToBeSyncedSize)
+
+	return []interface{}{gossipKind, status.NodeID, state, size}
+}
+
+// decodeGossip parses the frames built by encodeGossip, returning false if they're malformed. LastSeen is set to now
+func decodeGossip(data [][]byte) (PeerStatus, bool) {
+	if len(data) != 4 || string(data[0]) != gossipKind || len(data[1]) == 0 || len(data[2]) != 8 || len(data[3]) != 8 {
+		return PeerStatus{}, false
+	}
+
+	return PeerStatus{
+		NodeID:         string(data[1]),
+		State:          binary.LittleEndian.Uint64(data[2]),
+		ToBeSyncedSize: binary.LittleEndian.Uint64(data[3]),
+		LastSeen:       time.Now().UTC(),
+	}, true
+}
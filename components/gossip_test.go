@@ -0,0 +1,133 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGossipFrames(t *testing.T) {
+	status := PeerStatus{NodeID: "a", State: 10, ToBeSyncedSize: 3}
+
+	var data [][]byte
+	for _, frame := range encodeGossip(status) {
+		switch frame := frame.(type) {
+		case string:
+			data = append(data, []byte(frame))
+		case []byte:
+			data = append(data, frame)
+		}
+	}
+
+	decoded, ok := decodeGossip(data)
+	assert.True(t, ok)
+	assert.Equal(t, "a", decoded.NodeID)
+	assert.Equal(t, uint64(10), decoded.State)
+	assert.Equal(t, uint64(3), decoded.ToBeSyncedSize)
+
+	_, ok = decodeGossip(data[:3])
+	assert.False(t, ok)
+	_, ok = decodeGossip([][]byte{data[0], {}, data[2], data[3]})
+	assert.False(t, ok)
+}
+
+func TestGossipComponent(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	first := &GossipComponent{
+		Name:          "Gossip",
+		NodeID:        "first",
+		Address:       "inproc://gossipFirst",
+		Peers:         []string{"inproc://gossipSecond"},
+		Interval:      time.Millisecond,
+		ListenTimeout: time.Millisecond,
+	}
+	second := &GossipComponent{
+		Name:          "SecondGossip",
+		NodeID:        "second",
+		Address:       "inproc://gossipSecond",
+		Peers:         []string{"inproc://gossipFirst"},
+		Interval:      time.Millisecond,
+		ListenTimeout: time.Millisecond,
+	}
+	receiver := &WebReceiver{}
+
+	acrd := accord.DummyAccordComponents(first, second, receiver)
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// Give our frames a chance to make it across
+	deadline := time.Now().Add(time.Second)
+	for len(first.Cluster()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	cluster := first.Cluster()
+	assert.Len(t, cluster, 2)
+	assert.Equal(t, "first", cluster[0].NodeID)
+	assert.Equal(t, "second", cluster[1].NodeID)
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/cluster?name=missing", nil))
+	assert.Equal(t, 404, resp.Code)
+
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/cluster", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	var view []PeerStatus
+	err = json.Unmarshal(resp.Body.Bytes(), &view)
+	assert.Nil(t, err)
+	assert.Len(t, view, 2)
+}
+
+func TestGossipReconcile(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	results := map[string]bool{}
+	acrd := accord.DummyAccord()
+	acrd.OnReconcile = func(result accord.ReconcileResult) {
+		results[result.Peer] = result.Aligned
+	}
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = acrd.HandleNewMessage(&accord.Message{ID: 7})
+	assert.Nil(t, err)
+
+	gossip := &GossipComponent{
+		NodeID:      "self",
+		cluster:     map[string]PeerStatus{},
+		clusterLock: &sync.Mutex{},
+		log:         acrd.Logger,
+	}
+	gossip.update(PeerStatus{NodeID: "self", State: 7, LastSeen: time.Now()})
+	gossip.update(PeerStatus{NodeID: "aligned", State: 7, LastSeen: time.Now()})
+	gossip.update(PeerStatus{NodeID: "diverged", State: 3, LastSeen: time.Now()})
+
+	gossip.reconcile(acrd)
+	assert.Equal(t, map[string]bool{"aligned": true, "diverged": false}, results)
+	assert.Equal(t, uint64(0), acrd.Status().HistorySize)
+
+	// Peers we haven't heard from since aren't reconciled again
+	results = map[string]bool{}
+	gossip.update(PeerStatus{NodeID: "diverged", State: 3, LastSeen: time.Now()})
+	gossip.reconcile(acrd)
+	assert.Equal(t, map[string]bool{"diverged": false}, results)
+}
@@ -1,10 +1,48 @@
+//go:build !noZMQ
+
 package components
 
 import (
+	"fmt"
 	"syscall"
 
 	zmq "github.com/pebbe/zmq4"
 )
 
+// Our ZeroMQ components (PollListener, PollRequestor and GossipComponent, along with QueueClient) link against libzmq
+// through cgo, and are left out entirely when built with the noZMQ tag. See the README for more
+
 // ZMQTimeout represents a timeout from ZeroMQ
 var ZMQTimeout = zmq.Errno(syscall.EAGAIN)
+
+// ZMQInitError is returned by our ZeroMQ components when ZeroMQ itself can't be set up, which almost always means the
+// libzmq found at runtime isn't one we can use
+type ZMQInitError struct {
+	Err error
+}
+
+// Error implements error, pointing at what's likely missing
+func (err *ZMQInitError) Error() string {
+	return fmt.Sprintf("could not initialize ZeroMQ (%v): the poll and gossip components need libzmq 4.x installed, "+
+		"or Accord can be built with the noZMQ tag and WebReceiver used in their place", err.Err)
+}
+
+// Unwrap returns the underlying error
+func (err *ZMQInitError) Unwrap() error {
+	return err.Err
+}
+
+// checkZMQ makes sure the libzmq we've loaded is one zmq4 supports. Our build pins the headers, but the shared library
+// can be swapped out from under us at runtime
+func checkZMQ() error {
+	major, minor, patch := zmq.Version()
+	if major != 4 {
+		return &ZMQInitError{Err: fmt.Errorf("found libzmq %d.%d.%d", major, minor, patch)}
+	}
+	return nil
+}
+
+// zmqInitError wraps an error creating a ZeroMQ context or socket, which only fails when ZeroMQ can't be set up
+func zmqInitError(err error) error {
+	return &ZMQInitError{Err: err}
+}
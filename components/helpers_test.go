@@ -0,0 +1,22 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckZMQ(t *testing.T) {
+	// Our tests couldn't be running if libzmq was missing entirely, so it had better be one we support
+	assert.Nil(t, checkZMQ())
+
+	cause := errors.New("no such file")
+	err := zmqInitError(cause)
+	assert.True(t, errors.Is(err, cause))
+	assert.True(t, strings.Contains(err.Error(), "libzmq"))
+	assert.True(t, strings.Contains(err.Error(), "noZMQ"))
+}
@@ -0,0 +1,218 @@
+package components
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by DoWithRetry when the policy's circuit breaker is open and we're refusing to hit
+// the endpoint at all
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState describes whether a RetryPolicy's circuit breaker is letting requests through
+type CircuitState string
+
+const (
+	// CircuitClosed means requests are flowing normally
+	CircuitClosed CircuitState = "closed"
+
+	// CircuitOpen means we've seen too many consecutive failures and are refusing requests until the cooldown passes
+	CircuitOpen CircuitState = "open"
+
+	// CircuitHalfOpen means the cooldown has passed and we're letting a single request through to see if the endpoint
+	// has recovered
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// RetryPolicy describes how outbound HTTP requests made by our components should be retried, and carries the
+// circuit breaker state for a single downstream endpoint. Any component that talks HTTP to the outside world
+// should own one of these (per endpoint) and send its requests through DoWithRetry, so that a single dead
+// downstream can't back us up by having every request retried forever.
+//
+// A RetryPolicy must not be copied after first use
+type RetryPolicy struct {
+	// Client is the HTTP client used to perform requests. Defaults to http.DefaultClient
+	Client *http.Client
+
+	// MaxAttempts is the total number of times we'll try a request before giving up. Defaults to 3
+	MaxAttempts int
+
+	// BaseDelay is how long we wait before our first retry, doubling every attempt after that. Defaults to 100ms
+	BaseDelay time.Duration
+
+	// MaxDelay caps how long we'll ever wait between two attempts. Defaults to 10s
+	MaxDelay time.Duration
+
+	// BreakerThreshold is the number of consecutive failed attempts after which the circuit opens. Defaults to 5
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the circuit stays open before we let a request through to test the waters.
+	// Defaults to 30s
+	BreakerCooldown time.Duration
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+
+	// probing is set while a request is testing a half open circuit, so that everybody else is still turned away
+	probing bool
+}
+
+// setDefaults fills in anything the user left empty with something reasonable
+func (policy *RetryPolicy) setDefaults() {
+	if policy.Client == nil {
+		policy.Client = http.DefaultClient
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = 100 * time.Millisecond
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = 10 * time.Second
+	}
+	if policy.BreakerThreshold == 0 {
+		policy.BreakerThreshold = 5
+	}
+	if policy.BreakerCooldown == 0 {
+		policy.BreakerCooldown = 30 * time.Second
+	}
+}
+
+// CircuitState returns the current state of our circuit breaker, for whoever owns the policy to report on
+func (policy *RetryPolicy) CircuitState() CircuitState {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+
+	return policy.state()
+}
+
+// state is the lock free version of CircuitState
+func (policy *RetryPolicy) state() CircuitState {
+	if policy.BreakerThreshold == 0 || policy.failures < policy.BreakerThreshold {
+		return CircuitClosed
+	}
+	if time.Since(policy.openedAt) >= policy.BreakerCooldown {
+		return CircuitHalfOpen
+	}
+	return CircuitOpen
+}
+
+// record updates our circuit breaker with the outcome of an attempt
+func (policy *RetryPolicy) record(success bool) {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+
+	if success {
+		policy.failures = 0
+		return
+	}
+
+	policy.failures++
+	if policy.failures >= policy.BreakerThreshold {
+		// This also resets the cooldown if a half open test request fails
+		policy.openedAt = time.Now()
+	}
+}
+
+// backoff returns how long to wait before the given retry (starting at 1). We use "full jitter", picking a random
+// delay between zero and our exponential ceiling, so that a fleet of nodes that failed together don't all come back
+// at the exact same moment
+func (policy *RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := policy.BaseDelay
+	for i := 1; i < retry && ceiling < policy.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > policy.MaxDelay {
+		ceiling = policy.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// DoWithRetry performs the given request using the passed in policy, retrying on network errors and 5xx responses
+// with exponential, jittered backoff until we either succeed or run out of attempts. If the policy's circuit breaker
+// is open we return ErrCircuitOpen without making a request at all, as we do when it's half open and another request
+// is already testing it. The last response (if any) is returned along with the last error, and it's up to the caller
+// to close its body. Should the request's context be done while we're backing off we give up straight away, returning
+// the context's error
+func DoWithRetry(req *http.Request, policy *RetryPolicy) (*http.Response, error) {
+	policy.lock.Lock()
+	policy.setDefaults()
+	state := policy.state()
+	if state == CircuitOpen || state == CircuitHalfOpen && policy.probing {
+		policy.lock.Unlock()
+		return nil, ErrCircuitOpen
+	}
+
+	// We're the one request a half open circuit lets through, until we're done
+	if state == CircuitHalfOpen {
+		policy.probing = true
+		defer func() {
+			policy.lock.Lock()
+			policy.probing = false
+			policy.lock.Unlock()
+		}()
+	}
+	policy.lock.Unlock()
+
+	// We need to be able to send our body multiple times, so if the request can't recreate it on its own we
+	// buffer it up front
+	var body []byte
+	if req.Body != nil && req.GetBody == nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(policy.backoff(attempt - 1)):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		} else if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err = policy.Client.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			policy.record(true)
+			return resp, nil
+		}
+		policy.record(false)
+
+		// A half open circuit only gets a single test request, and if we've just tripped the breaker there's
+		// no point in continuing to hammer away
+		if policy.CircuitState() != CircuitClosed {
+			break
+		}
+
+		if resp != nil && attempt < policy.MaxAttempts {
+			resp.Body.Close()
+		}
+	}
+
+	if err == nil {
+		err = errors.New("request failed with status " + resp.Status)
+	}
+	return resp, err
+}
@@ -0,0 +1,169 @@
+package components
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoWithRetry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "hello", string(body))
+
+		if attempts < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	req, err := http.NewRequest("POST", server.URL, bytes.NewBufferString("hello"))
+	assert.Nil(t, err)
+
+	resp, err := DoWithRetry(req, policy)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, CircuitClosed, policy.CircuitState())
+}
+
+func TestDoWithRetryGivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, BreakerThreshold: 100}
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	assert.Nil(t, err)
+
+	resp, err := DoWithRetry(req, policy)
+	assert.NotNil(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, 2, attempts)
+}
+
+func TestDoWithRetryContextDone(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, BreakerThreshold: 100}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	assert.Nil(t, err)
+
+	// Our backoff would keep us waiting for up to an hour, but the context being cancelled cuts it short
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	resp, err := DoWithRetry(req, policy)
+	assert.Nil(t, resp)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestDoWithRetryCircuitBreaker(t *testing.T) {
+	attempts := 0
+	healthy := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if !healthy {
+			w.WriteHeader(500)
+		}
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{
+		MaxAttempts:      5,
+		BaseDelay:        time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  20 * time.Millisecond,
+	}
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	assert.Nil(t, err)
+
+	// We should stop retrying as soon as the breaker trips
+	resp, err := DoWithRetry(req, policy)
+	assert.NotNil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, CircuitOpen, policy.CircuitState())
+
+	// While open we shouldn't even try
+	_, err = DoWithRetry(req, policy)
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 2, attempts)
+
+	// Once the cooldown passes we let a request through and close the circuit if it works
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, policy.CircuitState())
+
+	healthy = true
+	resp, err = DoWithRetry(req, policy)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, CircuitClosed, policy.CircuitState())
+}
+
+func TestDoWithRetryHalfOpenProbe(t *testing.T) {
+	received := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{BreakerThreshold: 1, BreakerCooldown: time.Millisecond}
+	policy.record(false)
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, policy.CircuitState())
+
+	// Our first request tests the waters...
+	probed := make(chan error)
+	go func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := DoWithRetry(req, policy)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		probed <- err
+	}()
+	<-received
+
+	// ...while everyone else is still turned away
+	req, err := http.NewRequest("GET", server.URL, nil)
+	assert.Nil(t, err)
+	_, err = DoWithRetry(req, policy)
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	close(release)
+	assert.Nil(t, <-probed)
+	assert.Len(t, received, 0)
+	assert.Equal(t, CircuitClosed, policy.CircuitState())
+}
@@ -1,7 +1,11 @@
+//go:build !noZMQ
+
 package components
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/cj-dimaggio/accord/accord"
@@ -9,6 +13,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// errUnacknowledged is the reason we give Accord when our remote asks for a new Message without having acknowledged the
+// last one we sent it
+var errUnacknowledged = errors.New("remote asked for a new message without acknowledging the last")
+
 // PollListener is part of a "polling" scheme of possible Accord components that can be used when your
 // network typology best lends itself to a synchronization method that consists of going out and polling
 // for changes from a remote Accord instance.
@@ -18,6 +26,9 @@ import (
 type PollListener struct {
 	accord.ComponentRunner
 
+	// Name identifies this component so that it can be looked up and managed at runtime. Defaults to "PollListener"
+	Name string
+
 	// Address is the ZeroMQ address to use. This must follow the ZMQ addressing schema (transport://endpoint)
 	Address string
 
@@ -29,16 +40,83 @@ type PollListener struct {
 	ListenTimeout time.Duration
 	SendTimeout   time.Duration
 
+	// Target optionally names the sync target this listener serves. When set, the listener keeps its own cursor into
+	// Accord's ToBeSynced queue rather than dequeuing directly, which lets multiple listeners (say, a primary peer and an
+	// archive peer) be fed from the same queue without a slow one holding up the rest. Messages are only removed from
+	// the queue once every registered target has confirmed them. Leave it empty for the classic single peer behavior
+	Target string
+
+	// Filter optionally limits the Messages this listener's remote receives to those it returns true for, so that peers
+	// only interested in some of our Messages (a topic, say) aren't sent the rest. Messages that are filtered out are
+	// skipped for this listener's Target only, so Filter requires a Target and is ignored without one. Keep in mind that
+	// a filtered remote never processes everything we have, so its state won't align with ours
+	Filter func(accord.Message) bool
+
+	// Prioritized sends the highest Priority Message in the queue next, rather than strictly the oldest, so that urgent
+	// Messages aren't stuck behind a large backlog. PriorityAging guards against low priority Messages being starved
+	// (see SyncQueue.PeekHighestPriority). Finding the highest priority Message means looking through the whole queue on
+	// every send. Prioritization isn't supported alongside Target, and is ignored if both are set
+	Prioritized   bool
+	PriorityAging time.Duration
+
+	// LongPoll holds on to a "send" that arrives while our queue is empty for up to EmptyHoldTimeout (defaulting to 5
+	// seconds), replying with a Message the moment one is enqueued or "empty" once the hold runs out. This trades a held
+	// connection for near real time delivery, without our remote having to poll aggressively. Our remote only waits so
+	// long for a reply (ten of its ListenTimeouts, for a PollRequestor) so the hold should be kept well under that
+	LongPoll         bool
+	EmptyHoldTimeout time.Duration
+
+	// Handshake requires our remote to complete a "hello" exchange, confirming that we speak the same protocol version
+	// and Message codec, before we'll hand it any Messages. We always answer a "hello" whether or not this is set, so
+	// that a PollRequestor that wants to check compatibility can, but without it a remote that skips the handshake is
+	// still served
+	Handshake bool
+
+	// SendRateLimit caps how many Messages a second we send our remote, and SendByteRateLimit how many bytes a second
+	// of them, so that pushing a big backlog over a metered or shared link doesn't saturate it. Each allows bursts of up
+	// to a second's worth. A Message over the limit is held back until it's allowed (in slices of no more than our
+	// ListenTimeout, so that we stay responsive to being stopped) before we reply with it. Zero (the default) means
+	// there's no limit
+	SendRateLimit     float64
+	SendByteRateLimit float64
+
 	sock *zmq.Socket
 	log  *logrus.Entry
 
 	state func(*accord.Accord)
 	reply []interface{}
+
+	// holdUntil is when a long poll we're holding runs out, and enqueued and cancelHold are what we're waiting on for a
+	// new Message in the meantime
+	holdUntil  time.Time
+	enqueued   <-chan struct{}
+	cancelHold func()
+
+	// sendBucket and byteBucket enforce our SendRateLimit and SendByteRateLimit, and are nil without one. sendAt is
+	// when the Message we're about to reply with is allowed to go
+	sendBucket *tokenBucket
+	byteBucket *tokenBucket
+	sendAt     time.Time
+
+	// handshaken is set once our remote has completed a compatible "hello" exchange
+	handshaken bool
+
+	// sent is the Message we last handed to our remote and are waiting on an "ok" for, so that we know what we're
+	// recording a delivery receipt for
+	sent *accord.Message
+
+	// release is an AtMostOnce Message we're about to send, which we take off our queue as soon as it's gone rather
+	// than waiting on an "ok", and released is set once we have, so that its "ok" doesn't take anything else with it
+	release  *accord.Message
+	released bool
 }
 
 // Start binds our ZeroMQ socket and gets us ready to start processing incomming requests
 func (listener *PollListener) Start(accord *accord.Accord) (err error) {
-	listener.log = accord.Logger.WithField("component", "PollListener")
+	if listener.Name == "" {
+		listener.Name = "PollListener"
+	}
+	listener.log = accord.Logger.WithField("component", listener.Name)
 
 	listener.log.Debug("Entering recvState")
 	listener.state = listener.recvState
@@ -50,15 +128,45 @@ func (listener *PollListener) Start(accord *accord.Accord) (err error) {
 	if listener.SendTimeout == 0 {
 		listener.SendTimeout = 2 * time.Second
 	}
+	if listener.EmptyHoldTimeout == 0 {
+		listener.EmptyHoldTimeout = 5 * time.Second
+	}
+
+	now := time.Now()
+	listener.sendBucket = newTokenBucket(listener.SendRateLimit, now)
+	listener.byteBucket = newTokenBucket(listener.SendByteRateLimit, now)
+	listener.sendAt = time.Time{}
+
+	if listener.Target != "" {
+		listener.log = listener.log.WithField("target", listener.Target)
+		err = accord.ToBeSynced.RegisterTarget(listener.Target)
+		if err != nil {
+			listener.log.WithError(err).Error("Could not register our sync target")
+			return err
+		}
+
+		if listener.Prioritized {
+			listener.log.Warn("Prioritization isn't supported alongside a sync target, sending in FIFO order")
+			listener.Prioritized = false
+		}
+	} else if listener.Filter != nil {
+		listener.log.Warn("Filtering requires a sync target, sending every Message")
+		listener.Filter = nil
+	}
 
 	// Can we have a brief talk about golang's error handling? I understand some of the grievances
 	// about exceptions but trying to do any kind of error handling just becomes an unreadable mess
 
 	listener.log.WithField("address", listener.Address).Info("Starting PollListener")
+	err = checkZMQ()
+	if err != nil {
+		listener.log.WithError(err).Error("ZeroMQ is unavailable")
+		return err
+	}
 	listener.sock, err = zmq.NewSocket(zmq.PAIR)
 	if err != nil {
 		listener.log.WithError(err).Error("Could not create ZeroMQ socket")
-		return err
+		return zmqInitError(err)
 	}
 
 	if listener.Bind {
@@ -92,8 +200,35 @@ func (listener *PollListener) Start(accord *accord.Accord) (err error) {
 	return nil
 }
 
+// ComponentName implements accord.NamedComponent
+func (listener *PollListener) ComponentName() string {
+	return listener.Name
+}
+
+// Describe implements accord.DescribedComponent
+func (listener *PollListener) Describe() accord.ComponentInfo {
+	health := listener.Health()
+	return accord.ComponentInfo{
+		Name:    listener.Name,
+		Type:    fmt.Sprintf("%T", listener),
+		Address: listener.Address,
+		Health:  &health,
+		Config: map[string]string{
+			"Bind":              fmt.Sprint(listener.Bind),
+			"Target":            listener.Target,
+			"Filtered":          fmt.Sprint(listener.Filter != nil),
+			"Prioritized":       fmt.Sprint(listener.Prioritized),
+			"ListenTimeout":     listener.ListenTimeout.String(),
+			"SendTimeout":       listener.SendTimeout.String(),
+			"SendRateLimit":     fmt.Sprint(listener.SendRateLimit),
+			"SendByteRateLimit": fmt.Sprint(listener.SendByteRateLimit),
+		},
+	}
+}
+
 // cleanup closes our sockets and makes sure we don't have any hanging states that may cause an issue
 func (listener *PollListener) cleanup(*accord.Accord) {
+	listener.stopHold()
 	err := listener.sock.Close()
 	if err != nil {
 		listener.log.WithError(err).Warn("Error closing ZeroMQ socket")
@@ -110,49 +245,78 @@ func (listener *PollListener) tick(acrd *accord.Accord) {
 }
 
 func (listener *PollListener) recvState(acrd *accord.Accord) {
-	msg, err := listener.sock.Recv(0)
+	data, err := listener.sock.RecvMessageBytes(0)
 	if err != nil {
 		listener.ExpectedOrShutdown(err, ZMQTimeout)
 		return
 	}
 
+	msg, err := validateFrames(data)
+	if err != nil {
+		// We can't trust anything about a malformed request, so we don't act on it at all and simply let the
+		// client know
+		listener.log.WithField("message", msg).WithField("frames", len(data)).Warn("Received a malformed request")
+		listener.reply = []interface{}{"error", "protocol"}
+		listener.log.Debug("Entering sendState")
+		listener.state = listener.sendState
+		return
+	}
+
+	if listener.Handshake && !listener.handshaken && msg != "hello" {
+		listener.log.WithField("message", msg).Warn("Received a request before completing our handshake")
+		listener.reply = []interface{}{"error", "handshake"}
+		listener.log.Debug("Entering sendState")
+		listener.state = listener.sendState
+		return
+	}
+
 	switch msg {
+	case "hello":
+		listener.log.Debug("Received 'hello'")
+		// Our remote wants to make sure we can understand each other before exchanging any Messages
+		local := localHello()
+		compression, err := negotiate(local, parseHello(data))
+		if err != nil {
+			listener.log.WithError(err).Error("Remote is incompatible, refusing to sync with it")
+			listener.handshaken = false
+			listener.reply = []interface{}{"error", err.Error()}
+			break
+		}
+
+		listener.log.WithField("compression", compression).Info("Completed handshake with remote")
+		listener.handshaken = true
+		local.Compression = []string{compression}
+		listener.reply = local.frames()
+
 	case "send":
 		listener.log.Debug("Received 'send'")
 		// We have a request to send a new piece of data, let's take a look at what it is but *not*
 		// actually take it off our queue yey
-		msg, err := acrd.ToBeSynced.Peek()
-		if err != nil {
-			// This is not good but not necessarily an *unrecoverable* error (although, realistically it
-			// probably mean human intervention is needed). In any case, we simply tell our client somethings
-			// up but don't take down our application just yet
-			listener.log.WithError(err).Error("Error ocurred reading from the queue")
-			listener.reply = []interface{}{"error", "queue read"}
-			break
+		if listener.sent != nil {
+			// Our remote never acknowledged the last Message we sent it, so let Accord know in case it's one our remote
+			// will never be able to handle
+			skipped, err := acrd.ReportSyncFailure(listener.sent, listener.Target, errUnacknowledged)
+			if err != nil {
+				listener.log.WithError(err).WithField("id", listener.sent.ID).Error("Could not report a failed sync")
+			} else if skipped {
+				listener.log.WithField("id", listener.sent.ID).Warn("Gave up on a Message our remote never acknowledged")
+			}
 		}
+		listener.sent = nil
+		listener.released = false
 
-		if msg == nil {
-			// If our queue is empty, tell the client and also tell it our state
-			listener.log.Debug("Sending queue empty and our status")
-			buf := make([]byte, 8)
-			binary.LittleEndian.PutUint64(buf, acrd.Status().State)
-			listener.reply = []interface{}{"empty", buf}
-			break
+		if listener.LongPoll {
+			// Start waiting on a new Message before we look, so that one enqueued in between can't slip past us
+			listener.enqueued, listener.cancelHold = acrd.ToBeSynced.Notify()
 		}
 
-		data, err := msg.Serialize()
-		if err != nil {
-			// Like above, this isn't necessarily the end of the world in the sense that we're not screwing up our
-			// state. We simply log the error, tell the client, and keep moving
-			listener.log.WithError(err).Error("Error serializing message")
-			listener.reply = []interface{}{"error", "serialize"}
-			break
+		if !listener.prepareSend(acrd) && listener.LongPoll {
+			listener.log.Debug("Queue is empty, entering holdState")
+			listener.holdUntil = time.Now().Add(listener.EmptyHoldTimeout)
+			listener.state = listener.holdState
+			return
 		}
-
-		// We use ZeroMQ's multi part messaging here to make it easier for the client to parse the response. Essentially
-		// our responses have categories, they can be an "error", or a "msg", or a "deleted"
-		listener.log.Debug("Sending message")
-		listener.reply = []interface{}{"msg", data}
+		listener.stopHold()
 		break
 
 	case "ok":
@@ -165,7 +329,14 @@ func (listener *PollListener) recvState(acrd *accord.Accord) {
 		// problems are all solvable, but let's start with getting an MVP going and then try adding that stuff. For now let's
 		// put it in the category of TODO
 
-		_, err := acrd.ToBeSynced.Dequeue()
+		if listener.released {
+			// What we sent has already left our queue, so there's nothing left for this "ok" to confirm
+			listener.released = false
+			listener.reply = []interface{}{"deleted"}
+			break
+		}
+
+		err := listener.confirm(acrd, listener.sent)
 		if err != nil {
 			// We're in a bit of a rough spot here if this ever *does* happen (god I hope it doesn't).
 			// Without a rollback system (which should we just add?) there's not a whole lot we can do to
@@ -174,15 +345,38 @@ func (listener *PollListener) recvState(acrd *accord.Accord) {
 			// the remote should probably do too so that nothing else bad happens)
 			listener.log.WithError(err).Fatal("Error removing from our queue")
 			listener.sock.SendMessage("error", "dequeue")
-			listener.Shutdown(err)
+			listener.ShutdownWith(accord.ShutdownStorage, err)
 			return
 		}
 
+		if listener.sent != nil {
+			err = acrd.RecordDelivery(listener.sent.ID, listener.peer())
+			if err != nil {
+				// The message has already left our queue, so all we can do is make some noise about the gap in our
+				// receipts
+				listener.log.WithError(err).WithField("id", listener.sent.ID).Error("Could not record delivery receipt")
+			}
+			listener.sent = nil
+		}
+
 		// This is a bit unnecessary but ZeroMQ demands we send *something* so we might as well send this
 		listener.log.Debug("sending 'deleted'")
 		listener.reply = []interface{}{"deleted"}
 		break
 
+	case "applied":
+		listener.log.Debug("Received 'applied'")
+		// Our remote is letting us know it actually processed one of the Messages we sent it, rather than just
+		// receiving it. It may well be long gone from our queue by now
+		id := binary.LittleEndian.Uint64(data[1])
+		err := acrd.RecordConfirmation(id, listener.peer())
+		if err != nil {
+			listener.log.WithError(err).WithField("id", id).Error("Could not record processing confirmation")
+			listener.reply = []interface{}{"error", "confirm"}
+			break
+		}
+		listener.reply = []interface{}{"confirmed"}
+
 	default:
 		listener.log.WithField("message", msg).Warn("Received unknown request")
 		listener.reply = []interface{}{"unknown"}
@@ -194,14 +388,187 @@ func (listener *PollListener) recvState(acrd *accord.Accord) {
 	listener.state = listener.sendState
 }
 
-// sentData sends data over to the client
+// prepareSend gets our reply to a "send" ready: the next Message our remote should receive, or an "error" if we can't
+// read it. Returns false, having prepared an "empty" reply along with our state, if our queue is empty
+func (listener *PollListener) prepareSend(acrd *accord.Accord) bool {
+	msg, err := listener.peek(acrd)
+	if err != nil {
+		// This is not good but not necessarily an *unrecoverable* error (although, realistically it
+		// probably mean human intervention is needed). In any case, we simply tell our client somethings
+		// up but don't take down our application just yet
+		listener.log.WithError(err).Error("Error ocurred reading from the queue")
+		listener.reply = []interface{}{"error", "queue read"}
+		return true
+	}
+
+	if msg == nil {
+		// If our queue is empty, tell the client and also tell it our state
+		listener.log.Debug("Sending queue empty and our status")
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, acrd.Status().State)
+		listener.reply = []interface{}{"empty", buf}
+		return false
+	}
+
+	// Our remote gets its own copy of the Message, reshaped for it if need be, while we keep track of the queued one so
+	// that its "ok" confirms what's actually in our queue
+	out, err := acrd.TransformOutbound(msg, listener.peer())
+	if err != nil {
+		listener.log.WithError(err).WithField("id", msg.ID).Error("Error transforming message")
+		listener.reply = []interface{}{"error", "transform"}
+		return true
+	}
+
+	data, err := out.Serialize()
+	if err != nil {
+		// Like above, this isn't necessarily the end of the world in the sense that we're not screwing up our
+		// state. We simply log the error, tell the client, and keep moving
+		listener.log.WithError(err).Error("Error serializing message")
+		listener.reply = []interface{}{"error", "serialize"}
+		return true
+	}
+
+	// We use ZeroMQ's multi part messaging here to make it easier for the client to parse the response. Essentially
+	// our responses have categories, they can be an "error", or a "msg", or a "deleted"
+	listener.log.Debug("Sending message")
+	listener.reply = []interface{}{"msg", data}
+	listener.throttle(len(data))
+	if msg.DeliveryMode == accord.AtMostOnce {
+		listener.release = msg
+	} else {
+		listener.sent = msg
+	}
+	return true
+}
+
+// holdState waits on a long poll, in slices of no more than our ListenTimeout so that we stay responsive to being
+// stopped, until either a Message is enqueued or our hold runs out
+func (listener *PollListener) holdState(acrd *accord.Accord) {
+	wait := time.Until(listener.holdUntil)
+	if wait > listener.ListenTimeout {
+		wait = listener.ListenTimeout
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-listener.enqueued:
+		// Look again, as we still need to be notified should somebody have gotten to the new Message before us
+		listener.enqueued, listener.cancelHold = acrd.ToBeSynced.Notify()
+	case <-timer.C:
+		if time.Now().Before(listener.holdUntil) {
+			return
+		}
+	}
+
+	if listener.prepareSend(acrd) || !time.Now().Before(listener.holdUntil) {
+		listener.stopHold()
+		listener.log.Debug("Entering sendState")
+		listener.state = listener.sendState
+	}
+}
+
+// stopHold stops waiting on new Messages for a long poll
+func (listener *PollListener) stopHold() {
+	if listener.cancelHold != nil {
+		listener.cancelHold()
+		listener.cancelHold = nil
+		listener.enqueued = nil
+	}
+}
+
+// peek returns the next message our remote should receive, taking into account whether we're tracking a sync target
+func (listener *PollListener) peek(acrd *accord.Accord) (*accord.Message, error) {
+	if listener.Target != "" {
+		for {
+			msg, err := acrd.ToBeSynced.PeekTarget(listener.Target)
+			if err != nil || msg == nil || listener.Filter == nil || listener.Filter(*msg) {
+				return msg, err
+			}
+
+			// Our remote isn't interested, so move our cursor past it without bothering anyone else
+			listener.log.WithField("id", msg.ID).Debug("Skipping filtered Message")
+			_, err = acrd.ToBeSynced.Skip(listener.Target, msg.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if listener.Prioritized {
+		return acrd.ToBeSynced.PeekHighestPriority(listener.PriorityAging)
+	}
+	return acrd.ToBeSynced.Peek()
+}
+
+// confirm marks the message we last sent as handled by our remote, either by moving our target's cursor past it or,
+// if we don't have a target, by taking it out of our queue. Either way it's done by ID, as what we sent may not be at
+// the head when prioritized, and may have been swept out as expired while we waited on our remote, in which case
+// there's nothing left to confirm (nor if we don't know what we sent)
+func (listener *PollListener) confirm(acrd *accord.Accord, sent *accord.Message) error {
+	if sent == nil {
+		return nil
+	}
+
+	var found bool
+	var err error
+	if listener.Target != "" {
+		found, err = acrd.ToBeSynced.ConfirmTargetMessage(listener.Target, sent.ID)
+	} else {
+		found, err = acrd.ToBeSynced.Remove(sent.ID)
+	}
+	if err == nil && !found {
+		listener.log.WithField("id", sent.ID).Debug("What we sent has already left our queue")
+	}
+	return err
+}
+
+// peer is how we identify our remote in delivery receipts and processing confirmations
+func (listener *PollListener) peer() string {
+	if listener.Target != "" {
+		return listener.Target
+	}
+	return listener.Name
+}
+
+// throttle works out when a Message of the given size may be sent under our SendRateLimit and SendByteRateLimit
+func (listener *PollListener) throttle(size int) {
+	now := time.Now()
+	listener.sendAt = listener.sendBucket.take(1, now)
+	if byteAt := listener.byteBucket.take(float64(size), now); byteAt.After(listener.sendAt) {
+		listener.sendAt = byteAt
+	}
+}
+
+// sentData sends data over to the client, once our rate limits allow it
 func (listener *PollListener) sendState(acrd *accord.Accord) {
+	if wait := time.Until(listener.sendAt); wait > 0 {
+		// Wait in slices, so that being stopped isn't held up by a long throttle
+		if wait > listener.ListenTimeout {
+			wait = listener.ListenTimeout
+		}
+		time.Sleep(wait)
+		return
+	}
+
 	_, err := listener.sock.SendMessage(listener.reply...)
 	if err != nil {
 		listener.ExpectedOrShutdown(err, ZMQTimeout)
 		return
 	}
 
+	if listener.release != nil {
+		// An AtMostOnce Message doesn't wait on our remote, so it's done with as soon as it's on its way
+		err = listener.confirm(acrd, listener.release)
+		if err != nil {
+			listener.log.WithError(err).WithField("id", listener.release.ID).Error("Error removing from our queue")
+			listener.ShutdownWith(accord.ShutdownStorage, err)
+			return
+		}
+		listener.release = nil
+		listener.released = true
+	}
+
 	listener.log.Debug("Entering recvState")
 	listener.state = listener.recvState
 }
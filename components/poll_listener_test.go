@@ -1,7 +1,10 @@
+//go:build !noZMQ
+
 package components
 
 import (
 	"encoding/binary"
+	"strings"
 	"testing"
 	"time"
 
@@ -98,3 +101,1070 @@ func TestPollListener(t *testing.T) {
 	assert.Len(t, data, 2)
 	assert.Equal(t, "error", string(data[0]))
 }
+
+func TestPollListenerSynchronous(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerSynchronousTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// Step the listener through its state machine by hand, rather than racing its goroutine
+	listener.Synchronous()
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerSynchronousTest")
+	assert.Nil(t, err)
+
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+
+	// Receiving the request and replying to it are separate ticks
+	listener.TickOnce()
+	listener.TickOnce()
+
+	data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	assert.Len(t, data, 2)
+	assert.Equal(t, "msg", string(data[0]))
+
+	_, err = client.Send("ok", 0)
+	assert.Nil(t, err)
+
+	listener.TickOnce()
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+	listener.TickOnce()
+
+	data, err = client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("deleted"), data[0])
+}
+
+func TestPollListenerDeliveryModes(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerDeliveryModesTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	atMostOnce, err := accord.NewMessage([]byte("a"))
+	assert.Nil(t, err)
+	atMostOnce.DeliveryMode = accord.AtMostOnce
+	assert.Nil(t, acrd.HandleNewMessage(atMostOnce))
+	atLeastOnce, err := accord.NewMessage([]byte("b"))
+	assert.Nil(t, err)
+	assert.Nil(t, acrd.HandleNewMessage(atLeastOnce))
+
+	listener.Synchronous()
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerDeliveryModesTest")
+	assert.Nil(t, err)
+
+	// An at most once Message leaves our queue as soon as it's sent
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	listener.TickOnce()
+	assert.Equal(t, uint64(2), acrd.Status().ToBeSyncedSize)
+	listener.TickOnce()
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	assert.Equal(t, "msg", string(data[0]))
+	msg, err := accord.DeserializeMessage(data[1])
+	assert.Nil(t, err)
+	assert.Equal(t, atMostOnce.ID, msg.ID)
+
+	// And its "ok" doesn't take the next one with it
+	_, err = client.Send("ok", 0)
+	assert.Nil(t, err)
+	listener.TickOnce()
+	listener.TickOnce()
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+	data, err = client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", string(data[0]))
+
+	// An at least once Message waits on its "ok"
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	listener.TickOnce()
+	listener.TickOnce()
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	data, err = client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	msg, err = accord.DeserializeMessage(data[1])
+	assert.Nil(t, err)
+	assert.Equal(t, atLeastOnce.ID, msg.ID)
+
+	_, err = client.Send("ok", 0)
+	assert.Nil(t, err)
+	listener.TickOnce()
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+	listener.TickOnce()
+	data, err = client.RecvMessageBytes(zmq.DONTWAIT)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", string(data[0]))
+}
+
+func TestPollListenerMalformed(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerMalformedTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerMalformedTest")
+	assert.Nil(t, err)
+
+	// An "ok" with extra frames shouldn't dequeue anything
+	_, err = client.SendMessage("ok", "extra")
+	assert.Nil(t, err)
+	data, err := client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Len(t, data, 2)
+	assert.Equal(t, "error", string(data[0]))
+	assert.Equal(t, "protocol", string(data[1]))
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	// Unknown requests are still answered
+	_, err = client.Send("bogus", 0)
+	assert.Nil(t, err)
+	data, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Len(t, data, 1)
+	assert.Equal(t, "unknown", string(data[0]))
+
+	// And we should still be working normally afterwards
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	data, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Len(t, data, 2)
+	assert.Equal(t, "msg", string(data[0]))
+}
+
+func TestPollListenerReceipts(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerReceiptsTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Target:        "primary",
+	}
+	acrd := accord.DummyAccord()
+	acrd.DeliveryReceipts = true
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerReceiptsTest")
+	assert.Nil(t, err)
+
+	// A stray "ok" before anything was sent shouldn't leave a receipt
+	_, err = client.Send("ok", 0)
+	assert.Nil(t, err)
+	_, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	_, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+
+	receipts, err := acrd.Receipts(msg.ID)
+	assert.Nil(t, err)
+	assert.Empty(t, receipts)
+
+	_, err = client.Send("ok", 0)
+	assert.Nil(t, err)
+	_, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+
+	receipts, err = acrd.Receipts(msg.ID)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, "primary", receipts[0].Peer)
+}
+
+func TestPollListenerStuckHead(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerStuckHeadTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+	}
+	acrd := accord.DummyAccord()
+	acrd.MaxHeadRetries = 2
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	poison, err := accord.NewMessage([]byte("poison"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(poison)
+	assert.Nil(t, err)
+	next, err := accord.NewMessage([]byte("next"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(next)
+	assert.Nil(t, err)
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerStuckHeadTest")
+	assert.Nil(t, err)
+
+	send := func() *accord.Message {
+		_, err := client.Send("send", 0)
+		assert.Nil(t, err)
+		resp, err := client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "msg", string(resp[0]))
+		msg, err := accord.DeserializeMessage(resp[1])
+		assert.Nil(t, err)
+		return msg
+	}
+
+	// Our remote keeps asking for the poison Message without ever acknowledging it
+	assert.Equal(t, poison.ID, send().ID)
+	assert.Equal(t, poison.ID, send().ID)
+	assert.Equal(t, uint64(0), acrd.Status().HeadOfLineDrops)
+
+	// Until we give up on it and move on to what was stuck behind it
+	assert.Equal(t, next.ID, send().ID)
+	assert.Equal(t, uint64(1), acrd.Status().HeadOfLineDrops)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	dead, err := acrd.DeadLetters(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, poison.ID, dead[0].Message.ID)
+	assert.Equal(t, errUnacknowledged.Error(), dead[0].Reason)
+}
+
+func TestPollListenerFilter(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	// Each peer subscribes to its own set of keys, which our payloads are prefixed with
+	subscribe := func(keys ...string) func(accord.Message) bool {
+		return func(msg accord.Message) bool {
+			for _, key := range keys {
+				if strings.HasPrefix(string(msg.Payload), key+":") {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	peer := func(name string, filter func(accord.Message) bool) (*PollListener, *zmq.Socket) {
+		listener := &PollListener{
+			Address:       "inproc://pollListenerFilterTest" + name,
+			Bind:          true,
+			ListenTimeout: time.Millisecond,
+			SendTimeout:   time.Millisecond,
+			Target:        name,
+			Filter:        filter,
+		}
+		listener.Synchronous()
+		err := listener.Start(acrd)
+		assert.Nil(t, err)
+
+		client, err := zmq.NewSocket(zmq.PAIR)
+		assert.Nil(t, err)
+		err = client.Connect(listener.Address)
+		assert.Nil(t, err)
+		return listener, client
+	}
+
+	listenerA, clientA := peer("a", subscribe("orders", "invoices"))
+	defer listenerA.WaitForStop()
+	defer listenerA.Stop(0)
+	defer clientA.Close()
+	listenerB, clientB := peer("b", subscribe("users"))
+	defer listenerB.WaitForStop()
+	defer listenerB.Stop(0)
+	defer clientB.Close()
+
+	for _, payload := range []string{"orders:1", "users:1", "invoices:1", "users:2"} {
+		msg, err := accord.NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		err = acrd.HandleNewMessage(msg)
+		assert.Nil(t, err)
+	}
+
+	// request sends a request to a listener and steps it through receiving it and replying
+	request := func(listener *PollListener, client *zmq.Socket, kind string) [][]byte {
+		_, err := client.Send(kind, 0)
+		assert.Nil(t, err)
+		listener.TickOnce()
+		listener.TickOnce()
+
+		data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+		assert.Nil(t, err)
+		return data
+	}
+
+	// received drains everything a peer is sent
+	received := func(listener *PollListener, client *zmq.Socket) []string {
+		payloads := []string{}
+		for {
+			data := request(listener, client, "send")
+			if string(data[0]) != "msg" {
+				assert.Equal(t, "empty", string(data[0]))
+				return payloads
+			}
+
+			msg, err := accord.DeserializeMessage(data[1])
+			assert.Nil(t, err)
+			payloads = append(payloads, string(msg.Payload))
+			request(listener, client, "ok")
+		}
+	}
+
+	assert.Equal(t, []string{"orders:1", "invoices:1"}, received(listenerA, clientA))
+
+	// What A skipped is still waiting for B
+	assert.Equal(t, uint64(4), acrd.Status().ToBeSyncedSize)
+	assert.Equal(t, []string{"users:1", "users:2"}, received(listenerB, clientB))
+
+	// And once both have moved past everything it's gone
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+}
+
+func TestPollListenerSweptWhileSent(t *testing.T) {
+	for _, target := range []string{"", "primary"} {
+		accord.AccordCleanup()
+
+		acrd := accord.DummyAccord()
+		err := acrd.Start()
+		assert.Nil(t, err)
+
+		listener := &PollListener{
+			Address:       "inproc://pollListenerSweptWhileSentTest" + target,
+			Bind:          true,
+			ListenTimeout: time.Millisecond,
+			SendTimeout:   time.Millisecond,
+			Target:        target,
+		}
+		listener.Synchronous()
+		err = listener.Start(acrd)
+		assert.Nil(t, err)
+
+		client, err := zmq.NewSocket(zmq.PAIR)
+		assert.Nil(t, err)
+		err = client.Connect(listener.Address)
+		assert.Nil(t, err)
+
+		request := func(kind string) [][]byte {
+			_, err := client.Send(kind, 0)
+			assert.Nil(t, err)
+			listener.TickOnce()
+			listener.TickOnce()
+
+			data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+			assert.Nil(t, err)
+			return data
+		}
+
+		now := time.Now().UTC()
+		expiring, err := accord.NewMessage([]byte("expiring"))
+		assert.Nil(t, err)
+		expiring.ExpiresAt = now.Add(time.Minute)
+		assert.Nil(t, acrd.HandleNewMessage(expiring))
+		live, err := accord.NewMessage([]byte("live"))
+		assert.Nil(t, err)
+		assert.Nil(t, acrd.HandleNewMessage(live))
+
+		data := request("send")
+		assert.Equal(t, "msg", string(data[0]))
+		sent, err := accord.DeserializeMessage(data[1])
+		assert.Nil(t, err)
+		assert.Equal(t, expiring.ID, sent.ID)
+
+		// What we sent expires and is swept out while we wait on our remote...
+		removed, err := acrd.ToBeSynced.RemoveExpired(now.Add(time.Hour))
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), removed)
+
+		// ...so its "ok" has nothing left to confirm, and mustn't take the live Message with it
+		assert.Equal(t, "deleted", string(request("ok")[0]))
+		assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+		data = request("send")
+		assert.Equal(t, "msg", string(data[0]))
+		sent, err = accord.DeserializeMessage(data[1])
+		assert.Nil(t, err)
+		assert.Equal(t, live.ID, sent.ID)
+		assert.Equal(t, "deleted", string(request("ok")[0]))
+		assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+
+		client.Close()
+		listener.Stop(0)
+		listener.WaitForStop()
+		acrd.Stop()
+	}
+	accord.AccordCleanup()
+}
+
+func TestPollListenerOutboundTransformer(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	acrd := accord.DummyAccord()
+	acrd.OutboundTransformer = accord.OutboundFunc(func(msg accord.Message, peer string) (accord.Message, error) {
+		msg.Payload = append(msg.Payload, []byte("@"+peer)...)
+		return msg, nil
+	})
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerTransformTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Target:        "peer",
+	}
+	listener.Synchronous()
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect(listener.Address)
+	assert.Nil(t, err)
+
+	request := func(kind string) [][]byte {
+		_, err := client.Send(kind, 0)
+		assert.Nil(t, err)
+		listener.TickOnce()
+		listener.TickOnce()
+
+		data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+		assert.Nil(t, err)
+		return data
+	}
+
+	msg, err := accord.NewMessage([]byte("hello"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	// The wire copy is transformed for our peer
+	data := request("send")
+	assert.Equal(t, "msg", string(data[0]))
+	sent, err := accord.DeserializeMessage(data[1])
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, sent.ID)
+	assert.Equal(t, "hello@peer", string(sent.Payload))
+
+	// While the queued copy is untouched
+	queued, err := acrd.ToBeSynced.PeekTarget("peer")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(queued.Payload))
+
+	// And our peer's "ok" confirms it
+	assert.Equal(t, "deleted", string(request("ok")[0]))
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+}
+
+func TestPollListenerPersistedCursorRestart(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	// start brings up an Accord with a listener for target "a", along with a second target "b" that never confirms
+	// anything, so that nothing ever leaves the queue and only our cursor can say what "a" has seen
+	start := func(address string) (*accord.Accord, *PollListener, *zmq.Socket) {
+		acrd := accord.DummyAccord()
+		acrd.PersistSyncCursors = true
+		err := acrd.Start()
+		assert.Nil(t, err)
+		assert.Nil(t, acrd.ToBeSynced.RegisterTarget("b"))
+
+		listener := &PollListener{
+			Address:       address,
+			Bind:          true,
+			ListenTimeout: time.Millisecond,
+			SendTimeout:   time.Millisecond,
+			Target:        "a",
+		}
+		listener.Synchronous()
+		err = listener.Start(acrd)
+		assert.Nil(t, err)
+
+		client, err := zmq.NewSocket(zmq.PAIR)
+		assert.Nil(t, err)
+		err = client.Connect(address)
+		assert.Nil(t, err)
+		return acrd, listener, client
+	}
+
+	request := func(listener *PollListener, client *zmq.Socket, kind string) [][]byte {
+		_, err := client.Send(kind, 0)
+		assert.Nil(t, err)
+		listener.TickOnce()
+		listener.TickOnce()
+
+		data, err := client.RecvMessageBytes(zmq.DONTWAIT)
+		assert.Nil(t, err)
+		return data
+	}
+
+	sent := func(data [][]byte) uint64 {
+		assert.Equal(t, "msg", string(data[0]))
+		msg, err := accord.DeserializeMessage(data[1])
+		assert.Nil(t, err)
+		return msg.ID
+	}
+
+	acrd, listener, client := start("inproc://pollListenerRestartTest1")
+	msg1 := &accord.Message{ID: 1}
+	msg2 := &accord.Message{ID: 2}
+	assert.Nil(t, acrd.HandleNewMessage(msg1))
+	assert.Nil(t, acrd.HandleNewMessage(msg2))
+
+	// Our remote confirms the first Message, but we go down before it confirms the second
+	assert.Equal(t, msg1.ID, sent(request(listener, client, "send")))
+	request(listener, client, "ok")
+	assert.Equal(t, msg2.ID, sent(request(listener, client, "send")))
+
+	client.Close()
+	listener.Stop(0)
+	listener.WaitForStop()
+	acrd.Stop()
+
+	// After the restart we resend only the Message that was never confirmed
+	acrd, listener, client = start("inproc://pollListenerRestartTest2")
+	defer acrd.Stop()
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+	defer client.Close()
+
+	assert.Equal(t, msg2.ID, sent(request(listener, client, "send")))
+	request(listener, client, "ok")
+	assert.Equal(t, "empty", string(request(listener, client, "send")[0]))
+	assert.Equal(t, uint64(2), acrd.Status().ToBeSyncedSize)
+}
+
+func TestPollListenerPrioritized(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerPrioritizedTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Prioritized:   true,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	// A backlog of normal Messages with an urgent one at the end
+	var ids []uint64
+	for i, priority := range []uint8{0, 0, 0, 10} {
+		msg, err := accord.NewMessage([]byte{byte(i)})
+		assert.Nil(t, err)
+		msg.Priority = priority
+		err = acrd.HandleNewMessage(msg)
+		assert.Nil(t, err)
+		ids = append(ids, msg.ID)
+	}
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerPrioritizedTest")
+	assert.Nil(t, err)
+
+	for _, id := range []uint64{ids[3], ids[0], ids[1], ids[2]} {
+		_, err = client.Send("send", 0)
+		assert.Nil(t, err)
+		resp, err := client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "msg", string(resp[0]))
+		msg, err := accord.DeserializeMessage(resp[1])
+		assert.Nil(t, err)
+		assert.Equal(t, id, msg.ID)
+
+		_, err = client.Send("ok", 0)
+		assert.Nil(t, err)
+		_, err = client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+}
+
+func TestPollListenerLongPoll(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:          "inproc://pollListenerLongPollTest",
+		Bind:             true,
+		ListenTimeout:    time.Millisecond,
+		SendTimeout:      time.Millisecond,
+		LongPoll:         true,
+		EmptyHoldTimeout: 200 * time.Millisecond,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerLongPollTest")
+	assert.Nil(t, err)
+	err = client.SetRcvtimeo(time.Second)
+	assert.Nil(t, err)
+
+	// With nothing enqueued we're held until the hold runs out
+	start := time.Now()
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	resp, err := client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "empty", string(resp[0]))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// A Message enqueued during a hold is delivered right away
+	start = time.Now()
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		acrd.HandleNewMessage(msg)
+	}()
+
+	resp, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "msg", string(resp[0]))
+	sent, err := accord.DeserializeMessage(resp[1])
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, sent.ID)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestPollListenerLongPollStop(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:          "inproc://pollListenerLongPollStopTest",
+		Bind:             true,
+		ListenTimeout:    time.Millisecond,
+		SendTimeout:      time.Millisecond,
+		LongPoll:         true,
+		EmptyHoldTimeout: time.Minute,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerLongPollStopTest")
+	assert.Nil(t, err)
+
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// A held request mustn't keep us from stopping
+	stopped := make(chan struct{})
+	go func() {
+		listener.Stop(0)
+		listener.WaitForStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("PollListener did not stop while holding a long poll")
+	}
+}
+
+func TestPollListenerHandshake(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerHandshakeTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Handshake:     true,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerHandshakeTest")
+	assert.Nil(t, err)
+
+	// We shouldn't be handed anything before we've said hello
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	data, err := client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "error", string(data[0]))
+	assert.Equal(t, "handshake", string(data[1]))
+
+	// A mismatched hello is refused with a description of why
+	mismatched := localHello()
+	mismatched.Codec = "gob"
+	_, err = client.SendMessage(mismatched.frames()...)
+	assert.Nil(t, err)
+	data, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "error", string(data[0]))
+	assert.Contains(t, string(data[1]), "codec")
+
+	// A matching one is answered with what was agreed on
+	_, err = client.SendMessage(localHello().frames()...)
+	assert.Nil(t, err)
+	data, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data[0]))
+	assert.Equal(t, []string{"none"}, parseHello(data).Compression)
+
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	data, err = client.RecvMessageBytes(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "msg", string(data[0]))
+}
+
+func TestPollListenerConfirmations(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerConfirmationsTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Target:        "primary",
+	}
+	acrd := accord.DummyAccord()
+	acrd.ProcessingConfirmations = true
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	err = acrd.HandleNewMessage(msg)
+	assert.Nil(t, err)
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerConfirmationsTest")
+	assert.Nil(t, err)
+
+	request := func(parts ...interface{}) string {
+		_, err := client.SendMessage(parts...)
+		assert.Nil(t, err)
+		data, err := client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		return string(data[0])
+	}
+
+	assert.Equal(t, "msg", request("send"))
+	assert.Equal(t, "deleted", request("ok"))
+
+	confirmed, _ := acrd.ConfirmationStatus(msg.ID)
+	assert.False(t, confirmed)
+
+	// Our remote lets us know it went on to process the Message
+	id := make([]byte, 8)
+	binary.LittleEndian.PutUint64(id, msg.ID)
+	assert.Equal(t, "confirmed", request("applied", id))
+
+	confirmed, at := acrd.ConfirmationStatus(msg.ID)
+	assert.True(t, confirmed)
+	assert.False(t, at.IsZero())
+
+	// A confirmation without a whole ID is refused
+	assert.Equal(t, "error", request("applied", []byte{1}))
+}
+
+// drainListener fetches count Messages from a PollListener as fast as it'll hand them over, acknowledging each
+func drainListener(t *testing.T, client *zmq.Socket, count int) {
+	for i := 0; i < count; i++ {
+		_, err := client.Send("send", 0)
+		assert.Nil(t, err)
+		data, err := client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "msg", string(data[0]))
+
+		_, err = client.Send("ok", 0)
+		assert.Nil(t, err)
+		_, err = client.RecvMessageBytes(0)
+		assert.Nil(t, err)
+	}
+}
+
+func TestPollListenerSendRateLimit(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerSendRateLimitTest",
+		Bind:          true,
+		ListenTimeout: 10 * time.Millisecond,
+		SendTimeout:   time.Second,
+		SendRateLimit: 20,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	for i := 0; i < 30; i++ {
+		msg, err := accord.NewMessage([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Nil(t, acrd.HandleNewMessage(msg))
+	}
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerSendRateLimitTest")
+	assert.Nil(t, err)
+
+	// A second's worth goes out in a burst, after which the other 10 are spread out at 20 a second
+	start := time.Now()
+	drainListener(t, client, 30)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 450*time.Millisecond, "sent 30 messages in %v", elapsed)
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+}
+
+func TestPollListenerSendByteRateLimit(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:           "inproc://pollListenerSendByteRateLimitTest",
+		Bind:              true,
+		ListenTimeout:     10 * time.Millisecond,
+		SendTimeout:       time.Second,
+		SendByteRateLimit: 4096,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	// A little over 8KiB altogether, or a burst of 4KiB and then over a second's worth
+	for i := 0; i < 8; i++ {
+		payload := make([]byte, 1024)
+		payload[0] = byte(i)
+		msg, err := accord.NewMessage(payload)
+		assert.Nil(t, err)
+		assert.Nil(t, acrd.HandleNewMessage(msg))
+	}
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+	defer listener.WaitForStop()
+	defer listener.Stop(0)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerSendByteRateLimitTest")
+	assert.Nil(t, err)
+
+	start := time.Now()
+	drainListener(t, client, 8)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 900*time.Millisecond, "sent 8KiB in %v", elapsed)
+}
+
+func TestPollListenerSendRateLimitStop(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := PollListener{
+		Address:       "inproc://pollListenerSendRateLimitStopTest",
+		Bind:          true,
+		ListenTimeout: 10 * time.Millisecond,
+		SendTimeout:   time.Second,
+		SendRateLimit: 0.01,
+	}
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	for i := 0; i < 2; i++ {
+		msg, err := accord.NewMessage([]byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Nil(t, acrd.HandleNewMessage(msg))
+	}
+
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+
+	client, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer client.Close()
+	err = client.Connect("inproc://pollListenerSendRateLimitStopTest")
+	assert.Nil(t, err)
+
+	// Our burst is all of a single Message, after which the next is held back for the next hundred seconds
+	drainListener(t, client, 1)
+	_, err = client.Send("send", 0)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	// Which doesn't hold up stopping
+	start := time.Now()
+	listener.Stop(0)
+	listener.WaitForStop()
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+}
@@ -0,0 +1,130 @@
+package components
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cj-dimaggio/accord/accord"
+)
+
+// pollProtocolVersion is the version of our poll protocol, exchanged in our "hello" handshake. It must change whenever
+// the shape or meaning of our messages does
+const pollProtocolVersion = 1
+
+// pollCompression lists the compression schemes we support for Messages sent over our poll protocol, in order of
+// preference. For now we only speak uncompressed, but the handshake leaves room to negotiate something better
+var pollCompression = []string{"none"}
+
+// ErrMalformedFrames is returned when a multipart message in our poll protocol doesn't have the shape we expect
+var ErrMalformedFrames = errors.New("malformed poll protocol message")
+
+// pollFrameCounts is the single source of truth for the shape of every message in our poll protocol (spoken between
+// PollRequestor and PollListener), mapping the message's first frame (its kind) to the total number of frames it must
+// have. Anything not in this list is a message we don't know how to handle
+var pollFrameCounts = map[string]int{
+	// Requestor to listener
+	"send":    1,
+	"ok":      1,
+	"applied": 2, // "applied", the ID of a Message we processed as a little endian uint64
+
+	// Either direction
+	"hello": 4, // "hello", protocol version as a little endian uint32, Message codec, comma separated compression
+
+	// Listener to requestor
+	"msg":       2, // "msg", serialized Message
+	"empty":     2, // "empty", our state as a little endian uint64
+	"deleted":   1,
+	"confirmed": 1,
+	"error":     2, // "error", a short description of what went wrong
+	"unknown":   1,
+}
+
+// pollFrameSizes lists any frames that must be an exact size, by message kind and then frame index
+var pollFrameSizes = map[string]map[int]int{
+	"empty":   {1: 8},
+	"hello":   {1: 4},
+	"applied": {1: 8},
+}
+
+// validateFrames checks that a multipart message received over our poll protocol is well formed, returning its kind.
+// A kind we don't recognize isn't considered malformed (so that the caller can decide how to handle it), but is
+// returned along with ErrMalformedFrames if it has anything other than a single frame
+func validateFrames(data [][]byte) (string, error) {
+	if len(data) == 0 {
+		return "", ErrMalformedFrames
+	}
+
+	kind := string(data[0])
+	expected, ok := pollFrameCounts[kind]
+	if !ok {
+		expected = 1
+	}
+
+	if len(data) != expected {
+		return kind, ErrMalformedFrames
+	}
+
+	for index, size := range pollFrameSizes[kind] {
+		if len(data[index]) != size {
+			return kind, ErrMalformedFrames
+		}
+	}
+
+	return kind, nil
+}
+
+// pollHello is what each side of our poll protocol tells the other about itself during the "hello" handshake. When
+// sent by a PollRequestor, Compression lists everything it supports in order of preference. When sent back by a
+// PollListener it holds the single scheme that was chosen
+type pollHello struct {
+	Version     uint32
+	Codec       string
+	Compression []string
+}
+
+// localHello describes ourselves
+func localHello() pollHello {
+	return pollHello{
+		Version:     pollProtocolVersion,
+		Codec:       accord.MessageCodec,
+		Compression: pollCompression,
+	}
+}
+
+// frames builds the multipart message for the hello
+func (hello pollHello) frames() []interface{} {
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, hello.Version)
+	return []interface{}{"hello", version, hello.Codec, strings.Join(hello.Compression, ",")}
+}
+
+// parseHello reads a hello out of a multipart message that has already been through validateFrames
+func parseHello(data [][]byte) pollHello {
+	return pollHello{
+		Version:     binary.LittleEndian.Uint32(data[1]),
+		Codec:       string(data[2]),
+		Compression: strings.Split(string(data[3]), ","),
+	}
+}
+
+// negotiate checks that a remote's hello is compatible with our own and picks the compression to use: the first of the
+// remote's preferences that we also support. A descriptive error is returned if we can't work together
+func negotiate(local, remote pollHello) (string, error) {
+	if local.Version != remote.Version {
+		return "", fmt.Errorf("incompatible poll protocol version: we speak %d, remote speaks %d", local.Version, remote.Version)
+	}
+	if local.Codec != remote.Codec {
+		return "", fmt.Errorf("incompatible message codec: we use %q, remote uses %q", local.Codec, remote.Codec)
+	}
+
+	for _, theirs := range remote.Compression {
+		for _, ours := range local.Compression {
+			if theirs == ours {
+				return ours, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no common compression: we support %v, remote supports %v", local.Compression, remote.Compression)
+}
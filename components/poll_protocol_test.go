@@ -0,0 +1,94 @@
+package components
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFrames(t *testing.T) {
+	frames := func(parts ...string) [][]byte {
+		data := [][]byte{}
+		for _, part := range parts {
+			data = append(data, []byte(part))
+		}
+		return data
+	}
+
+	kind, err := validateFrames(frames("send"))
+	assert.Nil(t, err)
+	assert.Equal(t, "send", kind)
+
+	kind, err = validateFrames(frames("empty", "12345678"))
+	assert.Nil(t, err)
+	assert.Equal(t, "empty", kind)
+
+	// Unknown kinds are fine as long as they're a single frame
+	kind, err = validateFrames(frames("bogus"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bogus", kind)
+
+	_, err = validateFrames(frames())
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	_, err = validateFrames(frames("msg"))
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	_, err = validateFrames(frames("msg", "data", "extra"))
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	_, err = validateFrames(frames("empty", "1234"))
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	kind, err = validateFrames(frames("applied", "12345678"))
+	assert.Nil(t, err)
+	assert.Equal(t, "applied", kind)
+
+	_, err = validateFrames(frames("applied", "1234"))
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	_, err = validateFrames(frames("bogus", "extra"))
+	assert.Equal(t, ErrMalformedFrames, err)
+
+	kind, err = validateFrames(frames("hello", "1234", "codec", "none"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", kind)
+
+	_, err = validateFrames(frames("hello", "1", "codec", "none"))
+	assert.Equal(t, ErrMalformedFrames, err)
+}
+
+func TestPollHandshake(t *testing.T) {
+	toFrames := func(parts []interface{}) [][]byte {
+		data := [][]byte{}
+		for _, part := range parts {
+			switch part := part.(type) {
+			case string:
+				data = append(data, []byte(part))
+			case []byte:
+				data = append(data, part)
+			}
+		}
+		return data
+	}
+
+	local := localHello()
+	remote := parseHello(toFrames(pollHello{Version: local.Version, Codec: local.Codec, Compression: []string{"zstd", "none"}}.frames()))
+	assert.Equal(t, local.Version, remote.Version)
+	assert.Equal(t, local.Codec, remote.Codec)
+	assert.Equal(t, []string{"zstd", "none"}, remote.Compression)
+
+	// We should settle on the common subset
+	compression, err := negotiate(local, remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "none", compression)
+
+	_, err = negotiate(local, pollHello{Version: local.Version + 1, Codec: local.Codec, Compression: []string{"none"}})
+	assert.NotNil(t, err)
+
+	_, err = negotiate(local, pollHello{Version: local.Version, Codec: "gob", Compression: []string{"none"}})
+	assert.NotNil(t, err)
+
+	_, err = negotiate(local, pollHello{Version: local.Version, Codec: local.Codec, Compression: []string{"zstd"}})
+	assert.NotNil(t, err)
+}
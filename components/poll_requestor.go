@@ -1,14 +1,17 @@
+//go:build !noZMQ
+
 package components
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/cj-dimaggio/accord/accord"
 	zmq "github.com/pebbe/zmq4"
 
 	"github.com/sirupsen/logrus"
-	"github.com/syndtr/goleveldb/leveldb/errors"
 )
 
 // PollRequestor is a part of a "polling" scheme, along with PollListener, for use your network demands that you use a polling mechanism
@@ -17,6 +20,9 @@ import (
 type PollRequestor struct {
 	accord.ComponentRunner
 
+	// Name identifies this component so that it can be looked up and managed at runtime. Defaults to "PollRequestor"
+	Name string
+
 	// Address is the ZeroMQ address to use. This must follow the ZMQ addressing schema (transport://endpoint)
 	Address string
 
@@ -29,8 +35,20 @@ type PollRequestor struct {
 	SendTimeout   time.Duration
 
 	// WaitOnEmpty specifies how long we should wait before requesting again if the remote tells us its queue is empty
+	// (or, when handshaking, before trying again with a remote we're incompatible with)
 	WaitOnEmpty time.Duration
 
+	// Handshake makes us begin with a "hello" exchange, confirming that the remote speaks the same protocol version and
+	// Message codec (and agreeing on compression) before we request any Messages. If the remote is incompatible we
+	// refuse to sync with it, logging why, and periodically try again so that sync picks back up once it's upgraded.
+	// This turns a mismatched rolling upgrade into a clear error rather than Messages that silently fail to apply
+	Handshake bool
+
+	// ConfirmProcessing makes us tell our remote about every Message of theirs our Manager actually processes (rather
+	// than skipped, or held on to while processing is paused), so that it can record the confirmation (see
+	// accord.Accord.ProcessingConfirmations). A remote that predates confirmations is detected and simply not sent any
+	ConfirmProcessing bool
+
 	ctx  *zmq.Context
 	sock *zmq.Socket
 	log  *logrus.Entry
@@ -43,14 +61,27 @@ type PollRequestor struct {
 
 	// If we haven't received anything in awhile we're probably in a hung state and we should reset
 	reset int
+
+	// handshaken is set once we've completed a compatible "hello" exchange, and compression holds what we agreed on
+	handshaken  bool
+	compression string
+
+	// applied is the ID of the Message we've processed but have yet to confirm, if any, and confirming is set while
+	// we're waiting to hear back about a confirmation. unconfirmable is set once we learn our remote doesn't support them
+	applied       uint64
+	confirming    bool
+	unconfirmable bool
 }
 
 // Start initializes our PollRequestor and creates, configures, and connects our sockets
 func (requestor *PollRequestor) Start(accord *accord.Accord) (err error) {
-	requestor.log = accord.Logger.WithField("component", "PollRequestor")
+	if requestor.Name == "" {
+		requestor.Name = "PollRequestor"
+	}
+	requestor.log = accord.Logger.WithField("component", requestor.Name)
 
-	requestor.log.Debug("Entering requestMsgState")
-	requestor.state = requestor.requestMsgState
+	requestor.log.Debug("Entering request state")
+	requestor.state = requestor.requestState()
 
 	// Default our timeout to something reasonable
 	if requestor.ListenTimeout == 0 {
@@ -76,16 +107,22 @@ func (requestor *PollRequestor) Start(accord *accord.Accord) (err error) {
 }
 
 func (requestor *PollRequestor) createSocket() (err error) {
+	err = checkZMQ()
+	if err != nil {
+		requestor.log.WithError(err).Error("ZeroMQ is unavailable")
+		return err
+	}
+
 	requestor.ctx, err = zmq.NewContext()
 	if err != nil {
 		requestor.log.WithError(err).Error("Could not create ZeroMQ context")
-		return err
+		return zmqInitError(err)
 	}
 
 	requestor.sock, err = requestor.ctx.NewSocket(zmq.PAIR)
 	if err != nil {
 		requestor.log.WithError(err).Error("Could not create ZeroMQ socket")
-		return err
+		return zmqInitError(err)
 	}
 
 	if requestor.Bind {
@@ -144,6 +181,30 @@ func (requestor *PollRequestor) closeSocket() error {
 	return nil
 }
 
+// ComponentName implements accord.NamedComponent
+func (requestor *PollRequestor) ComponentName() string {
+	return requestor.Name
+}
+
+// Describe implements accord.DescribedComponent
+func (requestor *PollRequestor) Describe() accord.ComponentInfo {
+	health := requestor.Health()
+	return accord.ComponentInfo{
+		Name:    requestor.Name,
+		Type:    fmt.Sprintf("%T", requestor),
+		Address: requestor.Address,
+		Health:  &health,
+		Config: map[string]string{
+			"Bind":              fmt.Sprint(requestor.Bind),
+			"Handshake":         fmt.Sprint(requestor.Handshake),
+			"ConfirmProcessing": fmt.Sprint(requestor.ConfirmProcessing),
+			"ListenTimeout":     requestor.ListenTimeout.String(),
+			"SendTimeout":       requestor.SendTimeout.String(),
+			"WaitOnEmpty":       requestor.WaitOnEmpty.String(),
+		},
+	}
+}
+
 // cleanup makes sure all of our connections are cleaned up and not left in a hanging state
 func (requestor *PollRequestor) cleanup(*accord.Accord) {
 	err := requestor.closeSocket()
@@ -161,11 +222,32 @@ func (requestor *PollRequestor) tick(acrd *accord.Accord) {
 	requestor.state(acrd)
 }
 
-// requestMsgState is our initial state where we send a request off to our remote to get a new message
+// requestState returns the state we should enter when we're ready to make our next request: our handshake if we have
+// yet to complete one, otherwise a request for a new message
+func (requestor *PollRequestor) requestState() func(*accord.Accord) {
+	if requestor.Handshake && !requestor.handshaken {
+		return requestor.helloState
+	}
+	return requestor.requestMsgState
+}
+
+// helloState sends our half of the handshake, describing what we speak, to our remote
+func (requestor *PollRequestor) helloState(acrd *accord.Accord) {
+	requestor.request(localHello().frames()...)
+}
+
+// requestMsgState is our usual starting state where we send a request off to our remote to get a new message
 // from their queue
 func (requestor *PollRequestor) requestMsgState(acrd *accord.Accord) {
+	requestor.request("send")
+}
+
+// request sends the passed in request to our remote and moves us on to receiveState to wait for the response. If the
+// send times out we recreate our socket and try again on our next tick
+func (requestor *PollRequestor) request(parts ...interface{}) {
 	requestor.reset = 0
-	_, err := requestor.sock.Send("send", 0)
+	requestor.confirming = false
+	_, err := requestor.sock.SendMessage(parts...)
 	if err != nil {
 		requestor.ExpectedOrShutdown(err, ZMQTimeout)
 		requestor.log.Debug("Timed out sending. Destroying socket and trying again")
@@ -173,12 +255,12 @@ func (requestor *PollRequestor) requestMsgState(acrd *accord.Accord) {
 		err = requestor.closeSocket()
 		if err != nil {
 			requestor.log.WithError(err).Error("Error closing ZeroMQ socket")
-			requestor.Shutdown(err)
+			requestor.ShutdownWith(accord.ShutdownNetwork, err)
 		}
 		err = requestor.createSocket()
 		if err != nil {
 			requestor.log.WithError(err).Error("Error recreating the the ZeroMQ socket")
-			requestor.Shutdown(err)
+			requestor.ShutdownWith(accord.ShutdownNetwork, err)
 		}
 		return
 	}
@@ -189,8 +271,8 @@ func (requestor *PollRequestor) requestMsgState(acrd *accord.Accord) {
 // receiveState waits to receive a response from our remote
 func (requestor *PollRequestor) receiveState(acrd *accord.Accord) {
 	if requestor.reset >= 10 {
-		requestor.log.Debug("Timed out listening too many times. Re-entering requestMsgState")
-		requestor.state = requestor.requestMsgState
+		requestor.log.Debug("Timed out listening too many times. Re-entering request state")
+		requestor.state = requestor.requestState()
 		return
 	}
 
@@ -202,14 +284,38 @@ func (requestor *PollRequestor) receiveState(acrd *accord.Accord) {
 		return
 	}
 
-	// PollListener sends a multipart ZMQ message, let's look at the first part to see what kind of response we got
-	switch string(data[0]) {
-	case "msg":
-		// We received an actual message from the remote and we must now process it
-		if len(data) < 2 {
-			requestor.log.Error("Received a message from remote that we don't know how to parse")
+	// PollListener sends a multipart ZMQ message, let's make sure it's well formed and then look at the first part to
+	// see what kind of response we got
+	kind, err := validateFrames(data)
+	if err != nil {
+		requestor.log.WithField("message", kind).WithField("frames", len(data)).Error("Received a malformed response from remote")
+		requestor.log.Debug("Entering request state")
+		requestor.state = requestor.requestState()
+		return
+	}
+
+	// Whatever the answer to a confirmation is, we're done with it
+	if requestor.confirming {
+		requestor.applied = 0
+	}
+
+	switch kind {
+	case "hello":
+		// Our remote has answered our handshake with what it speaks and the compression it picked
+		remote := parseHello(data)
+		compression, err := negotiate(localHello(), remote)
+		if err != nil {
+			requestor.log.WithError(err).Error("Remote is incompatible, refusing to sync with it")
+			time.Sleep(requestor.WaitOnEmpty)
 			break
 		}
+
+		requestor.log.WithField("compression", compression).Info("Completed handshake with remote")
+		requestor.handshaken = true
+		requestor.compression = compression
+
+	case "msg":
+		// We received an actual message from the remote and we must now process it
 		msg, err := accord.DeserializeMessage(data[1])
 		if err != nil {
 			// Not much we can do, let's just log, return and try again I guess
@@ -217,15 +323,27 @@ func (requestor *PollRequestor) receiveState(acrd *accord.Accord) {
 			break
 		}
 
-		err = acrd.HandleRemoteMessage(msg)
+		result, err := acrd.HandleRemoteMessageWithResult(msg)
+		if errors.Is(err, accord.ErrShuttingDown) {
+			// Accord is shutting down, so rather than asking for more we wait here for it to stop us. Crucially we never
+			// send our "ok", so our remote keeps the Message and it'll be sent to us again once we're back up
+			requestor.log.WithError(err).Error("Error handling remote message, waiting to be stopped without acknowledging it")
+			requestor.log.Debug("Entering shutdownState")
+			requestor.state = requestor.shutdownState
+			return
+		}
 		if err != nil {
-			// again, not much recourse here, we just have to give up on this sequence and try again
-			// (although if we do get an error from HandleRemoteMessage it probably means Accord will
-			// shutdown shortly after this)
-			requestor.log.WithError(err).Error("Error handling remote message")
+			// Accord rejected this one Message (it's over our payload limit, has an ID that doesn't match its content,
+			// or doesn't satisfy its schema, say) but is otherwise fine. We don't acknowledge it, so when we ask for more our remote learns we didn't take it, and can give
+			// up on it if it keeps failing (see accord.Accord.ReportSyncFailure)
+			requestor.log.WithError(err).Error("Rejecting remote message")
 			break
 		}
 
+		if result.Processed && requestor.ConfirmProcessing && !requestor.unconfirmable {
+			requestor.applied = msg.ID
+		}
+
 		// We need to send out our "ok" to tell the remote it's okay to clean up
 		requestor.log.Debug("Entering sendOKState")
 		requestor.state = requestor.sendOKState
@@ -234,12 +352,8 @@ func (requestor *PollRequestor) receiveState(acrd *accord.Accord) {
 	case "empty":
 		// If the remote is empty than we should tell accord to check our state against theirs and then wait a bit before
 		// sending a new request
-		if len(data) < 2 {
-			requestor.log.Error("Received an 'empty' from remote that we don't know how to parse")
-		} else {
-			state := binary.LittleEndian.Uint64(data[1])
-			acrd.CheckRemoteState(state)
-		}
+		state := binary.LittleEndian.Uint64(data[1])
+		acrd.CheckRemoteState(state)
 		time.Sleep(requestor.WaitOnEmpty)
 
 	case "deleted":
@@ -247,29 +361,69 @@ func (requestor *PollRequestor) receiveState(acrd *accord.Accord) {
 		// log it and move on
 		requestor.log.Debug("Remote has dequeued")
 
+		// Now that the remote has moved on we can let it know we went on to process the Message
+		if requestor.applied != 0 {
+			requestor.log.Debug("Entering confirmState")
+			requestor.state = requestor.confirmState
+			return
+		}
+
+	case "confirmed":
+		requestor.log.Debug("Remote has recorded our confirmation")
+
 	case "error":
 		// Looks like we received an error from the remote, we need to log it and see if there's anything we should
 		// do
-		if len(data) >= 2 {
-			remoteErr := string(data[1])
-			requestor.log.WithField("errorMessage", remoteErr).Error("Received error from remote")
-
-			// You can look at the PollListener code to see why this is such a bad thing, and why our best course
-			// of action for this particular error is to panic and shutdown
-			if remoteErr == "dequeue" {
-				requestor.log.Fatal("Received a dequeue error from remote")
-				requestor.Shutdown(errors.New("remote dequeue received"))
-			}
-		} else {
-			requestor.log.Warn("Received an unparsable error from remote")
+		remoteErr := string(data[1])
+		requestor.log.WithField("errorMessage", remoteErr).Error("Received error from remote")
+
+		// While handshaking an error means the remote has refused us, so we give it a moment before trying again
+		if requestor.Handshake && !requestor.handshaken {
+			requestor.log.Error("Remote refused our handshake, refusing to sync with it")
+			time.Sleep(requestor.WaitOnEmpty)
+		}
+
+		// You can look at the PollListener code to see why this is such a bad thing, and why our best course
+		// of action for this particular error is to panic and shutdown
+		if remoteErr == "dequeue" {
+			requestor.log.Fatal("Received a dequeue error from remote")
+			requestor.ShutdownWith(accord.ShutdownRemote, errors.New("remote dequeue received"))
 		}
 	default:
-		requestor.log.WithField("message", string(data[0])).Warn("Got a message we don't know how to handle")
+		// A remote that doesn't know what "applied" is predates processing confirmations, so there's no point sending
+		// it any more of them
+		if requestor.confirming && kind == "unknown" {
+			requestor.log.Warn("Remote does not support processing confirmations, no longer sending them")
+			requestor.unconfirmable = true
+			break
+		}
+
+		requestor.log.WithField("message", kind).Warn("Got a message we don't know how to handle")
+
+		// A remote that doesn't know what a "hello" is predates our handshake, so we can't be sure we're compatible
+		if requestor.Handshake && !requestor.handshaken {
+			requestor.log.Error("Remote does not support our handshake, refusing to sync with it")
+			time.Sleep(requestor.WaitOnEmpty)
+		}
 	}
 	// We've received something and handled it, so now let's go back to our request state
-	requestor.log.Debug("Entering requestMsgState")
-	requestor.state = requestor.requestMsgState
+	requestor.log.Debug("Entering request state")
+	requestor.state = requestor.requestState()
+
+}
+
+// shutdownState is where we wait, doing nothing, for a shutting down Accord to stop us
+func (requestor *PollRequestor) shutdownState(acrd *accord.Accord) {
+	time.Sleep(requestor.ListenTimeout)
+}
+
+// confirmState tells our remote that we processed the last Message it sent us
+func (requestor *PollRequestor) confirmState(acrd *accord.Accord) {
+	id := make([]byte, 8)
+	binary.LittleEndian.PutUint64(id, requestor.applied)
 
+	requestor.request("applied", id)
+	requestor.confirming = true
 }
 
 // sendOKState sends out an "ok" message to the remote server to signify that
@@ -1,7 +1,10 @@
+//go:build !noZMQ
+
 package components
 
 import (
 	"encoding/binary"
+	"errors"
 	"testing"
 	"time"
 
@@ -86,3 +89,412 @@ func TestPollRequestor(t *testing.T) {
 	assert.Equal(t, uint64(0), acrd.Status().HistorySize)
 
 }
+
+func TestPollRequestorMalformed(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorMalformedTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+
+	manager := accord.DummyManager{ShouldProcessRet: true}
+	acrd := accord.DummyAccordManager(&manager)
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorMalformedTest")
+	assert.Nil(t, err)
+
+	msgData, err := (&accord.Message{ID: 5, Payload: []byte{1}}).Serialize()
+	assert.Nil(t, err)
+
+	malformed := [][]interface{}{
+		{"msg"},
+		{"msg", msgData, "extra"},
+		{"empty", []byte{1, 2, 3}},
+		{"error"},
+	}
+
+	// Every malformed response should be dropped and we should simply get asked again
+	for _, response := range malformed {
+		data, err := server.Recv(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "send", data)
+
+		_, err = server.SendMessage(response...)
+		assert.Nil(t, err)
+	}
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	assert.Equal(t, 0, manager.ProcessCount)
+}
+
+func TestPollRequestorMaxPayloadSize(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorMaxPayloadTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+
+	manager := accord.DummyManager{ShouldProcessRet: true}
+	acrd := accord.DummyAccordManager(&manager)
+	acrd.MaxPayloadSize = 2
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorMaxPayloadTest")
+	assert.Nil(t, err)
+
+	msgData, err := (&accord.Message{ID: 5, Payload: []byte{1, 2, 3}}).Serialize()
+	assert.Nil(t, err)
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	_, err = server.SendMessage("msg", msgData)
+	assert.Nil(t, err)
+
+	// Our oversized message should be rejected rather than acknowledged
+	data, err = server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	assert.Equal(t, 0, manager.ProcessCount)
+}
+
+func TestPollRequestorVerifyMessageIDs(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorVerifyTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+
+	manager := accord.DummyManager{ShouldProcessRet: true}
+	acrd := accord.DummyAccordManager(&manager)
+	acrd.VerifyMessageIDs = true
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorVerifyTest")
+	assert.Nil(t, err)
+
+	msg, err := accord.NewMessage([]byte("abc"))
+	assert.Nil(t, err)
+	msg.ID++
+	msgData, err := msg.Serialize()
+	assert.Nil(t, err)
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	_, err = server.SendMessage("msg", msgData)
+	assert.Nil(t, err)
+
+	// Our tampered message should be rejected rather than acknowledged
+	data, err = server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	assert.Equal(t, 0, manager.ProcessCount)
+}
+
+func TestPollRequestorHandshake(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorHandshakeTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+		Handshake:     true,
+	}
+
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorHandshakeTest")
+	assert.Nil(t, err)
+
+	expectHello := func() {
+		data, err := server.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(data[0]))
+		assert.Equal(t, localHello(), parseHello(data))
+	}
+
+	// A remote that predates our handshake, or refuses it, or is incompatible shouldn't be synced with
+	expectHello()
+	_, err = server.SendMessage("unknown")
+	assert.Nil(t, err)
+
+	expectHello()
+	_, err = server.SendMessage("error", "incompatible")
+	assert.Nil(t, err)
+
+	expectHello()
+	mismatched := localHello()
+	mismatched.Version++
+	_, err = server.SendMessage(mismatched.frames()...)
+	assert.Nil(t, err)
+
+	// Once we've agreed we move on to requesting messages
+	expectHello()
+	_, err = server.SendMessage(localHello().frames()...)
+	assert.Nil(t, err)
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+}
+
+func TestPollRequestorConfirmProcessing(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:           "inproc://pollRequestorConfirmTest",
+		Bind:              false,
+		ListenTimeout:     time.Millisecond,
+		SendTimeout:       time.Millisecond,
+		WaitOnEmpty:       time.Millisecond,
+		ConfirmProcessing: true,
+	}
+
+	manager := accord.DummyManager{ShouldProcessRet: true}
+	acrd := accord.DummyAccordManager(&manager)
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorConfirmTest")
+	assert.Nil(t, err)
+
+	// deliver plays the listener's side of sending over a Message, up to its "deleted"
+	deliver := func(msg accord.Message) {
+		data, err := server.Recv(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "send", data)
+
+		serialized, err := msg.Serialize()
+		assert.Nil(t, err)
+		_, err = server.SendMessage("msg", serialized)
+		assert.Nil(t, err)
+
+		data, err = server.Recv(0)
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", data)
+		_, err = server.Send("deleted", 0)
+		assert.Nil(t, err)
+	}
+	expectApplied := func(id uint64) {
+		data, err := server.RecvMessageBytes(0)
+		assert.Nil(t, err)
+		assert.Len(t, data, 2)
+		assert.Equal(t, "applied", string(data[0]))
+		assert.Equal(t, id, binary.LittleEndian.Uint64(data[1]))
+	}
+
+	// A Message we process is confirmed once the remote has dequeued it
+	deliver(accord.Message{ID: 5, Payload: []byte{1}})
+	expectApplied(5)
+	_, err = server.Send("confirmed", 0)
+	assert.Nil(t, err)
+
+	// One we decide against isn't
+	manager.ShouldProcessRet = false
+	deliver(accord.Message{ID: 6, StateAt: 1, Payload: []byte{2}})
+	manager.ShouldProcessRet = true
+
+	// And a remote that doesn't understand confirmations isn't sent any more of them
+	deliver(accord.Message{ID: 7, StateAt: 5, Payload: []byte{3}})
+	expectApplied(7)
+	_, err = server.Send("unknown", 0)
+	assert.Nil(t, err)
+
+	deliver(accord.Message{ID: 8, StateAt: 7, Payload: []byte{4}})
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	assert.Equal(t, 3, manager.ProcessCount)
+}
+
+// failingManager errors on every Message it's asked to process, which brings Accord down
+type failingManager struct {
+	accord.DummyManager
+}
+
+func (manager *failingManager) Process(msg accord.Message, fromRemote bool) error {
+	return errors.New("failed to process")
+}
+
+func TestPollRequestorHandleRemoteError(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorHandleErrorTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+
+	acrd := accord.DummyAccordManager(&failingManager{})
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorHandleErrorTest")
+	assert.Nil(t, err)
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+
+	msgData, err := (&accord.Message{ID: 5, Payload: []byte{1}}).Serialize()
+	assert.Nil(t, err)
+	_, err = server.SendMessage("msg", msgData)
+	assert.Nil(t, err)
+
+	// Accord is shutting down, so our requestor should neither tell our remote it was okay to dequeue the Message nor
+	// ask for more
+	err = server.SetRcvtimeo(50 * time.Millisecond)
+	assert.Nil(t, err)
+	_, err = server.Recv(0)
+	assert.NotNil(t, err)
+
+	// It leaves stopping to Accord, rather than stopping itself from inside its own tick
+	assert.False(t, requestor.Health().Stopped)
+	requestor.Stop(0)
+	requestor.WaitForStop()
+	assert.True(t, requestor.Health().Stopped)
+}
+
+func TestPollRequestorRejectedRemoteMessage(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	requestor := PollRequestor{
+		Address:       "inproc://pollRequestorRejectedTest",
+		Bind:          false,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+
+	manager := &accord.DummyManager{}
+	acrd := accord.DummyAccordManager(manager)
+	acrd.InboundTransformer = accord.InboundFunc(func(msg accord.Message) (accord.Message, error) {
+		if string(msg.Payload) == "bad" {
+			return msg, errors.New("can't read that")
+		}
+		return msg, nil
+	})
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	err = requestor.Start(acrd)
+	assert.Nil(t, err)
+	defer requestor.WaitForStop()
+	defer requestor.Stop(0)
+
+	server, err := zmq.NewSocket(zmq.PAIR)
+	assert.Nil(t, err)
+	defer server.Close()
+	err = server.Bind("inproc://pollRequestorRejectedTest")
+	assert.Nil(t, err)
+
+	send := func(msg *accord.Message) {
+		msgData, err := msg.Serialize()
+		assert.Nil(t, err)
+		_, err = server.SendMessage("msg", msgData)
+		assert.Nil(t, err)
+	}
+
+	data, err := server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+
+	// A Message Accord rejects isn't acknowledged, but our requestor carries on asking for more
+	send(&accord.Message{ID: 5, Payload: []byte("bad")})
+	data, err = server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "send", data)
+	assert.False(t, requestor.Health().Stopped)
+
+	// And handles the next one as usual
+	send(&accord.Message{ID: 6, Payload: []byte("good")})
+	data, err = server.Recv(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", data)
+	assert.Equal(t, 1, manager.ProcessCount)
+}
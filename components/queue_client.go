@@ -0,0 +1,235 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// ErrNothingPeeked is returned by QueueClient.Dequeue when there's no peeked Message for it to dequeue
+var ErrNothingPeeked = errors.New("no message has been peeked")
+
+// ErrUnexpectedReply is returned by QueueClient when the queue's owner answers with something other than what our
+// request calls for
+var ErrUnexpectedReply = errors.New("unexpected reply from the queue's owner")
+
+// QueueOwnerError is returned by QueueClient when the process owning the queue reports that it couldn't do what we
+// asked (it couldn't read its queue, say, or our handshake was incompatible)
+type QueueOwnerError struct {
+	// Reason is what the queue's owner told us went wrong
+	Reason string
+}
+
+// Error implements error
+func (err *QueueOwnerError) Error() string {
+	return fmt.Sprintf("queue owner reported an error: %s", err.Reason)
+}
+
+// QueueClient lets a separate process on the same host drain an Accord process's sync queue, for splitting ingestion
+// and sending into processes of their own. Our queue is a LevelDB store, which only one process can have open at a
+// time, so rather than opening it twice the process that ingests Messages owns the queue as usual and serves it
+// through a PollListener bound to a local address (an "ipc://" path, say) with Handshake set. The sender process
+// dials that address with DialQueue and drives the queue with Peek and Dequeue, delivering each Message however it
+// likes in between.
+//
+// It's the same poll protocol PollRequestor speaks, so whatever the PollListener is configured with applies (a Target
+// to keep its own cursor, Prioritized, delivery modes and so on). If the PollListener uses LongPoll, our timeout must
+// be longer than its EmptyHoldTimeout. A Message that's peeked but never dequeued, because the sender crashed or we
+// timed out, is handed out again by the next Peek, so delivery is at least once. A PollListener only serves a single
+// client, so run one for each sender process. A QueueClient is safe for concurrent use, although requests are made
+// one at a time
+type QueueClient struct {
+	address string
+	timeout time.Duration
+
+	// sock is our connection to the queue's owner. It's nil after a failed request, until the next one reconnects
+	sock *zmq.Socket
+
+	// peeked is the Message our last Peek returned, which is still at the head of the queue until we Dequeue it
+	peeked *accord.Message
+
+	lock *sync.Mutex
+}
+
+// DialQueue connects to the PollListener serving a sync queue at the given ZeroMQ address and completes our handshake
+// with it, giving up on any request that takes longer than timeout (defaulting to 2 seconds)
+func DialQueue(address string, timeout time.Duration) (*QueueClient, error) {
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	client := &QueueClient{
+		address: address,
+		timeout: timeout,
+		lock:    &sync.Mutex{},
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	err := client.connect()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// connect creates our socket and says hello to the queue's owner, making sure we speak the same protocol and Message
+// codec. lock must be held by the caller
+func (client *QueueClient) connect() error {
+	err := checkZMQ()
+	if err != nil {
+		return err
+	}
+
+	client.sock, err = zmq.NewSocket(zmq.PAIR)
+	if err != nil {
+		return zmqInitError(err)
+	}
+
+	for _, setup := range []func() error{
+		func() error { return client.sock.SetSndtimeo(client.timeout) },
+		func() error { return client.sock.SetRcvtimeo(client.timeout) },
+		func() error { return client.sock.SetLinger(0) },
+		func() error { return client.sock.Connect(client.address) },
+	} {
+		err = setup()
+		if err != nil {
+			client.disconnect()
+			return err
+		}
+	}
+
+	kind, data, err := client.exchange(localHello().frames()...)
+	if err != nil {
+		client.disconnect()
+		return err
+	}
+	if kind != "hello" {
+		client.disconnect()
+		return ErrUnexpectedReply
+	}
+
+	_, err = negotiate(localHello(), parseHello(data))
+	if err != nil {
+		client.disconnect()
+		return err
+	}
+	return nil
+}
+
+// disconnect closes our socket, forgetting anything we'd peeked, so that our next request starts over. lock must be
+// held by the caller
+func (client *QueueClient) disconnect() {
+	if client.sock != nil {
+		client.sock.Close()
+		client.sock = nil
+	}
+	client.peeked = nil
+}
+
+// exchange sends a request to the queue's owner and returns the kind of its reply, along with the reply itself. A
+// reply we can't trust (or don't get at all) disconnects us, as our socket can no longer be relied on to pair our
+// requests up with their replies. lock must be held by the caller
+func (client *QueueClient) exchange(parts ...interface{}) (string, [][]byte, error) {
+	if client.sock == nil {
+		err := client.connect()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	_, err := client.sock.SendMessage(parts...)
+	if err != nil {
+		client.disconnect()
+		return "", nil, err
+	}
+
+	data, err := client.sock.RecvMessageBytes(0)
+	if err != nil {
+		client.disconnect()
+		return "", nil, err
+	}
+
+	kind, err := validateFrames(data)
+	if err != nil {
+		client.disconnect()
+		return kind, nil, err
+	}
+
+	switch kind {
+	case "error":
+		return kind, nil, &QueueOwnerError{Reason: string(data[1])}
+	case "unknown":
+		return kind, nil, &QueueOwnerError{Reason: "unknown request"}
+	}
+	return kind, data, nil
+}
+
+// Peek returns the Message at the head of the queue without taking it off, or nil if the queue is empty. The same
+// Message is returned until it's been dequeued
+func (client *QueueClient) Peek() (*accord.Message, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.peeked != nil {
+		return client.peeked, nil
+	}
+
+	kind, data, err := client.exchange("send")
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "msg":
+		msg, err := accord.DeserializeMessage(data[1])
+		if err != nil {
+			return nil, err
+		}
+		client.peeked = msg
+		return msg, nil
+	case "empty":
+		return nil, nil
+	default:
+		client.disconnect()
+		return nil, ErrUnexpectedReply
+	}
+}
+
+// Dequeue takes the Message our last Peek returned off the queue, once it's been dealt with. Returns ErrNothingPeeked
+// if there isn't one
+func (client *QueueClient) Dequeue() error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.peeked == nil {
+		return ErrNothingPeeked
+	}
+
+	kind, _, err := client.exchange("ok")
+	if err != nil {
+		return err
+	}
+	if kind != "deleted" {
+		client.disconnect()
+		return ErrUnexpectedReply
+	}
+
+	client.peeked = nil
+	return nil
+}
+
+// Close disconnects us from the queue's owner. Anything we'd peeked but not dequeued stays on the queue
+func (client *QueueClient) Close() {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.disconnect()
+}
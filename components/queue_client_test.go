@@ -0,0 +1,123 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+// startQueueOwner starts an Accord holding the given payloads in its sync queue, serving it through a PollListener on
+// a local socket, as an ingest process would
+func startQueueOwner(t *testing.T, address string, payloads ...string) (*accord.Accord, []*accord.Message, func()) {
+	acrd := accord.DummyAccord()
+	err := acrd.Start()
+	assert.Nil(t, err)
+
+	msgs := []*accord.Message{}
+	for _, payload := range payloads {
+		msg, err := accord.NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		err = acrd.HandleNewMessage(msg)
+		assert.Nil(t, err)
+		msgs = append(msgs, msg)
+	}
+
+	listener := &PollListener{
+		Address:       address,
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Handshake:     true,
+	}
+	err = listener.Start(acrd)
+	assert.Nil(t, err)
+
+	return acrd, msgs, func() {
+		listener.Stop(0)
+		listener.WaitForStop()
+		acrd.Stop()
+	}
+}
+
+// queueAddress is a local socket for a test to serve a queue on
+func queueAddress(name string) string {
+	return "ipc://" + filepath.Join(os.TempDir(), name)
+}
+
+func TestQueueClient(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	address := queueAddress("accordQueueClientTest")
+	acrd, msgs, stop := startQueueOwner(t, address, "first", "second")
+	defer stop()
+
+	client, err := DialQueue(address, 0)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// Peeking leaves the Message where it is, however many times we look
+	for i := 0; i < 2; i++ {
+		msg, err := client.Peek()
+		assert.Nil(t, err)
+		assert.Equal(t, msgs[0].ID, msg.ID)
+		assert.Equal(t, msgs[0].Payload, msg.Payload)
+	}
+	assert.Equal(t, uint64(2), acrd.Status().ToBeSyncedSize)
+
+	// Until we dequeue it
+	err = client.Dequeue()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+	assert.Equal(t, ErrNothingPeeked, client.Dequeue())
+
+	msg, err := client.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msgs[1].ID, msg.ID)
+	err = client.Dequeue()
+	assert.Nil(t, err)
+
+	// Once it's drained there's nothing left to peek
+	msg, err = client.Peek()
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+}
+
+func TestQueueClientAbandoned(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	address := queueAddress("accordQueueClientAbandonedTest")
+	acrd, msgs, stop := startQueueOwner(t, address, "first")
+	defer stop()
+
+	// A sender that goes away without dequeuing what it peeked...
+	client, err := DialQueue(address, 0)
+	assert.Nil(t, err)
+	msg, err := client.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msgs[0].ID, msg.ID)
+	client.Close()
+
+	// ...leaves it for the next one
+	client, err = DialQueue(address, 0)
+	assert.Nil(t, err)
+	defer client.Close()
+	msg, err = client.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msgs[0].ID, msg.ID)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+}
+
+func TestQueueClientNoOwner(t *testing.T) {
+	// Nobody is serving this queue, so we never hear back
+	_, err := DialQueue(queueAddress("accordQueueClientNoOwnerTest"), 10*time.Millisecond)
+	assert.Equal(t, ZMQTimeout, err)
+}
@@ -0,0 +1,52 @@
+package components
+
+import (
+	"time"
+)
+
+// tokenBucket rate limits something to rate units a second, allowing bursts of up to a second's worth (and never less
+// than a single unit, so that a rate below one a second still lets something through straight away). Rather than
+// turning away whatever doesn't fit, take lets the bucket go into debt and says how long to wait before going ahead, so
+// that a single take larger than the bucket (one big Message, under a byte limit) is slowed down rather than stuck
+// forever. It isn't safe for concurrent use
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket for the given rate, or nil (which never limits anything) if rate isn't positive
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take removes n tokens from the bucket, returning when whatever they pay for may go ahead: now if the bucket had them
+// to spare, otherwise once it has refilled enough to cover its debt
+func (bucket *tokenBucket) take(n float64, now time.Time) time.Time {
+	if bucket == nil {
+		return now
+	}
+
+	// Refill for the time that's passed, up to our burst
+	if now.After(bucket.last) {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+		bucket.last = now
+	}
+
+	bucket.tokens -= n
+	if bucket.tokens >= 0 {
+		return now
+	}
+	return now.Add(time.Duration(-bucket.tokens / bucket.rate * float64(time.Second)))
+}
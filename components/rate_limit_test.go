@@ -0,0 +1,80 @@
+package components
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketStaysUnderRate(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(10, start)
+
+	// Sending as fast as we're allowed to for ten seconds, with a burst of a second's worth up front. The window ends
+	// halfway between two sends, so that rounding can't move one across it
+	now := start
+	sent := 0
+	for {
+		at := bucket.take(1, now)
+		if at.Sub(start) > 10*time.Second+50*time.Millisecond {
+			break
+		}
+		now = at
+		sent++
+	}
+	assert.Equal(t, 110, sent)
+
+	// And over any one second window past the burst, no more than the rate gets through
+	bucket = newTokenBucket(10, start)
+	times := []time.Time{}
+	now = start
+	for i := 0; i < 50; i++ {
+		now = bucket.take(1, now)
+		times = append(times, now)
+	}
+	for i := 10; i+11 < len(times); i++ {
+		assert.True(t, times[i+11].Sub(times[i]) > time.Second, "more than 10 in a second starting at %d", i)
+	}
+}
+
+func TestTokenBucketLargeTake(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(100, start)
+
+	// Something bigger than a second's worth still goes, once the bucket has refilled enough to cover it
+	sendAt := bucket.take(250, start)
+	assert.Equal(t, start.Add(1500*time.Millisecond), sendAt)
+
+	// By when it's gone the bucket is empty again
+	assert.Equal(t, sendAt.Add(10*time.Millisecond), bucket.take(1, sendAt))
+}
+
+func TestTokenBucketIdleRefill(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(10, start)
+	bucket.take(10, start)
+
+	// Sitting idle only ever builds back up to a second's worth
+	later := start.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, later, bucket.take(1, later))
+	}
+	assert.True(t, bucket.take(1, later).After(later))
+}
+
+func TestTokenBucketSlowRate(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(0.5, start)
+
+	// Less than one a second still lets the first through straight away, and the next two seconds later
+	assert.Equal(t, start, bucket.take(1, start))
+	assert.Equal(t, start.Add(2*time.Second), bucket.take(1, start))
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	now := time.Now()
+	var bucket *tokenBucket
+	assert.Nil(t, newTokenBucket(0, now))
+	assert.Equal(t, now, bucket.take(1e9, now))
+}
@@ -1,15 +1,29 @@
 package components
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cj-dimaggio/accord/accord"
 	"github.com/sirupsen/logrus"
 )
 
+// Middleware wraps an http.Handler with some cross-cutting behavior (logging, tracing, authentication, CORS, etc...)
+type Middleware func(http.Handler) http.Handler
+
 // WebReceiver is a Component that is responsible for starting an HTTP server and ingesting
 // incoming local commands. While the main functionality is to allow for the insertion of
 // new commands into the system, it should be thought more broadly as the general point
@@ -24,87 +38,455 @@ import (
 // most basic of authentication. Meaning that the implementor should exercise caution to make
 // sure that the server is only bound to localhost or, if exposed to the internet, behind
 // a reverse proxy (such as nginx) so that basic authentication and TLS can be added.
+//
+// Our HTTP API is versioned so that response formats can evolve without breaking existing clients. A client picks a
+// version either by prefixing the path with it ("/v1/status") or, for an unprefixed path, with a "version" parameter on
+// its Accept header ("Accept: application/json; version=1"); the path prefix wins if both are given. Asking for a
+// version we don't serve gets a 404 for a path prefix and a 406 for an Accept header. Unversioned requests are served by
+// version 1, so the original routes ("/", "/ping", "/status", etc...) remain aliases for their "/v1" counterparts. Every
+// response carries the version that served it in an "API-Version" header
 type WebReceiver struct {
 
+	// Name identifies this component so that it can be looked up at runtime. Defaults to "WebReceiver"
+	Name string
+
 	// The address the HTTP server should bind to
 	BindAddress string
 
+	// BindRetries is how many more times we'll try to bind BindAddress, BindRetryDelay apart, if it's already in use
+	// when we start. This smooths over restarts where the previous process is still letting go of the port. Zero (the
+	// default) means we give up straight away. BindRetryDelay defaults to 500 milliseconds
+	BindRetries    int
+	BindRetryDelay time.Duration
+
+	// ShutdownTimeout is how long we give in-flight requests to finish when stopping before we forcibly close their
+	// connections. Defaults to 5 seconds
+	ShutdownTimeout time.Duration
+
+	// MaxConcurrentRequests caps how many requests we'll handle at once. Requests beyond the cap are immediately
+	// turned away with a 503 rather than being left to pile up waiting on Accord, which gives clients backpressure
+	// instead of letting a flood of connections become a flood of goroutines. "/ping" is exempt so that health checks
+	// keep working while we're saturated. Zero (the default) means there's no limit
+	MaxConcurrentRequests int
+
+	// MaxPendingBeforeReject caps how deep our sync backlog (Accord's ToBeSynced queue) can get before we stop accepting
+	// new commands. Once it's reached, new commands are turned away with a 503 until synchronization catches up, so that
+	// producers slow down to the rate we can actually sync at rather than growing the backlog until the disk fills.
+	// Zero (the default) means there's no limit
+	MaxPendingBeforeReject uint64
+
+	// DedupWindow guards against clients that retry a command we already handled. A command whose payload is byte
+	// identical to one we accepted within the window, sent to the same endpoint (a command or a relay) with the same
+	// type, isn't handled again; instead we respond with a 200 and the result the original got, marked as a Duplicate.
+	// Zero (the default) disables the check
+	DedupWindow time.Duration
+
+	// Middleware is a chain of user supplied wrappers that every request passes through, in order, before reaching
+	// its handler. The first Middleware is the outermost
+	Middleware []Middleware
+
+	// slots is the semaphore used to enforce MaxConcurrentRequests
+	slots chan struct{}
+
+	// recent remembers the payloads we've accepted within our DedupWindow
+	recent *payloadCache
+
+	// routes holds any additional handlers registered through Handle, to be added to our mux on Start
+	routes []route
+
 	// server is the HTTP web server that will be binding to a port and listening for requests
 	server *http.Server
 
 	// mux is where we register our HTTP routes so that requests can be dispatched to the correct
-	// function handlers
+	// function handlers. Note that it does *not* include our Middleware, which wraps it
 	mux *http.ServeMux
 
-	// stopSignal is used to communicate with our background process to cleanly shutdown our
-	// process
-	stopSignal *sync.Cond
+	// stopOnce makes sure that we only ever begin shutting down once, no matter how many times Stop
+	// gets called
+	stopOnce *sync.Once
+
+	// done is closed once our HTTP server has been completely shutdown. Much like ComponentRunner's done
+	// signal, but a closed channel can be safely waited on by any number of goroutines, at any time, without
+	// having to worry about missing a broadcast
+	done chan struct{}
 
-	stopping bool
+	// serving is closed once our HTTP server's Serve has returned, so that we're not done until it has
+	serving chan struct{}
 
 	accord *accord.Accord
 	log    *logrus.Entry
 }
 
-// Start initializes our web routes and starts the HTTP server (it does *not*, however, assure
-// that the port is completely bound and listening at the time it returns, as this occurs in a
-// background thread)
+// Start initializes our web routes and starts the HTTP server. Our address is bound before we return, so that a port
+// that's already in use is reported as an error rather than leaving us running without anything to serve, but requests
+// are served in a background thread
 func (receiver *WebReceiver) Start(accord *accord.Accord) (err error) {
 	// Save a reference to our accord instance so we can use it within our handlers
 	receiver.accord = accord
-	receiver.log = accord.Logger.WithField("component", "WebReceiver")
+	if receiver.Name == "" {
+		receiver.Name = "WebReceiver"
+	}
+	receiver.log = accord.Logger.WithField("component", receiver.Name)
 
 	// Will be used much the same way as ComponentRunner, to signal when the background thread
 	// has been cleanly shutdown
-	receiver.stopSignal = sync.NewCond(&sync.Mutex{})
+	receiver.stopOnce = &sync.Once{}
+	receiver.done = make(chan struct{})
+
+	if receiver.ShutdownTimeout == 0 {
+		receiver.ShutdownTimeout = 5 * time.Second
+	}
+
+	if receiver.BindRetryDelay == 0 {
+		receiver.BindRetryDelay = 500 * time.Millisecond
+	}
+
+	if receiver.DedupWindow > 0 {
+		receiver.recent = newPayloadCache(receiver.DedupWindow)
+	}
 
 	receiver.mux = http.NewServeMux()
 
-	// Register our routes
-	receiver.mux.HandleFunc("/", receiver.newCommand)
-	receiver.mux.HandleFunc("/ping", receiver.ping)
-	receiver.mux.HandleFunc("/status", receiver.status)
+	// Register our routes, letting any user registered routes take the place of our built in ones
+	overridden := map[string]bool{}
+	for _, r := range receiver.routes {
+		receiver.mux.Handle(r.pattern, r.handler)
+		overridden[r.pattern] = true
+	}
 
-	// Start our server in a background thread so that we don't block
-	receiver.server = &http.Server{Addr: receiver.BindAddress, Handler: receiver.mux}
+	builtin := []route{
+		{"/", http.HandlerFunc(receiver.newCommand)},
+		{"/relay", http.HandlerFunc(receiver.relay)},
+		{"/ping", http.HandlerFunc(receiver.ping)},
+		{"/status", http.HandlerFunc(receiver.status)},
+		{"/components", http.HandlerFunc(receiver.components)},
+		{"/components/pause", http.HandlerFunc(receiver.pauseComponent)},
+		{"/components/resume", http.HandlerFunc(receiver.resumeComponent)},
+		{"/components/health", http.HandlerFunc(receiver.componentHealth)},
+		{"/admin/conflicts", http.HandlerFunc(receiver.conflicts)},
+		{"/admin/conflict-stats", http.HandlerFunc(receiver.conflictStats)},
+		{"/admin/receipts", http.HandlerFunc(receiver.receipts)},
+		{"/admin/deadletters", http.HandlerFunc(receiver.deadLetters)},
+		{"/admin/reemit/", http.HandlerFunc(receiver.reEmit)},
+		{"/history/stream", http.HandlerFunc(receiver.historyStream)},
+		{"/cluster", http.HandlerFunc(receiver.cluster)},
+		{"/peers", http.HandlerFunc(receiver.peers)},
+		{"/admin/storage", http.HandlerFunc(receiver.storage)},
+	}
+	for _, r := range builtin {
+		if !overridden[r.pattern] {
+			receiver.mux.Handle(r.pattern, r.handler)
+		}
+	}
+
+	// Wrap our mux in our concurrency limit, our version negotiation and then our middleware, working backwards so that
+	// the first Middleware ends up outermost
+	var handler http.Handler = receiver.mux
+	if receiver.MaxConcurrentRequests > 0 {
+		receiver.slots = make(chan struct{}, receiver.MaxConcurrentRequests)
+		handler = receiver.limitConcurrency(handler)
+	}
+	handler = negotiateVersion(handler)
+	for i := len(receiver.Middleware) - 1; i >= 0; i-- {
+		handler = receiver.Middleware[i](handler)
+	}
+
+	receiver.server = &http.Server{Addr: receiver.BindAddress, Handler: handler}
 
 	receiver.log.WithField("address", receiver.BindAddress).Info("Starting HTTP server")
-	go receiver.server.ListenAndServe()
+	listener, err := receiver.bind()
+	if err != nil {
+		receiver.log.WithError(err).WithField("address", receiver.BindAddress).Error("Unable to bind HTTP server")
 
-	return
-}
+		// We never got going, so there's nothing for Stop to shut down
+		receiver.stopOnce.Do(func() {})
+		close(receiver.done)
+		return err
+	}
 
-// Stop begins the process of shutting down our running HTTP server and returns
-func (receiver *WebReceiver) Stop(int) {
+	// Serve in a background thread so that we don't block
+	receiver.serving = make(chan struct{})
+	release := accord.TrackGoroutine(receiver.Name)
 	go func() {
-		receiver.log.Info("Shutting down HTTP server")
-		receiver.stopping = true
-		receiver.server.Shutdown(nil)
-		receiver.stopping = false
-		receiver.stopSignal.Broadcast()
-		receiver.log.Info("HTTP server safely shutdown")
+		defer close(receiver.serving)
+		defer release()
+
+		err := receiver.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			receiver.log.WithError(err).Error("HTTP server stopped unexpectedly")
+		}
 	}()
+
 	return
 }
 
+// bind listens on our BindAddress, retrying according to BindRetries
+func (receiver *WebReceiver) bind() (net.Listener, error) {
+	// Mirror http.Server, which listens on the standard HTTP port when not given an address
+	address := receiver.BindAddress
+	if address == "" {
+		address = ":http"
+	}
+
+	listener, err := net.Listen("tcp", address)
+	for retry := 0; err != nil && retry < receiver.BindRetries; retry++ {
+		receiver.log.WithError(err).WithField("retry", retry+1).Warn("Unable to bind HTTP server, retrying")
+		time.Sleep(receiver.BindRetryDelay)
+		listener, err = net.Listen("tcp", address)
+	}
+
+	return listener, err
+}
+
+// route is a pattern and handler pair waiting to be registered on our mux
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// Handle registers an additional handler for the given pattern (following http.ServeMux's rules). This must be called
+// before Start. Registering a pattern used by one of our built in routes ("/", "/relay", "/ping", etc...) replaces it.
+// Like our built in routes, the handler is also served under every version prefix ("/v1/...")
+func (receiver *WebReceiver) Handle(pattern string, handler http.Handler) {
+	receiver.routes = append(receiver.routes, route{pattern, handler})
+}
+
+// HandleFunc is a convenience wrapper around Handle for plain functions
+func (receiver *WebReceiver) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	receiver.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// APIVersion is the newest version of our HTTP API, and the one that serves requests that don't ask for a version
+const APIVersion = 1
+
+// apiVersions are the versions of our HTTP API we still serve
+var apiVersions = map[int]bool{1: true}
+
+// versionPrefix matches a path prefixed with a version ("/v1", "/v1/status", etc...)
+var versionPrefix = regexp.MustCompile(`^/v([0-9]+)(/.*)?$`)
+
+// negotiateVersion wraps a handler so that it only sees requests for a version we serve, with any version prefix
+// stripped from their path so that our routes needn't be registered once per version (see WebReceiver)
+func negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := APIVersion
+
+		if match := versionPrefix.FindStringSubmatch(r.URL.Path); match != nil {
+			version, _ = strconv.Atoi(match[1])
+			if !apiVersions[version] {
+				http.Error(w, "unsupported API version", 404)
+				return
+			}
+
+			// Strip the prefix from a copy of the request, just as http.StripPrefix would
+			path := match[2]
+			if path == "" {
+				path = "/"
+			}
+			stripped := new(http.Request)
+			*stripped = *r
+			stripped.URL = new(url.URL)
+			*stripped.URL = *r.URL
+			stripped.URL.Path = path
+			stripped.URL.RawPath = ""
+			r = stripped
+		} else if requested, ok := acceptedVersion(r); ok {
+			if !apiVersions[requested] {
+				http.Error(w, "unsupported API version", 406)
+				return
+			}
+			version = requested
+		}
+
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptedVersion returns the version asked for by the "version" parameter of a request's Accept header, if any. A
+// version that isn't a number is returned as 0, which we never serve
+func acceptedVersion(r *http.Request) (int, bool) {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if param, ok := params["version"]; ok {
+			version, err := strconv.Atoi(param)
+			if err != nil {
+				return 0, true
+			}
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// limitConcurrency wraps a handler so that no more than MaxConcurrentRequests requests are handled at once, turning
+// away anything past that with a 503
+func (receiver *WebReceiver) limitConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case receiver.slots <- struct{}{}:
+			defer func() { <-receiver.slots }()
+			next.ServeHTTP(w, r)
+		default:
+			receiver.log.Warn("Too many concurrent requests, turning one away")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", 503)
+		}
+	})
+}
+
+// Stop begins the process of shutting down our running HTTP server and returns. In-flight requests are given
+// ShutdownTimeout to finish, after which their connections are forcibly closed. It is safe to call Stop
+// multiple times
+func (receiver *WebReceiver) Stop(int) {
+	receiver.stopOnce.Do(func() {
+		release := receiver.accord.TrackGoroutine(receiver.Name)
+		go func() {
+			defer close(receiver.done)
+			defer release()
+			receiver.log.Info("Shutting down HTTP server")
+
+			ctx, cancel := context.WithTimeout(context.Background(), receiver.ShutdownTimeout)
+			defer cancel()
+
+			err := receiver.server.Shutdown(ctx)
+			if err != nil {
+				receiver.log.WithError(err).Warn("HTTP server did not shutdown gracefully in time, forcibly closing connections")
+				receiver.server.Close()
+			}
+
+			// Serve returns as soon as we begin shutting down, but not necessarily before Shutdown does
+			<-receiver.serving
+			receiver.log.Info("HTTP server safely shutdown")
+		}()
+	})
+}
+
 // WaitForStop waits and listens for the process begun by our "Stop" function finishes, indicating
-// that the HTTP server has cleanly shutdown
+// that the HTTP server has cleanly shutdown. Like ComponentRunner, make sure you only use this after
+// having already called Start and Stop, otherwise it will hang forever.
 func (receiver *WebReceiver) WaitForStop() {
-	if receiver.stopping {
-		receiver.stopSignal.L.Lock()
-		receiver.stopSignal.Wait()
-		receiver.stopSignal.L.Unlock()
+	<-receiver.done
+}
+
+// ComponentName implements accord.NamedComponent
+func (receiver *WebReceiver) ComponentName() string {
+	return receiver.Name
+}
+
+// Describe implements accord.DescribedComponent. We're stopped once our HTTP server has been completely shut down
+func (receiver *WebReceiver) Describe() accord.ComponentInfo {
+	health := accord.Health{}
+	if receiver.done != nil {
+		select {
+		case <-receiver.done:
+			health.Stopped = true
+		default:
+		}
+	}
+
+	return accord.ComponentInfo{
+		Name:    receiver.Name,
+		Type:    fmt.Sprintf("%T", receiver),
+		Address: receiver.BindAddress,
+		Health:  &health,
+		Config: map[string]string{
+			"ShutdownTimeout":        receiver.ShutdownTimeout.String(),
+			"MaxConcurrentRequests":  strconv.Itoa(receiver.MaxConcurrentRequests),
+			"MaxPendingBeforeReject": strconv.FormatUint(receiver.MaxPendingBeforeReject, 10),
+			"DedupWindow":            receiver.DedupWindow.String(),
+			"Middleware":             strconv.Itoa(len(receiver.Middleware)),
+			"Routes":                 strconv.Itoa(len(receiver.routes)),
+		},
 	}
 }
 
 // newCommand performs the main role of WebReceiver, it takes data sent in through
 // a web request, wraps it in a Message struct, and sends it off to Accord to handle.
-// Upon success it returns a 201 with an "ok" message.
+// Upon success it returns a 201 with the accord.HandleResult as JSON.
+//
+// Passing "dryRun=true" in the query runs the request through all of our checks without creating anything, responding
+// with a 200 and a Verdict describing what would have happened, so that clients can pre-flight their requests.
+//
+// Passing "type" in the query sets the new Message's Type. A Message that doesn't satisfy Accord's Schemas (see
+// accord.SchemaRegistry) is turned away with a 422.
 //
 // Note that this message does *not* transport Message structs, it *creates* new ones
 // using the passed in data as a payload
 func (receiver *WebReceiver) newCommand(w http.ResponseWriter, r *http.Request) {
 	receiver.log.Debug("Received a new command request")
+	receiver.ingest(w, r, "command", receiver.accord.HandleNewMessageWithResult)
+}
+
+// relay accepts a new command exactly like newCommand, but only queues it up to be synchronized to our remotes rather
+// than processing it ourselves
+func (receiver *WebReceiver) relay(w http.ResponseWriter, r *http.Request) {
+	receiver.log.Debug("Received a new relay request")
+	receiver.ingest(w, r, "relay", receiver.accord.RelayMessageWithResult)
+}
+
+// Verdict describes what would have become of a command sent as a dry run (see newCommand)
+type Verdict struct {
+	// Accepted is whether the command would have been accepted
+	Accepted bool
+
+	// Duplicate is set when the command would have been answered with a Message we already created for the same
+	// payload (see DedupWindow), rather than creating a new one. MessageID is that Message's ID
+	Duplicate bool
+	MessageID uint64
+
+	// Status is the HTTP status code the command would have been answered with
+	Status int
+
+	// Reason explains why the command would have been rejected
+	Reason string
+}
+
+// ingest turns the body of a request into a new Message and passes it to handle, taking care of rejecting requests
+// when our backlog is too deep and of deduplicating repeated payloads sent to the same endpoint with the same type. A
+// dry run goes through the same checks, but stops short of handling (or remembering) anything
+func (receiver *WebReceiver) ingest(w http.ResponseWriter, r *http.Request, endpoint string,
+	handle func(*accord.Message) (accord.HandleResult, error)) {
+
+	dryRun := false
+	if r.URL.Query().Get("dryRun") != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(r.URL.Query().Get("dryRun"))
+		if err != nil {
+			http.Error(w, "invalid dryRun: "+err.Error(), 400)
+			return
+		}
+	}
+
+	// reject turns a request away, or describes how it would have been turned away when it's a dry run
+	reject := func(code int, reason string) {
+		if dryRun {
+			receiver.writeVerdict(w, Verdict{Status: code, Reason: reason})
+			return
+		}
+		http.Error(w, reason, code)
+	}
+
+	if receiver.MaxPendingBeforeReject > 0 {
+		pending := receiver.accord.ToBeSynced.Size()
+		if pending >= receiver.MaxPendingBeforeReject {
+			receiver.log.WithField("pending", pending).Warn("Sync backlog is too deep, rejecting new command")
+			if !dryRun {
+				w.Header().Set("Retry-After", "1")
+			}
+			reject(503, "sync backlog is full")
+			return
+		}
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 
 	// A called should take a status of 500 as an indication that something went wrong While
@@ -116,24 +498,197 @@ func (receiver *WebReceiver) newCommand(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	msg, err := accord.NewMessage(body)
+	msgType := r.URL.Query().Get("type")
+
+	// handled is what became of our Message once it's been handled, for the cache to remember
+	var handled *accord.HandleResult
+
+	if receiver.recent != nil {
+		// Claiming the key holds back any identical request until we've handled the message (or turned it away), so
+		// that two identical requests racing each other can't both get through
+		key := dedupKey(endpoint, msgType, body)
+		result, ok := receiver.recent.claim(key, !dryRun)
+		if !ok && !dryRun {
+			defer func() {
+				receiver.recent.release(key, handled)
+			}()
+		}
+
+		if ok {
+			if dryRun {
+				receiver.writeVerdict(w, Verdict{Accepted: true, Duplicate: true, MessageID: result.MessageID, Status: 200})
+				return
+			}
+
+			receiver.log.WithField("id", result.MessageID).Info("Received a duplicate command, returning the existing message")
+			result.Duplicate = true
+			receiver.writeResult(w, 200, result)
+			return
+		}
+	}
+
+	msg, err := receiver.accord.NewMessage(body)
+	if err == accord.ErrPayloadTooLarge {
+		receiver.log.WithField("size", len(body)).Warn("Rejecting a new command with an oversized payload")
+		reject(413, err.Error())
+		return
+	}
 	if err != nil {
 		receiver.log.WithError(err).Warn("Error generating a new message")
-		http.Error(w, err.Error(), 500)
+		reject(500, err.Error())
+		return
+	}
+	msg.Type = msgType
+
+	err = receiver.accord.CheckSchema(msg)
+	if err != nil {
+		receiver.log.WithError(err).WithField("type", msg.Type).Warn("Rejecting a new command that doesn't satisfy its schema")
+		reject(422, err.Error())
+		return
+	}
+
+	if dryRun {
+		receiver.writeVerdict(w, Verdict{Accepted: true, Status: 201})
 		return
 	}
 
-	err = receiver.accord.HandleNewMessage(msg)
+	result, err := handle(msg)
+	if err == accord.ErrDiskFull {
+		receiver.log.Warn("Accord is over its disk limit, rejecting new command")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), 507)
+		return
+	}
 	if err != nil {
 		receiver.log.WithError(err).Warn("Error handling new message")
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	handled = &result
+
 	// We return a 201 response to indicate that a new message has been created
 	receiver.log.Debug("New command successfully handled")
-	w.WriteHeader(201)
-	w.Write([]byte("ok"))
+	receiver.writeResult(w, 201, result)
+}
+
+// writeResult responds with what became of a command, as JSON
+func (receiver *WebReceiver) writeResult(w http.ResponseWriter, code int, result accord.HandleResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding result to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// writeVerdict responds to a dry run with what would have become of the command, as JSON
+func (receiver *WebReceiver) writeVerdict(w http.ResponseWriter, verdict Verdict) {
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding verdict to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// payloadCache is a small, time bounded record of the payloads we've recently turned into Messages, keyed by
+// dedupKey
+type payloadCache struct {
+	window  time.Duration
+	entries map[[sha256.Size]byte]recentPayload
+
+	// inFlight holds a channel for every key that a request has claimed but not yet released, which is closed as it's
+	// released
+	inFlight map[[sha256.Size]byte]chan struct{}
+
+	// lock protects entries and inFlight. It's only ever held to look at or update them, never while a Message is
+	// being handled
+	lock *sync.Mutex
+}
+
+// recentPayload is what became of the Message we created for a payload, and when we did it
+type recentPayload struct {
+	result accord.HandleResult
+	at     time.Time
+}
+
+// newPayloadCache creates an empty payloadCache that remembers payloads for the given window
+func newPayloadCache(window time.Duration) *payloadCache {
+	return &payloadCache{
+		window:   window,
+		entries:  map[[sha256.Size]byte]recentPayload{},
+		inFlight: map[[sha256.Size]byte]chan struct{}{},
+		lock:     &sync.Mutex{},
+	}
+}
+
+// dedupKey identifies a command for deduplication: the same payload sent to another endpoint, or with another type,
+// is a different command
+func dedupKey(endpoint, msgType string, payload []byte) [sha256.Size]byte {
+	hasher := sha256.New()
+	for _, field := range [][]byte{[]byte(endpoint), []byte(msgType), payload} {
+		binary.Write(hasher, binary.BigEndian, uint64(len(field)))
+		hasher.Write(field)
+	}
+
+	var key [sha256.Size]byte
+	copy(key[:], hasher.Sum(nil))
+	return key
+}
+
+// claim returns the result of handling the Message created for key, if it was created within our window, first
+// waiting out any request that has claimed the key in the meantime. If there isn't one and mark is set, the key is
+// claimed for the caller, who must release it once they're done. Anything that has fallen out of the window is pruned
+// along the way, so the cache never holds more than a window's worth of payloads
+func (cache *payloadCache) claim(key [sha256.Size]byte, mark bool) (accord.HandleResult, bool) {
+	for {
+		cache.lock.Lock()
+		now := time.Now()
+		for hash, entry := range cache.entries {
+			if now.Sub(entry.at) > cache.window {
+				delete(cache.entries, hash)
+			}
+		}
+
+		entry, ok := cache.entries[key]
+		if ok {
+			cache.lock.Unlock()
+			return entry.result, true
+		}
+
+		busy, ok := cache.inFlight[key]
+		if !ok {
+			if mark {
+				cache.inFlight[key] = make(chan struct{})
+			}
+			cache.lock.Unlock()
+			return accord.HandleResult{}, false
+		}
+
+		cache.lock.Unlock()
+		<-busy
+	}
+}
+
+// release gives up a key claimed through claim, remembering what became of its Message if it was handled
+func (cache *payloadCache) release(key [sha256.Size]byte, result *accord.HandleResult) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if result != nil {
+		cache.entries[key] = recentPayload{result: *result, at: time.Now()}
+	}
+	close(cache.inFlight[key])
+	delete(cache.inFlight, key)
 }
 
 // pingHandler is responsible for sending back a small response upon any kind of request to indicate
@@ -156,3 +711,415 @@ func (receiver *WebReceiver) status(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(data)
 }
+
+// components is an admin handler that lists every component our Accord is running, describing each as a JSON array
+// (see accord.Accord.Components)
+func (receiver *WebReceiver) components(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(receiver.accord.Components())
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding components to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// findPausable looks up the component named in the request's "name" query parameter, writing out an error response and
+// returning nil if it doesn't exist or can't be paused
+func (receiver *WebReceiver) findPausable(w http.ResponseWriter, r *http.Request) accord.PausableComponent {
+	name := r.URL.Query().Get("name")
+	comp := receiver.accord.FindComponent(name)
+	if comp == nil {
+		receiver.log.WithField("name", name).Warn("Request for unknown component")
+		http.Error(w, "unknown component", 404)
+		return nil
+	}
+
+	pausable, ok := comp.(accord.PausableComponent)
+	if !ok {
+		receiver.log.WithField("name", name).Warn("Request to manage a component that can't be paused")
+		http.Error(w, "component can not be paused", 400)
+		return nil
+	}
+
+	return pausable
+}
+
+// writeHealth sends back a component's health as a JSON string with a status of 200
+func (receiver *WebReceiver) writeHealth(w http.ResponseWriter, comp accord.PausableComponent) {
+	data, err := json.Marshal(comp.Health())
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding health to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// pauseComponent is an admin handler that pauses the component named by the "name" query parameter, leaving it
+// running but halting its work until it is resumed. Responds with the component's health. Only POSTs are accepted, so
+// that nothing following links (a prefetcher or crawler, say) can pause our sync
+func (receiver *WebReceiver) pauseComponent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+
+	comp := receiver.findPausable(w, r)
+	if comp == nil {
+		return
+	}
+
+	comp.Pause()
+	receiver.writeHealth(w, comp)
+}
+
+// resumeComponent is an admin handler that resumes a component previously paused through pauseComponent. Responds
+// with the component's health. Like pauseComponent, only POSTs are accepted
+func (receiver *WebReceiver) resumeComponent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+
+	comp := receiver.findPausable(w, r)
+	if comp == nil {
+		return
+	}
+
+	comp.Resume()
+	receiver.writeHealth(w, comp)
+}
+
+// componentHealth reports the health of the component named by the "name" query parameter
+func (receiver *WebReceiver) componentHealth(w http.ResponseWriter, r *http.Request) {
+	comp := receiver.findPausable(w, r)
+	if comp == nil {
+		return
+	}
+
+	receiver.writeHealth(w, comp)
+}
+
+// conflicts is an admin handler that returns our audit trail of conflict resolution decisions as a JSON list, oldest
+// first. The optional "offset" and "limit" query parameters can be used to page through the results
+func (receiver *WebReceiver) conflicts(w http.ResponseWriter, r *http.Request) {
+	offset, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	records, err := receiver.accord.Conflicts(offset, limit)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error reading conflict log")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding conflicts to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// conflictStats is an admin handler that returns how our decisions about remote Messages have gone, counted by the path
+// each took (see accord.ConflictStats), as a JSON string
+func (receiver *WebReceiver) conflictStats(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(receiver.accord.ConflictStats())
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding conflict stats to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// deadLetters is an admin handler for our dead letter queue. A GET returns the dead lettered Messages as a JSON list,
+// oldest first, paged by the optional "offset" and "limit" query parameters. A POST acts on the Message named by the
+// "id" query parameter, either putting it back on our sync queue ("action=requeue") or dropping it ("action=discard")
+func (receiver *WebReceiver) deadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		offset, limit, ok := parsePage(w, r)
+		if !ok {
+			return
+		}
+
+		letters, err := receiver.accord.DeadLetters(offset, limit)
+		if err != nil {
+			receiver.log.WithError(err).Warn("Error reading dead letter queue")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		data, err := json.Marshal(letters)
+		if err != nil {
+			receiver.log.WithError(err).Warn("Error encoding dead letters to json")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Write(data)
+		return
+	}
+
+	query := r.URL.Query()
+	id, err := strconv.ParseUint(query.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+
+	switch query.Get("action") {
+	case "requeue":
+		err = receiver.accord.DeadLetterRequeue(id)
+	case "discard":
+		err = receiver.accord.DeadLetterDiscard(id)
+	default:
+		http.Error(w, "invalid action", 400)
+		return
+	}
+
+	if err == accord.ErrDeadLetterNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		receiver.log.WithError(err).WithField("id", id).Warn("Error acting on dead letter")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// reEmit is an admin handler that POSTs to /admin/reemit/{id} to sync the Message with that ID, found in our history,
+// to our peers again (see accord.Accord.ReEmit)
+func (receiver *WebReceiver) reEmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/admin/reemit/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+
+	err = receiver.accord.ReEmit(id)
+	if err == accord.ErrNotInHistory {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		receiver.log.WithError(err).WithField("id", id).Warn("Error re-emitting message")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// cluster reports our view of the cluster, as collected by a ClusterView (a GossipComponent, usually), as a JSON list
+// ordered by node ID. The optional "name" query parameter picks which component to ask, defaulting to "Gossip"
+func (receiver *WebReceiver) cluster(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Gossip"
+	}
+
+	gossip, ok := receiver.accord.FindComponent(name).(ClusterView)
+	if !ok {
+		receiver.log.WithField("name", name).Warn("Request for the cluster view without a gossip component")
+		http.Error(w, "no gossip component", 404)
+		return
+	}
+
+	data, err := json.Marshal(gossip.Cluster())
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding cluster to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// peers reports how far each of our sync targets has gotten through our sync queue (see accord.Accord.PeerProgress) as
+// a JSON object keyed by target
+func (receiver *WebReceiver) peers(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(receiver.accord.PeerProgress())
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding peer progress to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// storage reports on the footprint of our stores on disk (see accord.Accord.StorageStats) as a JSON object keyed by store
+func (receiver *WebReceiver) storage(w http.ResponseWriter, r *http.Request) {
+	stats, err := receiver.accord.StorageStats()
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error reading storage stats")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding storage stats to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// historyStreamChunk is how many Messages historyStream reads from our history at a time. Our history is only locked
+// while each chunk is read, never while it's being written out to a (possibly slow) client
+var historyStreamChunk uint64 = 100
+
+// historyStream streams our entire history, oldest first, as newline delimited JSON (one Message per line). The
+// optional "since" query parameter (an RFC 3339 timestamp) skips Messages created at or before it, so that an export can
+// be resumed. Anything pushed while we're streaming is included, and the stream ends once we've caught up. If our
+// history is cleared part way through, the stream simply ends early, as everything after where we got to is gone
+func (receiver *WebReceiver) historyStream(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if r.URL.Query().Get("since") != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), 400)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	var offset uint64
+	var last *accord.Message
+	for {
+		// We re-read the last Message we sent along with each chunk, to make sure our offset still points at it
+		from, limit := offset, historyStreamChunk
+		if last != nil {
+			from, limit = offset-1, historyStreamChunk+1
+		}
+
+		msgs, err := receiver.accord.History(from, limit)
+		if err != nil {
+			receiver.log.WithError(err).Warn("Error reading history")
+			if last == nil {
+				http.Error(w, err.Error(), 500)
+			}
+			return
+		}
+
+		if last != nil {
+			if len(msgs) == 0 || msgs[0].ID != last.ID {
+				receiver.log.Info("History was cleared while it was being streamed, ending the stream early")
+				return
+			}
+			msgs = msgs[1:]
+		}
+		if len(msgs) == 0 {
+			return
+		}
+
+		for _, msg := range msgs {
+			if !since.IsZero() && !msg.Timestamp.After(since) {
+				continue
+			}
+
+			err = encoder.Encode(msg)
+			if err != nil {
+				receiver.log.WithError(err).Debug("Client went away while streaming history")
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		offset += uint64(len(msgs))
+		last = msgs[len(msgs)-1]
+	}
+}
+
+// parsePage reads the optional "offset" and "limit" query parameters used by our paged admin handlers, writing out an
+// error response and returning false if either is invalid
+func parsePage(w http.ResponseWriter, r *http.Request) (offset, limit uint64, ok bool) {
+	var err error
+
+	query := r.URL.Query()
+	if query.Get("offset") != "" {
+		offset, err = strconv.ParseUint(query.Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid offset", 400)
+			return 0, 0, false
+		}
+	}
+	if query.Get("limit") != "" {
+		limit, err = strconv.ParseUint(query.Get("limit"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid limit", 400)
+			return 0, 0, false
+		}
+	}
+
+	return offset, limit, true
+}
+
+// receipts is an admin handler that returns our delivery receipts as a JSON list, oldest first. If the "id" query
+// parameter is given we return only the receipts for that Message, otherwise the optional "offset" and "limit" query
+// parameters can be used to page through all of them
+func (receiver *WebReceiver) receipts(w http.ResponseWriter, r *http.Request) {
+	var receipts []accord.DeliveryReceipt
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		messageID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", 400)
+			return
+		}
+
+		receipts, err = receiver.accord.Receipts(messageID)
+		if err != nil {
+			receiver.log.WithError(err).Warn("Error reading receipt log")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	} else {
+		offset, limit, ok := parsePage(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		receipts, err = receiver.accord.AllReceipts(offset, limit)
+		if err != nil {
+			receiver.log.WithError(err).Warn("Error reading receipt log")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	data, err := json.Marshal(receipts)
+	if err != nil {
+		receiver.log.WithError(err).Warn("Error encoding receipts to json")
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/cj-dimaggio/accord/accord"
 	"github.com/stretchr/testify/assert"
@@ -33,6 +36,19 @@ func TestWebReceiverWaitStopTwice(t *testing.T) {
 	receiver.WaitForStop()
 }
 
+func TestWebReceiverGoroutines(t *testing.T) {
+	acrd := accord.DummyAccord()
+	receiver := WebReceiver{BindAddress: "127.0.0.1:0"}
+	assert.Nil(t, receiver.Start(acrd))
+	assert.Equal(t, map[string]int{"WebReceiver": 1}, acrd.Goroutines())
+
+	// Stopping more than once doesn't start more than one goroutine to do it, and we're back to nothing once stopped
+	receiver.Stop(0)
+	receiver.Stop(0)
+	receiver.WaitForStop()
+	assert.Empty(t, acrd.Goroutines())
+}
+
 func TestWebReceiverPing(t *testing.T) {
 	req := httptest.NewRequest("GET", "/ping", nil)
 	resp := httptest.NewRecorder()
@@ -59,24 +75,283 @@ func TestWebReceiverNewCommand(t *testing.T) {
 	resp := httptest.NewRecorder()
 
 	receiver := WebReceiver{}
-	accord := accord.DummyAccord()
+	acrd := accord.DummyAccord()
 	defer receiver.WaitForStop()
 	defer receiver.Stop(0)
-	defer accord.Stop()
+	defer acrd.Stop()
 
-	accord.Start()
-	receiver.Start(accord)
+	acrd.Start()
+	receiver.Start(acrd)
 
 	receiver.mux.ServeHTTP(resp, req)
 	assert.Equal(t, resp.Code, 201)
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var result accord.HandleResult
+	err := json.Unmarshal(resp.Body.Bytes(), &result)
 	assert.Nil(t, err)
-	assert.Equal(t, string(body), "ok")
 
-	status := accord.Status()
+	status := acrd.Status()
 	assert.Equal(t, uint64(1), status.ToBeSyncedSize)
+	assert.NotZero(t, result.MessageID)
+	assert.False(t, result.Duplicate)
+	assert.Equal(t, status.State, result.State)
+	assert.Equal(t, uint64(0), result.QueuePosition)
+
+}
+
+func TestWebReceiverRelay(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	manager := accord.NewDummerManager()
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccordManager(manager)
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/relay", bytes.NewBufferString("hello, world")))
+	assert.Equal(t, 201, resp.Code)
+
+	assert.Equal(t, 0, manager.ProcessCount)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+}
+
+func TestWebReceiverDedupWindow(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{DedupWindow: 20 * time.Millisecond}
+	acrd := accord.DummyAccord()
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/", bytes.NewBufferString(body)))
+		return resp
+	}
+
+	result := func(resp *httptest.ResponseRecorder) accord.HandleResult {
+		var result accord.HandleResult
+		err := json.Unmarshal(resp.Body.Bytes(), &result)
+		assert.Nil(t, err)
+		return result
+	}
+
+	resp := post("abc")
+	assert.Equal(t, 201, resp.Code)
+	original := result(resp)
+	assert.False(t, original.Duplicate)
+	msg, err := acrd.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, original.MessageID)
+
+	// A retry of the same payload gets back the message we already created
+	resp = post("abc")
+	assert.Equal(t, 200, resp.Code)
+	duplicate := result(resp)
+	assert.True(t, duplicate.Duplicate)
+	assert.Equal(t, msg.ID, duplicate.MessageID)
+	assert.Equal(t, original.State, duplicate.State)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	// Different payloads are unaffected
+	resp = post("def")
+	assert.Equal(t, 201, resp.Code)
+	assert.Equal(t, uint64(1), result(resp).QueuePosition)
+
+	// As is the same payload sent as a relay, or with another type
+	relay := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(relay, httptest.NewRequest("POST", "/relay", bytes.NewBufferString("abc")))
+	assert.Equal(t, 201, relay.Code)
+	typed := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(typed, httptest.NewRequest("POST", "/?type=other", bytes.NewBufferString("abc")))
+	assert.Equal(t, 201, typed.Code)
+	assert.Equal(t, uint64(4), acrd.Status().ToBeSyncedSize)
 
+	// And once the window has passed the same payload is a new message
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 201, post("abc").Code)
+	assert.Equal(t, uint64(5), acrd.Status().ToBeSyncedSize)
+
+	// Identical requests racing each other only create one message between them
+	codes := make(chan int, 10)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			codes <- post("racing").Code
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == 201 {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created)
+	assert.Equal(t, uint64(6), acrd.Status().ToBeSyncedSize)
+}
+
+func TestWebReceiverDedupClaims(t *testing.T) {
+	cache := newPayloadCache(time.Minute)
+	first := dedupKey("command", "", []byte("abc"))
+	assert.NotEqual(t, first, dedupKey("relay", "", []byte("abc")))
+	assert.NotEqual(t, first, dedupKey("command", "abc", nil))
+
+	_, ok := cache.claim(first, true)
+	assert.False(t, ok)
+
+	// Another key isn't held up by the one in flight
+	other := dedupKey("command", "", []byte("def"))
+	_, ok = cache.claim(other, true)
+	assert.False(t, ok)
+	cache.release(other, nil)
+
+	// But the same key waits until it's released, and then gets its result
+	waited := make(chan accord.HandleResult)
+	go func() {
+		result, ok := cache.claim(first, true)
+		assert.True(t, ok)
+		waited <- result
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("claimed a key that was already in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cache.release(first, &accord.HandleResult{MessageID: 5})
+	assert.Equal(t, uint64(5), (<-waited).MessageID)
+
+	// A key released without a result is free to be claimed again
+	_, ok = cache.claim(other, true)
+	assert.False(t, ok)
+	cache.release(other, nil)
+}
+
+func TestWebReceiverDryRun(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{DedupWindow: time.Minute, MaxPendingBeforeReject: 2}
+	acrd := accord.DummyAccord()
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	post := func(target, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", target, bytes.NewBufferString(body)))
+		return resp
+	}
+
+	verdict := func(resp *httptest.ResponseRecorder) Verdict {
+		assert.Equal(t, 200, resp.Code)
+		var verdict Verdict
+		err := json.Unmarshal(resp.Body.Bytes(), &verdict)
+		assert.Nil(t, err)
+		return verdict
+	}
+
+	// A new payload would be accepted, but nothing is created
+	assert.Equal(t, Verdict{Accepted: true, Status: 201}, verdict(post("/?dryRun=true", "abc")))
+	assert.Equal(t, Verdict{Accepted: true, Status: 201}, verdict(post("/relay?dryRun=true", "abc")))
+	assert.Equal(t, uint64(0), acrd.Status().ToBeSyncedSize)
+
+	// Nor is the payload remembered, so the real thing still goes through
+	resp := post("/", "abc")
+	assert.Equal(t, 201, resp.Code)
+	var result accord.HandleResult
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &result))
+
+	assert.Equal(t, Verdict{Accepted: true, Duplicate: true, MessageID: result.MessageID, Status: 200},
+		verdict(post("/?dryRun=true", "abc")))
+
+	acrd.MaxPayloadSize = 2
+	rejected := verdict(post("/?dryRun=true", "def"))
+	acrd.MaxPayloadSize = 0
+	assert.False(t, rejected.Accepted)
+	assert.Equal(t, 413, rejected.Status)
+	assert.Equal(t, accord.ErrPayloadTooLarge.Error(), rejected.Reason)
+
+	assert.Equal(t, 201, post("/", "def").Code)
+	rejected = verdict(post("/?dryRun=true", "ghi"))
+	assert.False(t, rejected.Accepted)
+	assert.Equal(t, 503, rejected.Status)
+	assert.Equal(t, uint64(2), acrd.Status().ToBeSyncedSize)
+
+	assert.Equal(t, 400, post("/?dryRun=maybe", "ghi").Code)
+}
+
+func TestWebReceiverHistoryStream(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	defer func(chunk uint64) { historyStreamChunk = chunk }(historyStreamChunk)
+	historyStreamChunk = 2
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	msgs := []*accord.Message{}
+	for i := 0; i < 5; i++ {
+		msg, err := accord.NewMessage([]byte{byte(i)})
+		assert.Nil(t, err)
+		msg.Timestamp = time.Date(2020, 1, 1, 0, 0, i, 0, time.UTC)
+		assert.Nil(t, acrd.HandleNewMessage(msg))
+		msgs = append(msgs, msg)
+	}
+
+	stream := func(query string) (*httptest.ResponseRecorder, []uint64) {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/history/stream"+query, nil))
+
+		ids := []uint64{}
+		if resp.Code != 200 {
+			return resp, ids
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			msg := accord.Message{}
+			err := decoder.Decode(&msg)
+			if !assert.Nil(t, err) {
+				break
+			}
+			ids = append(ids, msg.ID)
+		}
+		return resp, ids
+	}
+
+	resp, ids := stream("")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+	assert.Equal(t, []uint64{msgs[0].ID, msgs[1].ID, msgs[2].ID, msgs[3].ID, msgs[4].ID}, ids)
+
+	// Only Messages created after since are included
+	_, ids = stream("?since=" + msgs[2].Timestamp.Format(time.RFC3339Nano))
+	assert.Equal(t, []uint64{msgs[3].ID, msgs[4].ID}, ids)
+
+	resp, _ = stream("?since=yesterday")
+	assert.Equal(t, 400, resp.Code)
 }
 
 func TestWebReceiverStatus(t *testing.T) {
@@ -111,3 +386,594 @@ func TestWebReceiverStatus(t *testing.T) {
 	assert.Equal(t, uint64(0), status.ToBeSyncedSize)
 	assert.Equal(t, uint64(0), status.State)
 }
+
+func TestWebReceiverPeers(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	assert.Nil(t, acrd.ToBeSynced.RegisterTarget("primary"))
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, acrd.HandleNewMessage(&accord.Message{ID: id}))
+	}
+	assert.Nil(t, acrd.ToBeSynced.ConfirmTarget("primary"))
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/peers", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	progress := map[string]accord.PeerProgress{}
+	err := json.Unmarshal(resp.Body.Bytes(), &progress)
+	assert.Nil(t, err)
+	assert.Len(t, progress, 1)
+	assert.Equal(t, uint64(1), progress["primary"].LastConfirmedID)
+	assert.Equal(t, uint64(2), progress["primary"].Behind)
+}
+
+func TestWebReceiverStorage(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	for id := uint64(1); id <= 3; id++ {
+		assert.Nil(t, acrd.HandleNewMessage(&accord.Message{ID: id}))
+	}
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/storage", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	stats := map[string]accord.StorageStats{}
+	err := json.Unmarshal(resp.Body.Bytes(), &stats)
+	assert.Nil(t, err)
+	assert.True(t, stats[accord.SyncFilename].DiskBytes > 0)
+	assert.True(t, stats[accord.StateFilename].DiskBytes > 0)
+}
+
+func TestWebReceiverShutdownTimeout(t *testing.T) {
+	// Find ourselves a free port to bind to
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	receiver := WebReceiver{BindAddress: address, ShutdownTimeout: 50 * time.Millisecond}
+	receiver.Start(accord.DummyAccord())
+
+	started := make(chan struct{})
+	receiver.mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(time.Second)
+	})
+
+	// Wait for our server to come up
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get("http://" + address + "/ping")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	go func() {
+		resp, err := http.Get("http://" + address + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// Our slow request would take a full second, but we should give up on it once our timeout elapses
+	begin := time.Now()
+	receiver.Stop(0)
+	receiver.WaitForStop()
+	elapsed := time.Since(begin)
+
+	assert.True(t, elapsed >= 50*time.Millisecond, "stopped too early: %v", elapsed)
+	assert.True(t, elapsed < 500*time.Millisecond, "stopped too late: %v", elapsed)
+}
+
+func TestWebReceiverPortInUse(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer held.Close()
+
+	receiver := WebReceiver{BindAddress: held.Addr().String()}
+	err = receiver.Start(accord.DummyAccord())
+	assert.NotNil(t, err)
+
+	// Even though we never started, stopping mustn't hang
+	receiver.Stop(0)
+	receiver.WaitForStop()
+}
+
+func TestWebReceiverBindRetries(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := held.Addr().String()
+
+	// Let go of the port while we're retrying
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		held.Close()
+	}()
+
+	receiver := WebReceiver{BindAddress: address, BindRetries: 20, BindRetryDelay: 10 * time.Millisecond}
+	err = receiver.Start(accord.DummyAccord())
+	assert.Nil(t, err)
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+
+	// And as soon as Start returns we're being served
+	resp, err := http.Get("http://" + address + "/ping")
+	assert.Nil(t, err)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+}
+
+func TestWebReceiverHandleAndMiddleware(t *testing.T) {
+	receiver := WebReceiver{}
+
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	receiver.Middleware = []Middleware{tag("outer"), tag("inner")}
+
+	receiver.HandleFunc("/custom", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("custom"))
+	})
+	receiver.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("overridden"))
+	})
+
+	receiver.Start(accord.DummyAccord())
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+
+	resp := httptest.NewRecorder()
+	receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/custom", nil))
+	assert.Equal(t, "custom", resp.Body.String())
+	assert.Equal(t, []string{"outer", "inner"}, order)
+
+	resp = httptest.NewRecorder()
+	receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, "overridden", resp.Body.String())
+
+	// Our other built in routes should still be there
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/components/health?name=missing", nil))
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestWebReceiverVersioning(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	request := func(method, url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString("hello, world"))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp := httptest.NewRecorder()
+		receiver.server.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Our legacy routes behave exactly like their versioned counterparts
+	for _, path := range []string{"/ping", "/status", "/peers", "/components/health?name=missing"} {
+		legacy := request("GET", path, "")
+		versioned := request("GET", "/v1"+path, "")
+		assert.Equal(t, legacy.Code, versioned.Code, path)
+		assert.Equal(t, legacy.Body.String(), versioned.Body.String(), path)
+		assert.Equal(t, "1", legacy.Header().Get("API-Version"), path)
+		assert.Equal(t, "1", versioned.Header().Get("API-Version"), path)
+	}
+
+	// Including new commands, with or without a trailing slash
+	for _, path := range []string{"/", "/v1/", "/v1"} {
+		resp := request("POST", path, "")
+		assert.Equal(t, 201, resp.Code, path)
+		var result accord.HandleResult
+		assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &result), path)
+		assert.NotZero(t, result.MessageID, path)
+	}
+	assert.Equal(t, uint64(3), acrd.Status().ToBeSyncedSize)
+
+	// Handlers that read their path see it without the prefix
+	assert.Equal(t, 400, request("POST", "/v1/admin/reemit/seven", "").Code)
+
+	// Or a version can be asked for through the Accept header
+	resp := request("GET", "/ping", "text/html, application/json; version=1")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "pong", resp.Body.String())
+	assert.Equal(t, "1", resp.Header().Get("API-Version"))
+
+	// Versions we don't serve are turned away rather than falling back to our new command route
+	assert.Equal(t, 404, request("POST", "/v2/", "").Code)
+	assert.Equal(t, 404, request("GET", "/v2/ping", "").Code)
+	assert.Equal(t, 406, request("GET", "/ping", "application/json; version=2").Code)
+	assert.Equal(t, 406, request("GET", "/ping", "application/json; version=latest").Code)
+	assert.Equal(t, uint64(3), acrd.Status().ToBeSyncedSize)
+
+	// And the path prefix wins over the Accept header
+	assert.Equal(t, 200, request("GET", "/v1/ping", "application/json; version=2").Code)
+}
+
+func TestWebReceiverConflicts(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccordManager(&accord.DummyManager{ShouldProcessRet: true})
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	err := acrd.HandleRemoteMessage(&accord.Message{ID: 10, StateAt: 100})
+	assert.Nil(t, err)
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/conflicts", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	var records []accord.ConflictRecord
+	err = json.Unmarshal(resp.Body.Bytes(), &records)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, uint64(10), records[0].MessageID)
+	assert.True(t, records[0].Processed)
+
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/conflicts?limit=abc", nil))
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestWebReceiverConflictStats(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	manager := &accord.DummyManager{ShouldProcessRet: true}
+	acrd := accord.DummyAccordManager(manager)
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	err := acrd.HandleRemoteMessage(&accord.Message{ID: 1, StateAt: 0})
+	assert.Nil(t, err)
+	err = acrd.HandleRemoteMessage(&accord.Message{ID: 10, StateAt: 100})
+	assert.Nil(t, err)
+	manager.ShouldProcessRet = false
+	err = acrd.HandleRemoteMessage(&accord.Message{ID: 20, StateAt: 200})
+	assert.Nil(t, err)
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/conflict-stats", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	var stats accord.ConflictStats
+	err = json.Unmarshal(resp.Body.Bytes(), &stats)
+	assert.Nil(t, err)
+	assert.Equal(t, accord.ConflictStats{Aligned: 1, Accepted: 1, Skipped: 1}, stats)
+}
+
+func TestWebReceiverReceipts(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+	acrd.DeliveryReceipts = true
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	assert.Nil(t, acrd.RecordDelivery(10, "primary"))
+	assert.Nil(t, acrd.RecordDelivery(20, "primary"))
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/receipts?id=20", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	var receipts []accord.DeliveryReceipt
+	err := json.Unmarshal(resp.Body.Bytes(), &receipts)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, uint64(20), receipts[0].MessageID)
+
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/receipts", nil))
+	assert.Equal(t, 200, resp.Code)
+	err = json.Unmarshal(resp.Body.Bytes(), &receipts)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 2)
+
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/receipts?id=abc", nil))
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestWebReceiverReEmit(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	assert.Nil(t, acrd.HandleNewMessage(&accord.Message{ID: 7}))
+	_, err := acrd.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+
+	request := func(method, url string) int {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest(method, url, nil))
+		return resp.Code
+	}
+
+	assert.Equal(t, 405, request("GET", "/admin/reemit/7"))
+	assert.Equal(t, 400, request("POST", "/admin/reemit/seven"))
+	assert.Equal(t, 404, request("POST", "/admin/reemit/8"))
+	assert.Equal(t, uint64(0), acrd.ToBeSynced.Size())
+
+	assert.Equal(t, 200, request("POST", "/admin/reemit/7"))
+	head, err := acrd.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), head.ID)
+}
+
+func TestWebReceiverDeadLetters(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+	acrd.MaxHeadRetries = 1
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	msg1 := &accord.Message{ID: 1}
+	msg2 := &accord.Message{ID: 2}
+	assert.Nil(t, acrd.HandleNewMessage(msg1))
+	assert.Nil(t, acrd.HandleNewMessage(msg2))
+	_, err := acrd.ReportSyncFailure(msg1, "", nil)
+	assert.Nil(t, err)
+	_, err = acrd.ReportSyncFailure(msg2, "", nil)
+	assert.Nil(t, err)
+
+	request := func(method, url string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest(method, url, nil))
+		return resp
+	}
+
+	resp := request("GET", "/admin/deadletters")
+	assert.Equal(t, 200, resp.Code)
+	var letters []accord.DeadLetter
+	err = json.Unmarshal(resp.Body.Bytes(), &letters)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 2)
+
+	assert.Equal(t, 200, request("POST", "/admin/deadletters?action=requeue&id=1").Code)
+	assert.Equal(t, 200, request("POST", "/admin/deadletters?action=discard&id=2").Code)
+	assert.Equal(t, uint64(1), acrd.Status().ToBeSyncedSize)
+
+	resp = request("GET", "/admin/deadletters")
+	err = json.Unmarshal(resp.Body.Bytes(), &letters)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 0)
+
+	assert.Equal(t, 404, request("POST", "/admin/deadletters?action=discard&id=2").Code)
+	assert.Equal(t, 400, request("POST", "/admin/deadletters?action=explode&id=1").Code)
+	assert.Equal(t, 400, request("POST", "/admin/deadletters?action=requeue&id=abc").Code)
+}
+
+func TestWebReceiverMaxPendingBeforeReject(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{MaxPendingBeforeReject: 2}
+	acrd := accord.DummyAccord()
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/", bytes.NewBufferString("abc")))
+		assert.Equal(t, 201, resp.Code)
+	}
+
+	// Our backlog is full, so we should be pushing back
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/", bytes.NewBufferString("abc")))
+	assert.Equal(t, 503, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+	assert.Equal(t, uint64(2), acrd.Status().ToBeSyncedSize)
+
+	// Once sync catches up we take new commands again
+	_, err := acrd.ToBeSynced.Dequeue()
+	assert.Nil(t, err)
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/", bytes.NewBufferString("abc")))
+	assert.Equal(t, 201, resp.Code)
+}
+
+func TestWebReceiverDiskFull(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+	acrd.MaxDiskBytes = 1
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", "/", bytes.NewBufferString("hello, world")))
+	assert.Equal(t, 507, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+	assert.Equal(t, uint64(0), acrd.ToBeSynced.Size())
+
+	// Our usage against the limit shows up in our status
+	resp = httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/status", nil))
+	var status accord.Status
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &status))
+	assert.True(t, status.DiskFull)
+	assert.True(t, status.DiskBytes > 1)
+	assert.Equal(t, uint64(1), status.MaxDiskBytes)
+}
+
+func TestWebReceiverSchemas(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	receiver := WebReceiver{}
+	acrd := accord.DummyAccord()
+	acrd.Schemas = accord.NewSchemaRegistry(accord.UnknownPassThrough)
+	assert.Nil(t, acrd.Schemas.Register("greeting", accord.JSONSchema("", nil)))
+
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+	defer acrd.Stop()
+
+	acrd.Start()
+	receiver.Start(acrd)
+
+	request := func(url, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest("POST", url, bytes.NewBufferString(body)))
+		return resp
+	}
+
+	// A payload that doesn't satisfy its type's schema is turned away, even as a dry run
+	assert.Equal(t, 422, request("/?type=greeting", "hello").Code)
+	resp := request("/?type=greeting&dryRun=true", "hello")
+	assert.Equal(t, 200, resp.Code)
+	var verdict Verdict
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &verdict))
+	assert.False(t, verdict.Accepted)
+	assert.Equal(t, 422, verdict.Status)
+	assert.Equal(t, uint64(0), acrd.ToBeSynced.Size())
+
+	// While one that does is created with its type
+	assert.Equal(t, 201, request("/?type=greeting", `"hello"`).Code)
+	msg, err := acrd.ToBeSynced.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "greeting", msg.Type)
+
+	// And untyped commands are left alone
+	assert.Equal(t, 201, request("/", "hello").Code)
+}
+
+func TestWebReceiverMaxConcurrentRequests(t *testing.T) {
+	receiver := WebReceiver{MaxConcurrentRequests: 1}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	receiver.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	receiver.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+
+	receiver.Start(accord.DummyAccord())
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+
+	done := make(chan int)
+	go func() {
+		resp := httptest.NewRecorder()
+		receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/slow", nil))
+		done <- resp.Code
+	}()
+	<-started
+
+	// We're saturated, so anything else should be turned away
+	resp := httptest.NewRecorder()
+	receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, 503, resp.Code)
+
+	// Except for pings
+	resp = httptest.NewRecorder()
+	receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	close(release)
+	assert.Equal(t, 200, <-done)
+
+	// Once our slow request finishes we have room again
+	resp = httptest.NewRecorder()
+	receiver.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, 200, resp.Code)
+}
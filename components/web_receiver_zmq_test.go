@@ -0,0 +1,129 @@
+//go:build !noZMQ
+
+package components
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cj-dimaggio/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebReceiverPauseComponent(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := &PollListener{
+		Name:          "listener",
+		Address:       "inproc://webReceiverPauseTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+	}
+	receiver := WebReceiver{}
+
+	acrd := accord.DummyAccordComponents(listener)
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	receiver.Start(acrd)
+	defer receiver.WaitForStop()
+	defer receiver.Stop(0)
+
+	request := func(method, path string) (int, accord.Health) {
+		resp := httptest.NewRecorder()
+		receiver.mux.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+
+		var health accord.Health
+		if resp.Code == 200 {
+			err := json.Unmarshal(resp.Body.Bytes(), &health)
+			assert.Nil(t, err)
+		}
+		return resp.Code, health
+	}
+
+	// Following a link doesn't get to pause anything
+	code, _ := request("GET", "/components/pause?name=listener")
+	assert.Equal(t, 405, code)
+	assert.False(t, listener.Health().Paused)
+
+	code, health := request("POST", "/components/pause?name=listener")
+	assert.Equal(t, 200, code)
+	assert.True(t, health.Paused)
+
+	code, _ = request("GET", "/components/resume?name=listener")
+	assert.Equal(t, 405, code)
+	assert.True(t, listener.Health().Paused)
+
+	code, health = request("GET", "/components/health?name=listener")
+	assert.Equal(t, 200, code)
+	assert.True(t, health.Paused)
+
+	code, health = request("POST", "/components/resume?name=listener")
+	assert.Equal(t, 200, code)
+	assert.False(t, health.Paused)
+
+	code, _ = request("POST", "/components/pause?name=missing")
+	assert.Equal(t, 404, code)
+}
+
+func TestWebReceiverListComponents(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	listener := &PollListener{
+		Name:          "listener",
+		Address:       "inproc://webReceiverComponentsTest",
+		Bind:          true,
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		Target:        "archive",
+	}
+	requestor := &PollRequestor{
+		Address:       "inproc://webReceiverComponentsTest",
+		ListenTimeout: time.Millisecond,
+		SendTimeout:   time.Millisecond,
+		WaitOnEmpty:   time.Millisecond,
+	}
+	receiver := &WebReceiver{Name: "api"}
+
+	acrd := accord.DummyAccordComponents(listener, requestor, receiver)
+	err := acrd.Start()
+	assert.Nil(t, err)
+	defer acrd.Stop()
+
+	resp := httptest.NewRecorder()
+	receiver.mux.ServeHTTP(resp, httptest.NewRequest("GET", "/components", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	var infos []accord.ComponentInfo
+	err = json.Unmarshal(resp.Body.Bytes(), &infos)
+	assert.Nil(t, err)
+
+	// Every component we configured is listed, in order, as it was configured
+	assert.Len(t, infos, 3)
+	if len(infos) != 3 {
+		return
+	}
+	assert.Equal(t, "listener", infos[0].Name)
+	assert.Equal(t, "*components.PollListener", infos[0].Type)
+	assert.Equal(t, "inproc://webReceiverComponentsTest", infos[0].Address)
+	assert.Equal(t, "archive", infos[0].Config["Target"])
+	assert.Equal(t, &accord.Health{}, infos[0].Health)
+
+	assert.Equal(t, "PollRequestor", infos[1].Name)
+	assert.Equal(t, "*components.PollRequestor", infos[1].Type)
+	assert.Equal(t, "1ms", infos[1].Config["WaitOnEmpty"])
+
+	assert.Equal(t, "api", infos[2].Name)
+	assert.Equal(t, "*components.WebReceiver", infos[2].Type)
+	assert.Equal(t, &accord.Health{}, infos[2].Health)
+
+	// And reflects their current state
+	listener.Pause()
+	assert.True(t, acrd.Components()[0].Health.Paused)
+}